@@ -0,0 +1,374 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/fio"
+	"bitcask-go/index"
+	"bitcask-go/utils"
+	"encoding/json"
+	"errors"
+	"hash/crc32"
+	"io"
+	iofs "io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	// BackupManifestFileName 备份清单文件名，清单最后写入，存在才说明备份完整
+	BackupManifestFileName = "backup-manifest.json"
+
+	backupManifestVersion = 1
+)
+
+// BackupOptions 备份配置项
+type BackupOptions struct {
+	// Incremental 上一次备份的目录，不为空时是增量备份
+	// 和上一次备份相比没有变化的文件不再拷贝，清单中记录它们所在的备份目录
+	Incremental string
+
+	// HardLink 是否优先使用硬链接代替拷贝，文件系统不支持或者跨设备时自动退化为拷贝
+	// 增量备份中没有变化的文件也会从上一次备份中硬链接过来，这样每个备份都是完整的
+	// 硬链接和数据目录共享数据，不能直接在备份目录上打开数据库写入
+	HardLink bool
+}
+
+// DefaultBackupOptions 默认的备份配置，完整拷贝所有文件
+var DefaultBackupOptions = BackupOptions{
+	Incremental: "",
+	HardLink:    false,
+}
+
+// BackupManifest 备份清单
+type BackupManifest struct {
+	Version   int          `json:"version"`
+	CreatedAt time.Time    `json:"createdAt"`
+	SeqNo     uint64       `json:"seqNo"`          //备份时的事务序列号
+	IndexType IndexerType  `json:"indexType"`      //备份时的索引类型
+	Base      string       `json:"base,omitempty"` //增量备份依赖的上一次备份目录
+	Files     []BackupFile `json:"files"`          //按照文件名排序
+}
+
+// BackupFile 备份清单中的一个文件
+type BackupFile struct {
+	Name     string    `json:"name"`
+	Size     int64     `json:"size"`
+	ModTime  time.Time `json:"modTime"`       //数据目录中源文件的修改时间，增量备份据此判断文件是否变化
+	Checksum uint32    `json:"checksum"`      //文件内容的crc32
+	Dir      string    `json:"dir,omitempty"` //文件所在的备份目录，为空表示就在当前备份目录中
+}
+
+// BackUp 完整备份数据库，将数据拷贝到新的目录中，备份期间不阻塞写入
+func (db *DB) BackUp(dir string) error {
+	_, err := db.BackUpWithOptions(dir, DefaultBackupOptions)
+	return err
+}
+
+// BackUpWithOptions 按照配置备份数据库，返回备份清单
+// 只在切换活跃文件、记录文件列表的时候短暂持有锁，之后旧的数据文件不会再被修改，拷贝时不需要持有锁
+func (db *DB) BackUpWithOptions(dir string, opts BackupOptions) (*BackupManifest, error) {
+	//备份需要切换活跃文件
+	if db.options.ReadOnly {
+		return nil, ErrDatabaseReadOnly
+	}
+	var base *BackupManifest
+	if opts.Incremental != "" {
+		if filepath.Clean(opts.Incremental) == filepath.Clean(dir) {
+			return nil, ErrBackupBaseIsTarget
+		}
+		manifest, err := ReadBackupManifest(db.fs, opts.Incremental)
+		if err != nil {
+			return nil, err
+		}
+		base = manifest
+	}
+
+	if err := db.fs.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, err
+	}
+	//先删除旧的清单，备份中途失败时目录不会被当作有效的备份
+	manifestPath := filepath.Join(dir, BackupManifestFileName)
+	if err := db.fs.Remove(manifestPath); err != nil && !errors.Is(err, iofs.ErrNotExist) {
+		return nil, err
+	}
+
+	fileNames, seqNo, snapshot, err := db.prepareBackup()
+	if err != nil {
+		return nil, err
+	}
+	if snapshot != nil {
+		defer func() {
+			_ = snapshot.Close()
+		}()
+	}
+
+	manifest := &BackupManifest{
+		Version:   backupManifestVersion,
+		CreatedAt: time.Now(),
+		SeqNo:     seqNo,
+		IndexType: db.options.IndexType,
+	}
+	if base != nil {
+		manifest.Base = opts.Incremental
+	}
+
+	baseFiles := make(map[string]BackupFile)
+	if base != nil {
+		for _, file := range base.Files {
+			baseFiles[file.Name] = file
+		}
+	}
+
+	for _, fileName := range fileNames {
+		srcPath := filepath.Join(db.options.DirPath, fileName)
+		info, err := db.fs.Stat(srcPath)
+		if err != nil {
+			return nil, err
+		}
+
+		file := BackupFile{Name: fileName, Size: info.Size(), ModTime: info.ModTime()}
+		destPath := filepath.Join(dir, fileName)
+		link := opts.HardLink
+		if prev, ok := baseFiles[fileName]; ok && prev.Size == file.Size && prev.ModTime.Equal(file.ModTime) {
+			//文件没有变化，沿用上一次备份的结果
+			file.Checksum = prev.Checksum
+			prevDir := prev.Dir
+			if prevDir == "" {
+				prevDir = opts.Incremental
+			}
+			if !opts.HardLink {
+				file.Dir = prevDir
+				manifest.Files = append(manifest.Files, file)
+				continue
+			}
+			//上一次备份中的文件不会再被修改，总是可以硬链接
+			srcPath = filepath.Join(prevDir, fileName)
+			link = true
+		}
+
+		if link {
+			err = fio.LinkOrCopyFile(db.fs, srcPath, destPath, db.rateLimiter)
+		} else if err = removeIfExists(db.fs, destPath); err == nil {
+			err = fio.CopyFile(db.fs, srcPath, destPath, db.rateLimiter)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		//校验和根据备份之后的文件计算，可以发现拷贝过程中的错误
+		checksum, err := fileChecksum(db.fs, destPath, db.rateLimiter)
+		if err != nil {
+			return nil, err
+		}
+		if file.Checksum != 0 && file.Checksum != checksum {
+			return nil, ErrBackupCorrupted
+		}
+		file.Checksum = checksum
+		manifest.Files = append(manifest.Files, file)
+	}
+
+	//b+树索引是一直在修改的，每次都完整写入快照
+	if snapshot != nil {
+		if err := db.writeIndexSnapshot(dir, snapshot); err != nil {
+			return nil, err
+		}
+		if err := db.writeSeqNoFile(dir, seqNo); err != nil {
+			return nil, err
+		}
+		for _, fileName := range []string{index.BptreeIndexFileName, data.SeqNoFileName} {
+			file, err := statBackupFile(db.fs, dir, fileName, db.rateLimiter)
+			if err != nil {
+				return nil, err
+			}
+			manifest.Files = append(manifest.Files, *file)
+		}
+	}
+
+	sort.Slice(manifest.Files, func(i, j int) bool {
+		return manifest.Files[i].Name < manifest.Files[j].Name
+	})
+	if err := writeBackupManifest(db.fs, dir, manifest); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// 持有锁切换活跃文件，返回需要备份的文件，这些文件在之后都不会再被修改，备份和检查点共用
+func (db *DB) prepareBackup() ([]string, uint64, index.Snapshot, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	//活跃文件有数据时切换新的活跃文件，备份包含调用之前写入的所有数据
+	if db.activeFile != nil && db.activeFile.WriteOff > data.FileHeaderSize {
+		if err := db.activeFile.Sync(); err != nil {
+			return nil, 0, nil, err
+		}
+		if err := db.archiveActiveFile(); err != nil {
+			return nil, 0, nil, err
+		}
+		if err := db.setActiveDataFile(); err != nil {
+			return nil, 0, nil, err
+		}
+	}
+
+	var fileNames []string
+	for fileId := range db.olderFile {
+		fileNames = append(fileNames, filepath.Base(data.GetDataFileName(db.options.DirPath, fileId)))
+	}
+	//merge生成的文件只会在重启加载merge目录时被替换，格式清单只会在升级时被替换
+	for _, fileName := range []string{data.HintFileName, data.MergeFinishedFileName, ManifestFileName} {
+		if _, err := db.fs.Stat(filepath.Join(db.options.DirPath, fileName)); err == nil {
+			fileNames = append(fileNames, fileName)
+		}
+	}
+
+	var snapshot index.Snapshot
+	if snapshotter, ok := db.index.(index.Snapshotter); ok {
+		var err error
+		if snapshot, err = snapshotter.Snapshot(); err != nil {
+			return nil, 0, nil, err
+		}
+	}
+	return fileNames, db.seqNo, snapshot, nil
+}
+
+// 将索引快照写入目录
+func (db *DB) writeIndexSnapshot(dir string, snapshot index.Snapshot) error {
+	fileName := filepath.Join(dir, index.BptreeIndexFileName)
+	if err := removeIfExists(db.fs, fileName); err != nil {
+		return err
+	}
+	file, err := db.fs.OpenFile(fileName, os.O_CREATE|os.O_RDWR|os.O_TRUNC, fio.DataFilePerm)
+	if err != nil {
+		return err
+	}
+	_, err = snapshot.WriteTo(file)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// 将事务序列号写入目录，b+树索引模式下启动时需要加载
+func (db *DB) writeSeqNoFile(dir string, seqNo uint64) error {
+	if err := removeIfExists(db.fs, filepath.Join(dir, data.SeqNoFileName)); err != nil {
+		return err
+	}
+	seqNoFile, err := data.OpenSeqNoFile(db.fs, dir, db.options.Checksum)
+	if err != nil {
+		return err
+	}
+	record := &data.LogRecord{
+		Key:   []byte(seqNoKey),
+		Value: []byte(strconv.FormatUint(seqNo, 10)),
+	}
+	encRecord, _ := seqNoFile.EncodeLogRecord(record)
+	err = seqNoFile.Write(encRecord)
+	if err == nil {
+		err = seqNoFile.Sync()
+	}
+	if closeErr := seqNoFile.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// ReadBackupManifest 读取备份目录中的清单
+func ReadBackupManifest(fs fio.FileSystem, dir string) (*BackupManifest, error) {
+	if fs == nil {
+		fs = fio.OSFileSystem{}
+	}
+	file, err := fs.OpenFile(filepath.Join(dir, BackupManifestFileName), os.O_RDONLY, 0)
+	if err != nil {
+		if errors.Is(err, iofs.ErrNotExist) {
+			return nil, ErrBackupManifestNotFound
+		}
+		return nil, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	manifest := &BackupManifest{}
+	if err := json.NewDecoder(io.NewSectionReader(file, 0, info.Size())).Decode(manifest); err != nil {
+		return nil, ErrBackupCorrupted
+	}
+	if manifest.Version > backupManifestVersion {
+		return nil, ErrBackupVersionUnsupported
+	}
+	return manifest, nil
+}
+
+// 先写临时文件再重命名，保证清单要么完整要么不存在
+func writeBackupManifest(fs fio.FileSystem, dir string, manifest *BackupManifest) error {
+	buf, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmpPath := filepath.Join(dir, BackupManifestFileName+".tmp")
+	file, err := fs.OpenFile(tmpPath, os.O_CREATE|os.O_RDWR|os.O_TRUNC, fio.DataFilePerm)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(buf)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return fs.Rename(tmpPath, filepath.Join(dir, BackupManifestFileName))
+}
+
+// 获取备份目录中文件的信息和校验和
+func statBackupFile(fs fio.FileSystem, dir, fileName string, limiter *utils.RateLimiter) (*BackupFile, error) {
+	path := filepath.Join(dir, fileName)
+	info, err := fs.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	checksum, err := fileChecksum(fs, path, limiter)
+	if err != nil {
+		return nil, err
+	}
+	return &BackupFile{Name: fileName, Size: info.Size(), ModTime: info.ModTime(), Checksum: checksum}, nil
+}
+
+// 计算整个文件的crc32
+func fileChecksum(fs fio.FileSystem, path string, limiter *utils.RateLimiter) (uint32, error) {
+	file, err := fs.OpenFile(path, os.O_RDONLY, 0)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	hash := crc32.NewIEEE()
+	if _, err := io.Copy(hash, utils.NewRateLimitedReader(io.NewSectionReader(file, 0, info.Size()), limiter)); err != nil {
+		return 0, err
+	}
+	return hash.Sum32(), nil
+}
+
+// 目标文件可能是之前备份时创建的硬链接，直接截断会修改和它共享数据的文件，所以先删除
+func removeIfExists(fs fio.FileSystem, path string) error {
+	if err := fs.Remove(path); err != nil && !errors.Is(err, iofs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
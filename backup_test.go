@@ -0,0 +1,302 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/fio"
+	"bitcask-go/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDB_BackUpWithOptions(t *testing.T) {
+	opts := DefaultOptions
+	opts.FileSystem = fio.NewMemFileSystem()
+	opts.DirPath = "/bitcask"
+	opts.DataFileSize = 32 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 1000; i++ {
+		err = db.Put(utils.GetTestKey(i), utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	err = db.Delete(utils.GetTestKey(0))
+	assert.Nil(t, err)
+
+	manifest, err := db.BackUpWithOptions("/backup", DefaultBackupOptions)
+	assert.Nil(t, err)
+	assert.Equal(t, backupManifestVersion, manifest.Version)
+	assert.Greater(t, len(manifest.Files), 1)
+	for _, file := range manifest.Files {
+		assert.Empty(t, file.Dir)
+		checksum, err := fileChecksum(opts.FileSystem, filepath.Join("/backup", file.Name), nil)
+		assert.Nil(t, err)
+		assert.Equal(t, file.Checksum, checksum)
+	}
+
+	//清单可以重新读出来
+	readManifest, err := ReadBackupManifest(opts.FileSystem, "/backup")
+	assert.Nil(t, err)
+	assert.Equal(t, len(manifest.Files), len(readManifest.Files))
+	assert.Equal(t, manifest.SeqNo, readManifest.SeqNo)
+
+	//备份之后的写入不影响备份的内容
+	err = db.Put(utils.GetTestKey(1000), utils.GetTestKey(1000))
+	assert.Nil(t, err)
+
+	backupOpts := opts
+	backupOpts.DirPath = "/backup"
+	db2, err := Open(backupOpts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	assert.Equal(t, 999, len(db2.ListKeys()))
+	_, err = db2.Get(utils.GetTestKey(0))
+	assert.Equal(t, ErrKeyNotFound, err)
+	_, err = db2.Get(utils.GetTestKey(1000))
+	assert.Equal(t, ErrKeyNotFound, err)
+	value, err := db2.Get(utils.GetTestKey(999))
+	assert.Nil(t, err)
+	assert.Equal(t, utils.GetTestKey(999), value)
+
+	//备份目录不完整时读不到清单
+	_, err = ReadBackupManifest(opts.FileSystem, "/not-exist")
+	assert.Equal(t, ErrBackupManifestNotFound, err)
+}
+
+func TestDB_BackUpWithOptions_NotBlockWrites(t *testing.T) {
+	opts := DefaultOptions
+	opts.FileSystem = fio.NewMemFileSystem()
+	opts.DirPath = "/bitcask"
+	opts.DataFileSize = 32 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 2000; i++ {
+		err = db.Put(utils.GetTestKey(i), utils.RandomValue(128))
+		assert.Nil(t, err)
+	}
+
+	//限速之后备份需要一段时间
+	db.SetBackgroundIORate(512 * 1024)
+	done := make(chan error)
+	go func() {
+		_, err := db.BackUpWithOptions("/backup", DefaultBackupOptions)
+		done <- err
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	err = db.Put(utils.GetTestKey(2000), utils.RandomValue(128))
+	assert.Nil(t, err)
+	select {
+	case err := <-done:
+		t.Fatalf("backup finished before write, err: %v", err)
+	default:
+	}
+	assert.Nil(t, <-done)
+}
+
+func TestDB_BackUpWithOptions_Incremental(t *testing.T) {
+	opts := DefaultOptions
+	opts.FileSystem = fio.NewMemFileSystem()
+	opts.DirPath = "/bitcask"
+	opts.DataFileSize = 32 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 1000; i++ {
+		err = db.Put(utils.GetTestKey(i), utils.RandomValue(64))
+		assert.Nil(t, err)
+	}
+	full, err := db.BackUpWithOptions("/backup-full", DefaultBackupOptions)
+	assert.Nil(t, err)
+
+	for i := 1000; i < 1100; i++ {
+		err = db.Put(utils.GetTestKey(i), utils.RandomValue(64))
+		assert.Nil(t, err)
+	}
+
+	//基础备份不存在
+	_, err = db.BackUpWithOptions("/backup-inc", BackupOptions{Incremental: "/not-exist"})
+	assert.Equal(t, ErrBackupManifestNotFound, err)
+	_, err = db.BackUpWithOptions("/backup-full", BackupOptions{Incremental: "/backup-full"})
+	assert.Equal(t, ErrBackupBaseIsTarget, err)
+
+	//只拷贝新增的文件，其他文件指向上一次的备份
+	inc, err := db.BackUpWithOptions("/backup-inc", BackupOptions{Incremental: "/backup-full"})
+	assert.Nil(t, err)
+	assert.Equal(t, "/backup-full", inc.Base)
+	assert.Greater(t, len(inc.Files), len(full.Files))
+	var copied int
+	for _, file := range inc.Files {
+		if file.Dir == "" {
+			copied++
+		} else {
+			assert.Equal(t, "/backup-full", file.Dir)
+		}
+	}
+	assert.Equal(t, len(inc.Files)-len(full.Files), copied)
+	entries, err := opts.FileSystem.ReadDir("/backup-inc")
+	assert.Nil(t, err)
+	assert.Equal(t, copied+1, len(entries))
+
+	//第二次增量备份，没有变化的文件仍然指向最初的备份
+	err = db.Put(utils.GetTestKey(1100), utils.RandomValue(64))
+	assert.Nil(t, err)
+	inc2, err := db.BackUpWithOptions("/backup-inc2", BackupOptions{Incremental: "/backup-inc"})
+	assert.Nil(t, err)
+	dirs := make(map[string]int)
+	for _, file := range inc2.Files {
+		dirs[file.Dir]++
+	}
+	assert.Equal(t, len(full.Files), dirs["/backup-full"])
+	assert.Equal(t, copied, dirs["/backup-inc"])
+	assert.Equal(t, 1, dirs[""])
+
+	//硬链接的增量备份是完整的，可以直接打开读取
+	_, err = db.BackUpWithOptions("/backup-link", BackupOptions{Incremental: "/backup-inc", HardLink: true})
+	assert.Nil(t, err)
+	linkOpts := opts
+	linkOpts.DirPath = "/backup-link"
+	db2, err := Open(linkOpts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	assert.Equal(t, 1101, len(db2.ListKeys()))
+}
+
+func TestDB_BackUpWithOptions_BPlusTree(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-backup-bptree")
+	opts.DirPath = dir
+	opts.IndexType = BPlusTree
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 101; i++ {
+		err = db.Put(utils.GetTestKey(i), utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+
+	backupDir, _ := os.MkdirTemp("", "bitcask-go-backup-bptree-backup")
+	_, err = db.BackUpWithOptions(backupDir, BackupOptions{HardLink: true})
+	assert.Nil(t, err)
+	err = db.Put(utils.GetTestKey(101), utils.GetTestKey(101))
+	assert.Nil(t, err)
+
+	backupOpts := opts
+	backupOpts.DirPath = backupDir
+	db2, err := Open(backupOpts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	assert.Equal(t, 101, len(db2.ListKeys()))
+	assert.Equal(t, db.seqNo, db2.seqNo)
+	value, err := db2.Get(utils.GetTestKey(100))
+	assert.Nil(t, err)
+	assert.Equal(t, utils.GetTestKey(100), value)
+}
+
+func TestDB_BackUpWithOptions_HardLinkMMap(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-backup-mmap")
+	opts.DirPath = dir
+	opts.DataFileSize = 1024 * 1024
+	opts.IOType = fio.MemoryMap
+	db, err := Open(opts)
+	assert.Nil(t, err)
+
+	for i := 0; i < 100; i++ {
+		err = db.Put(utils.GetTestKey(i), utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	backupDir, _ := os.MkdirTemp("", "bitcask-go-backup-mmap-dest")
+	defer os.RemoveAll(backupDir)
+	_, err = db.BackUpWithOptions(backupDir, BackupOptions{HardLink: true})
+	assert.Nil(t, err)
+	srcInfo, err := os.Stat(data.GetDataFileName(dir, 0))
+	assert.Nil(t, err)
+	linkInfo, err := os.Stat(data.GetDataFileName(backupDir, 0))
+	assert.Nil(t, err)
+	assert.True(t, os.SameFile(srcInfo, linkInfo))
+
+	//关闭数据库不能修改和备份共享的文件
+	err = db.Close()
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	restoreDir, _ := os.MkdirTemp("", "bitcask-go-backup-mmap-restore")
+	_ = os.RemoveAll(restoreDir)
+	defer os.RemoveAll(restoreDir)
+	restoreOpts := DefaultRestoreOptions
+	restoreOpts.Options = opts
+	err = Restore(backupDir, restoreDir, restoreOpts)
+	assert.Nil(t, err)
+
+	opts.DirPath = restoreDir
+	db2, err := Open(opts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	assert.Equal(t, 100, len(db2.ListKeys()))
+	value, err := db2.Get(utils.GetTestKey(99))
+	assert.Nil(t, err)
+	assert.Equal(t, utils.GetTestKey(99), value)
+}
+
+func TestDB_BackUpWithOptions_HardLinkAfterClose(t *testing.T) {
+	for _, ioType := range []fio.FileIOType{fio.StandardIO, fio.MemoryMap} {
+		opts := DefaultOptions
+		dir, _ := os.MkdirTemp("", "bitcask-go-backup-link")
+		opts.DirPath = dir
+		opts.DataFileSize = 32 * 1024
+		opts.IOType = ioType
+		db, err := Open(opts)
+		assert.Nil(t, err)
+
+		for i := 0; i < 1000; i++ {
+			err = db.Put(utils.GetTestKey(i), utils.GetTestKey(i))
+			assert.Nil(t, err)
+		}
+		backupDir, _ := os.MkdirTemp("", "bitcask-go-backup-link-dest")
+		_, err = db.BackUpWithOptions(backupDir, BackupOptions{HardLink: true})
+		assert.Nil(t, err)
+
+		//归档的数据文件不会再被修改，任何IO类型都使用硬链接
+		srcInfo, err := os.Stat(data.GetDataFileName(dir, 0))
+		assert.Nil(t, err)
+		linkInfo, err := os.Stat(data.GetDataFileName(backupDir, 0))
+		assert.Nil(t, err)
+		assert.True(t, os.SameFile(srcInfo, linkInfo))
+
+		//源数据库继续写入并关闭之后，备份仍然可以恢复
+		for i := 0; i < 100; i++ {
+			err = db.Put(utils.GetTestKey(i), []byte("after-backup"))
+			assert.Nil(t, err)
+		}
+		assert.Nil(t, db.Close())
+
+		restoreDir := backupDir + "-restore"
+		restoreOpts := DefaultRestoreOptions
+		restoreOpts.Options = opts
+		err = Restore(backupDir, restoreDir, restoreOpts)
+		assert.Nil(t, err)
+
+		restoredOpts := opts
+		restoredOpts.DirPath = restoreDir
+		db2, err := Open(restoredOpts)
+		assert.Nil(t, err)
+		assert.Equal(t, 1000, len(db2.ListKeys()))
+		value, err := db2.Get(utils.GetTestKey(0))
+		assert.Nil(t, err)
+		assert.Equal(t, utils.GetTestKey(0), value)
+		destroyDB(db2)
+
+		_ = os.RemoveAll(dir)
+		_ = os.RemoveAll(backupDir)
+	}
+}
@@ -2,6 +2,7 @@ package bitcask_go
 
 import (
 	"bitcask-go/data"
+	"bytes"
 	"encoding/binary"
 	"sync"
 	"sync/atomic"
@@ -16,6 +17,7 @@ type WriteBatch struct {
 	mu            *sync.Mutex
 	db            *DB
 	pendingWrites map[string]*data.LogRecord //暂存用户写入的数据
+	expects       map[string][]byte          //提交时需要满足的条件，值为nil表示key不存在
 }
 
 // NewWriteBatch 初始化
@@ -30,9 +32,27 @@ func (db *DB) NewWriteBatch(opts WriteBatchOptions) *WriteBatch {
 		mu:            new(sync.Mutex),
 		db:            db,
 		pendingWrites: map[string]*data.LogRecord{},
+		expects:       map[string][]byte{},
 	}
 }
 
+// Expect 提交时要求key当前的值等于value，value为nil时要求key不存在
+// 条件不满足时Commit返回ErrBatchConditionFailed，不写入任何数据
+func (wb *WriteBatch) Expect(key []byte, value []byte) error {
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+
+	wb.mu.Lock()
+	defer wb.mu.Unlock()
+
+	if value != nil {
+		value = append([]byte{}, value...)
+	}
+	wb.expects[string(key)] = value
+	return nil
+}
+
 // Put 批量写数据
 func (wb *WriteBatch) Put(key []byte, value []byte) error {
 	if len(key) == 0 {
@@ -76,10 +96,13 @@ func (wb *WriteBatch) Delete(key []byte) error {
 
 // Commit 提交事务 将暂存的数据写到数据文件 更新索引
 func (wb *WriteBatch) Commit() error {
+	if wb.db.options.ReadOnly {
+		return ErrDatabaseReadOnly
+	}
 	wb.mu.Lock()
 	wb.mu.Unlock()
 
-	if len(wb.pendingWrites) == 0 {
+	if len(wb.pendingWrites) == 0 && len(wb.expects) == 0 {
 		return nil
 	}
 
@@ -90,6 +113,17 @@ func (wb *WriteBatch) Commit() error {
 	//加锁保证事务提交的串行化
 	wb.mu.Lock()
 	defer wb.mu.Unlock()
+	//和单条写入共用数据库的锁，保证条件写入等操作的原子性
+	wb.db.mu.Lock()
+	defer wb.db.mu.Unlock()
+
+	//检查和写入在同一个临界区内完成
+	if err := wb.checkExpects(); err != nil {
+		return err
+	}
+	if len(wb.pendingWrites) == 0 {
+		return nil
+	}
 
 	//实际写入数据
 	//获取当前最新事务序列号
@@ -150,10 +184,31 @@ func (wb *WriteBatch) Commit() error {
 
 	//清空暂存的数据 方便下一次commit
 	wb.pendingWrites = make(map[string]*data.LogRecord)
+	wb.expects = make(map[string][]byte)
 
 	return nil
 }
 
+// 检查提交的条件，调用方需要持有数据库的锁
+func (wb *WriteBatch) checkExpects() error {
+	for key, expected := range wb.expects {
+		value, err := wb.db.get([]byte(key))
+		if err != nil && err != ErrKeyNotFound {
+			return err
+		}
+		if expected == nil {
+			if err != ErrKeyNotFound {
+				return ErrBatchConditionFailed
+			}
+			continue
+		}
+		if err == ErrKeyNotFound || !bytes.Equal(value, expected) {
+			return ErrBatchConditionFailed
+		}
+	}
+	return nil
+}
+
 // key+Seq Number编码 将事务序列号编码到key前面
 func logRecordKeyWithSeq(key []byte, seqNo uint64) []byte {
 	seq := make([]byte, binary.MaxVarintLen64)
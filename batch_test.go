@@ -3,6 +3,7 @@ package bitcask_go
 import (
 	"bitcask-go/utils"
 	"github.com/stretchr/testify/assert"
+	"os"
 	"testing"
 )
 
@@ -118,3 +119,45 @@ func TestDB_WriteBatch3(t *testing.T) {
 	//err = wb.Commit()
 	//assert.Nil(t, err)
 }
+
+func TestDB_WriteBatchExpect(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-batch-expect")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	err = db.Put(utils.GetTestKey(1), []byte("a"))
+	assert.Nil(t, err)
+
+	// 1.条件满足时正常提交
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Expect(utils.GetTestKey(1), []byte("a")))
+	assert.Nil(t, wb.Expect(utils.GetTestKey(2), nil))
+	assert.Nil(t, wb.Put(utils.GetTestKey(1), []byte("b")))
+	assert.Nil(t, wb.Put(utils.GetTestKey(2), []byte("b")))
+	assert.Nil(t, wb.Commit())
+	val, err := db.Get(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("b"), val)
+
+	// 2.值不匹配或者 key 已经存在时不写入任何数据
+	wb = db.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Expect(utils.GetTestKey(1), []byte("a")))
+	assert.Nil(t, wb.Put(utils.GetTestKey(3), []byte("c")))
+	assert.Equal(t, ErrBatchConditionFailed, wb.Commit())
+	_, err = db.Get(utils.GetTestKey(3))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	wb = db.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Expect(utils.GetTestKey(2), nil))
+	assert.Nil(t, wb.Put(utils.GetTestKey(3), []byte("c")))
+	assert.Equal(t, ErrBatchConditionFailed, wb.Commit())
+
+	// 3.key 不存在时期望的值不匹配
+	wb = db.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Expect(utils.GetTestKey(4), []byte{}))
+	assert.Equal(t, ErrBatchConditionFailed, wb.Commit())
+}
@@ -0,0 +1,79 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/fio"
+	"os"
+	"path/filepath"
+)
+
+// Checkpoint 在dir中生成数据库当前状态的检查点，可以直接用Open打开
+// 切换活跃文件之后，旧的数据文件和hint文件都不会再被修改，通过硬链接放到检查点目录中，
+// 不在同一个文件系统时退化为拷贝，dir必须不存在或者为空
+// 检查点中额外创建一个空的活跃文件，打开检查点之后的写入不会修改和数据目录共享的文件
+func (db *DB) Checkpoint(dir string) error {
+	if db.options.ReadOnly {
+		return ErrDatabaseReadOnly
+	}
+	if entries, err := db.fs.ReadDir(dir); err == nil && len(entries) > 0 {
+		return ErrCheckpointDirNotEmpty
+	}
+	if err := db.fs.MkdirAll(dir, os.ModePerm); err != nil {
+		return err
+	}
+
+	fileNames, seqNo, snapshot, err := db.prepareBackup()
+	if err != nil {
+		return err
+	}
+	if snapshot != nil {
+		defer func() {
+			_ = snapshot.Close()
+		}()
+	}
+
+	finished := false
+	defer func() {
+		if !finished {
+			_ = db.fs.RemoveAll(dir)
+		}
+	}()
+
+	var nextFileId uint32
+	for _, fileName := range fileNames {
+		srcPath, destPath := filepath.Join(db.options.DirPath, fileName), filepath.Join(dir, fileName)
+		if err = fio.LinkOrCopyFile(db.fs, srcPath, destPath, db.rateLimiter); err != nil {
+			return err
+		}
+		if fileId, ok := parseDataFileId(fileName); ok && fileId >= nextFileId {
+			nextFileId = fileId + 1
+		}
+	}
+
+	if snapshot != nil {
+		if err := db.writeIndexSnapshot(dir, snapshot); err != nil {
+			return err
+		}
+		if err := db.writeSeqNoFile(dir, seqNo); err != nil {
+			return err
+		}
+	}
+
+	//文件id最大的数据文件会作为活跃文件，不能是硬链接
+	if nextFileId > 0 {
+		activeFile, err := data.OpenDataFile(db.fs, dir, nextFileId, fio.StandardIO, db.options.Checksum)
+		if err != nil {
+			return err
+		}
+		err = activeFile.Sync()
+		if closeErr := activeFile.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	finished = true
+	return nil
+}
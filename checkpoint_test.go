@@ -0,0 +1,200 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/fio"
+	"bitcask-go/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDB_Checkpoint(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-checkpoint")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	db, err := Open(opts)
+	defer func() {
+		destroyDB(db)
+	}()
+	assert.Nil(t, err)
+
+	for i := 0; i < 1000; i++ {
+		err = db.Put(utils.GetTestKey(i), utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+
+	checkpointDir := filepath.Join(os.TempDir(), "bitcask-go-checkpoint-target")
+	err = db.Checkpoint(checkpointDir)
+	assert.Nil(t, err)
+	err = db.Checkpoint(checkpointDir)
+	assert.Equal(t, ErrCheckpointDirNotEmpty, err)
+
+	//旧的数据文件是硬链接
+	srcInfo, err := os.Stat(data.GetDataFileName(dir, 0))
+	assert.Nil(t, err)
+	linkInfo, err := os.Stat(data.GetDataFileName(checkpointDir, 0))
+	assert.Nil(t, err)
+	assert.True(t, os.SameFile(srcInfo, linkInfo))
+
+	err = db.Put(utils.GetTestKey(1000), utils.GetTestKey(1000))
+	assert.Nil(t, err)
+
+	cpOpts := opts
+	cpOpts.DirPath = checkpointDir
+	db2, err := Open(cpOpts)
+	defer func() {
+		destroyDB(db2)
+	}()
+	assert.Nil(t, err)
+	assert.Equal(t, 1000, len(db2.ListKeys()))
+
+	//检查点中的写入不影响原来的数据
+	for i := 0; i < 100; i++ {
+		err = db2.Put(utils.GetTestKey(i), []byte("checkpoint"))
+		assert.Nil(t, err)
+	}
+	assert.Nil(t, db2.Close())
+	db2, err = Open(cpOpts)
+	assert.Nil(t, err)
+	value, err := db2.Get(utils.GetTestKey(0))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("checkpoint"), value)
+
+	value, err = db.Get(utils.GetTestKey(0))
+	assert.Nil(t, err)
+	assert.Equal(t, utils.GetTestKey(0), value)
+	assert.Nil(t, db.Close())
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 1001, len(db.ListKeys()))
+	value, err = db.Get(utils.GetTestKey(0))
+	assert.Nil(t, err)
+	assert.Equal(t, utils.GetTestKey(0), value)
+}
+
+func TestDB_Checkpoint_MemFileSystem(t *testing.T) {
+	opts := DefaultOptions
+	opts.FileSystem = fio.NewMemFileSystem()
+	opts.DirPath = "/bitcask"
+	opts.DataFileMergeRatio = 0
+	db, err := Open(opts)
+	defer func() {
+		destroyDB(db)
+	}()
+	assert.Nil(t, err)
+
+	//空的数据库
+	err = db.Checkpoint("/checkpoint-empty")
+	assert.Nil(t, err)
+
+	for i := 0; i < 100; i++ {
+		err = db.Put(utils.GetTestKey(i%10), utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	assert.Nil(t, db.Merge())
+	assert.Nil(t, db.Close())
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	err = db.Put(utils.GetTestKey(10), utils.GetTestKey(10))
+	assert.Nil(t, err)
+
+	err = db.Checkpoint("/checkpoint")
+	assert.Nil(t, err)
+	_, err = opts.FileSystem.Stat(filepath.Join("/checkpoint", data.HintFileName))
+	assert.Nil(t, err)
+
+	cpOpts := opts
+	cpOpts.DirPath = "/checkpoint"
+	db2, err := Open(cpOpts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	assert.Equal(t, 11, len(db2.ListKeys()))
+	value, err := db2.Get(utils.GetTestKey(9))
+	assert.Nil(t, err)
+	assert.Equal(t, utils.GetTestKey(99), value)
+}
+
+func TestDB_Checkpoint_BPlusTree(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-checkpoint-bptree")
+	opts.DirPath = dir
+	opts.IndexType = BPlusTree
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 100; i++ {
+		err = db.Put(utils.GetTestKey(i), utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+
+	checkpointDir := filepath.Join(os.TempDir(), "bitcask-go-checkpoint-bptree-target")
+	err = db.Checkpoint(checkpointDir)
+	assert.Nil(t, err)
+	err = db.Put(utils.GetTestKey(100), utils.GetTestKey(100))
+	assert.Nil(t, err)
+
+	cpOpts := opts
+	cpOpts.DirPath = checkpointDir
+	db2, err := Open(cpOpts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	assert.Equal(t, 100, len(db2.ListKeys()))
+	err = db2.Put(utils.GetTestKey(101), utils.GetTestKey(101))
+	assert.Nil(t, err)
+	value, err := db2.Get(utils.GetTestKey(101))
+	assert.Nil(t, err)
+	assert.Equal(t, utils.GetTestKey(101), value)
+}
+
+func TestDB_Checkpoint_AfterClose(t *testing.T) {
+	for _, ioType := range []fio.FileIOType{fio.StandardIO, fio.MemoryMap} {
+		opts := DefaultOptions
+		dir, _ := os.MkdirTemp("", "bitcask-go-checkpoint-close")
+		opts.DirPath = dir
+		opts.DataFileSize = 32 * 1024
+		opts.IOType = ioType
+		db, err := Open(opts)
+		assert.Nil(t, err)
+
+		for i := 0; i < 1000; i++ {
+			err = db.Put(utils.GetTestKey(i), utils.GetTestKey(i))
+			assert.Nil(t, err)
+		}
+		checkpointDir := dir + "-checkpoint"
+		//打开检查点失败时也要删除
+		defer os.RemoveAll(checkpointDir)
+		err = db.Checkpoint(checkpointDir)
+		assert.Nil(t, err)
+
+		//归档的数据文件不会再被修改，任何IO类型都使用硬链接
+		srcInfo, err := os.Stat(data.GetDataFileName(dir, 0))
+		assert.Nil(t, err)
+		linkInfo, err := os.Stat(data.GetDataFileName(checkpointDir, 0))
+		assert.Nil(t, err)
+		assert.True(t, os.SameFile(srcInfo, linkInfo))
+
+		//源数据库继续写入并关闭之后，检查点仍然可以打开
+		for i := 0; i < 100; i++ {
+			err = db.Put(utils.GetTestKey(i), []byte("after-checkpoint"))
+			assert.Nil(t, err)
+		}
+		assert.Nil(t, db.Close())
+		_ = os.RemoveAll(dir)
+
+		cpOpts := opts
+		cpOpts.DirPath = checkpointDir
+		db2, err := Open(cpOpts)
+		assert.Nil(t, err)
+		assert.Equal(t, 1000, len(db2.ListKeys()))
+		for i := 0; i < 1000; i++ {
+			value, err := db2.Get(utils.GetTestKey(i))
+			assert.Nil(t, err)
+			assert.Equal(t, utils.GetTestKey(i), value)
+		}
+		destroyDB(db2)
+	}
+}
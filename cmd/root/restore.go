@@ -0,0 +1,60 @@
+package root
+
+import (
+	bitcask_go "bitcask-go"
+	"bitcask-go/index"
+	"fmt"
+	"github.com/spf13/cobra"
+	"os"
+	"time"
+)
+
+var restoreBackupDir, restoreDirPath, restoreIndexType, restoreUntilTime string
+var restoreUntilSeq *uint64
+
+var restoreCmd = &cobra.Command{
+	Use:   "restore",
+	Short: "restore ruri data from a backup",
+	Long:  "Restore validates the backup manifest, copies the data files into an empty directory and rebuilds the index. It can stop at a sequence number or a point in time.",
+	Run: func(cmd *cobra.Command, args []string) {
+		if restoreBackupDir == "" || restoreDirPath == "" {
+			fmt.Println("both the backup directory and the target directory are required")
+			os.Exit(1)
+		}
+
+		opts := bitcask_go.DefaultRestoreOptions
+		switch restoreIndexType {
+		case "btree":
+			opts.Options.IndexType = index.Btree
+		case "art":
+			opts.Options.IndexType = index.ART
+		case "bptree":
+			opts.Options.IndexType = index.BPTree
+		}
+		opts.UntilSeq = *restoreUntilSeq
+		if restoreUntilTime != "" {
+			until, err := time.Parse(time.RFC3339, restoreUntilTime)
+			if err != nil {
+				fmt.Printf("Unable to parse time: %s, please use RFC3339 format, e.g. 2006-01-02T15:04:05Z07:00 \n", restoreUntilTime)
+				os.Exit(1)
+			}
+			opts.UntilTime = until
+		}
+
+		if err := bitcask_go.Restore(restoreBackupDir, restoreDirPath, opts); err != nil {
+			fmt.Printf("Restore failed: %v \n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Restore %s to %s success \n", restoreBackupDir, restoreDirPath)
+	},
+}
+
+func init() {
+	restoreCmd.Flags().StringVarP(&restoreBackupDir, "backup", "b", "", "Directory of the backup to restore from")
+	restoreCmd.Flags().StringVarP(&restoreDirPath, "dpath", "d", "", "Directory to restore into, must be empty or not exist")
+	restoreCmd.Flags().StringVarP(&restoreIndexType, "itype", "t", "btree", "Type of memory index of the restored data (bptree/btree/art)")
+	restoreUntilSeq = restoreCmd.Flags().Uint64P("until-seq", "", 0, "Only restore records up to this sequence number (0 means all)")
+	restoreCmd.Flags().StringVarP(&restoreUntilTime, "until-time", "", "", "Only restore data files finished before this time in RFC3339 format (optional)")
+
+	AddCommands(restoreCmd)
+}
@@ -3,6 +3,7 @@ package root
 import (
 	bitcask_go "bitcask-go"
 	"bitcask-go/cmd/server"
+	"bitcask-go/fio"
 	"bitcask-go/index"
 	"fmt"
 	"github.com/spf13/cobra"
@@ -13,11 +14,12 @@ import (
 )
 
 var configFile string
-var cmdIndexType, cmdDirPath, cmdPort string
+var cmdIndexType, cmdIOType, cmdDirPath, cmdPort string
 var cmdSyncWrites, cmdMMapAtStartUp *bool
 var cmdDataFileMergeRatio *float32
 var cmdBytesPerSync *uint
-var cmdDataFileSize *int64
+var cmdDataFileSize, cmdBackgroundIORate *int64
+var cmdDatabases *int
 
 var standaloneCmd = &cobra.Command{
 	Use:   "standalone",
@@ -35,6 +37,7 @@ var standaloneCmd = &cobra.Command{
 			}
 		} else {
 			viper.Set("standalone.port", cmdPort)
+			viper.Set("standalone.databases", *cmdDatabases)
 
 			viper.Set("engine.dirPath", cmdDirPath)
 			viper.Set("engine.dataFileSize", *cmdDataFileSize)
@@ -42,11 +45,14 @@ var standaloneCmd = &cobra.Command{
 			viper.Set("engine.bytesPerSync", *cmdBytesPerSync)
 			viper.Set("engine.indexType", cmdIndexType)
 			viper.Set("engine.mMapAtStartUp", *cmdMMapAtStartUp)
+			viper.Set("engine.ioType", cmdIOType)
 			viper.Set("engine.dataFileMergeRatio", *cmdDataFileMergeRatio)
+			viper.Set("engine.backgroundIORate", *cmdBackgroundIORate)
 		}
 
 		//读取配置
 		addr := viper.GetString("standalone.addr")
+		databases := viper.GetInt("standalone.databases")
 
 		dirPath := viper.GetString("engine.dirPath")
 		dataFileSize := viper.GetInt64("engine.dataFileSize")
@@ -54,7 +60,9 @@ var standaloneCmd = &cobra.Command{
 		bytesPerSync := viper.GetUint("engine.bytesPerSync")
 		indexType := viper.GetString("engine.indexType")
 		mMapAtStartUp := viper.GetBool("engine.mMapAtStartUp")
+		ioType := viper.GetString("engine.ioType")
 		dataFileMergeRatio := viper.GetFloat64("engine.dataFileMergeRatio")
+		backgroundIORate := viper.GetInt64("engine.backgroundIORate")
 
 		bcOpt := bitcask_go.Options{
 			DirPath:            dirPath,
@@ -63,6 +71,7 @@ var standaloneCmd = &cobra.Command{
 			BytesPerSync:       bytesPerSync,
 			MMapAtStartUp:      mMapAtStartUp,
 			DataFileMergeRatio: float32(dataFileMergeRatio),
+			BackgroundIORate:   backgroundIORate,
 		}
 
 		switch indexType {
@@ -74,6 +83,15 @@ var standaloneCmd = &cobra.Command{
 			bcOpt.IndexType = index.BPTree
 		}
 
+		switch ioType {
+		case "mmap":
+			bcOpt.IOType = fio.MemoryMap
+		case "direct":
+			bcOpt.IOType = fio.DirectIO
+		default:
+			bcOpt.IOType = fio.StandardIO
+		}
+
 		if addr == "" {
 			log.Printf("unable to get addr\n")
 			return
@@ -81,6 +99,7 @@ var standaloneCmd = &cobra.Command{
 		runOpt := &server.RunOptions{
 			StandaloneOpt: bcOpt,
 			Addr:          addr,
+			Databases:     databases,
 		}
 
 		server.StartEngine(runOpt)
@@ -90,14 +109,17 @@ var standaloneCmd = &cobra.Command{
 func init() {
 	standaloneCmd.Flags().StringVarP(&configFile, "cpath", "c", "", "Path of the configuration file in yaml, json and toml format (optional)")
 	standaloneCmd.Flags().StringVarP(&cmdPort, "port", "p", ":9736", "Address of the host on the network (For example 192.168.1.151:9736) [default 0.0.0.0:9736]")
+	cmdDatabases = standaloneCmd.Flags().IntP("databases", "", 16, "Number of logical databases, database N>0 is stored in the directory <dpath>-dbN")
 
 	standaloneCmd.Flags().StringVarP(&cmdDirPath, "dpath", "d", "./store", "Directory Path where data logs are stored [default at ./datafile]")
 	standaloneCmd.Flags().StringVarP(&cmdIndexType, "itype", "t", "btree", "Type of memory index (bptree/btree/art)")
+	standaloneCmd.Flags().StringVarP(&cmdIOType, "iotype", "", "standard", "Type of data file io (standard/mmap/direct)")
 	cmdDataFileSize = standaloneCmd.Flags().Int64P("size", "", 268435456, "Maximum byte size per datafile (unit: Byte) [default 256MB]")
 	cmdSyncWrites = standaloneCmd.Flags().BoolP("sync", "", false, "Whether to enable write synchronization (true/false)")
 	cmdBytesPerSync = standaloneCmd.Flags().UintP("bytes", "", 0, "How many bytes are accumulated after they are persisted")
 	cmdMMapAtStartUp = standaloneCmd.Flags().BoolP("mmap", "", true, "Whether mmap is enabled")
 	cmdDataFileMergeRatio = standaloneCmd.Flags().Float32P("merge", "", 0.5, "The threshold for data file merging")
+	cmdBackgroundIORate = standaloneCmd.Flags().Int64P("bgrate", "", 0, "Bytes per second read by merge and backup (0 means unlimited)")
 
 	AddCommands(standaloneCmd)
 
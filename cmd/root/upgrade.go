@@ -0,0 +1,52 @@
+package root
+
+import (
+	bitcask_go "bitcask-go"
+	"bitcask-go/index"
+	"fmt"
+	"github.com/spf13/cobra"
+	"os"
+)
+
+var upgradeDirPath, upgradeIndexType string
+
+var upgradeCmd = &cobra.Command{
+	Use:   "upgrade",
+	Short: "upgrade ruri data to the current format version",
+	Long:  "Upgrade migrates a data directory written by an older version to the current on-disk format. The database must not be opened while upgrading, and an interrupted upgrade can simply be run again.",
+	Run: func(cmd *cobra.Command, args []string) {
+		if upgradeDirPath == "" {
+			fmt.Println("the data directory is required")
+			os.Exit(1)
+		}
+
+		opts := bitcask_go.DefaultOptions
+		opts.DirPath = upgradeDirPath
+		switch upgradeIndexType {
+		case "btree":
+			opts.IndexType = index.Btree
+		case "art":
+			opts.IndexType = index.ART
+		case "bptree":
+			opts.IndexType = index.BPTree
+		}
+
+		if err := bitcask_go.Upgrade(opts); err != nil {
+			fmt.Printf("Upgrade failed: %v \n", err)
+			os.Exit(1)
+		}
+		manifest, err := bitcask_go.ReadManifest(nil, upgradeDirPath)
+		if err != nil {
+			fmt.Printf("Upgrade failed: %v \n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Upgrade %s to format version %d success \n", upgradeDirPath, manifest.FormatVersion)
+	},
+}
+
+func init() {
+	upgradeCmd.Flags().StringVarP(&upgradeDirPath, "dpath", "d", "", "Directory of the data to upgrade")
+	upgradeCmd.Flags().StringVarP(&upgradeIndexType, "itype", "t", "btree", "Type of memory index of the data (bptree/btree/art), the b+tree index file is rebuilt if it exists")
+
+	AddCommands(upgradeCmd)
+}
@@ -0,0 +1,139 @@
+package server
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/tidwall/redcon"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 记录回复的连接，没有用到的方法不实现
+type testConn struct {
+	redcon.Conn
+	ctx     interface{}
+	replies []string
+}
+
+func (c *testConn) RemoteAddr() string       { return "test" }
+func (c *testConn) Context() interface{}     { return c.ctx }
+func (c *testConn) SetContext(v interface{}) { c.ctx = v }
+func (c *testConn) WriteError(msg string)    { c.replies = append(c.replies, "-"+msg) }
+func (c *testConn) WriteString(str string)   { c.replies = append(c.replies, "+"+str) }
+func (c *testConn) WriteArray(count int)     { c.replies = append(c.replies, "*"+strconv.Itoa(count)) }
+func (c *testConn) WriteNull()               { c.replies = append(c.replies, "$-1") }
+func (c *testConn) WriteAny(v interface{}) {
+	if b, ok := v.([]byte); ok {
+		v = string(b)
+	}
+	c.replies = append(c.replies, fmt.Sprint(v))
+}
+
+func newTestConn(svr *BitcaskServer) *testConn {
+	conn := &testConn{}
+	svr.accept(conn)
+	return conn
+}
+
+// 执行一条命令，返回所有的回复
+func (c *testConn) do(args ...string) string {
+	c.replies = nil
+	cmd := redcon.Command{}
+	for _, arg := range args {
+		cmd.Args = append(cmd.Args, []byte(arg))
+	}
+	execClientCommand(c, cmd)
+	return strings.Join(c.replies, " ")
+}
+
+func TestExec_Abort(t *testing.T) {
+	svr := openTestServer(t, t.TempDir())
+	defer closeTestServer(svr)
+	conn := newTestConn(svr)
+
+	assert.Equal(t, "+OK", conn.do("multi"))
+	assert.Equal(t, "+QUEUED", conn.do("set", "key", "value"))
+	assert.True(t, strings.HasPrefix(conn.do("not-exist"), "-"))
+	assert.True(t, strings.HasPrefix(conn.do("select", "1"), "-"))
+	assert.Equal(t, "-"+errExecAbort.Error(), conn.do("exec"))
+	assert.Equal(t, "$-1", conn.do("get", "key"))
+
+	// 放弃之后不在事务中
+	assert.Equal(t, "-"+errExecWithoutMulti.Error(), conn.do("exec"))
+}
+
+func TestExec_WatchModified(t *testing.T) {
+	svr := openTestServer(t, t.TempDir())
+	defer closeTestServer(svr)
+	conn, other := newTestConn(svr), newTestConn(svr)
+
+	assert.Equal(t, "OK", conn.do("set", "key", "1"))
+	assert.Equal(t, "+OK", conn.do("watch", "key"))
+	assert.Equal(t, "OK", other.do("set", "key", "2"))
+	assert.Equal(t, "+OK", conn.do("multi"))
+	assert.Equal(t, "+QUEUED", conn.do("set", "key", "3"))
+	assert.Equal(t, "*-1", conn.do("exec"))
+	assert.Equal(t, "2", conn.do("get", "key"))
+
+	// EXEC 之后取消 WATCH，没有修改时正常执行
+	assert.Equal(t, "+OK", conn.do("watch", "key"))
+	assert.Equal(t, "+OK", conn.do("multi"))
+	assert.Equal(t, "+QUEUED", conn.do("incr", "key"))
+	assert.Equal(t, "*1 3", conn.do("exec"))
+	assert.Equal(t, "OK", other.do("set", "key", "4"))
+	assert.Equal(t, "+OK", conn.do("multi"))
+	assert.Equal(t, "+QUEUED", conn.do("incr", "key"))
+	assert.Equal(t, "*1 5", conn.do("exec"))
+}
+
+func TestExec_WatchExpired(t *testing.T) {
+	svr := openTestServer(t, t.TempDir())
+	defer closeTestServer(svr)
+	conn := newTestConn(svr)
+	cli := conn.Context().(*BitcaskClient)
+
+	// 过期之后还没有被删除
+	assert.Equal(t, "OK", conn.do("psetex", "key", "50", "value"))
+	assert.Equal(t, "+OK", conn.do("watch", "key"))
+	time.Sleep(60 * time.Millisecond)
+	assert.Equal(t, "+OK", conn.do("multi"))
+	assert.Equal(t, "+QUEUED", conn.do("set", "other", "value"))
+	assert.Equal(t, "*-1", conn.do("exec"))
+	assert.Equal(t, "$-1", conn.do("get", "other"))
+
+	// 主动过期删除 key 时通知 WATCH 的客户端
+	assert.Equal(t, "OK", conn.do("psetex", "key", "50", "value"))
+	assert.Equal(t, "+OK", conn.do("watch", "key"))
+	assert.Eventually(t, func() bool {
+		svr.watchMu.Lock()
+		defer svr.watchMu.Unlock()
+		return cli.dirty
+	}, 2*time.Second, 10*time.Millisecond)
+	assert.Equal(t, "+OK", conn.do("multi"))
+	assert.Equal(t, "+QUEUED", conn.do("set", "other", "value"))
+	assert.Equal(t, "*-1", conn.do("exec"))
+}
+
+func TestDiscard(t *testing.T) {
+	svr := openTestServer(t, t.TempDir())
+	defer closeTestServer(svr)
+	conn, other := newTestConn(svr), newTestConn(svr)
+
+	assert.Equal(t, "-"+errDiscardWithoutMulti.Error(), conn.do("discard"))
+	assert.Equal(t, "OK", conn.do("set", "key", "1"))
+	assert.Equal(t, "+OK", conn.do("multi"))
+	assert.Equal(t, "+QUEUED", conn.do("set", "key", "2"))
+	assert.Equal(t, "+OK", conn.do("discard"))
+	assert.Equal(t, "1", conn.do("get", "key"))
+
+	// DISCARD 同时取消 WATCH
+	assert.Equal(t, "+OK", conn.do("watch", "key"))
+	assert.Equal(t, "+OK", conn.do("multi"))
+	assert.Equal(t, "+OK", conn.do("discard"))
+	assert.Equal(t, "OK", other.do("set", "key", "3"))
+	assert.Equal(t, "+OK", conn.do("multi"))
+	assert.Equal(t, "+QUEUED", conn.do("incr", "key"))
+	assert.Equal(t, "*1 4", conn.do("exec"))
+}
@@ -5,4 +5,5 @@ import bitcask_go "bitcask-go"
 type RunOptions struct {
 	StandaloneOpt bitcask_go.Options
 	Addr          string
+	Databases     int //逻辑数据库的数量，为0时使用默认的数量
 }
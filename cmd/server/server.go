@@ -1,35 +1,55 @@
 package server
 
 import (
+	bitcask_go "bitcask-go"
+	"encoding/json"
+	"errors"
 	"fmt"
 
 	bitcask_redis "bitcask-go/redis"
 	"github.com/tidwall/redcon"
 	"log"
+	"os"
+	"path/filepath"
 	"sync"
 )
 
+// 没有配置时逻辑数据库的数量，和 Redis 默认的数量一致
+const defaultDatabases = 16
+
 type BitcaskServer struct {
-	dbs    map[int]*bitcask_redis.RedisDataStructure
-	server *redcon.Server
-	mu     sync.RWMutex
+	dbs       map[int]*bitcask_redis.RedisDataStructure
+	opts      bitcask_go.Options // 数据库 0 的配置，其他数据库使用相邻的目录
+	databases int                // 逻辑数据库的数量
+	dirs      []int              // 每个数据库使用的数据目录的编号，交换数据库时交换
+	server    *redcon.Server
+	mu        sync.RWMutex // 切换和交换数据库、执行事务时独占，其他命令共享
+
+	watchMu sync.Mutex                               // 保护 WATCH 的状态
+	watched map[watchKey]map[*BitcaskClient]struct{} // 被 WATCH 的 key 和对应的客户端
 }
 
 func StartEngine(opt *RunOptions) {
-	//打开redis数据结构服务
-	redisDataStructure, err := bitcask_redis.NewRedisDataStructure(opt.StandaloneOpt)
-	if err != nil {
-		panic(err)
+	databases := opt.Databases
+	if databases <= 0 {
+		databases = defaultDatabases
 	}
 
 	//初始化bitcaskServer
 	bitcaskServer := &BitcaskServer{
-		dbs: make(map[int]*bitcask_redis.RedisDataStructure),
+		dbs:       make(map[int]*bitcask_redis.RedisDataStructure),
+		watched:   make(map[watchKey]map[*BitcaskClient]struct{}),
+		opts:      opt.StandaloneOpt,
+		databases: databases,
+	}
+
+	//打开redis数据结构服务
+	if err := bitcaskServer.openDatabases(); err != nil {
+		panic(err)
 	}
-	bitcaskServer.dbs[0] = redisDataStructure
 
 	//初始化一个redis服务
-	bitcaskServer.server = redcon.NewServer(opt.Addr, execClientCommand, bitcaskServer.accept, nil)
+	bitcaskServer.server = redcon.NewServer(opt.Addr, execClientCommand, bitcaskServer.accept, bitcaskServer.close)
 	bitcaskServer.Listen()
 
 }
@@ -42,19 +62,18 @@ func (svr *BitcaskServer) accept(conn redcon.Conn) bool {
 	defer svr.mu.Unlock()
 
 	cli.server = svr
+	cli.dbIndex = 0
 	cli.db = svr.dbs[0]
 	conn.SetContext(cli)
 	return true
 
 }
 
+// 连接关闭时取消客户端 WATCH 的 key
 func (svr *BitcaskServer) close(conn redcon.Conn, err error) {
-	for _, db := range svr.dbs {
-		_ = db.Close()
+	if cli, ok := conn.Context().(*BitcaskClient); ok {
+		svr.unwatchAll(cli)
 	}
-
-	//fmt.Println("3")
-	//_ = svr.server.Close()
 }
 
 func (svr *BitcaskServer) Listen() {
@@ -62,3 +81,163 @@ func (svr *BitcaskServer) Listen() {
 
 	_ = svr.server.ListenAndServe()
 }
+
+// 数据库和数据目录的对应关系不合法
+var errInvalidDBMap = errors.New("invalid database directory map")
+
+// 第 index 个数据库的配置，使用对应编号的数据目录
+func (svr *BitcaskServer) dbOptions(index int) bitcask_go.Options {
+	opts := svr.opts
+	opts.DirPath = svr.dbDir(svr.dirs[index])
+	return opts
+}
+
+// 编号为 slot 的数据目录，0 使用配置的目录，其他使用目录名加上编号
+func (svr *BitcaskServer) dbDir(slot int) string {
+	if slot == 0 {
+		return svr.opts.DirPath
+	}
+	return filepath.Clean(svr.opts.DirPath) + fmt.Sprintf("-db%d", slot)
+}
+
+// 记录数据库和数据目录对应关系的文件，放在数据库 0 的目录旁边
+func (svr *BitcaskServer) dbMapPath() string {
+	return filepath.Clean(svr.opts.DirPath) + "-databases"
+}
+
+// 加载数据库和数据目录的对应关系，没有交换过数据库时每个数据库使用自己编号的目录
+func (svr *BitcaskServer) loadDBMap() error {
+	var dirs []int
+	buf, err := os.ReadFile(svr.dbMapPath())
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err == nil {
+		if err = json.Unmarshal(buf, &dirs); err != nil {
+			return err
+		}
+	}
+
+	//对应关系是一个排列，每个数据目录只属于一个数据库
+	used := make(map[int]bool, len(dirs))
+	for _, slot := range dirs {
+		if slot < 0 || slot >= len(dirs) || used[slot] {
+			return errInvalidDBMap
+		}
+		used[slot] = true
+	}
+	//数据库的数量变多时，新增的数据库使用自己编号的目录
+	for i := len(dirs); i < svr.databases; i++ {
+		dirs = append(dirs, i)
+	}
+	svr.dirs = dirs
+	return nil
+}
+
+// 先写临时文件再重命名，崩溃之后读到的要么是写入之前的对应关系，要么是写入之后的
+func saveDBMap(path string, dirs []int) error {
+	buf, err := json.Marshal(dirs)
+	if err != nil {
+		return err
+	}
+
+	tmpPath := path + ".tmp"
+	file, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(buf)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(tmpPath)
+		return err
+	}
+	if err = os.Rename(tmpPath, path); err != nil {
+		_ = os.Remove(tmpPath)
+		return err
+	}
+
+	//持久化重命名
+	dir, err := os.Open(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	err = dir.Sync()
+	if closeErr := dir.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// 打开数据库 0 和已经有数据目录的数据库，其他数据库在第一次使用时打开
+func (svr *BitcaskServer) openDatabases() error {
+	if err := svr.loadDBMap(); err != nil {
+		return err
+	}
+	for i := 0; i < svr.databases; i++ {
+		if i > 0 {
+			if _, err := os.Stat(svr.dbOptions(i).DirPath); err != nil {
+				continue
+			}
+		}
+		if _, err := svr.getDB(i); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 获取第 index 个数据库，还没有打开时打开，调用方需要持有写锁
+func (svr *BitcaskServer) getDB(index int) (*bitcask_redis.RedisDataStructure, error) {
+	if index < 0 || index >= svr.databases {
+		return nil, errDBIndexOutOfRange
+	}
+	if db, ok := svr.dbs[index]; ok {
+		return db, nil
+	}
+
+	db, err := bitcask_redis.NewRedisDataStructure(svr.dbOptions(index))
+	if err != nil {
+		return nil, err
+	}
+	svr.dbs[index] = db
+	svr.setTouchHook(index)
+	return db, nil
+}
+
+// 后台任务删除过期的 key 时通知 WATCH 这个 key 的客户端，交换数据库之后编号变化，需要重新设置
+func (svr *BitcaskServer) setTouchHook(index int) {
+	svr.dbs[index].SetTouchHook(func(key []byte) {
+		svr.touchKeys(index, [][]byte{key})
+	})
+}
+
+// 交换两个数据库，调用方需要持有写锁
+// 数据目录不移动，只交换打开的实例和数据目录的对应关系，对应关系写入成功之后才生效，重启之后交换的结果仍然有效
+func (svr *BitcaskServer) swapDB(a, b int) error {
+	if _, err := svr.getDB(a); err != nil {
+		return err
+	}
+	if _, err := svr.getDB(b); err != nil {
+		return err
+	}
+	if a == b {
+		return nil
+	}
+
+	dirs := append([]int(nil), svr.dirs...)
+	dirs[a], dirs[b] = dirs[b], dirs[a]
+	if err := saveDBMap(svr.dbMapPath(), dirs); err != nil {
+		return err
+	}
+	svr.dirs = dirs
+	svr.dbs[a], svr.dbs[b] = svr.dbs[b], svr.dbs[a]
+	svr.setTouchHook(a)
+	svr.setTouchHook(b)
+	return nil
+}
@@ -0,0 +1,76 @@
+package server
+
+import (
+	bitcask_go "bitcask-go"
+	bitcask_redis "bitcask-go/redis"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func openTestServer(t *testing.T, dir string) *BitcaskServer {
+	opts := bitcask_go.DefaultOptions
+	opts.DirPath = filepath.Join(dir, "bitcask")
+	svr := &BitcaskServer{
+		dbs:       make(map[int]*bitcask_redis.RedisDataStructure),
+		watched:   make(map[watchKey]map[*BitcaskClient]struct{}),
+		opts:      opts,
+		databases: 4,
+	}
+	assert.Nil(t, svr.openDatabases())
+	return svr
+}
+
+func closeTestServer(svr *BitcaskServer) {
+	for _, db := range svr.dbs {
+		_ = db.Close()
+	}
+}
+
+func TestBitcaskServer_SwapDB(t *testing.T) {
+	dir := t.TempDir()
+	svr := openTestServer(t, dir)
+
+	key := []byte("key")
+	for i, value := range []string{"zero", "one"} {
+		db, err := svr.getDB(i)
+		assert.Nil(t, err)
+		assert.Nil(t, db.Set(key, 0, []byte(value)))
+	}
+	checkValue := func(svr *BitcaskServer, index int, expected string) {
+		db, err := svr.getDB(index)
+		assert.Nil(t, err)
+		value, err := db.Get(key)
+		assert.Nil(t, err)
+		assert.Equal(t, expected, string(value))
+	}
+
+	// 对应关系写入失败时两个数据库都保持原样并且可以继续使用
+	db0, db1 := svr.dbs[0], svr.dbs[1]
+	assert.Nil(t, os.Mkdir(svr.dbMapPath()+".tmp", os.ModePerm))
+	assert.NotNil(t, svr.swapDB(0, 1))
+	assert.Equal(t, db0, svr.dbs[0])
+	assert.Equal(t, db1, svr.dbs[1])
+	checkValue(svr, 0, "zero")
+	checkValue(svr, 1, "one")
+	assert.Nil(t, os.Remove(svr.dbMapPath()+".tmp"))
+
+	assert.Nil(t, svr.swapDB(0, 1))
+	checkValue(svr, 0, "one")
+	checkValue(svr, 1, "zero")
+	// 和还没有数据目录的数据库交换
+	assert.Nil(t, svr.swapDB(1, 3))
+	checkValue(svr, 3, "zero")
+	closeTestServer(svr)
+
+	// 重启之后交换的结果仍然有效
+	svr = openTestServer(t, dir)
+	defer closeTestServer(svr)
+	checkValue(svr, 0, "one")
+	checkValue(svr, 3, "zero")
+	db, err := svr.getDB(1)
+	assert.Nil(t, err)
+	_, err = db.Get(key)
+	assert.Equal(t, bitcask_go.ErrKeyNotFound, err)
+}
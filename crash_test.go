@@ -0,0 +1,194 @@
+package bitcask_go
+
+import (
+	"bitcask-go/fio"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// crashModel 崩溃测试中期望的数据状态
+type crashModel struct {
+	committed map[string]string  //已经成功写入的数据
+	pending   map[string]*string //正在执行的操作，nil表示删除，崩溃后要么全部生效要么全部不生效
+}
+
+func (m *crashModel) apply() {
+	for key, value := range m.pending {
+		if value == nil {
+			delete(m.committed, key)
+		} else {
+			m.committed[key] = *value
+		}
+	}
+	m.pending = make(map[string]*string)
+}
+
+// 返回 pending 全部生效之后的状态
+func (m *crashModel) withPending() map[string]string {
+	state := make(map[string]string)
+	for key, value := range m.committed {
+		state[key] = value
+	}
+	for key, value := range m.pending {
+		if value == nil {
+			delete(state, key)
+		} else {
+			state[key] = *value
+		}
+	}
+	return state
+}
+
+func crashTestOptions(fs fio.FileSystem) Options {
+	opts := DefaultOptions
+	opts.DirPath = "/bitcask"
+	opts.FileSystem = fs
+	opts.DataFileSize = 2 * 1024
+	opts.SyncWrites = true
+	opts.DataFileMergeRatio = 0
+	return opts
+}
+
+// 执行固定的一组操作，在第一个出错的地方返回，model 记录成功的操作
+func runCrashWorkload(opts Options, model *crashModel) error {
+	db, err := Open(opts)
+	if err != nil {
+		return err
+	}
+
+	key := func(i int) string { return fmt.Sprintf("key-%03d", i%17) }
+	for step := 0; step < 80; step++ {
+		value := fmt.Sprintf("value-%03d-%s", step, "bitcask-go-crash-test")
+		switch {
+		case step == 30 || step == 60:
+			err = db.Merge()
+		case step == 45 || step == 70:
+			//重启会加载 merge 目录
+			if err = db.Close(); err == nil {
+				db, err = Open(opts)
+			}
+		case step%7 == 0:
+			//批量写，要么全部可见，要么全部不可见
+			wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+			for j := 0; j < 3; j++ {
+				k, v := key(step+j), fmt.Sprintf("%s-%d", value, j)
+				model.pending[k] = &v
+				_ = wb.Put([]byte(k), []byte(v))
+			}
+			model.pending[key(step+5)] = nil
+			_ = wb.Delete([]byte(key(step + 5)))
+			err = wb.Commit()
+		case step%5 == 0:
+			model.pending[key(step)] = nil
+			err = db.Delete([]byte(key(step)))
+			if err == ErrKeyNotFound {
+				err = nil
+			}
+		default:
+			model.pending[key(step)] = &value
+			err = db.Put([]byte(key(step)), []byte(value))
+		}
+		if err != nil {
+			return err
+		}
+		model.apply()
+	}
+	return db.Close()
+}
+
+func readAll(t *testing.T, db *DB) map[string]string {
+	state := make(map[string]string)
+	for _, key := range db.ListKeys() {
+		value, err := db.Get(key)
+		assert.Nil(t, err)
+		state[string(key)] = string(value)
+	}
+	return state
+}
+
+// 重启之后的数据必须和崩溃前确认写入的数据一致，正在执行的操作要么全部生效，要么全部不生效
+func checkCrashRecovery(t *testing.T, opts Options, model *crashModel, desc string) bool {
+	db, err := Open(opts)
+	if !assert.Nil(t, err, desc) {
+		return false
+	}
+	defer func() {
+		_ = db.Close()
+	}()
+
+	state := readAll(t, db)
+	if !assert.Condition(t, func() bool {
+		return assert.ObjectsAreEqual(model.committed, state) || assert.ObjectsAreEqual(model.withPending(), state)
+	}, "%s: got %v, want %v or %v", desc, state, model.committed, model.withPending()) {
+		return false
+	}
+
+	//恢复之后仍然可以正常写入和 merge
+	if !assert.Nil(t, db.Put([]byte("after-crash"), []byte("ok")), desc) {
+		return false
+	}
+	state["after-crash"] = "ok"
+	if !assert.Nil(t, db.Merge(), desc) {
+		return false
+	}
+	if !assert.Nil(t, db.Close(), desc) {
+		return false
+	}
+	db, err = Open(opts)
+	if !assert.Nil(t, err, desc) {
+		return false
+	}
+	return assert.Equal(t, state, readAll(t, db), desc)
+}
+
+func TestDB_CrashRecovery(t *testing.T) {
+	defer pinTimeMarks()()
+	//先执行一遍，统计总的 IO 操作次数
+	memFS := fio.NewMemFileSystem()
+	faultFS := fio.NewFaultFileSystem(memFS)
+	model := &crashModel{committed: make(map[string]string), pending: make(map[string]*string)}
+	err := runCrashWorkload(crashTestOptions(faultFS), model)
+	assert.Nil(t, err)
+	total := faultFS.Count()
+	t.Log("total io operations: ", total)
+	assert.Greater(t, total, int64(100))
+
+	for _, shortWrite := range []bool{false, true} {
+		for _, dropUnsynced := range []bool{false, true} {
+			for n := int64(1); n <= total; n++ {
+				memFS := fio.NewMemFileSystem()
+				faultFS := fio.NewFaultFileSystem(memFS)
+				faultFS.FailAt(n, fio.FaultOpAll, shortWrite)
+
+				model := &crashModel{committed: make(map[string]string), pending: make(map[string]*string)}
+				err := runCrashWorkload(crashTestOptions(faultFS), model)
+				if !assert.NotNil(t, err) || !assert.True(t, faultFS.Crashed()) {
+					return
+				}
+
+				memFS.Crash(dropUnsynced)
+				desc := fmt.Sprintf("fail at op %d (short write %v, drop unsynced %v): %v", n, shortWrite, dropUnsynced, err)
+				if !checkCrashRecovery(t, crashTestOptions(memFS), model, desc) {
+					return
+				}
+			}
+		}
+	}
+}
+
+func TestDB_CrashRecovery_Rename(t *testing.T) {
+	defer pinTimeMarks()()
+	memFS := fio.NewMemFileSystem()
+	faultFS := fio.NewFaultFileSystem(memFS)
+	//只在第一次重命名时注入故障，也就是 merge 之后重启移动文件的时候
+	faultFS.FailAt(1, fio.FaultOpRename, false)
+
+	opts := crashTestOptions(faultFS)
+	model := &crashModel{committed: make(map[string]string), pending: make(map[string]*string)}
+	err := runCrashWorkload(opts, model)
+	assert.ErrorIs(t, err, fio.ErrInjectedFault)
+
+	memFS.Crash(true)
+	checkCrashRecovery(t, crashTestOptions(memFS), model, "fail first rename")
+}
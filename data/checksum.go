@@ -0,0 +1,144 @@
+package data
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"math/bits"
+)
+
+// ChecksumType 记录使用的校验算法，保存在文件头中
+type ChecksumType = byte
+
+const (
+	// ChecksumCRC32IEEE IEEE多项式的crc32，格式版本2之前的文件都使用这个算法
+	ChecksumCRC32IEEE ChecksumType = iota
+
+	// ChecksumCRC32C Castagnoli多项式的crc32，支持SSE4.2或者ARMv8 CRC指令的机器上有硬件加速
+	ChecksumCRC32C
+
+	// ChecksumXXHash32 xxHash32，没有硬件加速时比crc32更快
+	ChecksumXXHash32
+)
+
+var castagnoliTable = crc32.MakeTable(crc32.Castagnoli)
+
+// ValidChecksumType 是否是支持的校验算法
+func ValidChecksumType(typ ChecksumType) bool {
+	return typ <= ChecksumXXHash32
+}
+
+// 依次计算parts的校验和，等同于把parts拼接起来之后计算
+func checksum(typ ChecksumType, parts ...[]byte) uint32 {
+	switch typ {
+	case ChecksumCRC32C:
+		var crc uint32
+		for _, part := range parts {
+			crc = crc32.Update(crc, castagnoliTable, part)
+		}
+		return crc
+	case ChecksumXXHash32:
+		var d xxh32
+		d.reset(0)
+		for _, part := range parts {
+			d.write(part)
+		}
+		return d.sum()
+	default:
+		var crc uint32
+		for _, part := range parts {
+			crc = crc32.Update(crc, crc32.IEEETable, part)
+		}
+		return crc
+	}
+}
+
+const (
+	xxhPrime1 uint32 = 2654435761
+	xxhPrime2 uint32 = 2246822519
+	xxhPrime3 uint32 = 3266489917
+	xxhPrime4 uint32 = 668265263
+	xxhPrime5 uint32 = 374761393
+)
+
+// xxh32 可以分段写入的xxHash32
+type xxh32 struct {
+	v1, v2, v3, v4 uint32
+	seed           uint32
+	total          uint32   //写入的总长度，只需要低32位
+	mem            [16]byte //不足16字节的数据
+	memSize        int
+}
+
+func (d *xxh32) reset(seed uint32) {
+	d.seed = seed
+	d.v1 = seed + xxhPrime1 + xxhPrime2
+	d.v2 = seed + xxhPrime2
+	d.v3 = seed
+	d.v4 = seed - xxhPrime1
+	d.total = 0
+	d.memSize = 0
+}
+
+func xxhRound(acc, input uint32) uint32 {
+	acc += input * xxhPrime2
+	acc = bits.RotateLeft32(acc, 13)
+	return acc * xxhPrime1
+}
+
+// 每次处理16字节
+func (d *xxh32) stripe(b []byte) {
+	d.v1 = xxhRound(d.v1, binary.LittleEndian.Uint32(b[0:4]))
+	d.v2 = xxhRound(d.v2, binary.LittleEndian.Uint32(b[4:8]))
+	d.v3 = xxhRound(d.v3, binary.LittleEndian.Uint32(b[8:12]))
+	d.v4 = xxhRound(d.v4, binary.LittleEndian.Uint32(b[12:16]))
+}
+
+func (d *xxh32) write(b []byte) {
+	d.total += uint32(len(b))
+
+	//先补齐上一次剩下的数据
+	if d.memSize > 0 {
+		n := copy(d.mem[d.memSize:], b)
+		d.memSize += n
+		b = b[n:]
+		if d.memSize < 16 {
+			return
+		}
+		d.stripe(d.mem[:])
+		d.memSize = 0
+	}
+
+	for len(b) >= 16 {
+		d.stripe(b[:16])
+		b = b[16:]
+	}
+	d.memSize = copy(d.mem[:], b)
+}
+
+func (d *xxh32) sum() uint32 {
+	var h uint32
+	if d.total >= 16 {
+		h = bits.RotateLeft32(d.v1, 1) + bits.RotateLeft32(d.v2, 7) +
+			bits.RotateLeft32(d.v3, 12) + bits.RotateLeft32(d.v4, 18)
+	} else {
+		h = d.seed + xxhPrime5
+	}
+	h += d.total
+
+	b := d.mem[:d.memSize]
+	for ; len(b) >= 4; b = b[4:] {
+		h += binary.LittleEndian.Uint32(b) * xxhPrime3
+		h = bits.RotateLeft32(h, 17) * xxhPrime4
+	}
+	for _, c := range b {
+		h += uint32(c) * xxhPrime5
+		h = bits.RotateLeft32(h, 11) * xxhPrime1
+	}
+
+	h ^= h >> 15
+	h *= xxhPrime2
+	h ^= h >> 13
+	h *= xxhPrime3
+	h ^= h >> 16
+	return h
+}
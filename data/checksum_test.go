@@ -0,0 +1,135 @@
+package data
+
+import (
+	"bitcask-go/fio"
+	"bytes"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"hash/crc32"
+	"os"
+	"testing"
+)
+
+func TestChecksum(t *testing.T) {
+	input := []byte("Nobody inspects the spammish repetition")
+	assert.Equal(t, crc32.ChecksumIEEE(input), checksum(ChecksumCRC32IEEE, input))
+	assert.Equal(t, crc32.Checksum(input, crc32.MakeTable(crc32.Castagnoli)), checksum(ChecksumCRC32C, input))
+
+	//xxHash32的参考值
+	assert.Equal(t, uint32(0x02cc5d05), checksum(ChecksumXXHash32, nil))
+	assert.Equal(t, uint32(0x550d7456), checksum(ChecksumXXHash32, []byte("a")))
+	assert.Equal(t, uint32(0x32d153ff), checksum(ChecksumXXHash32, []byte("abc")))
+	assert.Equal(t, uint32(0xe2293b2f), checksum(ChecksumXXHash32, input))
+
+	//分段计算和整体计算的结果一致
+	for _, typ := range []ChecksumType{ChecksumCRC32IEEE, ChecksumCRC32C, ChecksumXXHash32} {
+		for i := 0; i <= len(input); i++ {
+			for j := i; j <= len(input); j++ {
+				assert.Equal(t, checksum(typ, input), checksum(typ, input[:i], input[i:j], input[j:]))
+			}
+		}
+	}
+}
+
+func TestDataFile_Checksum(t *testing.T) {
+	memFS := fio.NewMemFileSystem()
+	assert.Nil(t, memFS.MkdirAll("/bitcask", os.ModePerm))
+
+	for _, typ := range []ChecksumType{ChecksumCRC32IEEE, ChecksumCRC32C, ChecksumXXHash32} {
+		dataFile, err := OpenDataFile(memFS, "/bitcask", uint32(typ), fio.StandardIO, typ)
+		assert.Nil(t, err)
+		rec := &LogRecord{Key: []byte("name"), Value: bytes.Repeat([]byte("bitcask"), 10)}
+		encRecord, _ := dataFile.EncodeLogRecord(rec)
+		assert.Nil(t, dataFile.Write(encRecord))
+		assert.Nil(t, dataFile.Close())
+
+		//读取时使用文件头中记录的算法，和打开时传入的算法无关
+		dataFile, err = OpenDataFile(memFS, "/bitcask", uint32(typ), fio.StandardIO, ChecksumCRC32IEEE)
+		assert.Nil(t, err)
+		assert.Equal(t, typ, dataFile.Header.Checksum)
+		readRec, _, err := dataFile.ReadLogRecord(FileHeaderSize)
+		assert.Nil(t, err)
+		assert.Equal(t, rec, readRec)
+		assert.Nil(t, dataFile.Close())
+	}
+
+	//格式版本1的文件头没有记录算法，使用IEEE
+	file, err := memFS.OpenFile(GetDataFileName("/bitcask", 10), os.O_CREATE|os.O_RDWR, fio.DataFilePerm)
+	assert.Nil(t, err)
+	_, err = file.Write(EncodeFileHeader(&FileHeader{Version: 1}))
+	assert.Nil(t, err)
+	encRecord, _ := EncodeLogRecord(&LogRecord{Key: []byte("name"), Value: []byte("bitcask")})
+	_, err = file.Write(encRecord)
+	assert.Nil(t, err)
+	assert.Nil(t, file.Close())
+	dataFile, err := OpenDataFile(memFS, "/bitcask", 10, fio.StandardIO, ChecksumCRC32C)
+	assert.Nil(t, err)
+	readRec, _, err := dataFile.ReadLogRecord(FileHeaderSize)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("bitcask"), readRec.Value)
+
+	//不认识的校验算法
+	file, err = memFS.OpenFile(GetDataFileName("/bitcask", 11), os.O_CREATE|os.O_RDWR, fio.DataFilePerm)
+	assert.Nil(t, err)
+	_, err = file.Write(EncodeFileHeader(&FileHeader{Version: FormatVersion, Checksum: ChecksumXXHash32 + 1}))
+	assert.Nil(t, err)
+	assert.Nil(t, file.Close())
+	_, err = OpenDataFile(memFS, "/bitcask", 11, fio.StandardIO, ChecksumCRC32C)
+	assert.Equal(t, ErrUnsupportedChecksum, err)
+}
+
+func benchmarkRecord(valueSize int) *LogRecord {
+	return &LogRecord{
+		Key:   []byte("bitcask-go-benchmark-key"),
+		Value: bytes.Repeat([]byte("v"), valueSize),
+	}
+}
+
+func BenchmarkEncodeLogRecord(b *testing.B) {
+	for _, bc := range []struct {
+		name string
+		typ  ChecksumType
+	}{{"ieee", ChecksumCRC32IEEE}, {"crc32c", ChecksumCRC32C}, {"xxhash32", ChecksumXXHash32}} {
+		for _, valueSize := range []int{128, 4096} {
+			record := benchmarkRecord(valueSize)
+			b.Run(fmt.Sprintf("%s-%d", bc.name, valueSize), func(b *testing.B) {
+				b.SetBytes(int64(len(record.Key) + len(record.Value)))
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					EncodeLogRecordWithChecksum(record, bc.typ)
+				}
+			})
+		}
+	}
+}
+
+func BenchmarkReadLogRecord(b *testing.B) {
+	memFS := fio.NewMemFileSystem()
+	_ = memFS.MkdirAll("/bitcask", os.ModePerm)
+	for _, bc := range []struct {
+		name string
+		typ  ChecksumType
+	}{{"ieee", ChecksumCRC32IEEE}, {"crc32c", ChecksumCRC32C}, {"xxhash32", ChecksumXXHash32}} {
+		for _, valueSize := range []int{128, 4096} {
+			dataFile, err := OpenDataFile(memFS, "/bitcask", uint32(bc.typ)<<8|uint32(valueSize), fio.StandardIO, bc.typ)
+			if err != nil {
+				b.Fatal(err)
+			}
+			record := benchmarkRecord(valueSize)
+			encRecord, _ := dataFile.EncodeLogRecord(record)
+			if err := dataFile.Write(encRecord); err != nil {
+				b.Fatal(err)
+			}
+			b.Run(fmt.Sprintf("%s-%d", bc.name, valueSize), func(b *testing.B) {
+				b.SetBytes(int64(len(record.Key) + len(record.Value)))
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					if _, _, err := dataFile.ReadLogRecord(FileHeaderSize); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+			_ = dataFile.Close()
+		}
+	}
+}
@@ -23,55 +23,120 @@ type DataFile struct {
 	FileId    uint32        //文件id
 	WriteOff  int64         //文件写到了哪个位置
 	IoManager fio.IOManager //io 读写管理
+	Header    *FileHeader   //文件头
 }
 
-// OpenDataFile 打开新的数据文件
-func OpenDataFile(dirPath string, fileId uint32, ioType fio.FileIOType) (*DataFile, error) {
+// OpenDataFile 打开新的数据文件，新创建的文件使用checksumType校验，已有的文件使用文件头中记录的算法
+func OpenDataFile(fs fio.FileSystem, dirPath string, fileId uint32, ioType fio.FileIOType, checksumType ChecksumType) (*DataFile, error) {
 	fileName := GetDataFileName(dirPath, fileId)
-	return newDataFile(fileName, fileId, ioType)
+	return newDataFile(fs, fileName, fileId, ioType, checksumType)
 }
 
 // OpenHintFile 打开Hint文件索引
-func OpenHintFile(dirPath string) (*DataFile, error) {
+func OpenHintFile(fs fio.FileSystem, dirPath string, checksumType ChecksumType) (*DataFile, error) {
 	fileName := filepath.Join(dirPath, HintFileName)
-	return newDataFile(fileName, 0, fio.StandardIO)
+	return newDataFile(fs, fileName, 0, fio.StandardIO, checksumType)
 }
 
-func OpenMergeFinishedFile(dirPath string) (*DataFile, error) {
+func OpenMergeFinishedFile(fs fio.FileSystem, dirPath string, checksumType ChecksumType) (*DataFile, error) {
 	fileName := filepath.Join(dirPath, MergeFinishedFileName)
-	return newDataFile(fileName, 0, fio.StandardIO)
+	return newDataFile(fs, fileName, 0, fio.StandardIO, checksumType)
 }
 
 // OpenSeqNoFile 存储事务序列号文件
-func OpenSeqNoFile(dirPath string) (*DataFile, error) {
+func OpenSeqNoFile(fs fio.FileSystem, dirPath string, checksumType ChecksumType) (*DataFile, error) {
 	fileName := filepath.Join(dirPath, SeqNoFileName)
-	return newDataFile(fileName, 0, fio.StandardIO)
+	return newDataFile(fs, fileName, 0, fio.StandardIO, checksumType)
+}
+
+// OpenLegacyFile 只读打开格式版本0写入的文件，这些文件没有文件头，只用于升级
+func OpenLegacyFile(fs fio.FileSystem, fileName string) (*DataFile, error) {
+	ioManager, err := fio.NewIOManager(fs, fileName, fio.ReadOnlyIO)
+	if err != nil {
+		return nil, err
+	}
+	return &DataFile{IoManager: ioManager, Header: &FileHeader{Checksum: ChecksumCRC32IEEE}}, nil
 }
 
 func GetDataFileName(dirPath string, fileId uint32) string {
 	return filepath.Join(dirPath, fmt.Sprintf("%09d", fileId)+DataFileNameSuffix)
 }
 
-func newDataFile(fileName string, fileId uint32, ioType fio.FileIOType) (*DataFile, error) {
+func newDataFile(fs fio.FileSystem, fileName string, fileId uint32, ioType fio.FileIOType, checksumType ChecksumType) (*DataFile, error) {
 	//初始化IOManager，就是生成对应文件名的.data文件
-	ioManager, err := fio.NewIOManager(fileName, ioType)
+	ioManager, err := fio.NewIOManager(fs, fileName, ioType)
 	if err != nil {
 		return nil, err
 	}
 
-	return &DataFile{
+	dataFile := &DataFile{
 		FileId:    fileId,
 		WriteOff:  0,
 		IoManager: ioManager,
-	}, nil
+	}
+	if err := dataFile.initHeader(ioType, checksumType); err != nil {
+		_ = ioManager.Close()
+		return nil, err
+	}
+	return dataFile, nil
+}
+
+// 新文件写入文件头，已有的文件读取并校验文件头
+func (df *DataFile) initHeader(ioType fio.FileIOType, checksumType ChecksumType) error {
+	size, err := df.IoManager.Size()
+	if err != nil {
+		return err
+	}
+
+	//新创建的文件写入文件头
+	writable := ioType == fio.StandardIO || ioType == fio.MemoryMapRW
+	if size == 0 && writable {
+		df.Header = &FileHeader{Version: FormatVersion, Checksum: checksumType}
+		return df.Write(EncodeFileHeader(df.Header))
+	}
+
+	df.WriteOff = FileHeaderSize
+	return df.ReadHeader()
+}
+
+// ReadHeader 读取并校验文件头，文件头还没有写完整时Header为空，读取记录时当作没有数据
+// 只读实例可能打开写入实例刚刚创建的文件，写入实例崩溃时活跃文件的文件头也可能不完整，加载活跃文件时会重新写入
+func (df *DataFile) ReadHeader() error {
+	size, err := df.IoManager.Size()
+	if err != nil {
+		return err
+	}
+	if size < FileHeaderSize {
+		return nil
+	}
+
+	buf, err := df.readNBytes(FileHeaderSize, 0)
+	if err != nil {
+		return err
+	}
+	header, err := DecodeFileHeader(buf)
+	if err != nil {
+		return err
+	}
+	df.Header = header
+	return nil
+}
+
+// EncodeLogRecord 使用文件头中记录的校验算法对LogRecord进行编码
+func (df *DataFile) EncodeLogRecord(logRecord *LogRecord) ([]byte, int64) {
+	return EncodeLogRecordWithChecksum(logRecord, df.Header.Checksum)
 }
 
 // ReadLogRecord 根据offset偏移地址从数据文件中读取LogRecord
-func (df DataFile) ReadLogRecord(offset int64) (*LogRecord, int64, error) {
+func (df *DataFile) ReadLogRecord(offset int64) (*LogRecord, int64, error) {
 	fileSize, err := df.IoManager.Size()
 	if err != nil {
 		return nil, 0, err
 	}
+	//已经读到了文件末尾，或者文件头还没有写入
+	if offset >= fileSize || df.Header == nil {
+		return nil, 0, io.EOF
+	}
 	//如果LogRecord的长度比默认的LogRecordHeader还小，那么就算出它的实际长度
 	var headerBytes int64 = maxLogRecordHeaderSize
 	if offset+maxLogRecordHeaderSize > fileSize {
@@ -112,7 +177,7 @@ func (df DataFile) ReadLogRecord(offset int64) (*LogRecord, int64, error) {
 	}
 
 	//校验数据的crc是否正确
-	crc := getLogRecordCRC(logRecord, headerBuf[crc32.Size:headerSize])
+	crc := getLogRecordCRC(logRecord, headerBuf[crc32.Size:headerSize], df.Header.Checksum)
 	if crc != header.crc {
 		return nil, 0, ErrInvalidCRC
 	}
@@ -137,7 +202,7 @@ func (df *DataFile) WriteHintRecord(key []byte, pos *LogRecordPos) error {
 		Value: EncodeLogRecordPos(pos),
 	}
 
-	encRecord, _ := EncodeLogRecord(record)
+	encRecord, _ := df.EncodeLogRecord(record)
 
 	return df.Write(encRecord)
 }
@@ -150,12 +215,28 @@ func (df *DataFile) Close() error {
 	return df.IoManager.Close()
 }
 
-func (df *DataFile) SetIOManager(dirPath string, ioType fio.FileIOType) error {
+// Preallocate 预先分配数据文件的空间，只对支持预分配的IOManager生效
+func (df *DataFile) Preallocate(size int64) error {
+	if preallocator, ok := df.IoManager.(fio.Preallocator); ok {
+		return preallocator.Preallocate(size)
+	}
+	return nil
+}
+
+// Advise 设置数据文件的访问模式，只对支持的IOManager生效
+func (df *DataFile) Advise(advice fio.Advice) error {
+	if advisor, ok := df.IoManager.(fio.Advisor); ok {
+		return advisor.Advise(advice)
+	}
+	return nil
+}
+
+func (df *DataFile) SetIOManager(fs fio.FileSystem, dirPath string, ioType fio.FileIOType) error {
 	if err := df.IoManager.Close(); err != nil {
 		return err
 	}
 
-	ioManager, err := fio.NewIOManager(GetDataFileName(dirPath, df.FileId), ioType)
+	ioManager, err := fio.NewIOManager(fs, GetDataFileName(dirPath, df.FileId), ioType)
 	if err != nil {
 		return err
 	}
@@ -8,21 +8,21 @@ import (
 )
 
 func TestOpenDataFile(t *testing.T) {
-	dataFile, err := OpenDataFile("LogRecord", 0, fio.StandardIO)
+	dataFile, err := OpenDataFile(fio.OSFileSystem{}, "LogRecord", 0, fio.StandardIO, ChecksumCRC32IEEE)
 	assert.Nil(t, err)
 	assert.NotNil(t, dataFile)
 
-	dataFile1, err := OpenDataFile("LogRecord", 111, fio.StandardIO)
+	dataFile1, err := OpenDataFile(fio.OSFileSystem{}, "LogRecord", 111, fio.StandardIO, ChecksumCRC32IEEE)
 	assert.Nil(t, err)
 	assert.NotNil(t, dataFile1)
 
-	dataFile2, err := OpenDataFile("LogRecord", 111, fio.StandardIO)
+	dataFile2, err := OpenDataFile(fio.OSFileSystem{}, "LogRecord", 111, fio.StandardIO, ChecksumCRC32IEEE)
 	assert.Nil(t, err)
 	assert.NotNil(t, dataFile2)
 }
 
 func TestDataFile_Write(t *testing.T) {
-	dataFile1, err := OpenDataFile("LogRecord", 111, fio.StandardIO)
+	dataFile1, err := OpenDataFile(fio.OSFileSystem{}, "LogRecord", 111, fio.StandardIO, ChecksumCRC32IEEE)
 	assert.Nil(t, err)
 	assert.NotNil(t, dataFile1)
 
@@ -38,7 +38,7 @@ func TestDataFile_Write(t *testing.T) {
 }
 
 func TestDataFile_Close(t *testing.T) {
-	dataFile, err := OpenDataFile("LogRecord", 123, fio.StandardIO)
+	dataFile, err := OpenDataFile(fio.OSFileSystem{}, "LogRecord", 123, fio.StandardIO, ChecksumCRC32IEEE)
 	assert.Nil(t, err)
 	assert.NotNil(t, dataFile)
 
@@ -51,7 +51,7 @@ func TestDataFile_Close(t *testing.T) {
 }
 
 func TestDataFile_Sync(t *testing.T) {
-	dataFile, err := OpenDataFile(os.TempDir(), 456, fio.StandardIO)
+	dataFile, err := OpenDataFile(fio.OSFileSystem{}, os.TempDir(), 456, fio.StandardIO, ChecksumCRC32IEEE)
 	assert.Nil(t, err)
 	assert.NotNil(t, dataFile)
 
@@ -65,7 +65,7 @@ func TestDataFile_Sync(t *testing.T) {
 }
 
 func TestDataFile_ReadLogRecord(t *testing.T) {
-	dataFile, err := OpenDataFile("kv", 3333, fio.StandardIO)
+	dataFile, err := OpenDataFile(fio.OSFileSystem{}, "kv", 3333, fio.StandardIO, ChecksumCRC32IEEE)
 	assert.Nil(t, err)
 	assert.NotNil(t, dataFile)
 
@@ -81,7 +81,7 @@ func TestDataFile_ReadLogRecord(t *testing.T) {
 
 	t.Log(size1)
 
-	readRec1, readSize1, err := dataFile.ReadLogRecord(0)
+	readRec1, readSize1, err := dataFile.ReadLogRecord(FileHeaderSize)
 	assert.Nil(t, err)
 
 	assert.Equal(t, rec1, readRec1)
@@ -99,7 +99,7 @@ func TestDataFile_ReadLogRecord(t *testing.T) {
 
 	t.Log(size2)
 
-	readRec2, readSize2, err := dataFile.ReadLogRecord(24)
+	readRec2, readSize2, err := dataFile.ReadLogRecord(FileHeaderSize + size1)
 	assert.Nil(t, err)
 	assert.Equal(t, size2, readSize2)
 	assert.Equal(t, rec2, readRec2)
@@ -117,7 +117,7 @@ func TestDataFile_ReadLogRecord(t *testing.T) {
 
 	t.Log(size3)
 
-	readRec3, readSize3, err := dataFile.ReadLogRecord(size1 + size2)
+	readRec3, readSize3, err := dataFile.ReadLogRecord(FileHeaderSize + size1 + size2)
 	assert.Nil(t, err)
 	assert.Equal(t, size3, readSize3)
 	assert.Equal(t, rec3, readRec3)
@@ -0,0 +1,61 @@
+package data
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+)
+
+var (
+	ErrInvalidFileHeader        = errors.New("invalid file header, the file may be corrupted or written by an old version")
+	ErrUnsupportedFormatVersion = errors.New("the file is written by a newer format version")
+	ErrUnsupportedChecksum      = errors.New("the checksum type of the file is not supported")
+)
+
+// FormatVersion 当前的磁盘格式版本，记录格式发生不兼容的变化时递增
+// 0 表示没有文件头的旧格式，1 开始有文件头，2 在文件头中记录校验算法，3 在增量记录中记录增量链的长度，
+// 4 在数据文件中写入时间标记
+const FormatVersion uint32 = 4
+
+// FileHeaderSize 文件头的大小，数据文件中的第一条记录从这个位置开始
+// magic + version + checksum + 保留字段
+// 4 + 4 + 1 + 7
+const FileHeaderSize int64 = 16
+
+var fileMagic = []byte("BKGO")
+
+// FileHeader 数据文件、hint文件等所有记录文件开头的文件头
+type FileHeader struct {
+	Version  uint32       //写入这个文件时的格式版本
+	Checksum ChecksumType //记录使用的校验算法，版本1的文件都是IEEE
+}
+
+// EncodeFileHeader 对文件头进行编码
+func EncodeFileHeader(header *FileHeader) []byte {
+	buf := make([]byte, FileHeaderSize)
+	copy(buf[:len(fileMagic)], fileMagic)
+	binary.LittleEndian.PutUint32(buf[4:8], header.Version)
+	buf[8] = header.Checksum
+	return buf
+}
+
+// DecodeFileHeader 解码并校验文件头，不认识的新版本返回ErrUnsupportedFormatVersion
+func DecodeFileHeader(buf []byte) (*FileHeader, error) {
+	if int64(len(buf)) < FileHeaderSize || !bytes.Equal(buf[:len(fileMagic)], fileMagic) {
+		return nil, ErrInvalidFileHeader
+	}
+	header := &FileHeader{
+		Version:  binary.LittleEndian.Uint32(buf[4:8]),
+		Checksum: buf[8],
+	}
+	if header.Version == 0 {
+		return nil, ErrInvalidFileHeader
+	}
+	if header.Version > FormatVersion {
+		return nil, ErrUnsupportedFormatVersion
+	}
+	if !ValidChecksumType(header.Checksum) {
+		return nil, ErrUnsupportedChecksum
+	}
+	return header, nil
+}
@@ -0,0 +1,66 @@
+package data
+
+import (
+	"bitcask-go/fio"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+func TestDataFile_Header(t *testing.T) {
+	memFS := fio.NewMemFileSystem()
+	assert.Nil(t, memFS.MkdirAll("/bitcask", os.ModePerm))
+
+	//新的文件写入文件头
+	dataFile, err := OpenDataFile(memFS, "/bitcask", 0, fio.StandardIO, ChecksumCRC32IEEE)
+	assert.Nil(t, err)
+	assert.Equal(t, FileHeaderSize, dataFile.WriteOff)
+	assert.Equal(t, FormatVersion, dataFile.Header.Version)
+	rec, size := EncodeLogRecord(&LogRecord{Key: []byte("name"), Value: []byte("bitcask")})
+	assert.Nil(t, dataFile.Write(rec))
+	assert.Nil(t, dataFile.Close())
+
+	//重新打开时校验文件头
+	dataFile, err = OpenDataFile(memFS, "/bitcask", 0, fio.ReadOnlyIO, ChecksumCRC32IEEE)
+	assert.Nil(t, err)
+	assert.Equal(t, FileHeaderSize, dataFile.WriteOff)
+	readRec, readSize, err := dataFile.ReadLogRecord(FileHeaderSize)
+	assert.Nil(t, err)
+	assert.Equal(t, size, readSize)
+	assert.Equal(t, []byte("bitcask"), readRec.Value)
+	assert.Nil(t, dataFile.Close())
+
+	//更新的格式版本
+	file, err := memFS.OpenFile(GetDataFileName("/bitcask", 1), os.O_CREATE|os.O_RDWR, fio.DataFilePerm)
+	assert.Nil(t, err)
+	_, err = file.Write(EncodeFileHeader(&FileHeader{Version: FormatVersion + 1}))
+	assert.Nil(t, err)
+	assert.Nil(t, file.Close())
+	_, err = OpenDataFile(memFS, "/bitcask", 1, fio.StandardIO, ChecksumCRC32IEEE)
+	assert.Equal(t, ErrUnsupportedFormatVersion, err)
+
+	//没有文件头的旧文件
+	file, err = memFS.OpenFile(GetDataFileName("/bitcask", 2), os.O_CREATE|os.O_RDWR, fio.DataFilePerm)
+	assert.Nil(t, err)
+	_, err = file.Write(append(rec, rec...))
+	assert.Nil(t, err)
+	assert.Nil(t, file.Close())
+	_, err = OpenDataFile(memFS, "/bitcask", 2, fio.StandardIO, ChecksumCRC32IEEE)
+	assert.Equal(t, ErrInvalidFileHeader, err)
+	legacyFile, err := OpenLegacyFile(memFS, GetDataFileName("/bitcask", 2))
+	assert.Nil(t, err)
+	_, readSize, err = legacyFile.ReadLogRecord(0)
+	assert.Nil(t, err)
+	assert.Equal(t, size, readSize)
+	assert.Nil(t, legacyFile.Close())
+
+	//只读打开还没有写入文件头的空文件
+	file, err = memFS.OpenFile(GetDataFileName("/bitcask", 3), os.O_CREATE|os.O_RDWR, fio.DataFilePerm)
+	assert.Nil(t, err)
+	assert.Nil(t, file.Close())
+	dataFile, err = OpenDataFile(memFS, "/bitcask", 3, fio.ReadOnlyIO, ChecksumCRC32IEEE)
+	assert.Nil(t, err)
+	assert.Equal(t, FileHeaderSize, dataFile.WriteOff)
+	_, _, err = dataFile.ReadLogRecord(dataFile.WriteOff)
+	assert.NotNil(t, err)
+}
@@ -2,7 +2,6 @@ package data
 
 import (
 	"encoding/binary"
-	"hash/crc32"
 )
 
 type LogRecordType = byte
@@ -11,6 +10,20 @@ const (
 	LogRecordNormal LogRecordType = iota
 	LogRecordDeleted
 	LogRecordTxnFinished
+	// LogRecordMerge 增量记录，读取时和之前的值合并
+	LogRecordMerge
+	// LogRecordTimestamp 时间标记，之后的记录都是在这个时间写入的，value是毫秒时间戳
+	LogRecordTimestamp
+)
+
+// MergeOperandType 增量记录的操作类型
+type MergeOperandType = byte
+
+const (
+	// MergeOperandUser 使用用户注册的合并操作符
+	MergeOperandUser MergeOperandType = iota
+	// MergeOperandAddInt64 整数累加
+	MergeOperandAddInt64
 )
 
 // crc type keySize valueSize
@@ -45,8 +58,14 @@ type TransactionRecord struct {
 	Pos    *LogRecordPos
 }
 
-// EncodeLogRecord 对LogRecord进行编码 返回字节数组及长度
+// EncodeLogRecord 使用IEEE校验对LogRecord进行编码 返回字节数组及长度
+// 写入数据文件时使用DataFile.EncodeLogRecord，和文件头中记录的校验算法保持一致
 func EncodeLogRecord(logRecord *LogRecord) ([]byte, int64) {
+	return EncodeLogRecordWithChecksum(logRecord, ChecksumCRC32IEEE)
+}
+
+// EncodeLogRecordWithChecksum 使用指定的校验算法对LogRecord进行编码
+func EncodeLogRecordWithChecksum(logRecord *LogRecord, checksumType ChecksumType) ([]byte, int64) {
 	//初始化一个header部分的字节数组
 	header := make([]byte, maxLogRecordHeaderSize)
 
@@ -68,7 +87,7 @@ func EncodeLogRecord(logRecord *LogRecord) ([]byte, int64) {
 	copy(encBytes[index+len(logRecord.Key):], logRecord.Value)
 
 	//对整个logRecord进行crc校验
-	crc := crc32.ChecksumIEEE(encBytes[4:])
+	crc := checksum(checksumType, encBytes[4:])
 	binary.LittleEndian.PutUint32(encBytes[:4], crc)
 
 	//fmt.Printf("header length:%d,crc:%d\n", index, crc)
@@ -101,6 +120,74 @@ func DecodeLogRecordPos(buf []byte) *LogRecordPos {
 	}
 }
 
+// EncodeTimestamp 编码时间标记的value，毫秒时间戳
+func EncodeTimestamp(ms int64) []byte {
+	buf := make([]byte, binary.MaxVarintLen64)
+	n := binary.PutVarint(buf, ms)
+	return buf[:n]
+}
+
+// DecodeTimestamp 解码时间标记的value
+func DecodeTimestamp(buf []byte) int64 {
+	ms, _ := binary.Varint(buf)
+	return ms
+}
+
+// 增量记录中上一条记录的标识
+const (
+	mergeValueNoPrev        byte = 0 //写入增量之前key不存在
+	mergeValuePrev          byte = 1 //格式版本2写入的记录，没有记录增量链的长度
+	mergeValuePrevWithDepth byte = 2
+)
+
+// EncodeMergeValue 编码增量记录的value
+// 上一条记录的标识 + 上一条记录的位置 + 增量链的长度 + 操作类型 + 操作数
+// prev 为 nil 表示写入增量之前key不存在，depth 是包括这条记录在内连续的增量记录数量
+func EncodeMergeValue(prev *LogRecordPos, depth uint32, opType MergeOperandType, operand []byte) []byte {
+	buf := make([]byte, 1+binary.MaxVarintLen32*3+binary.MaxVarintLen64+1+len(operand))
+	var index = 1
+	if prev != nil {
+		buf[0] = mergeValuePrevWithDepth
+		index += binary.PutVarint(buf[index:], int64(prev.Fid))
+		index += binary.PutVarint(buf[index:], prev.Offset)
+		index += binary.PutVarint(buf[index:], int64(prev.Size))
+		index += binary.PutUvarint(buf[index:], uint64(depth))
+	}
+	buf[index] = opType
+	index += 1
+	index += copy(buf[index:], operand)
+	return buf[:index]
+}
+
+// DecodeMergeValue 解码增量记录的value，旧版本写入的记录不知道增量链的长度，depth 为 0
+func DecodeMergeValue(buf []byte) (*LogRecordPos, uint32, MergeOperandType, []byte) {
+	var prev *LogRecordPos
+	var depth uint32 = 1
+	var index = 1
+	if buf[0] != mergeValueNoPrev {
+		fileId, n := binary.Varint(buf[index:])
+		index += n
+		offset, n := binary.Varint(buf[index:])
+		index += n
+		size, n := binary.Varint(buf[index:])
+		index += n
+		prev = &LogRecordPos{
+			Fid:    uint32(fileId),
+			Offset: offset,
+			Size:   uint32(size),
+		}
+		depth = 0
+		if buf[0] == mergeValuePrevWithDepth {
+			d, n := binary.Uvarint(buf[index:])
+			index += n
+			depth = uint32(d)
+		}
+	}
+	opType := buf[index]
+	index += 1
+	return prev, depth, opType, buf[index:]
+}
+
 // 根据字节数组中的Header信息解码
 func decodeLogRecordHeader(buf []byte) (*logRecordHeader, int64) {
 	if len(buf) <= 4 {
@@ -126,14 +213,10 @@ func decodeLogRecordHeader(buf []byte) (*logRecordHeader, int64) {
 	return header, int64(index)
 }
 
-func getLogRecordCRC(lr *LogRecord, header []byte) uint32 {
+func getLogRecordCRC(lr *LogRecord, header []byte, checksumType ChecksumType) uint32 {
 	if lr == nil {
 		return 0
 	}
 
-	crc := crc32.ChecksumIEEE(header[:])
-	crc = crc32.Update(crc, crc32.IEEETable, lr.Key)
-	crc = crc32.Update(crc, crc32.IEEETable, lr.Value)
-
-	return crc
+	return checksum(checksumType, header, lr.Key, lr.Value)
 }
@@ -93,7 +93,7 @@ func TestGetLogRecordCRC(t *testing.T) {
 	}
 
 	headerBuf1 := []byte{104, 82, 240, 150, 0, 8, 20}
-	crc := getLogRecordCRC(rec1, headerBuf1[crc32.Size:])
+	crc := getLogRecordCRC(rec1, headerBuf1[crc32.Size:], ChecksumCRC32IEEE)
 	t.Log(crc)
 
 	assert.Equal(t, uint32(2532332136), crc)
@@ -104,7 +104,7 @@ func TestGetLogRecordCRC(t *testing.T) {
 	}
 
 	headerBuf2 := []byte{9, 252, 88, 14, 0, 8, 0}
-	crc2 := getLogRecordCRC(rec2, headerBuf2[crc32.Size:])
+	crc2 := getLogRecordCRC(rec2, headerBuf2[crc32.Size:], ChecksumCRC32IEEE)
 	t.Log(crc2)
 
 	assert.Equal(t, uint32(240712713), crc2)
@@ -116,7 +116,7 @@ func TestGetLogRecordCRC(t *testing.T) {
 	}
 
 	headerBuf3 := []byte{43, 153, 86, 17, 1, 8, 20}
-	crc3 := getLogRecordCRC(rec3, headerBuf3[crc32.Size:])
+	crc3 := getLogRecordCRC(rec3, headerBuf3[crc32.Size:], ChecksumCRC32IEEE)
 	t.Log(crc3)
 
 	assert.Equal(t, uint32(290887979), crc3)
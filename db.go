@@ -5,38 +5,51 @@ import (
 	"bitcask-go/fio"
 	"bitcask-go/index"
 	"bitcask-go/utils"
+	"bytes"
 	"errors"
 	"fmt"
-	"github.com/gofrs/flock"
 	"io"
+	iofs "io/fs"
 	"os"
 	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 )
 
 const (
-	seqNoKey     = "seq.no"
-	fileLockName = "flock"
+	seqNoKey       = "seq.no"
+	fileLockName   = "flock"
+	readerLockName = "flock-reader"
 )
 
 // DB bitcask 存储引擎实例
 type DB struct {
 	options         Options
 	mu              *sync.RWMutex
-	fileIds         []int                     //文件id，用于加载索引
-	activeFile      *data.DataFile            //当前活跃文件，可用于写入
-	olderFile       map[uint32]*data.DataFile //旧的数据文件，只能用于读
-	index           index.Indexer             //内存索引
-	seqNo           uint64                    //事务序列号 全局递增
-	isMerging       bool                      //是否正在进行merge
-	seqNoFileExists bool                      //存储事务序列号的文件是否存在
-	isInitial       bool                      //是否第一次初始化次目录
-	fileLock        *flock.Flock              //文件锁对象保证多进场之间的互斥
-	bytesWrites     uint                      //累计写了多少字节
-	reclaimSize     int64                     //表示有多少数据是无效的
+	fileIds         []int                                //文件id，用于加载索引
+	activeFile      *data.DataFile                       //当前活跃文件，可用于写入
+	olderFile       map[uint32]*data.DataFile            //旧的数据文件，只能用于读
+	index           index.Indexer                        //内存索引
+	seqNo           uint64                               //事务序列号 全局递增
+	isMerging       bool                                 //是否正在进行merge
+	seqNoFileExists bool                                 //存储事务序列号的文件是否存在
+	isInitial       bool                                 //是否第一次初始化次目录
+	fs              fio.FileSystem                       //文件系统
+	fileLock        fio.FileLock                         //文件锁对象保证多进场之间的互斥
+	bytesWrites     uint                                 //累计写了多少字节
+	reclaimSize     int64                                //表示有多少数据是无效的
+	subscriptions   map[*Subscription]struct{}           //变更订阅
+	changeNotify    chan struct{}                        //有新数据写入时关闭，用于唤醒订阅者
+	rateLimiter     *utils.RateLimiter                   //后台任务的IO限速
+	pendingTxns     map[uint64][]*data.TransactionRecord //只读模式下还没有读到事务完成标识的数据
+	refreshStop     chan struct{}                        //关闭时停止定时刷新
+	refreshDone     chan struct{}                        //定时刷新已经退出
+	mergeChains     map[string]*mergeChainHead           //活跃文件中增量链的最新位置和长度，追加增量时不需要读取上一条记录
+	lastTimeMark    int64                                //活跃文件中最近一次写入的时间标记，毫秒
+	noTimeMarks     bool                                 //不写入时间标记，merge生成的数据文件已经丢失了写入时间
 }
 
 // Stat 存储引擎统计信息
@@ -55,28 +68,48 @@ func Open(options Options) (*DB, error) {
 		return nil, err
 	}
 
+	//未指定文件系统时使用操作系统的文件系统
+	if options.FileSystem == nil {
+		options.FileSystem = fio.OSFileSystem{}
+	}
+	fs := options.FileSystem
+
+	//只读模式不会写入b+树索引文件，直接从数据文件加载内存索引
+	if options.ReadOnly && options.IndexType == BPlusTree {
+		options.IndexType = Btree
+	}
+
 	var isInitial bool
 	//判断数据目录是否存在，如果不存在，则创建这个目录
-	if _, err := os.Stat(options.DirPath); os.IsNotExist(err) {
+	if _, err := fs.Stat(options.DirPath); errors.Is(err, iofs.ErrNotExist) {
+		//只读模式不会创建目录
+		if options.ReadOnly {
+			return nil, err
+		}
 		isInitial = true
-		if err := os.MkdirAll(options.DirPath, os.ModePerm); err != nil {
+		if err := fs.MkdirAll(options.DirPath, iofs.ModePerm); err != nil {
 			return nil, err
 		}
 	}
 
 	//判断当前数据目录是否在使用 文件锁
-	fileLock := flock.New(filepath.Join(options.DirPath, fileLockName))
-	//尝试获取锁
-	hold, err := fileLock.TryLock()
+	//只读实例获取共享锁，写入实例加载merge数据时会获取互斥锁替换数据文件
+	var fileLock fio.FileLock
+	var err error
+	if options.ReadOnly {
+		fileLock, err = fs.TryRLock(filepath.Join(options.DirPath, readerLockName))
+	} else {
+		fileLock, err = fs.TryLock(filepath.Join(options.DirPath, fileLockName))
+	}
 	if err != nil {
+		if err == fio.ErrFileLocked {
+			return nil, ErrDatabaseIsUsing
+		}
 		return nil, err
 	}
-	if !hold {
-		return nil, ErrDatabaseIsUsing
-	}
 
 	//可能目录存在但是里面没有内容
-	entries, err := os.ReadDir(options.DirPath)
+	entries, err := fs.ReadDir(options.DirPath)
 	if err != nil {
 		return nil, err
 	}
@@ -84,20 +117,32 @@ func Open(options Options) (*DB, error) {
 		isInitial = true
 	}
 
+	//检查数据目录的格式版本
+	if err := checkFormatVersion(fs, options.DirPath, entries, options.ReadOnly); err != nil {
+		_ = fileLock.Unlock()
+		return nil, err
+	}
+
 	//初始化DB实例结构
 	db := &DB{
-		options:   options,
-		mu:        new(sync.RWMutex),
-		olderFile: make(map[uint32]*data.DataFile),
-		index:     index.NewIndexer(options.IndexType, options.DirPath, options.SyncWrites),
-		isInitial: isInitial,
-		fileLock:  fileLock,
+		options:     options,
+		mu:          new(sync.RWMutex),
+		olderFile:   make(map[uint32]*data.DataFile),
+		mergeChains: make(map[string]*mergeChainHead),
+		index:       index.NewIndexer(options.IndexType, options.DirPath, options.SyncWrites),
+		isInitial:   isInitial,
+		fs:          fs,
+		fileLock:    fileLock,
+		rateLimiter: utils.NewRateLimiter(options.BackgroundIORate),
 	}
 
 	//加载merge数据目录
 	//??? 为什么在这调用，还有为什么merge里面的方法不加锁
-	if err := db.loadMergeFiles(); err != nil {
-		return nil, err
+	//只读模式不会移动文件，merge的数据由写入实例加载
+	if !options.ReadOnly {
+		if err := db.loadMergeFiles(); err != nil {
+			return nil, err
+		}
 	}
 
 	//加载对应的数据文件
@@ -116,15 +161,20 @@ func Open(options Options) (*DB, error) {
 		if err := db.loadIndexFromDataFile(); err != nil {
 			return nil, err
 		}
+	}
 
-		//重置 IO 类型为标准 IO 类型
-		if db.options.MMapAtStartUp {
-			if err := db.resetIoType(); err != nil {
-				return nil, err
-			}
+	//重置 IO 类型为配置的 IO 类型，只读模式下一直使用只读的标准文件IO
+	if !options.ReadOnly && (db.options.MMapAtStartUp || db.options.IOType != fio.StandardIO) {
+		if err := db.resetIoType(); err != nil {
+			return nil, err
 		}
 	}
 
+	//旧的数据文件之后只会被随机读取
+	for _, dataFile := range db.olderFile {
+		_ = dataFile.Advise(fio.AdviceRandom)
+	}
+
 	//取出当前事务序列号
 	if options.IndexType == BPlusTree {
 		if err := db.loadSeqNo(); err != nil {
@@ -141,6 +191,10 @@ func Open(options Options) (*DB, error) {
 		}
 	}
 
+	if options.ReadOnly && options.RefreshInterval > 0 {
+		db.startRefresh()
+	}
+
 	return db, nil
 }
 
@@ -150,6 +204,10 @@ func (db *DB) Close() error {
 			panic(fmt.Sprintf("failed to unlock the directory,%v", err))
 		}
 	}()
+	//先关闭所有的订阅，订阅者读取数据时需要持有锁
+	db.closeSubscriptions()
+	db.stopRefresh()
+
 	if db.activeFile == nil {
 		return nil
 	}
@@ -164,22 +222,12 @@ func (db *DB) Close() error {
 		return err
 	}
 
-	//保存当前事务序列号
-	seqNoFile, err := data.OpenSeqNoFile(db.options.DirPath)
-	if err != nil {
-		return err
-	}
-	record := &data.LogRecord{
-		Key:   []byte(seqNoKey),
-		Value: []byte(strconv.FormatUint(db.seqNo, 10)),
-	}
-
-	encRecord, _ := data.EncodeLogRecord(record)
-	if err := seqNoFile.Write(encRecord); err != nil {
-		return err
-	}
-	if err := seqNoFile.Sync(); err != nil {
-		return err
+	//保存当前事务序列号，只读模式不写入任何文件
+	//上一次关闭时崩溃可能留下文件头不完整的文件，先删除再重新写入
+	if !db.options.ReadOnly {
+		if err := db.writeSeqNoFile(db.options.DirPath, db.seqNo); err != nil {
+			return err
+		}
 	}
 
 	//关闭活跃文件
@@ -200,7 +248,7 @@ func (db *DB) Close() error {
 
 // Sync 持久化数据文件
 func (db *DB) Sync() error {
-	if db.activeFile == nil {
+	if db.activeFile == nil || db.options.ReadOnly {
 		return nil
 	}
 
@@ -220,7 +268,7 @@ func (db *DB) Stat() *Stat {
 		dataFiles += 1
 	}
 
-	dirSize, err := utils.DirSize(db.options.DirPath)
+	dirSize, err := fio.DirSize(db.fs, db.options.DirPath)
 	if err != nil {
 		panic(fmt.Sprintf("failed to get dir size : %v", err))
 	}
@@ -233,12 +281,9 @@ func (db *DB) Stat() *Stat {
 	}
 }
 
-// BackUp 备份数据库，将数据拷贝到新的目录中
-func (db *DB) BackUp(dir string) error {
-	db.mu.RLock()
-	defer db.mu.RUnlock()
-
-	return utils.CopyDir(db.options.DirPath, dir, []string{fileLockName})
+// SetBackgroundIORate 调整merge、备份等后台任务每秒读取的字节数，0表示不限速
+func (db *DB) SetBackgroundIORate(bytesPerSec int64) {
+	db.rateLimiter.SetRate(bytesPerSec)
 }
 
 // Put 写入key/value数据
@@ -247,7 +292,114 @@ func (db *DB) Put(key []byte, value []byte) error {
 	if len(key) == 0 {
 		return ErrKeyIsEmpty
 	}
+	if db.options.ReadOnly {
+		return ErrDatabaseReadOnly
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	return db.put(key, value)
+}
+
+func (db *DB) Delete(key []byte) error {
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+	if db.options.ReadOnly {
+		return ErrDatabaseReadOnly
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	return db.delete(key)
+}
+
+// CompareAndSwap 当key当前的值等于expected时将其更新为value，返回是否更新成功
+// key不存在时返回false，需要在key不存在时写入请使用PutIfAbsent
+func (db *DB) CompareAndSwap(key []byte, expected []byte, value []byte) (bool, error) {
+	if len(key) == 0 {
+		return false, ErrKeyIsEmpty
+	}
+	if db.options.ReadOnly {
+		return false, ErrDatabaseReadOnly
+	}
+
+	//读取、比较和写入在同一个临界区内完成
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	oldValue, err := db.get(key)
+	if err == ErrKeyNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if !bytes.Equal(oldValue, expected) {
+		return false, nil
+	}
+
+	if err := db.put(key, value); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// PutIfAbsent 只有在key不存在时才写入，返回是否写入成功
+func (db *DB) PutIfAbsent(key []byte, value []byte) (bool, error) {
+	if len(key) == 0 {
+		return false, ErrKeyIsEmpty
+	}
+	if db.options.ReadOnly {
+		return false, ErrDatabaseReadOnly
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if pos := db.index.Get(key); pos != nil {
+		return false, nil
+	}
+
+	if err := db.put(key, value); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// DeleteIfValue 当key当前的值等于expected时删除key，返回是否删除成功
+func (db *DB) DeleteIfValue(key []byte, expected []byte) (bool, error) {
+	if len(key) == 0 {
+		return false, ErrKeyIsEmpty
+	}
+	if db.options.ReadOnly {
+		return false, ErrDatabaseReadOnly
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	oldValue, err := db.get(key)
+	if err == ErrKeyNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if !bytes.Equal(oldValue, expected) {
+		return false, nil
+	}
 
+	if err := db.delete(key); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// 写入数据并更新内存索引，调用方需要持有锁
+func (db *DB) put(key []byte, value []byte) error {
 	logRecord := &data.LogRecord{
 		Key:   logRecordKeyWithSeq(key, nonTransactionSeqNo),
 		Value: value,
@@ -255,7 +407,7 @@ func (db *DB) Put(key []byte, value []byte) error {
 	}
 
 	//追加写入到当前活跃数据文件当中
-	pos, err := db.appendLogRecordWithLock(logRecord)
+	pos, err := db.appendLogRecord(logRecord)
 	if err != nil {
 		return err
 	}
@@ -266,14 +418,10 @@ func (db *DB) Put(key []byte, value []byte) error {
 	}
 
 	return nil
-
 }
 
-func (db *DB) Delete(key []byte) error {
-	if len(key) == 0 {
-		return ErrKeyIsEmpty
-	}
-
+// 删除数据并更新内存索引，调用方需要持有锁
+func (db *DB) delete(key []byte) error {
 	//先检查key是否存在，不存在返回
 	if pos := db.index.Get(key); pos == nil {
 		return nil
@@ -286,7 +434,7 @@ func (db *DB) Delete(key []byte) error {
 	}
 
 	//加入到数据文件中
-	pos, err := db.appendLogRecordWithLock(logRecord)
+	pos, err := db.appendLogRecord(logRecord)
 	if err != nil {
 		return err
 
@@ -307,12 +455,18 @@ func (db *DB) Delete(key []byte) error {
 
 // Get 根据key读取数据
 func (db *DB) Get(key []byte) ([]byte, error) {
-	db.mu.Lock()
-	defer db.mu.Unlock()
+	//读取路径不修改共享状态，和MultiGet一样持有读锁
+	db.mu.RLock()
+	defer db.mu.RUnlock()
 	if len(key) == 0 {
 		return nil, ErrKeyIsEmpty
 	}
 
+	return db.get(key)
+}
+
+// 根据key读取数据，调用方需要持有锁
+func (db *DB) get(key []byte) ([]byte, error) {
 	//先从索引中拿，没有说明不存在
 	logRecordPos := db.index.Get(key)
 
@@ -323,6 +477,50 @@ func (db *DB) Get(key []byte) ([]byte, error) {
 	return db.getValueByPosition(logRecordPos)
 }
 
+// MultiGet 批量读取数据，返回的values和errs与keys一一对应
+// 先一次性从索引中拿到所有的位置信息，再按照文件id和偏移排序后读取，尽量顺序访问磁盘
+func (db *DB) MultiGet(keys [][]byte) ([][]byte, []error) {
+	values := make([][]byte, len(keys))
+	errs := make([]error, len(keys))
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	type readTask struct {
+		idx int
+		pos *data.LogRecordPos
+	}
+
+	//从索引中拿到所有key的位置信息
+	tasks := make([]readTask, 0, len(keys))
+	for i, key := range keys {
+		if len(key) == 0 {
+			errs[i] = ErrKeyIsEmpty
+			continue
+		}
+		pos := db.index.Get(key)
+		if pos == nil {
+			errs[i] = ErrKeyNotFound
+			continue
+		}
+		tasks = append(tasks, readTask{idx: i, pos: pos})
+	}
+
+	//按照文件id和偏移排序
+	sort.Slice(tasks, func(i, j int) bool {
+		if tasks[i].pos.Fid != tasks[j].pos.Fid {
+			return tasks[i].pos.Fid < tasks[j].pos.Fid
+		}
+		return tasks[i].pos.Offset < tasks[j].pos.Offset
+	})
+
+	for _, task := range tasks {
+		values[task.idx], errs[task.idx] = db.getValueByPosition(task.pos)
+	}
+
+	return values, errs
+}
+
 // ListKeys 获取数据库中所有的key
 func (db *DB) ListKeys() [][]byte {
 	iterator := db.index.Iterator(false)
@@ -361,6 +559,28 @@ func (db *DB) Fold(fn func(key []byte, value []byte) bool) error {
 
 // 根据索引位置信息获取对应的value
 func (db *DB) getValueByPosition(logRecordPos *data.LogRecordPos) ([]byte, error) {
+	logRecord, err := db.readLogRecordByPosition(logRecordPos)
+	if err != nil {
+		return nil, err
+	}
+
+	//判断LogRecord类型
+	if logRecord.Type == data.LogRecordDeleted {
+		return nil, ErrKeyNotFound
+	}
+
+	//增量记录需要和之前的值合并
+	if logRecord.Type == data.LogRecordMerge {
+		realKey, _ := parseLogRecordKey(logRecord.Key)
+		return db.resolveMergeRecord(realKey, logRecord)
+	}
+
+	return logRecord.Value, nil
+
+}
+
+// 根据索引位置信息读取对应的LogRecord
+func (db *DB) readLogRecordByPosition(logRecordPos *data.LogRecordPos) (*data.LogRecord, error) {
 	//根据文件id找到数据文件
 	var dataFile *data.DataFile
 	//当前活跃文件的文件id是否等于key对应的文件id
@@ -382,24 +602,14 @@ func (db *DB) getValueByPosition(logRecordPos *data.LogRecordPos) ([]byte, error
 		return nil, err
 	}
 
-	//判断LogRecord类型
-	if logRecord.Type == data.LogRecordDeleted {
-		return nil, ErrKeyNotFound
-	}
-
-	return logRecord.Value, nil
-
-}
-
-func (db *DB) appendLogRecordWithLock(logRecord *data.LogRecord) (*data.LogRecordPos, error) {
-	db.mu.Lock()
-	defer db.mu.Unlock()
-
-	return db.appendLogRecord(logRecord)
+	return logRecord, nil
 }
 
 // 追加写入到活跃文件当中
 func (db *DB) appendLogRecord(logRecord *data.LogRecord) (*data.LogRecordPos, error) {
+	if db.options.ReadOnly {
+		return nil, ErrDatabaseReadOnly
+	}
 
 	//判断当前活跃文件是否存在，因为数据库在没有写入的时候是没有文件生成的
 	//如果为空则初始化数据文件
@@ -411,22 +621,40 @@ func (db *DB) appendLogRecord(logRecord *data.LogRecord) (*data.LogRecordPos, er
 
 	//获取到了active文件，进行读写操作
 	//对logRecord进行编码
-	encRecord, size := data.EncodeLogRecord(logRecord)
+	encRecord, size := db.activeFile.EncodeLogRecord(logRecord)
+	//每个数据文件中每一毫秒的第一条记录之前写入时间标记，按时间恢复时可以精确到记录
+	now := timeMarkNow()
+	encMark, markSize := db.encodeTimeMark(now)
 
 	//如果写入的数据已经到达了活跃文件的阈值，则关闭活跃文件，并打开新的文件
-	if db.activeFile.WriteOff+size > db.options.DataFileSize {
+	if db.activeFile.WriteOff+markSize+size > db.options.DataFileSize {
 		//先将当前活跃文件持久化 保证已有的数据持久化到磁盘
 		if err := db.activeFile.Sync(); err != nil {
 			return nil, err
 		}
 
 		//将当前活跃文件转化为旧的文件
-		db.olderFile[db.activeFile.FileId] = db.activeFile
+		if err := db.archiveActiveFile(); err != nil {
+			return nil, err
+		}
 
 		//打开新的数据文件
 		if err := db.setActiveDataFile(); err != nil {
 			return nil, err
 		}
+		//新的活跃文件可能使用不同的校验算法，编码之后的长度不变
+		encRecord, _ = db.activeFile.EncodeLogRecord(logRecord)
+		encMark, markSize = db.encodeTimeMark(now)
+	}
+
+	if markSize > 0 {
+		if err := db.activeFile.Write(encMark); err != nil {
+			return nil, err
+		}
+		db.lastTimeMark = now
+		//时间标记不是有效的数据，merge时会被清理
+		db.reclaimSize += markSize
+		size += markSize
 	}
 
 	writeOff := db.activeFile.WriteOff
@@ -452,17 +680,37 @@ func (db *DB) appendLogRecord(logRecord *data.LogRecord) (*data.LogRecordPos, er
 		}
 	}
 
+	//唤醒等待新数据的订阅者
+	db.notifySubscribers()
+
 	//构造内存索引信息并返回
 	pos := &data.LogRecordPos{
 		Fid:    db.activeFile.FileId,
 		Offset: writeOff,
-		Size:   uint32(size),
+		Size:   uint32(size - markSize),
 	}
 
 	return pos, nil
 
 }
 
+// 将当前活跃文件转换为旧的数据文件，之后只会被随机读取
+// 可读写的内存映射预先分配了空间，关闭时才截断到实际大小，归档时立即关闭并使用只读的IO类型重新打开，
+// 之后文件不会再被修改，备份和检查点可以直接硬链接
+func (db *DB) archiveActiveFile() error {
+	db.olderFile[db.activeFile.FileId] = db.activeFile
+	//增量链不会跨越数据文件，之后追加增量时都会折叠为完整的数据
+	db.mergeChains = make(map[string]*mergeChainHead)
+	if db.options.IOType != fio.StandardIO {
+		if err := db.activeFile.SetIOManager(db.fs, db.options.DirPath, db.options.IOType); err != nil {
+			return err
+		}
+	}
+	//访问模式只是建议，失败不影响读写
+	_ = db.activeFile.Advise(fio.AdviceRandom)
+	return nil
+}
+
 // 设置当前活跃文件
 // 对db实例的共享文件访问的时候要持有锁
 func (db *DB) setActiveDataFile() error {
@@ -475,17 +723,37 @@ func (db *DB) setActiveDataFile() error {
 
 	//打开新的数据文件
 	//将获取的dataFile给数据库实例的activeFile
-	dataFile, err := data.OpenDataFile(db.options.DirPath, initalFileld, fio.StandardIO)
+	dataFile, err := data.OpenDataFile(db.fs, db.options.DirPath, initalFileld, db.activeIOType(), db.options.Checksum)
 	if err != nil {
 		return err
 	}
+	if err := dataFile.Preallocate(db.options.DataFileSize); err != nil {
+		return err
+	}
 	db.activeFile = dataFile
+	db.lastTimeMark = 0
 	return nil
 }
 
+// 时间标记使用的当前时间，毫秒
+var timeMarkNow = func() int64 {
+	return time.Now().UnixMilli()
+}
+
+// 编码需要写入活跃文件的时间标记，这一毫秒已经写入过时返回空
+func (db *DB) encodeTimeMark(now int64) ([]byte, int64) {
+	if db.noTimeMarks || now == db.lastTimeMark {
+		return nil, 0
+	}
+	return db.activeFile.EncodeLogRecord(&data.LogRecord{
+		Value: data.EncodeTimestamp(now),
+		Type:  data.LogRecordTimestamp,
+	})
+}
+
 // 从磁盘中加载数据文件
 func (db *DB) loadDataFile() error {
-	dirEntries, err := os.ReadDir(db.options.DirPath)
+	dirEntries, err := db.fs.ReadDir(db.options.DirPath)
 	if err != nil {
 		return err
 	}
@@ -513,10 +781,12 @@ func (db *DB) loadDataFile() error {
 	//遍历每个文件id，打开对应的数据文件
 	for i, fid := range fileIds {
 		ioType := fio.StandardIO
-		if db.options.MMapAtStartUp {
+		if db.options.ReadOnly {
+			ioType = fio.ReadOnlyIO
+		} else if db.options.MMapAtStartUp {
 			ioType = fio.MemoryMap
 		}
-		dataFile, err := data.OpenDataFile(db.options.DirPath, uint32(fid), ioType)
+		dataFile, err := data.OpenDataFile(db.fs, db.options.DirPath, uint32(fid), ioType, db.options.Checksum)
 		if err != nil {
 			return err
 		}
@@ -524,7 +794,7 @@ func (db *DB) loadDataFile() error {
 		if i == len(fileIds)-1 {
 			db.activeFile = dataFile
 		} else {
-			db.olderFile[uint32(i)] = dataFile
+			db.olderFile[uint32(fid)] = dataFile
 		}
 	}
 
@@ -543,7 +813,7 @@ func (db *DB) loadIndexFromDataFile() error {
 	hasMerge, nonMergeFileId := false, uint32(0)
 	mergeFinFileName := filepath.Join(db.options.DirPath, data.MergeFinishedFileName)
 	//如果存在
-	if _, err := os.Stat(mergeFinFileName); err == nil {
+	if _, err := db.fs.Stat(mergeFinFileName); err == nil {
 		fid, err := db.getNonMergeFileId(db.options.DirPath)
 		if err != nil {
 			return err
@@ -552,23 +822,8 @@ func (db *DB) loadIndexFromDataFile() error {
 		nonMergeFileId = fid
 	}
 
-	updateIndex := func(key []byte, typ data.LogRecordType, pos *data.LogRecordPos) {
-		var oldPos *data.LogRecordPos
-		if typ == data.LogRecordDeleted {
-			oldPos, _ = db.index.Delete(key)
-			db.reclaimSize += int64(pos.Size)
-		} else {
-			oldPos = db.index.Put(key, pos)
-		}
-
-		if oldPos != nil {
-			db.reclaimSize += int64(oldPos.Size)
-		}
-	}
-
 	//暂存事务数据
-	TransactionRecords := make(map[uint64][]*data.TransactionRecord)
-	var currentSeqNo = nonTransactionSeqNo
+	transactionRecords := make(map[uint64][]*data.TransactionRecord)
 
 	//遍历所以文件id，处理文件中的记录
 	for i, fid := range db.fileIds {
@@ -584,64 +839,157 @@ func (db *DB) loadIndexFromDataFile() error {
 			dataFile = db.olderFile[fileId]
 		}
 
-		var offset int64 = 0
-		for {
-			logRecord, size, err := dataFile.ReadLogRecord(offset)
-			if err != nil {
-				if err == io.EOF {
-					break
+		//加载索引时顺序读取整个文件
+		_ = dataFile.Advise(fio.AdviceSequential)
+		offset, err := db.loadIndexFromRecords(dataFile, data.FileHeaderSize, i == len(db.fileIds)-1, transactionRecords)
+		if err != nil {
+			return err
+		}
+		_ = dataFile.Advise(fio.AdviceNormal)
+
+		//如果是当前活跃文件，更新这个文件的offset
+		if i == len(db.fileIds)-1 {
+			db.activeFile.WriteOff = offset
+			//只读模式下末尾的数据可能是写入实例正在写入的，不能截断
+			if !db.options.ReadOnly {
+				if err := db.truncateActiveFile(); err != nil {
+					return err
 				}
-				return err
 			}
+		}
+	}
+
+	//只读模式刷新时继续处理没有提交的事务
+	if db.options.ReadOnly {
+		db.pendingTxns = transactionRecords
+	}
+
+	return nil
+}
 
-			//构建对应的内存索引
-			logRecordPos := &data.LogRecordPos{
-				Fid:    fileId,
-				Offset: offset,
-				Size:   uint32(size),
+// 从offset开始读取数据文件中的记录更新索引，返回读取结束的位置
+// 没有读到事务完成标识的数据暂存在transactionRecords中，isLast表示是否是最后一个数据文件
+func (db *DB) loadIndexFromRecords(dataFile *data.DataFile, offset int64, isLast bool,
+	transactionRecords map[uint64][]*data.TransactionRecord) (int64, error) {
+	for {
+		logRecord, size, err := dataFile.ReadLogRecord(offset)
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			//活跃文件末尾可能是崩溃时没有写完整的数据，当作文件结尾处理
+			if err == data.ErrInvalidCRC && isLast {
+				break
 			}
+			return 0, err
+		}
 
-			//可能会拿大commit的一部分数据 所以要暂存起来
-			//解析key 拿到事务序列号
-			realKey, seqNo := parseLogRecordKey(logRecord.Key)
-			if seqNo == nonTransactionSeqNo {
-				//非事务操作 直接更新索引
-				updateIndex(realKey, logRecord.Type, logRecordPos)
-			} else {
-				//事务完成 对应的seqNo的数据可以更新到内存索引当中
-				if logRecord.Type == data.LogRecordTxnFinished {
-					for _, txnRecords := range TransactionRecords[seqNo] {
-						updateIndex(txnRecords.Record.Key, txnRecords.Record.Type, txnRecords.Pos)
-					}
-
-					delete(TransactionRecords, seqNo)
-				} else {
-					//正常写入的数据 但是还未提交成功
-					logRecord.Key = realKey
-					TransactionRecords[seqNo] = append(TransactionRecords[seqNo], &data.TransactionRecord{
-						Record: logRecord,
-						Pos:    logRecordPos,
-					})
+		//时间标记只在按时间恢复时使用
+		if logRecord.Type == data.LogRecordTimestamp {
+			db.reclaimSize += size
+			offset += size
+			continue
+		}
+
+		//构建对应的内存索引
+		logRecordPos := &data.LogRecordPos{
+			Fid:    dataFile.FileId,
+			Offset: offset,
+			Size:   uint32(size),
+		}
+
+		//可能会拿大commit的一部分数据 所以要暂存起来
+		//解析key 拿到事务序列号
+		realKey, seqNo := parseLogRecordKey(logRecord.Key)
+		if seqNo == nonTransactionSeqNo {
+			//非事务操作 直接更新索引
+			db.updateIndex(realKey, logRecord.Type, logRecordPos)
+		} else {
+			//事务完成 对应的seqNo的数据可以更新到内存索引当中
+			if logRecord.Type == data.LogRecordTxnFinished {
+				for _, txnRecords := range transactionRecords[seqNo] {
+					db.updateIndex(txnRecords.Record.Key, txnRecords.Record.Type, txnRecords.Pos)
 				}
-			}
 
-			//更新事务序列号
-			if seqNo > currentSeqNo {
-				currentSeqNo = seqNo
+				delete(transactionRecords, seqNo)
+			} else {
+				//正常写入的数据 但是还未提交成功
+				logRecord.Key = realKey
+				transactionRecords[seqNo] = append(transactionRecords[seqNo], &data.TransactionRecord{
+					Record: logRecord,
+					Pos:    logRecordPos,
+				})
 			}
-			//递增offset，下一次从新的位置开始读取
-			offset += size
 		}
 
-		//如果是当前活跃文件，更新这个文件的offset
-		if i == len(db.fileIds)-1 {
-			db.activeFile.WriteOff = offset
+		//更新事务序列号
+		if seqNo > db.seqNo {
+			db.seqNo = seqNo
 		}
+		//递增offset，下一次从新的位置开始读取
+		offset += size
+	}
+	return offset, nil
+}
+
+// 根据加载的记录更新内存索引，并统计无效的数据量
+func (db *DB) updateIndex(key []byte, typ data.LogRecordType, pos *data.LogRecordPos) {
+	var oldPos *data.LogRecordPos
+	if typ == data.LogRecordDeleted {
+		oldPos, _ = db.index.Delete(key)
+		db.reclaimSize += int64(pos.Size)
+	} else {
+		oldPos = db.index.Put(key, pos)
 	}
 
-	//更新事务序列号
-	db.seqNo = currentSeqNo
+	//增量记录之前的数据仍然有效
+	if oldPos != nil && typ != data.LogRecordMerge {
+		db.reclaimSize += int64(oldPos.Size)
+	}
+}
 
+// 截断活跃文件末尾不完整的数据，否则新的数据会追加在这些数据后面
+func (db *DB) truncateActiveFile() error {
+	size, err := db.activeFile.IoManager.Size()
+	if err != nil {
+		return err
+	}
+	//崩溃时新创建的活跃文件可能没有写完整的文件头，清空之后重新写入
+	truncateSize, missingHeader := db.activeFile.WriteOff, size < data.FileHeaderSize
+	if missingHeader {
+		truncateSize = 0
+	} else if size <= db.activeFile.WriteOff {
+		return nil
+	}
+
+	fileName := data.GetDataFileName(db.options.DirPath, db.activeFile.FileId)
+	file, err := db.fs.OpenFile(fileName, os.O_RDWR, fio.DataFilePerm)
+	if err != nil {
+		return err
+	}
+	if err := file.Truncate(truncateSize); err != nil {
+		_ = file.Close()
+		return err
+	}
+	if missingHeader {
+		db.activeFile.Header = &data.FileHeader{Version: data.FormatVersion, Checksum: db.options.Checksum}
+		if _, err := file.Write(data.EncodeFileHeader(db.activeFile.Header)); err != nil {
+			_ = file.Close()
+			return err
+		}
+	}
+	if err := file.Sync(); err != nil {
+		_ = file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	//内存映射的大小是固定的，需要重新打开
+	if _, ok := db.activeFile.IoManager.(*fio.MMap); ok {
+		return db.activeFile.SetIOManager(db.fs, db.options.DirPath, fio.MemoryMap)
+	}
 	return nil
 }
 
@@ -655,21 +1003,40 @@ func checkOptions(options Options) error {
 	if options.DataFileMergeRatio < 0 || options.DataFileMergeRatio > 1 {
 		return errors.New("invalid merge ratio, must between 0 and 1")
 	}
+	if options.IOType != fio.StandardIO && options.IOType != fio.MemoryMap && options.IOType != fio.DirectIO {
+		return errors.New("unsupported io type, must be StandardIO, MemoryMap or DirectIO")
+	}
+	if !data.ValidChecksumType(options.Checksum) {
+		return errors.New("unsupported checksum type")
+	}
+	//b+树索引直接使用bbolt读写磁盘文件
+	if _, isOS := options.FileSystem.(fio.OSFileSystem); options.IndexType == BPlusTree && options.FileSystem != nil && !isOS {
+		return errors.New("b+tree index only supports the os file system")
+	}
 	return nil
 }
 
 func (db *DB) loadSeqNo() error {
 	filename := filepath.Join(db.options.DirPath, data.SeqNoFileName)
-	if _, err := os.Stat(filename); os.IsNotExist(err) {
+	if _, err := db.fs.Stat(filename); errors.Is(err, iofs.ErrNotExist) {
 		return nil
 	}
 
-	seqNoFile, err := data.OpenSeqNoFile(db.options.DirPath)
+	seqNoFile, err := data.OpenSeqNoFile(db.fs, db.options.DirPath, db.options.Checksum)
 	if err != nil {
 		return err
 	}
 
-	record, _, err := seqNoFile.ReadLogRecord(0)
+	defer seqNoFile.Close()
+
+	//文件头不完整说明写入时崩溃了，当作没有这个文件
+	if seqNoFile.Header == nil {
+		return db.fs.Remove(filename)
+	}
+	record, _, err := seqNoFile.ReadLogRecord(data.FileHeaderSize)
+	if err != nil {
+		return err
+	}
 
 	seqNo, err := strconv.ParseUint(string(record.Value), 10, 64)
 	if err != nil {
@@ -680,24 +1047,35 @@ func (db *DB) loadSeqNo() error {
 	db.seqNoFileExists = true
 
 	//seqNoFile会一直追加写，所有加载后将这个文件删掉
-	return os.Remove(filename)
+	return db.fs.Remove(filename)
 }
 
-// 将数据文件的IO类型设置为标准文件IO类型
+// 将数据文件的IO类型设置为配置的IO类型，活跃文件需要能够写入
 func (db *DB) resetIoType() error {
 	if db.activeFile == nil {
 		return nil
 	}
 
-	if err := db.activeFile.SetIOManager(db.options.DirPath, fio.StandardIO); err != nil {
+	if err := db.activeFile.SetIOManager(db.fs, db.options.DirPath, db.activeIOType()); err != nil {
+		return err
+	}
+	if err := db.activeFile.Preallocate(db.options.DataFileSize); err != nil {
 		return err
 	}
 
 	for _, dataFile := range db.olderFile {
-		if err := dataFile.SetIOManager(db.options.DirPath, fio.StandardIO); err != nil {
+		if err := dataFile.SetIOManager(db.fs, db.options.DirPath, db.options.IOType); err != nil {
 			return err
 		}
 	}
 
 	return nil
 }
+
+// 活跃文件使用的IO类型，内存映射时使用可读写的映射
+func (db *DB) activeIOType() fio.FileIOType {
+	if db.options.IOType == fio.MemoryMap {
+		return fio.MemoryMapRW
+	}
+	return fio.StandardIO
+}
@@ -1,10 +1,13 @@
 package bitcask_go
 
 import (
+	"bitcask-go/data"
+	"bitcask-go/fio"
 	"bitcask-go/utils"
 	"bytes"
 	"github.com/stretchr/testify/assert"
 	"os"
+	"sync"
 	"testing"
 	"time"
 )
@@ -16,15 +19,27 @@ func destroyDB(db *DB) {
 			_ = db.Close()
 
 		}
-		err := os.RemoveAll(db.options.DirPath)
+		err := db.fs.RemoveAll(db.options.DirPath)
 		if err != nil {
 			panic(err)
 		}
 	}
 }
 
+// 固定时间标记使用的时间，数据文件的大小和IO次数不再随执行时间变化，返回恢复的函数
+func pinTimeMarks() func() {
+	now := timeMarkNow
+	timeMarkNow = func() int64 {
+		return 1
+	}
+	return func() {
+		timeMarkNow = now
+	}
+}
+
 func TestOpen(t *testing.T) {
 	opts := DefaultOptions
+	opts.FileSystem = fio.NewMemFileSystem()
 	db, err := Open(opts)
 	defer destroyDB(db)
 	assert.Nil(t, err)
@@ -36,6 +51,7 @@ func TestOpen(t *testing.T) {
 
 func TestDB_Put(t *testing.T) {
 	opts := DefaultOptions
+	opts.FileSystem = fio.NewMemFileSystem()
 	opts.DataFileSize = 64 * 1024 * 1024
 	db, err := Open(opts)
 	defer destroyDB(db)
@@ -93,6 +109,7 @@ func TestDB_Put(t *testing.T) {
 
 func TestDB_Get(t *testing.T) {
 	opts := DefaultOptions
+	opts.FileSystem = fio.NewMemFileSystem()
 	opts.DataFileSize = 64 * 1024 * 1024
 	db, err := Open(opts)
 	defer destroyDB(db)
@@ -162,6 +179,7 @@ func TestDB_Get(t *testing.T) {
 
 func TestDB_Delete(t *testing.T) {
 	opts := DefaultOptions
+	opts.FileSystem = fio.NewMemFileSystem()
 	opts.DataFileSize = 64 * 1024 * 1024
 	db, err := Open(opts)
 	defer destroyDB(db)
@@ -213,6 +231,7 @@ func TestDB_Delete(t *testing.T) {
 
 func TestDB_ListKeys(t *testing.T) {
 	opts := DefaultOptions
+	opts.FileSystem = fio.NewMemFileSystem()
 	opts.DataFileSize = 64 * 1024 * 1024
 	db, err := Open(opts)
 	defer destroyDB(db)
@@ -246,6 +265,7 @@ func TestDB_ListKeys(t *testing.T) {
 
 func TestDB_Fold(t *testing.T) {
 	opts := DefaultOptions
+	opts.FileSystem = fio.NewMemFileSystem()
 	opts.DataFileSize = 64 * 1024 * 1024
 	db, err := Open(opts)
 	defer destroyDB(db)
@@ -275,6 +295,7 @@ func TestDB_Fold(t *testing.T) {
 
 func TestDB_Close(t *testing.T) {
 	opts := DefaultOptions
+	opts.FileSystem = fio.NewMemFileSystem()
 	opts.DataFileSize = 64 * 1024 * 1024
 	db, err := Open(opts)
 	defer destroyDB(db)
@@ -288,6 +309,7 @@ func TestDB_Close(t *testing.T) {
 
 func TestDB_Sync(t *testing.T) {
 	opts := DefaultOptions
+	opts.FileSystem = fio.NewMemFileSystem()
 	opts.DataFileSize = 64 * 1024 * 1024
 	db, err := Open(opts)
 	defer destroyDB(db)
@@ -304,6 +326,7 @@ func TestDB_Sync(t *testing.T) {
 
 func TestDB_FileLock(t *testing.T) {
 	opts := DefaultOptions
+	opts.FileSystem = fio.NewMemFileSystem()
 	opts.DataFileSize = 64 * 1024 * 1024
 	db, err := Open(opts)
 	defer destroyDB(db)
@@ -341,6 +364,7 @@ func TestDB_Open2(t *testing.T) {
 
 func TestDB_Stat(t *testing.T) {
 	opts := DefaultOptions
+	opts.FileSystem = fio.NewMemFileSystem()
 	opts.DataFileSize = 64 * 1024 * 1024
 	db, err := Open(opts)
 	defer destroyDB(db)
@@ -370,6 +394,7 @@ func TestDB_Stat(t *testing.T) {
 
 func TestDB_BackUp(t *testing.T) {
 	opts := DefaultOptions
+	opts.FileSystem = fio.NewMemFileSystem()
 	opts.DataFileSize = 64 * 1024 * 1024
 	db, err := Open(opts)
 	defer destroyDB(db)
@@ -391,3 +416,279 @@ func TestDB_BackUp(t *testing.T) {
 	assert.Nil(t, err)
 	assert.NotNil(t, db1)
 }
+
+func TestDB_CompareAndSwap(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-cas")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	// 1.key 不存在
+	ok, err := db.CompareAndSwap(utils.GetTestKey(1), []byte("a"), []byte("b"))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	// 2.期望值不匹配
+	err = db.Put(utils.GetTestKey(1), []byte("a"))
+	assert.Nil(t, err)
+	ok, err = db.CompareAndSwap(utils.GetTestKey(1), []byte("c"), []byte("b"))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	// 3.期望值匹配
+	ok, err = db.CompareAndSwap(utils.GetTestKey(1), []byte("a"), []byte("b"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	val, err := db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("b"), val)
+
+	// 4.key 为空
+	_, err = db.CompareAndSwap(nil, []byte("a"), []byte("b"))
+	assert.Equal(t, ErrKeyIsEmpty, err)
+}
+
+func TestDB_PutIfAbsent(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-put-if-absent")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	ok, err := db.PutIfAbsent(utils.GetTestKey(1), []byte("a"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+
+	ok, err = db.PutIfAbsent(utils.GetTestKey(1), []byte("b"))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	val, err := db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("a"), val)
+
+	// 删除之后可以重新写入
+	err = db.Delete(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	ok, err = db.PutIfAbsent(utils.GetTestKey(1), []byte("c"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+}
+
+func TestDB_DeleteIfValue(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-delete-if-value")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	err = db.Put(utils.GetTestKey(1), []byte("a"))
+	assert.Nil(t, err)
+
+	ok, err := db.DeleteIfValue(utils.GetTestKey(1), []byte("b"))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	ok, err = db.DeleteIfValue(utils.GetTestKey(1), []byte("a"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	_, err = db.Get(utils.GetTestKey(1))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	ok, err = db.DeleteIfValue(utils.GetTestKey(1), []byte("a"))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+}
+
+func TestDB_MultiGet(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-multi-get")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	// 写入多个数据文件
+	for i := 0; i < 1000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.GetTestKey(i*10))
+		assert.Nil(t, err)
+	}
+	err = db.Delete(utils.GetTestKey(500))
+	assert.Nil(t, err)
+	assert.Greater(t, len(db.olderFile), 0)
+
+	keys := [][]byte{utils.GetTestKey(999), utils.GetTestKey(1), nil, utils.GetTestKey(500), utils.GetTestKey(2000), utils.GetTestKey(300)}
+	values, errs := db.MultiGet(keys)
+	assert.Equal(t, len(keys), len(values))
+	assert.Equal(t, len(keys), len(errs))
+
+	assert.Nil(t, errs[0])
+	assert.Equal(t, utils.GetTestKey(9990), values[0])
+	assert.Nil(t, errs[1])
+	assert.Equal(t, utils.GetTestKey(10), values[1])
+	assert.Equal(t, ErrKeyIsEmpty, errs[2])
+	assert.Equal(t, ErrKeyNotFound, errs[3])
+	assert.Equal(t, ErrKeyNotFound, errs[4])
+	assert.Nil(t, errs[5])
+	assert.Equal(t, utils.GetTestKey(3000), values[5])
+}
+
+func TestDB_ConcurrentGet(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-concurrent-get")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 500; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.GetTestKey(i*10))
+		assert.Nil(t, err)
+	}
+	err = db.Increment([]byte("counter"), 1)
+	assert.Nil(t, err)
+
+	// 多个读者和一个写者并发执行
+	var wg sync.WaitGroup
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 500; i++ {
+				val, err := db.Get(utils.GetTestKey(i))
+				assert.Nil(t, err)
+				assert.Equal(t, utils.GetTestKey(i*10), val)
+				_, err = db.Get([]byte("counter"))
+				assert.Nil(t, err)
+			}
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 200; i++ {
+			err := db.Increment([]byte("counter"), 1)
+			assert.Nil(t, err)
+			err = db.Put(utils.GetTestKey(1000+i), utils.RandomValue(24))
+			assert.Nil(t, err)
+		}
+	}()
+	wg.Wait()
+
+	val, err := db.Get([]byte("counter"))
+	assert.Nil(t, err)
+	assert.Equal(t, "201", string(val))
+}
+
+func TestDB_MMapIOType(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-mmap-io")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	opts.IOType = fio.MemoryMap
+	opts.DataFileMergeRatio = 0
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 2000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(64))
+		assert.Nil(t, err)
+	}
+	for i := 0; i < 500; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	assert.Greater(t, len(db.olderFile), 1)
+	err = db.Sync()
+	assert.Nil(t, err)
+
+	val, err := db.Get(utils.GetTestKey(1999))
+	assert.Nil(t, err)
+	assert.NotNil(t, val)
+
+	// 关闭之后文件截断到实际写入的大小
+	activeFileId, writeOff := db.activeFile.FileId, db.activeFile.WriteOff
+	err = db.Close()
+	assert.Nil(t, err)
+	stat, err := os.Stat(data.GetDataFileName(dir, activeFileId))
+	assert.Nil(t, err)
+	assert.Equal(t, writeOff, stat.Size())
+
+	// 重启之后继续写入
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 1500, len(db2.ListKeys()))
+	err = db2.Put([]byte("key-after-reopen"), []byte("value"))
+	assert.Nil(t, err)
+	val, err = db2.Get([]byte("key-after-reopen"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("value"), val)
+
+	err = db2.Merge()
+	assert.Nil(t, err)
+	err = db2.Close()
+	assert.Nil(t, err)
+
+	db3, err := Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 1501, len(db3.ListKeys()))
+	for i := 0; i < 500; i++ {
+		_, err := db3.Get(utils.GetTestKey(i))
+		assert.Equal(t, ErrKeyNotFound, err)
+	}
+	db = db3
+}
+
+func TestDB_DirectIOType(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-direct-io")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	opts.IOType = fio.DirectIO
+	opts.DataFileMergeRatio = 0
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 2000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(64))
+		assert.Nil(t, err)
+	}
+	for i := 0; i < 500; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	assert.Greater(t, len(db.olderFile), 1)
+
+	// 从旧的数据文件中读取
+	val, err := db.Get(utils.GetTestKey(600))
+	assert.Nil(t, err)
+	assert.NotNil(t, val)
+
+	err = db.Merge()
+	assert.Nil(t, err)
+	err = db.Close()
+	assert.Nil(t, err)
+
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 1500, len(db2.ListKeys()))
+	for i := 500; i < 2000; i++ {
+		_, err := db2.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	db = db2
+}
@@ -3,14 +3,28 @@ package bitcask_go
 import "errors"
 
 var (
-	ErrKeyIsEmpty             = errors.New("the key is empty")
-	ErrIndexUpdateFailed      = errors.New("failed to update index")
-	ErrKeyNotFound            = errors.New("key not found in database")
-	ErrDataFileNotFound       = errors.New("data file is not found")
-	ErrDataDirectoryCorrupted = errors.New("the database directory maybe corrupted")
-	ErrExceedMaxBatchNum      = errors.New("exceed the max batch num")
-	ErrMergeIsProgress        = errors.New("merge is in progress,try again later")
-	ErrDatabaseIsUsing        = errors.New("the database directory is used by another process")
-	ErrMergeRatioUnreached    = errors.New("the merge ratio do not reach the option")
-	ErrNoEnoughSpaceForMerge  = errors.New("no enough disk space for merge")
+	ErrKeyIsEmpty               = errors.New("the key is empty")
+	ErrIndexUpdateFailed        = errors.New("failed to update index")
+	ErrKeyNotFound              = errors.New("key not found in database")
+	ErrDataFileNotFound         = errors.New("data file is not found")
+	ErrDataDirectoryCorrupted   = errors.New("the database directory maybe corrupted")
+	ErrExceedMaxBatchNum        = errors.New("exceed the max batch num")
+	ErrBatchConditionFailed     = errors.New("the write batch condition is not satisfied")
+	ErrMergeIsProgress          = errors.New("merge is in progress,try again later")
+	ErrDatabaseIsUsing          = errors.New("the database directory is used by another process")
+	ErrMergeRatioUnreached      = errors.New("the merge ratio do not reach the option")
+	ErrNoEnoughSpaceForMerge    = errors.New("no enough disk space for merge")
+	ErrMergeOperatorNotSet      = errors.New("the merge operator is not set in options")
+	ErrValueNotInteger          = errors.New("the value is not an integer")
+	ErrIncrementOverflow        = errors.New("increment or decrement would overflow")
+	ErrBackupManifestNotFound   = errors.New("the backup manifest is not found, backup maybe incomplete")
+	ErrBackupCorrupted          = errors.New("the backup is corrupted")
+	ErrBackupVersionUnsupported = errors.New("the backup version is not supported")
+	ErrRestoreTargetNotEmpty    = errors.New("the restore target directory is not empty")
+	ErrRestorePointUnavailable  = errors.New("the restore point is inside merged data files")
+	ErrCheckpointDirNotEmpty    = errors.New("the checkpoint directory is not empty")
+	ErrDatabaseReadOnly         = errors.New("the database is opened in read only mode")
+	ErrBackupBaseIsTarget       = errors.New("the incremental backup base can not be the backup directory")
+	ErrFormatVersionUnsupported = errors.New("the database directory is written by a newer format version")
+	ErrFormatUpgradeRequired    = errors.New("the database directory uses an old format version, run bitcask upgrade first")
 )
@@ -0,0 +1,114 @@
+//go:build linux
+
+package fio
+
+import (
+	"errors"
+	"golang.org/x/sys/unix"
+	"io"
+	"os"
+	"unsafe"
+)
+
+// directIOAlignment O_DIRECT要求读取的位置、长度和内存地址都按照块大小对齐
+const directIOAlignment = 4096
+
+// DirectFileIO 绕过页缓存的文件IO，只用于读取不再写入的旧数据文件，避免冷数据把热数据挤出页缓存
+type DirectFileIO struct {
+	fd *os.File
+}
+
+// NewDirectIOManager 以O_DIRECT方式打开文件，文件系统不支持时退化为标准文件IO
+func NewDirectIOManager(filename string) (IOManager, error) {
+	fd, err := os.OpenFile(filename, os.O_RDONLY|unix.O_DIRECT, DataFilePerm)
+	if err != nil {
+		if errors.Is(err, unix.EINVAL) {
+			return NewFileIOManager(OSFileSystem{}, filename)
+		}
+		return nil, err
+	}
+	return &DirectFileIO{fd: fd}, nil
+}
+
+func (dio *DirectFileIO) Read(b []byte, offset int64) (int, error) {
+	//将读取范围扩大到对齐的块
+	start := offset &^ (directIOAlignment - 1)
+	end := (offset + int64(len(b)) + directIOAlignment - 1) &^ (directIOAlignment - 1)
+	buf := alignedBlock(int(end - start))
+
+	var read int
+	for read < len(buf) {
+		n, err := unix.Pread(int(dio.fd.Fd()), buf[read:], start+int64(read))
+		if err != nil {
+			if err == unix.EINTR {
+				continue
+			}
+			return 0, err
+		}
+		read += n
+		//读到了文件末尾
+		if n == 0 || read%directIOAlignment != 0 {
+			break
+		}
+	}
+
+	if int64(read) <= offset-start {
+		return 0, io.EOF
+	}
+	n := copy(b, buf[offset-start:read])
+	if n < len(b) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Write 旧数据文件不会再写入
+func (dio *DirectFileIO) Write(b []byte) (int, error) {
+	return 0, ErrDirectIOReadOnly
+}
+
+func (dio *DirectFileIO) Sync() error {
+	return nil
+}
+
+func (dio *DirectFileIO) Close() error {
+	return dio.fd.Close()
+}
+
+func (dio *DirectFileIO) Size() (int64, error) {
+	stat, err := dio.fd.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return stat.Size(), nil
+}
+
+// Advise 设置文件的访问模式，O_DIRECT读取不经过页缓存，主要用于DontNeed释放之前缓存的页
+func (dio *DirectFileIO) Advise(advice Advice) error {
+	return fadvise(dio.fd.Fd(), advice)
+}
+
+// alignedBlock 分配起始地址按照块大小对齐的内存
+func alignedBlock(size int) []byte {
+	buf := make([]byte, size+directIOAlignment)
+	offset := 0
+	if remainder := int(uintptr(unsafe.Pointer(&buf[0])) & (directIOAlignment - 1)); remainder != 0 {
+		offset = directIOAlignment - remainder
+	}
+	return buf[offset : offset+size]
+}
+
+func fadvise(fd uintptr, advice Advice) error {
+	var fadv int
+	switch advice {
+	case AdviceSequential:
+		fadv = unix.FADV_SEQUENTIAL
+	case AdviceRandom:
+		fadv = unix.FADV_RANDOM
+	case AdviceDontNeed:
+		fadv = unix.FADV_DONTNEED
+	default:
+		fadv = unix.FADV_NORMAL
+	}
+	return unix.Fadvise(int(fd), 0, 0, fadv)
+}
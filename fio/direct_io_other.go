@@ -0,0 +1,13 @@
+//go:build !linux
+
+package fio
+
+// NewDirectIOManager 当前平台不支持O_DIRECT，使用标准文件IO
+func NewDirectIOManager(filename string) (IOManager, error) {
+	return NewFileIOManager(OSFileSystem{}, filename)
+}
+
+// 当前平台不支持posix_fadvise
+func fadvise(fd uintptr, advice Advice) error {
+	return nil
+}
@@ -0,0 +1,63 @@
+package fio
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDirectIO_Read(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "bitcask-go-direct-io")
+	filename := filepath.Join(dir, "direct.data")
+	defer destroyFile(dir)
+
+	//先用标准文件IO写入跨越多个块的数据
+	fio, err := NewFileIOManager(OSFileSystem{}, filename)
+	assert.Nil(t, err)
+	content := make([]byte, 10000)
+	for i := range content {
+		content[i] = byte(i % 251)
+	}
+	_, err = fio.Write(content)
+	assert.Nil(t, err)
+	assert.Nil(t, fio.Advise(AdviceSequential))
+	assert.Nil(t, fio.Sync())
+	assert.Nil(t, fio.Close())
+
+	dio, err := NewIOManager(OSFileSystem{}, filename, DirectIO)
+	assert.Nil(t, err)
+	defer dio.Close()
+
+	size, err := dio.Size()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(10000), size)
+
+	//不对齐的位置和长度
+	b := make([]byte, 5000)
+	n, err := dio.Read(b, 4000)
+	assert.Nil(t, err)
+	assert.Equal(t, 5000, n)
+	assert.Equal(t, content[4000:9000], b)
+
+	//读到文件末尾
+	n, err = dio.Read(b, 9000)
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, 1000, n)
+	assert.Equal(t, content[9000:], b[:n])
+
+	n, err = dio.Read(b, 10000)
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, 0, n)
+
+	_, err = dio.Write([]byte("a"))
+	assert.NotNil(t, err)
+
+	//访问模式的建议需要传递到文件上
+	advisor, ok := dio.(Advisor)
+	assert.True(t, ok)
+	for _, advice := range []Advice{AdviceSequential, AdviceRandom, AdviceDontNeed, AdviceNormal} {
+		assert.Nil(t, advisor.Advise(advice))
+	}
+}
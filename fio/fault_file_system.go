@@ -0,0 +1,175 @@
+package fio
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"sync"
+)
+
+var (
+	ErrInjectedFault = errors.New("injected io fault")
+)
+
+// FaultOp 可以注入故障的操作类型
+type FaultOp uint8
+
+const (
+	// FaultOpWrite 写文件
+	FaultOpWrite FaultOp = 1 << iota
+
+	// FaultOpSync 持久化文件
+	FaultOpSync
+
+	// FaultOpRename 重命名
+	FaultOpRename
+
+	// FaultOpRemove 删除文件或者目录
+	FaultOpRemove
+
+	// FaultOpCreate 创建文件或者目录
+	FaultOpCreate
+
+	// FaultOpTruncate 修改文件大小
+	FaultOpTruncate
+
+	// FaultOpAll 所有的操作
+	FaultOpAll = FaultOpWrite | FaultOpSync | FaultOpRename | FaultOpRemove | FaultOpCreate | FaultOpTruncate
+)
+
+// FaultFileSystem 故障注入文件系统，包装另一个文件系统，在第N次操作时注入故障
+// 故障发生之后所有修改操作都会失败，相当于进程在这个位置崩溃了
+// 配合MemFileSystem.Crash可以模拟崩溃时没有持久化的数据丢失
+type FaultFileSystem struct {
+	FileSystem
+	mu         sync.Mutex
+	ops        FaultOp //参与计数的操作
+	failAt     int64   //第几次操作注入故障，0表示不注入
+	count      int64   //已经执行的操作数量
+	shortWrite bool    //写操作出错时是否先写入一半的数据
+	crashed    bool    //是否已经注入了故障
+}
+
+func NewFaultFileSystem(fs FileSystem) *FaultFileSystem {
+	return &FaultFileSystem{FileSystem: fs, ops: FaultOpAll}
+}
+
+// FailAt 在第n次ops类型的操作时注入故障，并重新开始计数
+// shortWrite为true时，故障发生在写操作上会先写入一半的数据
+func (ffs *FaultFileSystem) FailAt(n int64, ops FaultOp, shortWrite bool) {
+	ffs.mu.Lock()
+	defer ffs.mu.Unlock()
+
+	ffs.failAt = n
+	ffs.ops = ops
+	ffs.shortWrite = shortWrite
+	ffs.count = 0
+	ffs.crashed = false
+}
+
+// Count 返回已经执行的ops类型的操作数量
+func (ffs *FaultFileSystem) Count() int64 {
+	ffs.mu.Lock()
+	defer ffs.mu.Unlock()
+	return ffs.count
+}
+
+// Crashed 是否已经注入了故障
+func (ffs *FaultFileSystem) Crashed() bool {
+	ffs.mu.Lock()
+	defer ffs.mu.Unlock()
+	return ffs.crashed
+}
+
+// inject 记录一次操作，返回这次操作是否应该失败，以及失败是否刚好发生在这次操作上
+func (ffs *FaultFileSystem) inject(op FaultOp) (fail bool, first bool) {
+	ffs.mu.Lock()
+	defer ffs.mu.Unlock()
+
+	if ffs.crashed {
+		return true, false
+	}
+	if ffs.ops&op == 0 {
+		return false, false
+	}
+	ffs.count++
+	if ffs.failAt > 0 && ffs.count == ffs.failAt {
+		ffs.crashed = true
+		return true, true
+	}
+	return false, false
+}
+
+func (ffs *FaultFileSystem) OpenFile(name string, flag int, perm fs.FileMode) (File, error) {
+	if flag&(os.O_CREATE|os.O_TRUNC) != 0 {
+		if fail, _ := ffs.inject(FaultOpCreate); fail {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: ErrInjectedFault}
+		}
+	}
+	file, err := ffs.FileSystem.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return &faultFile{File: file, fs: ffs}, nil
+}
+
+func (ffs *FaultFileSystem) MkdirAll(path string, perm fs.FileMode) error {
+	if fail, _ := ffs.inject(FaultOpCreate); fail {
+		return &fs.PathError{Op: "mkdir", Path: path, Err: ErrInjectedFault}
+	}
+	return ffs.FileSystem.MkdirAll(path, perm)
+}
+
+func (ffs *FaultFileSystem) Remove(name string) error {
+	if fail, _ := ffs.inject(FaultOpRemove); fail {
+		return &fs.PathError{Op: "remove", Path: name, Err: ErrInjectedFault}
+	}
+	return ffs.FileSystem.Remove(name)
+}
+
+func (ffs *FaultFileSystem) RemoveAll(path string) error {
+	if fail, _ := ffs.inject(FaultOpRemove); fail {
+		return &fs.PathError{Op: "remove", Path: path, Err: ErrInjectedFault}
+	}
+	return ffs.FileSystem.RemoveAll(path)
+}
+
+func (ffs *FaultFileSystem) Rename(oldPath, newPath string) error {
+	if fail, _ := ffs.inject(FaultOpRename); fail {
+		return &os.LinkError{Op: "rename", Old: oldPath, New: newPath, Err: ErrInjectedFault}
+	}
+	return ffs.FileSystem.Rename(oldPath, newPath)
+}
+
+// faultFile 故障注入文件系统中打开的文件
+type faultFile struct {
+	File
+	fs *FaultFileSystem
+}
+
+func (f *faultFile) Write(b []byte) (int, error) {
+	fail, first := f.fs.inject(FaultOpWrite)
+	if !fail {
+		return f.File.Write(b)
+	}
+	//只写入一部分数据之后崩溃
+	if first && f.fs.shortWrite && len(b) > 1 {
+		n, _ := f.File.Write(b[:len(b)/2])
+		return n, ErrInjectedFault
+	}
+	return 0, ErrInjectedFault
+}
+
+func (f *faultFile) Sync() error {
+	if fail, _ := f.fs.inject(FaultOpSync); fail {
+		return ErrInjectedFault
+	}
+	return f.File.Sync()
+}
+
+func (f *faultFile) Truncate(size int64) error {
+	if fail, _ := f.fs.inject(FaultOpTruncate); fail {
+		return ErrInjectedFault
+	}
+	return f.File.Truncate(size)
+}
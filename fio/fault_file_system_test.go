@@ -0,0 +1,67 @@
+package fio
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io"
+	"os"
+	"testing"
+)
+
+func TestFaultFileSystem_ShortWrite(t *testing.T) {
+	mfs := NewMemFileSystem()
+	ffs := NewFaultFileSystem(mfs)
+	ffs.FailAt(3, FaultOpWrite, true)
+
+	f, err := ffs.OpenFile("a.data", os.O_CREATE|os.O_RDWR|os.O_APPEND, DataFilePerm)
+	assert.Nil(t, err)
+	_, err = f.Write([]byte("key-a"))
+	assert.Nil(t, err)
+	assert.Nil(t, f.Sync())
+	_, err = f.Write([]byte("key-b"))
+	assert.Nil(t, err)
+
+	//第三次写入只写入一半的数据
+	n, err := f.Write([]byte("key-c"))
+	assert.Equal(t, 2, n)
+	assert.Equal(t, ErrInjectedFault, err)
+	assert.True(t, ffs.Crashed())
+	assert.Equal(t, int64(3), ffs.Count())
+
+	//故障之后所有的修改操作都会失败
+	assert.Equal(t, ErrInjectedFault, f.Sync())
+	err = ffs.Rename("a.data", "b.data")
+	assert.ErrorIs(t, err, ErrInjectedFault)
+
+	//进程崩溃，已经写入的数据仍然保留
+	mfs.Crash(false)
+	stat, err := mfs.Stat("a.data")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(12), stat.Size())
+
+	//掉电，只保留持久化的数据
+	mfs.Crash(true)
+	f2, err := mfs.OpenFile("a.data", os.O_RDONLY, 0)
+	assert.Nil(t, err)
+	b := make([]byte, 10)
+	n, err = f2.ReadAt(b, 0)
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, []byte("key-a"), b[:n])
+}
+
+func TestFaultFileSystem_FailRename(t *testing.T) {
+	mfs := NewMemFileSystem()
+	ffs := NewFaultFileSystem(mfs)
+	ffs.FailAt(2, FaultOpRename, false)
+
+	for _, name := range []string{"a", "b"} {
+		_, err := ffs.OpenFile(name, os.O_CREATE|os.O_RDWR, DataFilePerm)
+		assert.Nil(t, err)
+	}
+	assert.Nil(t, ffs.Rename("a", "c"))
+	assert.ErrorIs(t, ffs.Rename("b", "d"), ErrInjectedFault)
+
+	_, err := mfs.Stat("b")
+	assert.Nil(t, err)
+	_, err = mfs.Stat("d")
+	assert.NotNil(t, err)
+}
@@ -4,11 +4,20 @@ import "os"
 
 // FileIO 标准系统文件IO
 type FileIO struct {
-	fd *os.File //文件系统描述符
+	fd File //文件系统描述符
 }
 
-func NewFileIOManager(filename string) (*FileIO, error) {
-	fd, err := os.OpenFile(filename, os.O_CREATE|os.O_RDWR|os.O_APPEND, DataFilePerm)
+func NewFileIOManager(fs FileSystem, filename string) (*FileIO, error) {
+	fd, err := fs.OpenFile(filename, os.O_CREATE|os.O_RDWR|os.O_APPEND, DataFilePerm)
+	if err != nil {
+		return nil, err
+	}
+	return &FileIO{fd: fd}, nil
+}
+
+// NewReadOnlyFileIOManager 只读打开文件，文件不存在时返回错误
+func NewReadOnlyFileIOManager(fs FileSystem, filename string) (*FileIO, error) {
+	fd, err := fs.OpenFile(filename, os.O_RDONLY, 0)
 	if err != nil {
 		return nil, err
 	}
@@ -38,3 +47,11 @@ func (fio *FileIO) Size() (int64, error) {
 	}
 	return stat.Size(), nil
 }
+
+// Advise 设置文件的访问模式，只有操作系统的文件才生效
+func (fio *FileIO) Advise(advice Advice) error {
+	if f, ok := fio.fd.(interface{ Fd() uintptr }); ok {
+		return fadvise(f.Fd(), advice)
+	}
+	return nil
+}
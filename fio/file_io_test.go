@@ -15,7 +15,7 @@ func destroyFile(name string) {
 
 func TestNewFileIOManager(t *testing.T) {
 	path := filepath.Join("/Users/yefeixiang/coding/kv-projects/bitcask-go/tmp", "a.data")
-	fio, err := NewFileIOManager(path)
+	fio, err := NewFileIOManager(OSFileSystem{}, path)
 	defer destroyFile(path)
 	assert.Nil(t, err)
 	assert.NotNil(t, fio)
@@ -23,7 +23,7 @@ func TestNewFileIOManager(t *testing.T) {
 
 func TestFileIO_Write(t *testing.T) {
 	path := filepath.Join("/Users/yefeixiang/coding/kv-projects/bitcask-go/tmp", "a.data")
-	fio, err := NewFileIOManager(path)
+	fio, err := NewFileIOManager(OSFileSystem{}, path)
 	defer destroyFile(path)
 	assert.Nil(t, err)
 	assert.NotNil(t, fio)
@@ -45,7 +45,7 @@ func TestFileIO_Write(t *testing.T) {
 
 func TestFileIO_Read(t *testing.T) {
 	path := filepath.Join("/Users/yefeixiang/coding/kv-projects/bitcask-go/tmp", "a.data")
-	fio, err := NewFileIOManager(path)
+	fio, err := NewFileIOManager(OSFileSystem{}, path)
 	defer destroyFile(path)
 	assert.Nil(t, err)
 	assert.NotNil(t, fio)
@@ -72,7 +72,7 @@ func TestFileIO_Read(t *testing.T) {
 
 func TestFileIO_Sync(t *testing.T) {
 	path := filepath.Join("/Users/yefeixiang/coding/kv-projects/bitcask-go/tmp", "a.data")
-	fio, err := NewFileIOManager(path)
+	fio, err := NewFileIOManager(OSFileSystem{}, path)
 	defer destroyFile(path)
 	assert.Nil(t, err)
 	assert.NotNil(t, fio)
@@ -83,7 +83,7 @@ func TestFileIO_Sync(t *testing.T) {
 
 func TestFileIO_Close(t *testing.T) {
 	path := filepath.Join("/Users/yefeixiang/coding/kv-projects/bitcask-go/tmp", "a.data")
-	fio, err := NewFileIOManager(path)
+	fio, err := NewFileIOManager(OSFileSystem{}, path)
 	defer destroyFile(path)
 	assert.Nil(t, err)
 	assert.NotNil(t, fio)
@@ -0,0 +1,201 @@
+package fio
+
+import (
+	"bitcask-go/utils"
+	"errors"
+	"github.com/gofrs/flock"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+var (
+	ErrFileLocked = errors.New("the file is locked by another process")
+)
+
+// FileSystem 抽象文件系统接口，存储引擎所有的文件和目录操作都通过它完成
+type FileSystem interface {
+	// OpenFile 按照flag打开文件，语义和os.OpenFile一致
+	OpenFile(name string, flag int, perm fs.FileMode) (File, error)
+
+	// Stat 获取文件信息
+	Stat(name string) (fs.FileInfo, error)
+
+	// ReadDir 读取目录下的所有文件，按照文件名排序
+	ReadDir(name string) ([]fs.DirEntry, error)
+
+	// MkdirAll 递归创建目录
+	MkdirAll(path string, perm fs.FileMode) error
+
+	// Remove 删除文件或者空目录
+	Remove(name string) error
+
+	// RemoveAll 删除目录及其下面的所有文件
+	RemoveAll(path string) error
+
+	// Rename 重命名文件
+	Rename(oldPath, newPath string) error
+
+	// TryLock 尝试获取互斥的文件锁，已经被占用时返回ErrFileLocked
+	TryLock(name string) (FileLock, error)
+
+	// TryRLock 尝试获取共享的文件锁，可以和其他共享锁同时持有，已经被互斥锁占用时返回ErrFileLocked
+	TryRLock(name string) (FileLock, error)
+}
+
+// File 文件系统中打开的文件
+type File interface {
+	io.ReaderAt
+	io.Writer
+	io.Closer
+
+	// Sync 持久化数据
+	Sync() error
+
+	// Stat 获取文件信息
+	Stat() (fs.FileInfo, error)
+
+	// Truncate 修改文件大小
+	Truncate(size int64) error
+}
+
+// FileLock 文件锁，保证多进程之间的互斥
+type FileLock interface {
+	Unlock() error
+}
+
+// Linker 支持硬链接的文件系统
+type Linker interface {
+	// Link 创建newName指向oldName的硬链接，newName已经存在时返回错误
+	Link(oldName, newName string) error
+}
+
+// OSFileSystem 操作系统的文件系统
+type OSFileSystem struct{}
+
+func (OSFileSystem) OpenFile(name string, flag int, perm fs.FileMode) (File, error) {
+	return os.OpenFile(name, flag, perm)
+}
+
+func (OSFileSystem) Stat(name string) (fs.FileInfo, error) {
+	return os.Stat(name)
+}
+
+func (OSFileSystem) ReadDir(name string) ([]fs.DirEntry, error) {
+	return os.ReadDir(name)
+}
+
+func (OSFileSystem) MkdirAll(path string, perm fs.FileMode) error {
+	return os.MkdirAll(path, perm)
+}
+
+func (OSFileSystem) Remove(name string) error {
+	return os.Remove(name)
+}
+
+func (OSFileSystem) RemoveAll(path string) error {
+	return os.RemoveAll(path)
+}
+
+func (OSFileSystem) Rename(oldPath, newPath string) error {
+	return os.Rename(oldPath, newPath)
+}
+
+func (OSFileSystem) Link(oldName, newName string) error {
+	return os.Link(oldName, newName)
+}
+
+func (OSFileSystem) TryLock(name string) (FileLock, error) {
+	fileLock := flock.New(name)
+	hold, err := fileLock.TryLock()
+	if err != nil {
+		return nil, err
+	}
+	if !hold {
+		return nil, ErrFileLocked
+	}
+	return fileLock, nil
+}
+
+func (OSFileSystem) TryRLock(name string) (FileLock, error) {
+	fileLock := flock.New(name)
+	hold, err := fileLock.TryRLock()
+	if err != nil {
+		return nil, err
+	}
+	if !hold {
+		return nil, ErrFileLocked
+	}
+	return fileLock, nil
+}
+
+// DirSize 获取一个目录的大小
+func DirSize(fsys FileSystem, dirPath string) (int64, error) {
+	entries, err := fsys.ReadDir(dirPath)
+	if err != nil {
+		return 0, err
+	}
+
+	var size int64
+	for _, entry := range entries {
+		path := filepath.Join(dirPath, entry.Name())
+		if entry.IsDir() {
+			n, err := DirSize(fsys, path)
+			if err != nil {
+				return 0, err
+			}
+			size += n
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return 0, err
+		}
+		size += info.Size()
+	}
+	return size, nil
+}
+
+// CopyFile 拷贝单个文件，目标文件存在时会被覆盖，limiter为空时不限速
+func CopyFile(fsys FileSystem, src, dest string, limiter *utils.RateLimiter) error {
+	srcFile, err := fsys.OpenFile(src, os.O_RDONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer srcFile.Close()
+
+	info, err := srcFile.Stat()
+	if err != nil {
+		return err
+	}
+
+	destFile, err := fsys.OpenFile(dest, os.O_CREATE|os.O_RDWR|os.O_TRUNC, info.Mode())
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(destFile, utils.NewRateLimitedReader(io.NewSectionReader(srcFile, 0, info.Size()), limiter))
+	if err == nil {
+		err = destFile.Sync()
+	}
+	if closeErr := destFile.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// LinkOrCopyFile 优先使用硬链接，文件系统不支持或者链接失败(比如跨设备)时拷贝文件
+// 只能用于不会再被修改的文件，硬链接和源文件共享数据
+// 目标文件存在时先删除，它可能是和别的文件共享数据的硬链接，直接截断会修改别的文件
+func LinkOrCopyFile(fsys FileSystem, src, dest string, limiter *utils.RateLimiter) error {
+	if err := fsys.Remove(dest); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if linker, ok := fsys.(Linker); ok {
+		if err := linker.Link(src, dest); err == nil {
+			return nil
+		}
+	}
+	return CopyFile(fsys, src, dest, limiter)
+}
@@ -1,18 +1,50 @@
 package fio
 
+import "errors"
+
 const DataFilePerm = 0644
 
+var (
+	ErrDirectIOReadOnly = errors.New("the direct io manager is read only")
+)
+
 type FileIOType = byte
 
 const (
 	// StandardIO 标准文件 IO
 	StandardIO FileIOType = iota
 
-	// MemoryMap 内存文件映射
+	// MemoryMap 内存文件映射，只能读取数据
 	MemoryMap
+
+	// MemoryMapRW 可读写的内存文件映射
+	MemoryMapRW
+
+	// DirectIO 绕过页缓存直接读取，只能读取数据
+	DirectIO
+
+	// ReadOnlyIO 只读的标准文件IO，不会创建文件
+	ReadOnlyIO
 )
 
-// IOManager 抽象IO管理接口，可以接入不同的IO类型，目前支持标准文件IO
+// Advice 文件的访问模式，操作系统据此调整预读和页缓存
+type Advice = byte
+
+const (
+	// AdviceNormal 默认的访问模式
+	AdviceNormal Advice = iota
+
+	// AdviceSequential 顺序读取，加大预读
+	AdviceSequential
+
+	// AdviceRandom 随机读取，关闭预读
+	AdviceRandom
+
+	// AdviceDontNeed 之后不再访问，释放文件的页缓存
+	AdviceDontNeed
+)
+
+// IOManager 抽象IO管理接口，可以接入不同的IO类型，目前支持标准文件IO和内存文件映射
 type IOManager interface {
 	// Read 从文件的给定位置读取对应的数据
 	Read([]byte, int64) (int, error)
@@ -30,13 +62,41 @@ type IOManager interface {
 	Size() (int64, error)
 }
 
+// Preallocator 可以预先分配文件空间的IOManager
+type Preallocator interface {
+	// Preallocate 预先分配文件空间，不改变实际的数据大小
+	Preallocate(size int64) error
+}
+
+// Advisor 可以设置访问模式的IOManager
+type Advisor interface {
+	// Advise 设置文件的访问模式，只是给操作系统的建议
+	Advise(advice Advice) error
+}
+
 // NewIOManager 初始化IOManager
-func NewIOManager(fileName string, ioType FileIOType) (IOManager, error) {
+// 内存映射和DirectIO只能用于操作系统的文件系统，其他文件系统使用标准文件IO
+func NewIOManager(fs FileSystem, fileName string, ioType FileIOType) (IOManager, error) {
 	switch ioType {
 	case StandardIO:
-		return NewFileIOManager(fileName)
+		return NewFileIOManager(fs, fileName)
 	case MemoryMap:
+		if _, ok := fs.(OSFileSystem); !ok {
+			return NewFileIOManager(fs, fileName)
+		}
 		return NewMMapIOManager(fileName)
+	case MemoryMapRW:
+		if _, ok := fs.(OSFileSystem); !ok {
+			return NewFileIOManager(fs, fileName)
+		}
+		return NewMMapRWIOManager(fileName)
+	case DirectIO:
+		if _, ok := fs.(OSFileSystem); !ok {
+			return NewFileIOManager(fs, fileName)
+		}
+		return NewDirectIOManager(fileName)
+	case ReadOnlyIO:
+		return NewReadOnlyFileIOManager(fs, fileName)
 	default:
 		panic("unsupported io type")
 	}
@@ -0,0 +1,477 @@
+package fio
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemFileSystem 内存文件系统，数据不会落盘，可用于单元测试和临时缓存
+type MemFileSystem struct {
+	mu    sync.Mutex
+	files map[string]*memNode //文件路径 -> 文件内容
+	dirs  map[string]*memNode //目录路径 -> 目录信息
+	locks map[string]int      //已经被持有的文件锁，-1表示互斥锁，大于0表示共享锁的数量
+}
+
+// memNode 内存中的文件或者目录
+type memNode struct {
+	mu      sync.RWMutex
+	name    string
+	data    []byte
+	synced  []byte //最近一次Sync时的数据，崩溃之后只保留这部分
+	mode    fs.FileMode
+	modTime time.Time
+	isDir   bool
+}
+
+func NewMemFileSystem() *MemFileSystem {
+	return &MemFileSystem{
+		files: make(map[string]*memNode),
+		dirs:  make(map[string]*memNode),
+		locks: make(map[string]int),
+	}
+}
+
+func (mfs *MemFileSystem) OpenFile(name string, flag int, perm fs.FileMode) (File, error) {
+	mfs.mu.Lock()
+	defer mfs.mu.Unlock()
+
+	name = filepath.Clean(name)
+	if mfs.isDir(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+
+	node, ok := mfs.files[name]
+	if ok && flag&os.O_CREATE != 0 && flag&os.O_EXCL != 0 {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrExist}
+	}
+	if !ok {
+		if flag&os.O_CREATE == 0 {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+		}
+		//和操作系统一致，父目录必须存在
+		if !mfs.isDir(filepath.Dir(name)) {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+		}
+		node = &memNode{name: filepath.Base(name), mode: perm, modTime: time.Now()}
+		mfs.files[name] = node
+	}
+
+	if flag&os.O_TRUNC != 0 {
+		node.mu.Lock()
+		node.data = nil
+		node.mu.Unlock()
+	}
+
+	return &memFile{
+		node:     node,
+		readOnly: flag&(os.O_WRONLY|os.O_RDWR) == 0,
+		append:   flag&os.O_APPEND != 0,
+	}, nil
+}
+
+func (mfs *MemFileSystem) Stat(name string) (fs.FileInfo, error) {
+	mfs.mu.Lock()
+	defer mfs.mu.Unlock()
+
+	name = filepath.Clean(name)
+	if node, ok := mfs.files[name]; ok {
+		return node.stat(filepath.Base(name)), nil
+	}
+	if mfs.isDir(name) {
+		return mfs.dirStat(name), nil
+	}
+	return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+}
+
+func (mfs *MemFileSystem) ReadDir(name string) ([]fs.DirEntry, error) {
+	mfs.mu.Lock()
+	defer mfs.mu.Unlock()
+
+	name = filepath.Clean(name)
+	if !mfs.isDir(name) {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+
+	var entries []fs.DirEntry
+	for path, node := range mfs.files {
+		if filepath.Dir(path) == name {
+			entries = append(entries, fs.FileInfoToDirEntry(node.stat(filepath.Base(path))))
+		}
+	}
+	for path := range mfs.dirs {
+		if path != name && filepath.Dir(path) == name {
+			entries = append(entries, fs.FileInfoToDirEntry(mfs.dirStat(path)))
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+	return entries, nil
+}
+
+func (mfs *MemFileSystem) MkdirAll(path string, perm fs.FileMode) error {
+	mfs.mu.Lock()
+	defer mfs.mu.Unlock()
+
+	path = filepath.Clean(path)
+	for p := path; !mfs.isDir(p); p = filepath.Dir(p) {
+		if _, ok := mfs.files[p]; ok {
+			return &fs.PathError{Op: "mkdir", Path: p, Err: fs.ErrExist}
+		}
+		mfs.dirs[p] = &memNode{name: filepath.Base(p), mode: perm | fs.ModeDir, modTime: time.Now(), isDir: true}
+	}
+	return nil
+}
+
+func (mfs *MemFileSystem) Remove(name string) error {
+	mfs.mu.Lock()
+	defer mfs.mu.Unlock()
+
+	name = filepath.Clean(name)
+	if _, ok := mfs.files[name]; ok {
+		delete(mfs.files, name)
+		return nil
+	}
+	if _, ok := mfs.dirs[name]; ok {
+		if mfs.hasChildren(name) {
+			return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrInvalid}
+		}
+		delete(mfs.dirs, name)
+		return nil
+	}
+	return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrNotExist}
+}
+
+func (mfs *MemFileSystem) RemoveAll(path string) error {
+	mfs.mu.Lock()
+	defer mfs.mu.Unlock()
+
+	path = filepath.Clean(path)
+	delete(mfs.files, path)
+	delete(mfs.dirs, path)
+	for p := range mfs.files {
+		if isSubPath(path, p) {
+			delete(mfs.files, p)
+		}
+	}
+	for p := range mfs.dirs {
+		if isSubPath(path, p) {
+			delete(mfs.dirs, p)
+		}
+	}
+	return nil
+}
+
+func (mfs *MemFileSystem) Rename(oldPath, newPath string) error {
+	mfs.mu.Lock()
+	defer mfs.mu.Unlock()
+
+	oldPath, newPath = filepath.Clean(oldPath), filepath.Clean(newPath)
+	if !mfs.isDir(filepath.Dir(newPath)) {
+		return &os.LinkError{Op: "rename", Old: oldPath, New: newPath, Err: fs.ErrNotExist}
+	}
+
+	//重命名文件，目标文件存在则被覆盖
+	if node, ok := mfs.files[oldPath]; ok {
+		if mfs.isDir(newPath) {
+			return &os.LinkError{Op: "rename", Old: oldPath, New: newPath, Err: fs.ErrExist}
+		}
+		delete(mfs.files, oldPath)
+		node.mu.Lock()
+		node.name = filepath.Base(newPath)
+		node.mu.Unlock()
+		mfs.files[newPath] = node
+		return nil
+	}
+
+	//重命名目录，目标必须不存在
+	node, ok := mfs.dirs[oldPath]
+	if !ok {
+		return &os.LinkError{Op: "rename", Old: oldPath, New: newPath, Err: fs.ErrNotExist}
+	}
+	if _, ok := mfs.files[newPath]; ok || mfs.isDir(newPath) {
+		return &os.LinkError{Op: "rename", Old: oldPath, New: newPath, Err: fs.ErrExist}
+	}
+	delete(mfs.dirs, oldPath)
+	node.name = filepath.Base(newPath)
+	mfs.dirs[newPath] = node
+	for p, n := range mfs.files {
+		if isSubPath(oldPath, p) {
+			delete(mfs.files, p)
+			mfs.files[newPath+p[len(oldPath):]] = n
+		}
+	}
+	for p, n := range mfs.dirs {
+		if isSubPath(oldPath, p) {
+			delete(mfs.dirs, p)
+			mfs.dirs[newPath+p[len(oldPath):]] = n
+		}
+	}
+	return nil
+}
+
+func (mfs *MemFileSystem) Link(oldName, newName string) error {
+	mfs.mu.Lock()
+	defer mfs.mu.Unlock()
+
+	oldName, newName = filepath.Clean(oldName), filepath.Clean(newName)
+	node, ok := mfs.files[oldName]
+	if !ok {
+		return &os.LinkError{Op: "link", Old: oldName, New: newName, Err: fs.ErrNotExist}
+	}
+	if !mfs.isDir(filepath.Dir(newName)) {
+		return &os.LinkError{Op: "link", Old: oldName, New: newName, Err: fs.ErrNotExist}
+	}
+	if _, ok := mfs.files[newName]; ok || mfs.isDir(newName) {
+		return &os.LinkError{Op: "link", Old: oldName, New: newName, Err: fs.ErrExist}
+	}
+	//两个路径共享同一份数据
+	mfs.files[newName] = node
+	return nil
+}
+
+func (mfs *MemFileSystem) TryLock(name string) (FileLock, error) {
+	mfs.mu.Lock()
+	defer mfs.mu.Unlock()
+
+	name = filepath.Clean(name)
+	if _, ok := mfs.locks[name]; ok {
+		return nil, ErrFileLocked
+	}
+	mfs.locks[name] = -1
+	return &memFileLock{fs: mfs, name: name}, nil
+}
+
+func (mfs *MemFileSystem) TryRLock(name string) (FileLock, error) {
+	mfs.mu.Lock()
+	defer mfs.mu.Unlock()
+
+	name = filepath.Clean(name)
+	if mfs.locks[name] < 0 {
+		return nil, ErrFileLocked
+	}
+	mfs.locks[name]++
+	return &memFileLock{fs: mfs, name: name, shared: true}, nil
+}
+
+// Crash 模拟崩溃并释放所有的文件锁，崩溃之前打开的文件不应该再使用
+// dropUnsynced为true模拟掉电，所有文件丢弃没有Sync的数据，为false模拟进程崩溃，已经写入的数据仍然保留
+// 目录相关的操作(创建、删除、重命名)视为立即持久化
+func (mfs *MemFileSystem) Crash(dropUnsynced bool) {
+	mfs.mu.Lock()
+	defer mfs.mu.Unlock()
+
+	if dropUnsynced {
+		for _, node := range mfs.files {
+			node.mu.Lock()
+			node.data = node.synced
+			node.mu.Unlock()
+		}
+	}
+	mfs.locks = make(map[string]int)
+}
+
+// 当前目录和根目录总是存在
+func (mfs *MemFileSystem) isDir(path string) bool {
+	if path == "." || path == string(filepath.Separator) {
+		return true
+	}
+	_, ok := mfs.dirs[path]
+	return ok
+}
+
+func (mfs *MemFileSystem) dirStat(path string) fs.FileInfo {
+	if node, ok := mfs.dirs[path]; ok {
+		return node.stat(filepath.Base(path))
+	}
+	return &memFileInfo{name: filepath.Base(path), mode: fs.ModeDir | os.ModePerm, isDir: true}
+}
+
+func (mfs *MemFileSystem) hasChildren(dir string) bool {
+	for p := range mfs.files {
+		if filepath.Dir(p) == dir {
+			return true
+		}
+	}
+	for p := range mfs.dirs {
+		if p != dir && filepath.Dir(p) == dir {
+			return true
+		}
+	}
+	return false
+}
+
+// isSubPath path是否在dir目录下面
+func isSubPath(dir, path string) bool {
+	if dir == "." {
+		return path != "."
+	}
+	return strings.HasPrefix(path, dir+string(filepath.Separator)) ||
+		(dir == string(filepath.Separator) && path != dir)
+}
+
+// 硬链接的文件共享同一个节点，name为空时使用节点的名字
+func (node *memNode) stat(name string) fs.FileInfo {
+	node.mu.RLock()
+	defer node.mu.RUnlock()
+	if name == "" {
+		name = node.name
+	}
+	return &memFileInfo{
+		name:    name,
+		size:    int64(len(node.data)),
+		mode:    node.mode,
+		modTime: node.modTime,
+		isDir:   node.isDir,
+	}
+}
+
+// memFile 内存文件系统中打开的文件
+type memFile struct {
+	node     *memNode
+	offset   int64 //非追加模式下的写入位置
+	readOnly bool
+	append   bool
+	closed   bool
+}
+
+func (f *memFile) ReadAt(b []byte, offset int64) (int, error) {
+	if f.closed {
+		return 0, fs.ErrClosed
+	}
+	if offset < 0 {
+		return 0, fs.ErrInvalid
+	}
+
+	f.node.mu.RLock()
+	defer f.node.mu.RUnlock()
+
+	if offset >= int64(len(f.node.data)) {
+		return 0, io.EOF
+	}
+	n := copy(b, f.node.data[offset:])
+	if n < len(b) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (f *memFile) Write(b []byte) (int, error) {
+	if f.closed {
+		return 0, fs.ErrClosed
+	}
+	if f.readOnly {
+		return 0, fs.ErrPermission
+	}
+
+	f.node.mu.Lock()
+	defer f.node.mu.Unlock()
+
+	if f.append {
+		f.offset = int64(len(f.node.data))
+	}
+	//覆盖已经持久化的数据时先拷贝一份，避免修改synced
+	if f.offset < int64(len(f.node.synced)) {
+		f.node.data = append([]byte(nil), f.node.data...)
+	}
+	end := f.offset + int64(len(b))
+	if end > int64(len(f.node.data)) {
+		//按照append的策略扩容，避免每次写入都重新分配
+		f.node.data = append(f.node.data, make([]byte, end-int64(len(f.node.data)))...)
+	}
+	copy(f.node.data[f.offset:], b)
+	f.offset = end
+	f.node.modTime = time.Now()
+	return len(b), nil
+}
+
+func (f *memFile) Sync() error {
+	if f.closed {
+		return fs.ErrClosed
+	}
+
+	f.node.mu.Lock()
+	defer f.node.mu.Unlock()
+	//限制容量，之后追加写入不会影响已经持久化的数据
+	f.node.synced = f.node.data[:len(f.node.data):len(f.node.data)]
+	return nil
+}
+
+func (f *memFile) Close() error {
+	if f.closed {
+		return fs.ErrClosed
+	}
+	f.closed = true
+	return nil
+}
+
+func (f *memFile) Stat() (fs.FileInfo, error) {
+	if f.closed {
+		return nil, fs.ErrClosed
+	}
+	return f.node.stat(""), nil
+}
+
+func (f *memFile) Truncate(size int64) error {
+	if f.closed {
+		return fs.ErrClosed
+	}
+	if size < 0 {
+		return fs.ErrInvalid
+	}
+
+	f.node.mu.Lock()
+	defer f.node.mu.Unlock()
+
+	if size < int64(len(f.node.synced)) {
+		f.node.data = append([]byte(nil), f.node.data[:size]...)
+	} else if size <= int64(len(f.node.data)) {
+		f.node.data = f.node.data[:size]
+	} else {
+		data := make([]byte, size)
+		copy(data, f.node.data)
+		f.node.data = data
+	}
+	f.node.modTime = time.Now()
+	return nil
+}
+
+// memFileLock 内存文件系统中的文件锁
+type memFileLock struct {
+	fs     *MemFileSystem
+	name   string
+	shared bool
+}
+
+func (l *memFileLock) Unlock() error {
+	l.fs.mu.Lock()
+	defer l.fs.mu.Unlock()
+	if l.shared && l.fs.locks[l.name] > 1 {
+		l.fs.locks[l.name]--
+		return nil
+	}
+	delete(l.fs.locks, l.name)
+	return nil
+}
+
+type memFileInfo struct {
+	name    string
+	size    int64
+	mode    fs.FileMode
+	modTime time.Time
+	isDir   bool
+}
+
+func (fi *memFileInfo) Name() string       { return fi.name }
+func (fi *memFileInfo) Size() int64        { return fi.size }
+func (fi *memFileInfo) Mode() fs.FileMode  { return fi.mode }
+func (fi *memFileInfo) ModTime() time.Time { return fi.modTime }
+func (fi *memFileInfo) IsDir() bool        { return fi.isDir }
+func (fi *memFileInfo) Sys() interface{}   { return nil }
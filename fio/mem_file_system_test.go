@@ -0,0 +1,165 @@
+package fio
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io"
+	"io/fs"
+	"os"
+	"testing"
+)
+
+func TestMemFileSystem_OpenFile(t *testing.T) {
+	mfs := NewMemFileSystem()
+
+	//父目录不存在
+	_, err := mfs.OpenFile("/db/a.data", os.O_CREATE|os.O_RDWR, DataFilePerm)
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+
+	err = mfs.MkdirAll("/db", os.ModePerm)
+	assert.Nil(t, err)
+	f, err := mfs.OpenFile("/db/a.data", os.O_CREATE|os.O_RDWR|os.O_APPEND, DataFilePerm)
+	assert.Nil(t, err)
+
+	n, err := f.Write([]byte("bitcask kv"))
+	assert.Nil(t, err)
+	assert.Equal(t, 10, n)
+	_, err = f.Write([]byte("storage"))
+	assert.Nil(t, err)
+
+	b := make([]byte, 7)
+	n, err = f.ReadAt(b, 10)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("storage"), b)
+
+	n, err = f.ReadAt(b, 15)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, io.EOF, err)
+
+	stat, err := f.Stat()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(17), stat.Size())
+
+	err = f.Truncate(7)
+	assert.Nil(t, err)
+	stat, err = mfs.Stat("/db/a.data")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(7), stat.Size())
+
+	assert.Nil(t, f.Close())
+	_, err = f.Write([]byte("a"))
+	assert.ErrorIs(t, err, fs.ErrClosed)
+}
+
+func TestMemFileSystem_ReadDir(t *testing.T) {
+	mfs := NewMemFileSystem()
+	err := mfs.MkdirAll("/db/sub", os.ModePerm)
+	assert.Nil(t, err)
+
+	for _, name := range []string{"/db/b.data", "/db/a.data", "/db/sub/c.data"} {
+		f, err := mfs.OpenFile(name, os.O_CREATE|os.O_RDWR, DataFilePerm)
+		assert.Nil(t, err)
+		_, err = f.Write([]byte("12345"))
+		assert.Nil(t, err)
+	}
+
+	entries, err := mfs.ReadDir("/db")
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(entries))
+	assert.Equal(t, "a.data", entries[0].Name())
+	assert.Equal(t, "b.data", entries[1].Name())
+	assert.Equal(t, "sub", entries[2].Name())
+	assert.True(t, entries[2].IsDir())
+
+	size, err := DirSize(mfs, "/db")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(15), size)
+}
+
+func TestMemFileSystem_RenameAndRemove(t *testing.T) {
+	mfs := NewMemFileSystem()
+	err := mfs.MkdirAll("/db-merge", os.ModePerm)
+	assert.Nil(t, err)
+	err = mfs.MkdirAll("/db", os.ModePerm)
+	assert.Nil(t, err)
+	_, err = mfs.OpenFile("/db-merge/000000001.data", os.O_CREATE|os.O_RDWR, DataFilePerm)
+	assert.Nil(t, err)
+
+	err = mfs.Rename("/db-merge/000000001.data", "/db/000000001.data")
+	assert.Nil(t, err)
+	_, err = mfs.Stat("/db-merge/000000001.data")
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+	_, err = mfs.Stat("/db/000000001.data")
+	assert.Nil(t, err)
+
+	//非空目录不能直接删除
+	err = mfs.Remove("/db")
+	assert.NotNil(t, err)
+	err = mfs.RemoveAll("/db")
+	assert.Nil(t, err)
+	_, err = mfs.Stat("/db/000000001.data")
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+	_, err = mfs.Stat("/db")
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+}
+
+func TestMemFileSystem_Link(t *testing.T) {
+	mfs := NewMemFileSystem()
+	err := mfs.MkdirAll("/db", os.ModePerm)
+	assert.Nil(t, err)
+	err = mfs.MkdirAll("/backup", os.ModePerm)
+	assert.Nil(t, err)
+	file, err := mfs.OpenFile("/db/000000001.data", os.O_CREATE|os.O_RDWR, DataFilePerm)
+	assert.Nil(t, err)
+	_, err = file.Write([]byte("bitcask kv"))
+	assert.Nil(t, err)
+
+	err = mfs.Link("/db/000000001.data", "/backup/000000002.data")
+	assert.Nil(t, err)
+	err = mfs.Link("/db/000000001.data", "/backup/000000002.data")
+	assert.ErrorIs(t, err, fs.ErrExist)
+
+	info, err := mfs.Stat("/backup/000000002.data")
+	assert.Nil(t, err)
+	assert.Equal(t, "000000002.data", info.Name())
+	assert.Equal(t, int64(10), info.Size())
+
+	//删除源文件不影响硬链接
+	err = mfs.Remove("/db/000000001.data")
+	assert.Nil(t, err)
+	linked, err := mfs.OpenFile("/backup/000000002.data", os.O_RDONLY, 0)
+	assert.Nil(t, err)
+	buf := make([]byte, 10)
+	_, err = linked.ReadAt(buf, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, "bitcask kv", string(buf))
+}
+
+func TestMemFileSystem_TryLock(t *testing.T) {
+	mfs := NewMemFileSystem()
+	lock, err := mfs.TryLock("/db/flock")
+	assert.Nil(t, err)
+
+	_, err = mfs.TryLock("/db/flock")
+	assert.Equal(t, ErrFileLocked, err)
+
+	assert.Nil(t, lock.Unlock())
+	lock, err = mfs.TryLock("/db/flock")
+	assert.Nil(t, err)
+	assert.NotNil(t, lock)
+}
+
+func TestMemFileSystem_IOManager(t *testing.T) {
+	mfs := NewMemFileSystem()
+
+	//内存文件系统不支持内存映射，退化为标准文件IO
+	ioManager, err := NewIOManager(mfs, "a.data", MemoryMap)
+	assert.Nil(t, err)
+	_, ok := ioManager.(*FileIO)
+	assert.True(t, ok)
+
+	_, err = ioManager.Write([]byte("key-a"))
+	assert.Nil(t, err)
+	size, err := ioManager.Size()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(5), size)
+}
@@ -1,10 +1,15 @@
 package fio
 
 import (
+	"errors"
 	"golang.org/x/exp/mmap"
 	"os"
 )
 
+var (
+	ErrMMapReadOnly = errors.New("the mmap io manager is read only")
+)
+
 // MMap IO 内存文件映射
 type MMap struct {
 	readerAt *mmap.ReaderAt //只能用来读取数据
@@ -29,14 +34,14 @@ func (mmap *MMap) Read(b []byte, offset int64) (int, error) {
 	return mmap.readerAt.ReadAt(b, offset)
 }
 
-// Write 写入字节数组到文件中
+// Write 只读的内存映射不能写入，需要写入时使用MMapRW
 func (mmap *MMap) Write(b []byte) (int, error) {
-	panic("not implemented")
+	return 0, ErrMMapReadOnly
 }
 
-// Sync 持久化数据
+// Sync 只读的内存映射没有需要持久化的数据
 func (mmap *MMap) Sync() error {
-	panic("not implemented")
+	return nil
 }
 
 // Close 关闭文件
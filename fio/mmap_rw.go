@@ -0,0 +1,156 @@
+package fio
+
+import (
+	"golang.org/x/sys/unix"
+	"io"
+	"os"
+	"sync"
+)
+
+// MMapRW 可读写的内存文件映射，文件预先分配空间，写入通过内存拷贝完成
+// 关闭时将文件截断到实际写入的大小
+type MMapRW struct {
+	mu   sync.RWMutex
+	fd   *os.File
+	data []byte //映射的内存区域，长度就是文件当前分配的大小
+	size int64  //实际写入的数据大小
+}
+
+func NewMMapRWIOManager(filename string) (*MMapRW, error) {
+	fd, err := os.OpenFile(filename, os.O_CREATE|os.O_RDWR, DataFilePerm)
+	if err != nil {
+		return nil, err
+	}
+	stat, err := fd.Stat()
+	if err != nil {
+		_ = fd.Close()
+		return nil, err
+	}
+
+	mmapRW := &MMapRW{fd: fd, size: stat.Size()}
+	if err := mmapRW.remap(stat.Size()); err != nil {
+		_ = fd.Close()
+		return nil, err
+	}
+	return mmapRW, nil
+}
+
+func (mmap *MMapRW) Read(b []byte, offset int64) (int, error) {
+	mmap.mu.RLock()
+	defer mmap.mu.RUnlock()
+
+	if offset >= mmap.size {
+		return 0, io.EOF
+	}
+	n := copy(b, mmap.data[offset:mmap.size])
+	if n < len(b) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Write 追加写入到映射的内存中，空间不够时扩大文件并重新映射
+func (mmap *MMapRW) Write(b []byte) (int, error) {
+	mmap.mu.Lock()
+	defer mmap.mu.Unlock()
+
+	end := mmap.size + int64(len(b))
+	if end > int64(len(mmap.data)) {
+		capacity := 2 * int64(len(mmap.data))
+		if capacity < end {
+			capacity = end
+		}
+		if err := mmap.grow(capacity); err != nil {
+			return 0, err
+		}
+	}
+
+	copy(mmap.data[mmap.size:], b)
+	mmap.size = end
+	return len(b), nil
+}
+
+// Sync 将映射的内存刷到磁盘
+func (mmap *MMapRW) Sync() error {
+	mmap.mu.RLock()
+	defer mmap.mu.RUnlock()
+
+	if len(mmap.data) == 0 {
+		return nil
+	}
+	return unix.Msync(mmap.data, unix.MS_SYNC)
+}
+
+// Close 解除映射，并将文件截断到实际写入的大小
+func (mmap *MMapRW) Close() error {
+	mmap.mu.Lock()
+	defer mmap.mu.Unlock()
+
+	if err := mmap.unmap(); err != nil {
+		return err
+	}
+	if err := mmap.fd.Truncate(mmap.size); err != nil {
+		return err
+	}
+	//截断之后的大小也需要持久化，否则崩溃之后文件末尾可能还是预分配的空间
+	if err := mmap.fd.Sync(); err != nil {
+		return err
+	}
+	return mmap.fd.Close()
+}
+
+// Size 获取实际写入的数据大小
+func (mmap *MMapRW) Size() (int64, error) {
+	mmap.mu.RLock()
+	defer mmap.mu.RUnlock()
+	return mmap.size, nil
+}
+
+// Preallocate 预先分配文件空间，避免写入时频繁的重新映射
+func (mmap *MMapRW) Preallocate(size int64) error {
+	mmap.mu.Lock()
+	defer mmap.mu.Unlock()
+
+	if size <= int64(len(mmap.data)) {
+		return nil
+	}
+	return mmap.grow(size)
+}
+
+func (mmap *MMapRW) grow(capacity int64) error {
+	if err := mmap.fd.Truncate(capacity); err != nil {
+		return err
+	}
+	return mmap.remap(capacity)
+}
+
+func (mmap *MMapRW) remap(capacity int64) error {
+	if err := mmap.unmap(); err != nil {
+		return err
+	}
+	//空文件不能映射
+	if capacity == 0 {
+		return nil
+	}
+
+	data, err := unix.Mmap(int(mmap.fd.Fd()), 0, int(capacity), unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED)
+	if err != nil {
+		return err
+	}
+	mmap.data = data
+	return nil
+}
+
+func (mmap *MMapRW) unmap() error {
+	if mmap.data == nil {
+		return nil
+	}
+	if err := unix.Msync(mmap.data, unix.MS_SYNC); err != nil {
+		return err
+	}
+	if err := unix.Munmap(mmap.data); err != nil {
+		return err
+	}
+	mmap.data = nil
+	return nil
+}
@@ -0,0 +1,74 @@
+package fio
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMMapRW_Write(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "bitcask-go-mmap-rw")
+	filename := filepath.Join(dir, "mmap-rw.data")
+	defer destroyFile(dir)
+
+	mmapRW, err := NewMMapRWIOManager(filename)
+	assert.Nil(t, err)
+	err = mmapRW.Preallocate(16)
+	assert.Nil(t, err)
+
+	n, err := mmapRW.Write([]byte("bitcask kv"))
+	assert.Nil(t, err)
+	assert.Equal(t, 10, n)
+	//超过预分配的大小
+	n, err = mmapRW.Write([]byte("storage"))
+	assert.Nil(t, err)
+	assert.Equal(t, 7, n)
+
+	size, err := mmapRW.Size()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(17), size)
+
+	b := make([]byte, 7)
+	n, err = mmapRW.Read(b, 10)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("storage"), b)
+	n, err = mmapRW.Read(b, 15)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, io.EOF, err)
+
+	err = mmapRW.Sync()
+	assert.Nil(t, err)
+
+	//关闭之后文件截断到实际的大小
+	err = mmapRW.Close()
+	assert.Nil(t, err)
+	stat, err := os.Stat(filename)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(17), stat.Size())
+
+	//重新打开之后继续追加
+	mmapRW2, err := NewMMapRWIOManager(filename)
+	assert.Nil(t, err)
+	_, err = mmapRW2.Write([]byte("!"))
+	assert.Nil(t, err)
+	b = make([]byte, 18)
+	_, err = mmapRW2.Read(b, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("bitcask kvstorage!"), b)
+	assert.Nil(t, mmapRW2.Close())
+}
+
+func TestMMap_ReadOnly(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "bitcask-go-mmap")
+	filename := filepath.Join(dir, "mmap.data")
+	defer destroyFile(dir)
+
+	mmapIO, err := NewMMapIOManager(filename)
+	assert.Nil(t, err)
+	_, err = mmapIO.Write([]byte("aa"))
+	assert.Equal(t, ErrMMapReadOnly, err)
+	assert.Nil(t, mmapIO.Sync())
+	assert.Nil(t, mmapIO.Close())
+}
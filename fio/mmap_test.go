@@ -25,7 +25,7 @@ func TestMMap_Read(t *testing.T) {
 	assert.Equal(t, io.EOF, err)
 
 	//有文件的情况
-	fio, err := NewFileIOManager(filename)
+	fio, err := NewFileIOManager(OSFileSystem{}, filename)
 	assert.Nil(t, err)
 	_, err = fio.Write([]byte("aa"))
 	assert.Nil(t, err)
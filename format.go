@@ -0,0 +1,359 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/fio"
+	"bitcask-go/index"
+	"encoding/json"
+	"errors"
+	"io"
+	iofs "io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ManifestFileName 数据目录的格式清单，记录目录使用的磁盘格式版本
+const ManifestFileName = "MANIFEST"
+
+const upgradingFileSuffix = ".upgrading"
+
+// Manifest 数据目录的格式清单
+type Manifest struct {
+	FormatVersion uint32 `json:"formatVersion"`
+}
+
+// 不低于这个版本的目录可以直接打开，写入实例打开时更新格式清单
+const minCompatibleFormatVersion uint32 = 1
+
+// 升级到下一个格式版本的步骤，下标是升级之前的版本
+var formatUpgrades = []func(fs fio.FileSystem, dirPath string) error{
+	upgradeToV1,
+	upgradeToV2,
+	upgradeToV3,
+	upgradeToV4,
+}
+
+// ReadManifest 读取数据目录的格式清单，清单不存在时返回的错误满足errors.Is(err, fs.ErrNotExist)
+func ReadManifest(fs fio.FileSystem, dirPath string) (*Manifest, error) {
+	if fs == nil {
+		fs = fio.OSFileSystem{}
+	}
+	file, err := fs.OpenFile(filepath.Join(dirPath, ManifestFileName), os.O_RDONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	manifest := &Manifest{}
+	if err := json.NewDecoder(io.NewSectionReader(file, 0, info.Size())).Decode(manifest); err != nil {
+		return nil, ErrDataDirectoryCorrupted
+	}
+	return manifest, nil
+}
+
+// 先写临时文件再重命名，清单不会只写了一半
+func writeManifest(fs fio.FileSystem, dirPath string, manifest *Manifest) error {
+	buf, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+
+	tmpPath := filepath.Join(dirPath, ManifestFileName+".tmp")
+	file, err := fs.OpenFile(tmpPath, os.O_CREATE|os.O_RDWR|os.O_TRUNC, fio.DataFilePerm)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(buf)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return fs.Rename(tmpPath, filepath.Join(dirPath, ManifestFileName))
+}
+
+// 打开数据库时检查目录的格式版本，新的空目录写入当前版本的格式清单
+// 没有格式清单但是有数据的目录是旧版本写入的，需要先升级
+func checkFormatVersion(fs fio.FileSystem, dirPath string, entries []iofs.DirEntry, readOnly bool) error {
+	manifest, err := ReadManifest(fs, dirPath)
+	if err != nil && !errors.Is(err, iofs.ErrNotExist) {
+		return err
+	}
+
+	if manifest == nil {
+		for _, entry := range entries {
+			if isFormattedFile(entry.Name()) {
+				return ErrFormatUpgradeRequired
+			}
+		}
+		//只读模式不写入任何文件
+		if readOnly {
+			return nil
+		}
+		return writeManifest(fs, dirPath, &Manifest{FormatVersion: data.FormatVersion})
+	}
+
+	if manifest.FormatVersion > data.FormatVersion {
+		return ErrFormatVersionUnsupported
+	}
+	if manifest.FormatVersion < minCompatibleFormatVersion {
+		return ErrFormatUpgradeRequired
+	}
+	//之后新创建的文件使用当前版本的格式，旧版本不能再打开这个目录
+	if manifest.FormatVersion < data.FormatVersion && !readOnly {
+		return writeManifest(fs, dirPath, &Manifest{FormatVersion: data.FormatVersion})
+	}
+	return nil
+}
+
+// 受磁盘格式版本影响的文件
+func isFormattedFile(name string) bool {
+	switch name {
+	case data.HintFileName, data.MergeFinishedFileName, data.SeqNoFileName, index.BptreeIndexFileName:
+		return true
+	}
+	return strings.HasSuffix(name, data.DataFileNameSuffix)
+}
+
+// Upgrade 将数据目录及其merge目录升级到当前的格式版本，升级期间数据库不能被打开
+// 每升级一个版本就更新一次格式清单，中途失败之后可以重新执行
+func Upgrade(options Options) error {
+	if options.FileSystem == nil {
+		options.FileSystem = fio.OSFileSystem{}
+	}
+
+	hasBPTreeIndex, err := upgradeFormat(options)
+	if err != nil {
+		return err
+	}
+	if hasBPTreeIndex {
+		options.IndexType = BPlusTree
+	}
+	return rebuildBPTreeIndex(options)
+}
+
+// 持有文件锁逐个版本升级，返回升级之前是否有b+树索引文件
+func upgradeFormat(options Options) (bool, error) {
+	fs := options.FileSystem
+	if _, err := fs.Stat(options.DirPath); err != nil {
+		return false, err
+	}
+
+	//写入实例和只读实例都不能打开
+	for _, lockName := range []string{fileLockName, readerLockName} {
+		fileLock, err := fs.TryLock(filepath.Join(options.DirPath, lockName))
+		if err != nil {
+			if err == fio.ErrFileLocked {
+				return false, ErrDatabaseIsUsing
+			}
+			return false, err
+		}
+		defer func() {
+			_ = fileLock.Unlock()
+		}()
+	}
+
+	var version uint32
+	manifest, err := ReadManifest(fs, options.DirPath)
+	if err == nil {
+		version = manifest.FormatVersion
+	} else if !errors.Is(err, iofs.ErrNotExist) {
+		return false, err
+	}
+	if version > data.FormatVersion {
+		return false, ErrFormatVersionUnsupported
+	}
+
+	//b+树索引文件中的位置信息在升级时会失效，删除之后重新构建
+	_, err = fs.Stat(filepath.Join(options.DirPath, index.BptreeIndexFileName))
+	hasBPTreeIndex := err == nil
+
+	mergePath := (&DB{options: options}).getMergePath()
+	for ; version < data.FormatVersion; version++ {
+		for _, dirPath := range []string{options.DirPath, mergePath} {
+			if _, err := fs.Stat(dirPath); err != nil {
+				continue
+			}
+			if err := formatUpgrades[version](fs, dirPath); err != nil {
+				return false, err
+			}
+		}
+		if err := writeManifest(fs, options.DirPath, &Manifest{FormatVersion: version + 1}); err != nil {
+			return false, err
+		}
+	}
+	return hasBPTreeIndex, nil
+}
+
+// b+树索引文件不存在时从数据文件重新构建，需要在释放文件锁之后调用
+func rebuildBPTreeIndex(options Options) error {
+	if options.IndexType != BPlusTree {
+		return nil
+	}
+	if _, err := options.FileSystem.Stat(filepath.Join(options.DirPath, index.BptreeIndexFileName)); err == nil {
+		return nil
+	}
+	entries, err := options.FileSystem.ReadDir(options.DirPath)
+	if err != nil {
+		return err
+	}
+	hasDataFile := false
+	for _, entry := range entries {
+		if strings.HasSuffix(entry.Name(), data.DataFileNameSuffix) {
+			hasDataFile = true
+		}
+	}
+	if !hasDataFile {
+		return nil
+	}
+
+	//事务序列号在加载数据文件时重新计算
+	if err := removeIfExists(options.FileSystem, filepath.Join(options.DirPath, data.SeqNoFileName)); err != nil {
+		return err
+	}
+	return rebuildIndex(options)
+}
+
+// 版本0没有文件头，在所有记录文件开头加上文件头，hint文件和b+树索引中的位置需要加上文件头的大小
+func upgradeToV1(fs fio.FileSystem, dirPath string) error {
+	entries, err := fs.ReadDir(dirPath)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+		path := filepath.Join(dirPath, name)
+		switch {
+		case strings.HasSuffix(name, upgradingFileSuffix):
+			//上一次升级中途失败留下的临时文件
+			if err := fs.Remove(path); err != nil {
+				return err
+			}
+		case name == index.BptreeIndexFileName:
+			if err := fs.Remove(path); err != nil {
+				return err
+			}
+		case name == data.HintFileName:
+			if err := upgradeFile(fs, path, shiftHintRecords); err != nil {
+				return err
+			}
+		case isFormattedFile(name):
+			if err := upgradeFile(fs, path, copyRecords); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// 版本1的文件头中没有记录校验算法的字段都是0，也就是IEEE，文件不需要修改
+func upgradeToV2(fs fio.FileSystem, dirPath string) error {
+	return nil
+}
+
+// 版本2的增量记录没有记录增量链的长度，读取时仍然可以解析，下一次追加增量时折叠为完整的数据，文件不需要修改
+func upgradeToV3(fs fio.FileSystem, dirPath string) error {
+	return nil
+}
+
+// 版本3的数据文件中没有时间标记，按时间恢复时只能精确到数据文件，文件不需要修改
+func upgradeToV4(fs fio.FileSystem, dirPath string) error {
+	return nil
+}
+
+// 重写单个文件，写入文件头之后由convert写入原来的内容，已经有文件头的文件说明已经升级过，直接跳过
+func upgradeFile(fs fio.FileSystem, path string, convert func(w io.Writer, src *data.DataFile, size int64) error) error {
+	srcFile, err := data.OpenLegacyFile(fs, path)
+	if err != nil {
+		return err
+	}
+	defer srcFile.Close()
+
+	size, err := srcFile.IoManager.Size()
+	if err != nil {
+		return err
+	}
+	if size >= data.FileHeaderSize {
+		buf := make([]byte, data.FileHeaderSize)
+		if _, err := srcFile.IoManager.Read(buf, 0); err != nil {
+			return err
+		}
+		if _, err := data.DecodeFileHeader(buf); err == nil {
+			return nil
+		}
+	}
+
+	tmpPath := path + upgradingFileSuffix
+	destFile, err := fs.OpenFile(tmpPath, os.O_CREATE|os.O_RDWR|os.O_TRUNC, fio.DataFilePerm)
+	if err != nil {
+		return err
+	}
+	_, err = destFile.Write(data.EncodeFileHeader(&data.FileHeader{Version: 1}))
+	if err == nil {
+		err = convert(destFile, srcFile, size)
+	}
+	if err == nil {
+		err = destFile.Sync()
+	}
+	if closeErr := destFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = fs.Remove(tmpPath)
+		return err
+	}
+	return fs.Rename(tmpPath, path)
+}
+
+// 记录的格式没有变化，原样拷贝
+func copyRecords(w io.Writer, src *data.DataFile, size int64) error {
+	buf := make([]byte, 64*1024)
+	for offset := int64(0); offset < size; {
+		n, err := src.IoManager.Read(buf, offset)
+		if n > 0 {
+			if _, err := w.Write(buf[:n]); err != nil {
+				return err
+			}
+			offset += int64(n)
+		}
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// hint文件中记录的是数据文件中的位置，数据文件加上文件头之后位置向后移动
+func shiftHintRecords(w io.Writer, src *data.DataFile, size int64) error {
+	var offset int64
+	for offset < size {
+		logRecord, recordSize, err := src.ReadLogRecord(offset)
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		pos := data.DecodeLogRecordPos(logRecord.Value)
+		pos.Offset += data.FileHeaderSize
+		encRecord, _ := data.EncodeLogRecord(&data.LogRecord{Key: logRecord.Key, Value: data.EncodeLogRecordPos(pos)})
+		if _, err := w.Write(encRecord); err != nil {
+			return err
+		}
+		offset += recordSize
+	}
+	return nil
+}
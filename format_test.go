@@ -0,0 +1,272 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/fio"
+	"bitcask-go/utils"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// 去掉文件头并还原hint文件中的位置，模拟格式版本0写入的目录
+func downgradeToV0(t *testing.T, fs fio.FileSystem, dirPath string) {
+	entries, err := fs.ReadDir(dirPath)
+	assert.Nil(t, err)
+	for _, entry := range entries {
+		name := entry.Name()
+		path := filepath.Join(dirPath, name)
+		if name == ManifestFileName {
+			assert.Nil(t, fs.Remove(path))
+			continue
+		}
+		if name != data.HintFileName && name != data.MergeFinishedFileName &&
+			name != data.SeqNoFileName && !strings.HasSuffix(name, data.DataFileNameSuffix) {
+			continue
+		}
+
+		var content []byte
+		file, err := fs.OpenFile(path, os.O_RDONLY, 0)
+		assert.Nil(t, err)
+		info, err := file.Stat()
+		assert.Nil(t, err)
+		buf := make([]byte, info.Size())
+		_, err = io.ReadFull(io.NewSectionReader(file, 0, info.Size()), buf)
+		assert.Nil(t, err)
+		assert.Nil(t, file.Close())
+
+		if name == data.HintFileName {
+			hintFile, err := data.OpenHintFile(fs, dirPath, data.ChecksumCRC32IEEE)
+			assert.Nil(t, err)
+			for offset := data.FileHeaderSize; ; {
+				record, size, err := hintFile.ReadLogRecord(offset)
+				if err == io.EOF {
+					break
+				}
+				assert.Nil(t, err)
+				pos := data.DecodeLogRecordPos(record.Value)
+				pos.Offset -= data.FileHeaderSize
+				encRecord, _ := data.EncodeLogRecord(&data.LogRecord{Key: record.Key, Value: data.EncodeLogRecordPos(pos)})
+				content = append(content, encRecord...)
+				offset += size
+			}
+			assert.Nil(t, hintFile.Close())
+		} else {
+			content = buf[data.FileHeaderSize:]
+		}
+
+		file, err = fs.OpenFile(path, os.O_RDWR|os.O_TRUNC, fio.DataFilePerm)
+		assert.Nil(t, err)
+		_, err = file.Write(content)
+		assert.Nil(t, err)
+		assert.Nil(t, file.Close())
+	}
+}
+
+func TestOpen_FormatVersion(t *testing.T) {
+	memFS := fio.NewMemFileSystem()
+	opts := DefaultOptions
+	opts.FileSystem = memFS
+	opts.DirPath = "/bitcask"
+
+	//新的目录写入当前的格式版本
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	err = db.Put(utils.GetTestKey(1), utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Nil(t, db.Close())
+	manifest, err := ReadManifest(memFS, opts.DirPath)
+	assert.Nil(t, err)
+	assert.Equal(t, data.FormatVersion, manifest.FormatVersion)
+
+	//更新的版本写入的目录
+	err = writeManifest(memFS, opts.DirPath, &Manifest{FormatVersion: data.FormatVersion + 1})
+	assert.Nil(t, err)
+	_, err = Open(opts)
+	assert.Equal(t, ErrFormatVersionUnsupported, err)
+	err = Upgrade(opts)
+	assert.Equal(t, ErrFormatVersionUnsupported, err)
+
+	//失败之后释放了文件锁
+	err = writeManifest(memFS, opts.DirPath, &Manifest{FormatVersion: data.FormatVersion})
+	assert.Nil(t, err)
+	db, err = Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	value, err := db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, utils.GetTestKey(1), value)
+
+	//数据库打开时不能升级
+	err = Upgrade(opts)
+	assert.Equal(t, ErrDatabaseIsUsing, err)
+}
+
+func TestUpgrade(t *testing.T) {
+	memFS := fio.NewMemFileSystem()
+	opts := DefaultOptions
+	opts.FileSystem = memFS
+	opts.DirPath = "/bitcask"
+	opts.DataFileSize = 32 * 1024
+	opts.DataFileMergeRatio = 0
+	//格式版本0只支持IEEE校验
+	opts.Checksum = data.ChecksumCRC32IEEE
+
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	for i := 0; i < 2000; i++ {
+		err = db.Put(utils.GetTestKey(i%500), utils.RandomValue(64))
+		assert.Nil(t, err)
+	}
+	//merge之后重启加载hint文件
+	assert.Nil(t, db.Merge())
+	assert.Nil(t, db.Close())
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	for i := 0; i < 100; i++ {
+		err = db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Put(utils.GetTestKey(500), utils.GetTestKey(500)))
+	assert.Nil(t, wb.Commit())
+	//再次merge，merge目录在下次打开时才会加载
+	assert.Nil(t, db.Merge())
+	expected := make(map[string][]byte)
+	for _, key := range db.ListKeys() {
+		value, err := db.Get(key)
+		assert.Nil(t, err)
+		expected[string(key)] = value
+	}
+	assert.Nil(t, db.Close())
+
+	downgradeToV0(t, memFS, opts.DirPath)
+	downgradeToV0(t, memFS, db.getMergePath())
+
+	_, err = Open(opts)
+	assert.Equal(t, ErrFormatUpgradeRequired, err)
+
+	err = Upgrade(opts)
+	assert.Nil(t, err)
+	//已经是当前版本，再次升级不做任何修改
+	err = Upgrade(opts)
+	assert.Nil(t, err)
+
+	db, err = Open(opts)
+	defer func() {
+		destroyDB(db)
+	}()
+	assert.Nil(t, err)
+	assert.Equal(t, len(expected), len(db.ListKeys()))
+	for key, value := range expected {
+		actual, err := db.Get([]byte(key))
+		assert.Nil(t, err)
+		assert.Equal(t, value, actual)
+	}
+	err = db.Put(utils.GetTestKey(1), utils.GetTestKey(1))
+	assert.Nil(t, err)
+}
+
+func TestUpgrade_BPlusTree(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-upgrade-bptree")
+	opts.DirPath = dir
+	opts.IndexType = BPlusTree
+	opts.Checksum = data.ChecksumCRC32IEEE
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	for i := 0; i < 100; i++ {
+		err = db.Put(utils.GetTestKey(i), utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	assert.Nil(t, db.Close())
+	downgradeToV0(t, fio.OSFileSystem{}, dir)
+
+	//没有指定索引类型时也会重新构建b+树索引
+	upgradeOpts := DefaultOptions
+	upgradeOpts.DirPath = dir
+	err = Upgrade(upgradeOpts)
+	assert.Nil(t, err)
+
+	db, err = Open(opts)
+	defer func() {
+		destroyDB(db)
+	}()
+	assert.Nil(t, err)
+	assert.Equal(t, 100, len(db.ListKeys()))
+	value, err := db.Get(utils.GetTestKey(99))
+	assert.Nil(t, err)
+	assert.Equal(t, utils.GetTestKey(99), value)
+
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Put(utils.GetTestKey(100), utils.GetTestKey(100)))
+	assert.Nil(t, wb.Commit())
+}
+
+func TestOpen_Checksum(t *testing.T) {
+	memFS := fio.NewMemFileSystem()
+	opts := DefaultOptions
+	opts.FileSystem = memFS
+	opts.DirPath = "/bitcask"
+	opts.DataFileSize = 32 * 1024
+	opts.DataFileMergeRatio = 0
+	opts.Checksum = data.ChecksumCRC32IEEE
+
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	for i := 0; i < 500; i++ {
+		err = db.Put(utils.GetTestKey(i), utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	assert.Nil(t, db.Close())
+
+	//格式版本1的目录可以直接打开，打开之后更新格式清单
+	err = writeManifest(memFS, opts.DirPath, &Manifest{FormatVersion: 1})
+	assert.Nil(t, err)
+
+	//更换校验算法之后，旧的文件仍然使用原来的算法读写
+	for _, checksum := range []data.ChecksumType{data.ChecksumXXHash32, data.ChecksumCRC32C} {
+		opts.Checksum = checksum
+		db, err = Open(opts)
+		assert.Nil(t, err)
+		for i := 0; i < 1000; i++ {
+			err = db.Put(utils.GetTestKey(i), utils.RandomValue(64))
+			assert.Nil(t, err)
+		}
+		assert.Equal(t, checksum, db.activeFile.Header.Checksum)
+		assert.Nil(t, db.Close())
+	}
+	manifest, err := ReadManifest(memFS, opts.DirPath)
+	assert.Nil(t, err)
+	assert.Equal(t, data.FormatVersion, manifest.FormatVersion)
+
+	db, err = Open(opts)
+	defer func() {
+		destroyDB(db)
+	}()
+	assert.Nil(t, err)
+	checksums := make(map[data.ChecksumType]bool)
+	for _, dataFile := range db.olderFile {
+		checksums[dataFile.Header.Checksum] = true
+	}
+	assert.Equal(t, 3, len(checksums))
+	assert.Equal(t, 1000, len(db.ListKeys()))
+
+	//merge之后的文件都使用新的算法
+	assert.Nil(t, db.Merge())
+	assert.Nil(t, db.Close())
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 1000, len(db.ListKeys()))
+	for _, key := range db.ListKeys() {
+		_, err := db.Get(key)
+		assert.Nil(t, err)
+	}
+
+	opts.Checksum = data.ChecksumXXHash32 + 1
+	_, err = Open(opts)
+	assert.NotNil(t, err)
+}
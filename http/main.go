@@ -2,10 +2,13 @@ package main
 
 import (
 	bitcask_go "bitcask-go"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
+	"strings"
 )
 
 var db *bitcask_go.DB
@@ -33,16 +36,95 @@ func handlePut(writer http.ResponseWriter, request *http.Request) {
 		return
 	}
 
+	//If-Match / If-None-Match 条件写入
+	ifMatch := request.Header.Get("If-Match")
+	ifNoneMatch := request.Header.Get("If-None-Match")
+	if ifMatch != "" || ifNoneMatch != "" {
+		handleConditionalPut(writer, data, ifMatch, ifNoneMatch)
+		return
+	}
+
 	for key, value := range data {
 		if err := db.Put([]byte(key), []byte(value)); err != nil {
 			http.Error(writer, "method not allowed", http.StatusInternalServerError)
 			log.Printf("failed to put value in db: %v\n", err)
 			return
 		}
+		//单个key时返回新值的ETag
+		if len(data) == 1 {
+			writer.Header().Set("ETag", etag([]byte(value)))
+		}
 	}
 	_ = json.NewEncoder(writer).Encode("Put OK")
 }
 
+// 条件写入只支持单个key
+// If-None-Match: * 表示key不存在时才写入
+// If-Match: * 表示key存在时才写入，否则携带GET返回的ETag，与当前值的ETag相同时才写入
+func handleConditionalPut(writer http.ResponseWriter, data map[string]string, ifMatch, ifNoneMatch string) {
+	if len(data) != 1 {
+		http.Error(writer, "conditional put requires exactly one key", http.StatusBadRequest)
+		return
+	}
+	if (ifNoneMatch != "" && ifNoneMatch != "*") || (ifMatch != "" && ifNoneMatch != "") {
+		http.Error(writer, "unsupported precondition", http.StatusBadRequest)
+		return
+	}
+
+	for key, value := range data {
+		var ok bool
+		var err error
+		if ifNoneMatch == "*" {
+			ok, err = db.PutIfAbsent([]byte(key), []byte(value))
+		} else {
+			var current []byte
+			if current, ok, err = matchETag([]byte(key), ifMatch); err == nil && ok {
+				//比较ETag之后值可能被修改，以读到的值做CAS
+				ok, err = db.CompareAndSwap([]byte(key), current, []byte(value))
+			}
+		}
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusInternalServerError)
+			log.Printf("failed to put value in db: %v\n", err)
+			return
+		}
+		if !ok {
+			http.Error(writer, "precondition failed", http.StatusPreconditionFailed)
+			return
+		}
+		writer.Header().Set("ETag", etag([]byte(value)))
+	}
+	_ = json.NewEncoder(writer).Encode("Put OK")
+}
+
+// 值的ETag，取sha256的十六进制并加上引号
+func etag(value []byte) string {
+	sum := sha256.Sum256(value)
+	return "\"" + hex.EncodeToString(sum[:]) + "\""
+}
+
+// 读取key当前的值并与If-Match比较，key不存在时不匹配
+// If-Match 可以是 * 或者逗号分隔的多个ETag
+func matchETag(key []byte, ifMatch string) ([]byte, bool, error) {
+	value, err := db.Get(key)
+	if err == bitcask_go.ErrKeyNotFound {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	if strings.TrimSpace(ifMatch) == "*" {
+		return value, true, nil
+	}
+	tag := etag(value)
+	for _, candidate := range strings.Split(ifMatch, ",") {
+		if strings.TrimSpace(candidate) == tag {
+			return value, true, nil
+		}
+	}
+	return value, false, nil
+}
+
 func handleGet(writer http.ResponseWriter, request *http.Request) {
 	if request.Method != http.MethodGet {
 		http.Error(writer, "method not allowed", http.StatusMethodNotAllowed)
@@ -57,10 +139,51 @@ func handleGet(writer http.ResponseWriter, request *http.Request) {
 		return
 	}
 
+	if err == nil {
+		writer.Header().Set("ETag", etag(value))
+	}
 	writer.Header().Set("Content-Type", "application/json")
 	_ = json.NewEncoder(writer).Encode(string(value))
 }
 
+func handleBatchGet(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodPost {
+		http.Error(writer, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var keys []string
+	if err := json.NewDecoder(request.Body).Decode(&keys); err != nil {
+		http.Error(writer, "method not allowed", http.StatusBadRequest)
+		return
+	}
+
+	byteKeys := make([][]byte, len(keys))
+	for i, key := range keys {
+		byteKeys[i] = []byte(key)
+	}
+
+	//不存在的key返回null
+	values, errs := db.MultiGet(byteKeys)
+	result := make(map[string]*string, len(keys))
+	for i, key := range keys {
+		if errs[i] != nil {
+			if errs[i] != bitcask_go.ErrKeyNotFound && errs[i] != bitcask_go.ErrKeyIsEmpty {
+				http.Error(writer, errs[i].Error(), http.StatusInternalServerError)
+				log.Printf("failed to get value in db: %v\n", errs[i])
+				return
+			}
+			result[key] = nil
+			continue
+		}
+		value := string(values[i])
+		result[key] = &value
+	}
+
+	writer.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(writer).Encode(result)
+}
+
 func handleDelete(writer http.ResponseWriter, request *http.Request) {
 	if request.Method != http.MethodDelete {
 		http.Error(writer, "method not allowed", http.StatusMethodNotAllowed)
@@ -68,6 +191,27 @@ func handleDelete(writer http.ResponseWriter, request *http.Request) {
 	}
 
 	key := request.URL.Query().Get("key")
+
+	//If-Match 携带GET返回的ETag，与当前值的ETag相同时才删除
+	if ifMatch := request.Header.Get("If-Match"); ifMatch != "" {
+		current, ok, err := matchETag([]byte(key), ifMatch)
+		if err == nil && ok {
+			ok, err = db.DeleteIfValue([]byte(key), current)
+		}
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusInternalServerError)
+			log.Printf("failed to delete value in db: %v\n", err)
+			return
+		}
+		if !ok {
+			http.Error(writer, "precondition failed", http.StatusPreconditionFailed)
+			return
+		}
+		writer.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(writer).Encode("Delete OK")
+		return
+	}
+
 	err := db.Delete([]byte(key))
 	if err != nil && err != bitcask_go.ErrKeyNotFound {
 		http.Error(writer, err.Error(), http.StatusInternalServerError)
@@ -236,6 +380,7 @@ func main() {
 	http.HandleFunc("/bitcask/getIndexType", handleIndexType)
 	http.HandleFunc("/bitcask/batchput", handleBatchPut)
 	http.HandleFunc("/bitcask/batchdelete", handleBatchDelete)
+	http.HandleFunc("/bitcask/batchget", handleBatchGet)
 
 	http.HandleFunc("/bitcask/stat", handleStat)
 	http.HandleFunc("/bitcask/merge", handleMerge)
@@ -0,0 +1,108 @@
+package main
+
+import (
+	bitcask_go "bitcask-go"
+	"bitcask-go/fio"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// 替换init打开的数据库为内存文件系统上的实例
+func setupTestDB(t *testing.T) {
+	if db != nil {
+		_ = db.Close()
+		_ = os.RemoveAll(bitcask_go.DefaultOptions.DirPath)
+	}
+	opts := bitcask_go.DefaultOptions
+	opts.FileSystem = fio.NewMemFileSystem()
+	var err error
+	db, err = bitcask_go.Open(opts)
+	assert.Nil(t, err)
+	t.Cleanup(func() {
+		_ = db.Close()
+		db = nil
+	})
+}
+
+func doPut(body, ifMatch string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(http.MethodPost, "/bitcask/put", strings.NewReader(body))
+	if ifMatch != "" {
+		request.Header.Set("If-Match", ifMatch)
+	}
+	recorder := httptest.NewRecorder()
+	handlePut(recorder, request)
+	return recorder
+}
+
+func doGet(key string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(http.MethodGet, "/bitcask/get?key="+key, nil)
+	recorder := httptest.NewRecorder()
+	handleGet(recorder, request)
+	return recorder
+}
+
+func TestHandlePut_IfMatch(t *testing.T) {
+	setupTestDB(t)
+
+	// 1.key不存在时 If-Match: * 失败，GET不返回ETag
+	resp := doPut(`{"k":"v1"}`, "*")
+	assert.Equal(t, http.StatusPreconditionFailed, resp.Code)
+	assert.Empty(t, doGet("k").Header().Get("ETag"))
+
+	// 2.PUT和GET返回相同的ETag，且不是值本身
+	resp = doPut(`{"k":"v1"}`, "")
+	assert.Equal(t, http.StatusOK, resp.Code)
+	tag := resp.Header().Get("ETag")
+	assert.NotEmpty(t, tag)
+	assert.NotEqual(t, `"v1"`, tag)
+	assert.Equal(t, tag, doGet("k").Header().Get("ETag"))
+
+	// 3.携带当前值作为If-Match不再匹配
+	resp = doPut(`{"k":"v2"}`, `"v1"`)
+	assert.Equal(t, http.StatusPreconditionFailed, resp.Code)
+
+	// 4.ETag匹配时写入成功并返回新的ETag
+	resp = doPut(`{"k":"v2"}`, tag)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	newTag := resp.Header().Get("ETag")
+	assert.NotEqual(t, tag, newTag)
+	assert.Equal(t, newTag, doGet("k").Header().Get("ETag"))
+
+	// 5.旧的ETag不再匹配
+	resp = doPut(`{"k":"v3"}`, tag)
+	assert.Equal(t, http.StatusPreconditionFailed, resp.Code)
+
+	// 6.key存在时 If-Match: * 成功
+	resp = doPut(`{"k":"v3"}`, "*")
+	assert.Equal(t, http.StatusOK, resp.Code)
+	value, err := db.Get([]byte("k"))
+	assert.Nil(t, err)
+	assert.Equal(t, "v3", string(value))
+}
+
+func TestHandleDelete_IfMatch(t *testing.T) {
+	setupTestDB(t)
+
+	resp := doPut(`{"k":"v1"}`, "")
+	tag := resp.Header().Get("ETag")
+
+	request := httptest.NewRequest(http.MethodDelete, "/bitcask/delete?key=k", nil)
+	request.Header.Set("If-Match", `"v1"`)
+	recorder := httptest.NewRecorder()
+	handleDelete(recorder, request)
+	assert.Equal(t, http.StatusPreconditionFailed, recorder.Code)
+
+	request = httptest.NewRequest(http.MethodDelete, "/bitcask/delete?key=k", nil)
+	request.Header.Set("If-Match", tag)
+	recorder = httptest.NewRecorder()
+	handleDelete(recorder, request)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	_, err := db.Get([]byte("k"))
+	assert.Equal(t, bitcask_go.ErrKeyNotFound, err)
+}
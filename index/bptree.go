@@ -3,11 +3,13 @@ package index
 import (
 	"bitcask-go/data"
 	"go.etcd.io/bbolt"
+	"io"
 	"path/filepath"
 )
 
 const (
-	bptreeIndexFileName = "bptree-index"
+	// BptreeIndexFileName B+树索引文件名
+	BptreeIndexFileName = "bptree-index"
 )
 
 var (
@@ -25,7 +27,7 @@ func NewBPlusTree(dirPath string, syncWrites bool) *BPlusTree {
 	opts := bbolt.DefaultOptions
 	opts.NoSync = !syncWrites
 	//因为将索引存储到磁盘，所有需要文件路径 之前是存内存的 不需要路径参数
-	bptree, err := bbolt.Open(filepath.Join(dirPath, bptreeIndexFileName), 0644, opts)
+	bptree, err := bbolt.Open(filepath.Join(dirPath, BptreeIndexFileName), 0644, opts)
 	if err != nil {
 		panic("failed to open bptree")
 	}
@@ -121,6 +123,28 @@ func (bpt *BPlusTree) Close() error {
 	return bpt.tree.Close()
 }
 
+// Snapshot 开启一个只读事务作为快照，之后的写入不影响快照的内容，也不会被快照阻塞
+func (bpt *BPlusTree) Snapshot() (Snapshot, error) {
+	tx, err := bpt.tree.Begin(false)
+	if err != nil {
+		return nil, err
+	}
+	return &bptreeSnapshot{tx: tx}, nil
+}
+
+// B+树快照
+type bptreeSnapshot struct {
+	tx *bbolt.Tx
+}
+
+func (bps *bptreeSnapshot) WriteTo(w io.Writer) (int64, error) {
+	return bps.tx.WriteTo(w)
+}
+
+func (bps *bptreeSnapshot) Close() error {
+	return bps.tx.Rollback()
+}
+
 // B+树迭代器
 type bptreeIterator struct {
 	tx        *bbolt.Tx
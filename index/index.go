@@ -4,6 +4,7 @@ import (
 	"bitcask-go/data"
 	"bytes"
 	"github.com/google/btree"
+	"io"
 )
 
 // Indexer 抽象索引接口 后续如果想要接入其他的数据结构 则之间实现这个接口
@@ -27,6 +28,21 @@ type Indexer interface {
 	Close() error
 }
 
+// Snapshotter 可以导出一致性快照的索引，持久化到磁盘的索引在备份时需要
+type Snapshotter interface {
+	// Snapshot 获取当前时刻的索引快照
+	Snapshot() (Snapshot, error)
+}
+
+// Snapshot 索引快照
+type Snapshot interface {
+	// WriteTo 将快照以索引文件的格式写入w
+	WriteTo(w io.Writer) (int64, error)
+
+	// Close 释放快照
+	Close() error
+}
+
 type IndexType = int8
 
 const (
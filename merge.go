@@ -2,9 +2,11 @@ package bitcask_go
 
 import (
 	"bitcask-go/data"
+	"bitcask-go/fio"
 	"bitcask-go/utils"
+	"errors"
 	"io"
-	"os"
+	"io/fs"
 	"path"
 	"path/filepath"
 	"sort"
@@ -14,10 +16,14 @@ import (
 const (
 	mergeDirname     = "-merge"
 	mergeFinishedKey = "merge.finished"
+	mergedFileNumKey = "merged.file.num"
 )
 
 // Merge 清理无效数据 生成hint文件
 func (db *DB) Merge() error {
+	if db.options.ReadOnly {
+		return ErrDatabaseReadOnly
+	}
 	//如果数据库为空 返回
 	if db.activeFile == nil {
 		return nil
@@ -31,7 +37,7 @@ func (db *DB) Merge() error {
 	}
 
 	//查看可以merge的数据量是否达到阈值
-	totalSize, err := utils.DirSize(db.options.DirPath)
+	totalSize, err := fio.DirSize(db.fs, db.options.DirPath)
 	if err != nil {
 		db.mu.Unlock()
 		return err
@@ -41,14 +47,16 @@ func (db *DB) Merge() error {
 		return ErrMergeRatioUnreached
 	}
 	//查看剩余容量是否可以容纳merge之后的数据量
-	availableDiskSize, err := utils.AvailableDiskSize()
-	if err != nil {
-		db.mu.Unlock()
-		return err
-	}
-	if uint64(totalSize-db.reclaimSize) >= availableDiskSize {
-		db.mu.Unlock()
-		return ErrNoEnoughSpaceForMerge
+	if _, isOS := db.fs.(fio.OSFileSystem); isOS {
+		availableDiskSize, err := utils.AvailableDiskSize()
+		if err != nil {
+			db.mu.Unlock()
+			return err
+		}
+		if uint64(totalSize-db.reclaimSize) >= availableDiskSize {
+			db.mu.Unlock()
+			return ErrNoEnoughSpaceForMerge
+		}
 	}
 
 	db.isMerging = true
@@ -67,7 +75,10 @@ func (db *DB) Merge() error {
 	}
 
 	//将当前活跃文件转换为旧的活跃文件
-	db.olderFile[db.activeFile.FileId] = db.activeFile
+	if err := db.archiveActiveFile(); err != nil {
+		db.mu.Unlock()
+		return err
+	}
 	//打开新的活跃文件
 	if err := db.setActiveDataFile(); err != nil {
 		db.mu.Unlock()
@@ -93,9 +104,9 @@ func (db *DB) Merge() error {
 
 	mergePath := db.getMergePath()
 
-	//如果目录存在，说明发生过merge，将其删除掉
-	if _, err := os.Stat(mergePath); err != nil {
-		if err := os.RemoveAll(mergePath); err != nil {
+	//如果目录存在，说明之前的merge没有被加载，将其删除掉
+	if _, err := db.fs.Stat(mergePath); err == nil {
+		if err := db.fs.RemoveAll(mergePath); err != nil {
 			return err
 		}
 	}
@@ -108,16 +119,26 @@ func (db *DB) Merge() error {
 	if err != nil {
 		return err
 	}
+	mergeDB.noTimeMarks = true
+	defer func() {
+		_ = mergeDB.Close()
+	}()
 
 	//打开hint文件存储索引
-	hintFile, err := data.OpenHintFile(mergePath)
+	hintFile, err := data.OpenHintFile(db.fs, mergePath, db.options.Checksum)
 	if err != nil {
 		return err
 	}
+	defer func() {
+		_ = hintFile.Close()
+	}()
 
 	//遍历处理每个数据
 	for _, dataFile := range mergeFiles {
-		var offset int64 = 0
+		//merge顺序读取整个文件
+		_ = dataFile.Advise(fio.AdviceSequential)
+
+		var offset = data.FileHeaderSize
 		for {
 			logRecord, size, err := dataFile.ReadLogRecord(offset)
 			if err != nil {
@@ -127,6 +148,13 @@ func (db *DB) Merge() error {
 
 				return err
 			}
+			db.rateLimiter.Wait(int(size))
+
+			//时间标记不是有效的数据，直接丢弃
+			if logRecord.Type == data.LogRecordTimestamp {
+				offset += size
+				continue
+			}
 
 			//解析拿到实际的key
 			realKey, _ := parseLogRecordKey(logRecord.Key)
@@ -135,6 +163,17 @@ func (db *DB) Merge() error {
 			if logRecordPos != nil &&
 				logRecordPos.Fid == dataFile.FileId &&
 				logRecordPos.Offset == offset {
+				//增量记录折叠为一条完整的数据
+				if logRecord.Type == data.LogRecordMerge {
+					db.mu.RLock()
+					value, err := db.resolveMergeRecord(realKey, logRecord)
+					db.mu.RUnlock()
+					if err != nil {
+						return err
+					}
+					logRecord.Value = value
+					logRecord.Type = data.LogRecordNormal
+				}
 				//清除事务标记号
 				logRecord.Key = logRecordKeyWithSeq(realKey, nonTransactionSeqNo)
 				pos, err := mergeDB.appendLogRecord(logRecord)
@@ -151,6 +190,10 @@ func (db *DB) Merge() error {
 			//递增offset
 			offset += size
 		}
+
+		//merge读过的数据不会再被访问，释放页缓存，避免把前台读取的热数据挤出去
+		_ = dataFile.Advise(fio.AdviceDontNeed)
+		_ = dataFile.Advise(fio.AdviceRandom)
 	}
 
 	//循环结束之后对数据进行持久化，保证数据正确写入到磁盘
@@ -164,16 +207,32 @@ func (db *DB) Merge() error {
 
 	//新增一个标识merge完成的标识文件，存在才说明merge有效
 	//写标识merge完成的文件
-	mergeFinishedFile, err := data.OpenMergeFinishedFile(mergePath)
+	mergeFinishedFile, err := data.OpenMergeFinishedFile(db.fs, mergePath, db.options.Checksum)
 	if err != nil {
 		return err
 	}
+	defer func() {
+		_ = mergeFinishedFile.Close()
+	}()
 	//value是没有参与merge的活跃文件id
 	mergeFinRecord := &data.LogRecord{
 		Key:   []byte(mergeFinishedKey),
 		Value: []byte(strconv.Itoa(int(nonMergeFileId))),
 	}
-	encLogRecord, _ := data.EncodeLogRecord(mergeFinRecord)
+	encLogRecord, _ := mergeFinishedFile.EncodeLogRecord(mergeFinRecord)
+	if err := mergeFinishedFile.Write(encLogRecord); err != nil {
+		return err
+	}
+	//merge之后生成的数据文件数量，id更大的旧数据文件在加载时删除
+	var mergedFileNum uint32
+	if mergeDB.activeFile != nil {
+		mergedFileNum = mergeDB.activeFile.FileId + 1
+	}
+	mergedFileNumRecord := &data.LogRecord{
+		Key:   []byte(mergedFileNumKey),
+		Value: []byte(strconv.Itoa(int(mergedFileNum))),
+	}
+	encLogRecord, _ = mergeFinishedFile.EncodeLogRecord(mergedFileNumRecord)
 	if err := mergeFinishedFile.Write(encLogRecord); err != nil {
 		return err
 	}
@@ -194,19 +253,28 @@ func (db *DB) getMergePath() string {
 }
 
 // 加载merge数据目录
+// 标识merge完成的文件最后移动，中途崩溃时merge目录仍然有效，重启后会继续移动剩下的文件
 func (db *DB) loadMergeFiles() error {
 	mergePath := db.getMergePath()
 	//merge目录不存在的话直接返回
-	if _, err := os.Stat(mergePath); err != nil {
+	if _, err := db.fs.Stat(mergePath); err != nil {
 		return nil
 	}
 
+	//有只读实例正在读取时不能替换数据文件，留到下次启动时再加载
+	readerLock, err := db.fs.TryLock(filepath.Join(db.options.DirPath, readerLockName))
+	if err != nil {
+		if err == fio.ErrFileLocked {
+			return nil
+		}
+		return err
+	}
 	defer func() {
-		_ = os.RemoveAll(mergePath)
+		_ = readerLock.Unlock()
 	}()
 
 	//将整个merge目录读取出来
-	dirEntries, err := os.ReadDir(mergePath)
+	dirEntries, err := db.fs.ReadDir(mergePath)
 	if err != nil {
 		return err
 	}
@@ -216,66 +284,76 @@ func (db *DB) loadMergeFiles() error {
 	for _, entry := range dirEntries {
 		if entry.Name() == data.MergeFinishedFileName {
 			mergeFinished = true
+			continue
 		}
 
 		if entry.Name() == data.SeqNoFileName {
 			continue
 		}
 
-		if entry.Name() == fileLockName {
+		if entry.Name() == fileLockName || entry.Name() == readerLockName || entry.Name() == ManifestFileName {
 			continue
 		}
 
 		mergeFileNames = append(mergeFileNames, entry.Name())
 	}
 
-	//merge没有完成直接返回
+	//merge没有完成，直接删除merge目录
 	if mergeFinished == false {
-		return nil
+		return db.fs.RemoveAll(mergePath)
 	}
 
-	//拿到没有参与merge的文件id
+	//拿到没有参与merge的文件id，标识文件没有写完整说明merge没有完成
 	nonMergeFileId, err := db.getNonMergeFileId(mergePath)
 	if err != nil {
-		return err
+		return db.fs.RemoveAll(mergePath)
+	}
+	mergedFileNum, err := db.getMergedFileNum(mergePath, nonMergeFileId)
+	if err != nil {
+		return db.fs.RemoveAll(mergePath)
 	}
 
-	//删除旧的的数据文件
-	//删除比它小的文件id
-	var fileId uint32 = 0
-	for ; fileId < nonMergeFileId; fileId++ {
-		//拿到文件名字
+	//删除不会被merge之后的文件覆盖的旧数据文件
+	for fileId := mergedFileNum; fileId < nonMergeFileId; fileId++ {
 		fileName := data.GetDataFileName(db.options.DirPath, fileId)
-		if _, err := os.Stat(fileName); err != nil {
-			if err := os.Remove(fileName); err != nil {
+		if _, err := db.fs.Stat(fileName); err == nil {
+			if err := db.fs.Remove(fileName); err != nil {
 				return err
 			}
 		}
 	}
 
-	//将新的数据文件移动到正常读取的目录下面
+	//将新的数据文件移动到正常读取的目录下面，同名的旧数据文件直接被覆盖
+	mergeFileNames = append(mergeFileNames, data.MergeFinishedFileName)
 	for _, fileName := range mergeFileNames {
 		// temp/bitcask-merge 00.data 11.data
 		// temp/bitcask 00.data 11.data
 		srcPath := filepath.Join(mergePath, fileName)
 		destPath := filepath.Join(db.options.DirPath, fileName)
-		if err := os.Rename(srcPath, destPath); err != nil {
+		if err := db.fs.Rename(srcPath, destPath); err != nil {
 			return err
 		}
 	}
 
-	return nil
-
+	return db.fs.RemoveAll(mergePath)
 }
 
 // 拿到没有参与merge的文件id
 func (db *DB) getNonMergeFileId(dirPath string) (uint32, error) {
-	mergeFinishedFile, err := data.OpenMergeFinishedFile(dirPath)
+	return readNonMergeFileId(db.fs, dirPath)
+}
+
+func readNonMergeFileId(fs fio.FileSystem, dirPath string) (uint32, error) {
+	//调用方确认过文件已经存在，使用文件头中记录的校验算法
+	mergeFinishedFile, err := data.OpenMergeFinishedFile(fs, dirPath, data.ChecksumCRC32IEEE)
 	if err != nil {
 		return 0, err
 	}
-	//从merge完成的文件中读取数据，因为只写入了一条数据，所有偏移地址为0
-	record, _, err := mergeFinishedFile.ReadLogRecord(0)
+	defer func() {
+		_ = mergeFinishedFile.Close()
+	}()
+	//没有参与merge的文件id是第一条数据，紧跟在文件头之后
+	record, _, err := mergeFinishedFile.ReadLogRecord(data.FileHeaderSize)
 	if err != nil {
 		return 0, err
 	}
@@ -287,24 +365,57 @@ func (db *DB) getNonMergeFileId(dirPath string) (uint32, error) {
 	return uint32(nonMergeFileId), nil
 }
 
+// 拿到merge之后生成的数据文件数量，旧版本没有记录时认为和没有参与merge的文件id相同
+func (db *DB) getMergedFileNum(dirPath string, nonMergeFileId uint32) (uint32, error) {
+	mergeFinishedFile, err := data.OpenMergeFinishedFile(db.fs, dirPath, db.options.Checksum)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		_ = mergeFinishedFile.Close()
+	}()
+
+	_, size, err := mergeFinishedFile.ReadLogRecord(data.FileHeaderSize)
+	if err != nil {
+		return 0, err
+	}
+	record, _, err := mergeFinishedFile.ReadLogRecord(data.FileHeaderSize + size)
+	if err != nil {
+		if err == io.EOF {
+			return nonMergeFileId, nil
+		}
+		return 0, err
+	}
+	mergedFileNum, err := strconv.Atoi(string(record.Value))
+	if err != nil {
+		return 0, err
+	}
+
+	return uint32(mergedFileNum), nil
+}
+
 // 从hint文件中加载索引
 func (db *DB) loadIndexFromHintFile() error {
 	//查看hint索引文件是否存在
 	hintFileName := filepath.Join(db.options.DirPath, data.HintFileName)
-	if _, err := os.Stat(hintFileName); os.IsNotExist(err) {
+	if _, err := db.fs.Stat(hintFileName); errors.Is(err, fs.ErrNotExist) {
 		return nil
 	}
 
 	//打开对应的hint索引文件
 	//??? hint文件不应该是在-merge目录下面吗，但是db.options.DirPath应该是原来的目录
 	//hint文件也是在finishedMerge目录之前，并且默认文件id为0，也就是fid比finishedMerge小，在loadMergeFiles的时候挪到了db.options.DirPath下
-	hintFile, err := data.OpenHintFile(db.options.DirPath)
+	hintFile, err := data.OpenHintFile(db.fs, db.options.DirPath, db.options.Checksum)
 	if err != nil {
 		return err
 	}
+	defer func() {
+		_ = hintFile.Close()
+	}()
+	_ = hintFile.Advise(fio.AdviceSequential)
 
 	//构造内存索引
-	var offset int64 = 0
+	var offset = data.FileHeaderSize
 	for {
 		logRecord, size, err := hintFile.ReadLogRecord(offset)
 		if err != nil {
@@ -0,0 +1,252 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"math"
+	"strconv"
+)
+
+// MergeOperand 追加一条增量记录，读取时通过Options.MergeOperator和之前的值合并
+// 写入时不需要读取旧值
+func (db *DB) MergeOperand(key []byte, operand []byte) error {
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+	if db.options.ReadOnly {
+		return ErrDatabaseReadOnly
+	}
+	if db.options.MergeOperator == nil {
+		return ErrMergeOperatorNotSet
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	return db.appendOperand(key, data.MergeOperandUser, operand, nil)
+}
+
+// Increment 对key的整数值累加delta，值以十进制字符串的形式存储，key不存在时视为0
+// 和MergeOperand一样只追加增量记录，写入之前检查旧值，溢出或者旧值不是整数时返回错误并且不写入
+// 增量链的最新记录缓存了累加之后的值，连续累加时不需要沿着增量链读取
+func (db *DB) Increment(key []byte, delta int64) error {
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+	if db.options.ReadOnly {
+		return ErrDatabaseReadOnly
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	prevPos := db.index.Get(key)
+	existing, err := db.mergedValue(key, prevPos)
+	if err != nil && err != ErrKeyNotFound {
+		return err
+	}
+	operand := []byte(strconv.FormatInt(delta, 10))
+	value, err := addInt64(existing, operand)
+	if err != nil {
+		return err
+	}
+	return db.appendOperand(key, data.MergeOperandAddInt64, operand, value)
+}
+
+// prevPos处的记录合并之后的值，增量链的最新记录缓存了合并之后的值时不需要读取
+func (db *DB) mergedValue(key []byte, prevPos *data.LogRecordPos) ([]byte, error) {
+	if prevPos == nil {
+		return nil, ErrKeyNotFound
+	}
+	if head, ok := db.mergeChains[string(key)]; ok && head.value != nil &&
+		head.pos.Fid == prevPos.Fid && head.pos.Offset == prevPos.Offset {
+		return head.value, nil
+	}
+	return db.getValueByPosition(prevPos)
+}
+
+// 增量链的最大长度，超过之后写入合并之后的完整数据，读取时最多沿着增量链读取这么多条记录
+const maxMergeChainDepth = 16
+
+// 活跃文件中一条增量链的最新记录
+type mergeChainHead struct {
+	pos   *data.LogRecordPos
+	depth uint32
+	value []byte //合并之后的值，nil表示没有缓存
+}
+
+// 追加增量记录，调用方需要持有锁
+// value是写入之后合并的结果，调用方已经计算出来时传入，避免折叠时再次读取，否则为nil
+// 增量链不会跨越数据文件，这样merge的时候只需要在同一个文件中解析增量链
+func (db *DB) appendOperand(key []byte, opType data.MergeOperandType, operand []byte, value []byte) error {
+	prevPos := db.index.Get(key)
+
+	//上一条记录不在活跃文件中，直接写入合并之后的完整数据
+	if prevPos != nil && (db.activeFile == nil || prevPos.Fid != db.activeFile.FileId) {
+		return db.foldOperand(key, prevPos, opType, operand, value)
+	}
+
+	depth, err := db.mergeChainDepth(key, prevPos)
+	if err != nil {
+		return err
+	}
+	//增量链太长时读取需要很多次随机读，折叠为一条完整的数据
+	if depth >= maxMergeChainDepth {
+		return db.foldOperand(key, prevPos, opType, operand, value)
+	}
+
+	logRecord := &data.LogRecord{
+		Key:   logRecordKeyWithSeq(key, nonTransactionSeqNo),
+		Value: data.EncodeMergeValue(prevPos, depth+1, opType, operand),
+		Type:  data.LogRecordMerge,
+	}
+	pos, err := db.appendLogRecord(logRecord)
+	if err != nil {
+		return err
+	}
+
+	//写入时切换了活跃文件，增量链跨越了文件，再写入一条合并之后的完整数据
+	if prevPos != nil && pos.Fid != prevPos.Fid {
+		if value == nil {
+			if value, err = db.getValueByPosition(pos); err != nil {
+				return err
+			}
+		}
+		db.reclaimSize += int64(pos.Size)
+		return db.put(key, value)
+	}
+
+	//之前的记录仍然是增量链的一部分，不计入可回收的空间
+	db.index.Put(key, pos)
+	db.mergeChains[string(key)] = &mergeChainHead{pos: pos, depth: depth + 1, value: value}
+	return nil
+}
+
+// 读取prevPos处的值，合并增量之后写入完整的数据，value不为nil时就是合并之后的值
+func (db *DB) foldOperand(key []byte, prevPos *data.LogRecordPos, opType data.MergeOperandType, operand []byte, value []byte) error {
+	if value == nil {
+		existing, err := db.getValueByPosition(prevPos)
+		if err != nil {
+			return err
+		}
+		value, err = db.applyOperands(key, existing, []*mergeOperand{{opType: opType, operand: operand}})
+		if err != nil {
+			return err
+		}
+	}
+	delete(db.mergeChains, string(key))
+	return db.put(key, value)
+}
+
+// prevPos处的记录所在增量链的长度，不是增量记录时为0
+// 记录的位置和缓存的一致时不需要读取，否则读取上一条记录中保存的长度
+func (db *DB) mergeChainDepth(key []byte, prevPos *data.LogRecordPos) (uint32, error) {
+	if prevPos == nil {
+		return 0, nil
+	}
+	if head, ok := db.mergeChains[string(key)]; ok &&
+		head.pos.Fid == prevPos.Fid && head.pos.Offset == prevPos.Offset {
+		return head.depth, nil
+	}
+
+	record, err := db.readLogRecordByPosition(prevPos)
+	if err != nil {
+		return 0, err
+	}
+	if record.Type != data.LogRecordMerge {
+		return 0, nil
+	}
+	_, depth, _, _ := data.DecodeMergeValue(record.Value)
+	//旧版本写入的增量记录不知道长度，直接折叠
+	if depth == 0 {
+		return maxMergeChainDepth, nil
+	}
+	return depth, nil
+}
+
+type mergeOperand struct {
+	opType  data.MergeOperandType
+	operand []byte
+}
+
+// 沿着增量链向前读取，直到遇到完整的数据，再按照写入顺序合并
+func (db *DB) resolveMergeRecord(key []byte, logRecord *data.LogRecord) ([]byte, error) {
+	var operands []*mergeOperand
+	var existing []byte
+	for {
+		prev, _, opType, operand := data.DecodeMergeValue(logRecord.Value)
+		operands = append(operands, &mergeOperand{opType: opType, operand: operand})
+		if prev == nil {
+			break
+		}
+
+		record, err := db.readLogRecordByPosition(prev)
+		if err != nil {
+			return nil, err
+		}
+		if record.Type == data.LogRecordMerge {
+			logRecord = record
+			continue
+		}
+		if record.Type != data.LogRecordDeleted {
+			existing = record.Value
+		}
+		break
+	}
+
+	//读取的顺序是从新到旧，反转之后按照写入顺序合并
+	for i, j := 0, len(operands)-1; i < j; i, j = i+1, j-1 {
+		operands[i], operands[j] = operands[j], operands[i]
+	}
+
+	return db.applyOperands(key, existing, operands)
+}
+
+// 将增量依次合并到existing上
+func (db *DB) applyOperands(key []byte, existing []byte, operands []*mergeOperand) ([]byte, error) {
+	var err error
+	for i := 0; i < len(operands); {
+		if operands[i].opType == data.MergeOperandAddInt64 {
+			if existing, err = addInt64(existing, operands[i].operand); err != nil {
+				return nil, err
+			}
+			i++
+			continue
+		}
+
+		//连续的用户增量一次性交给合并操作符
+		var userOperands [][]byte
+		for ; i < len(operands) && operands[i].opType == data.MergeOperandUser; i++ {
+			userOperands = append(userOperands, operands[i].operand)
+		}
+		if db.options.MergeOperator == nil {
+			return nil, ErrMergeOperatorNotSet
+		}
+		if existing, err = db.options.MergeOperator.Merge(key, existing, userOperands); err != nil {
+			return nil, err
+		}
+	}
+
+	return existing, nil
+}
+
+func addInt64(existing []byte, operand []byte) ([]byte, error) {
+	var value int64
+	if existing != nil {
+		v, err := strconv.ParseInt(string(existing), 10, 64)
+		if err != nil {
+			return nil, ErrValueNotInteger
+		}
+		value = v
+	}
+
+	delta, err := strconv.ParseInt(string(operand), 10, 64)
+	if err != nil {
+		return nil, ErrValueNotInteger
+	}
+
+	if (delta > 0 && value > math.MaxInt64-delta) || (delta < 0 && value < math.MinInt64-delta) {
+		return nil, ErrIncrementOverflow
+	}
+
+	return []byte(strconv.FormatInt(value+delta, 10)), nil
+}
@@ -0,0 +1,219 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/fio"
+	"bitcask-go/utils"
+	"github.com/stretchr/testify/assert"
+	"math"
+	"os"
+	"strconv"
+	"testing"
+)
+
+// 将增量追加到旧值末尾
+type appendOperator struct{}
+
+func (appendOperator) Merge(key []byte, existing []byte, operands [][]byte) ([]byte, error) {
+	value := append([]byte{}, existing...)
+	for _, operand := range operands {
+		value = append(value, operand...)
+	}
+	return value, nil
+}
+
+func TestDB_Increment(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-increment")
+	opts.DirPath = dir
+	opts.DataFileSize = 4 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	// 1.key 不存在时从 0 开始累加
+	for i := 0; i < 1000; i++ {
+		err := db.Increment(utils.GetTestKey(1), 2)
+		assert.Nil(t, err)
+	}
+	err = db.Increment(utils.GetTestKey(1), -1)
+	assert.Nil(t, err)
+	val, err := db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("1999"), val)
+	// 写入了多个数据文件
+	assert.Greater(t, len(db.olderFile), 0)
+
+	// 2.在已有的值上累加
+	err = db.Put(utils.GetTestKey(2), []byte("100"))
+	assert.Nil(t, err)
+	err = db.Increment(utils.GetTestKey(2), 10)
+	assert.Nil(t, err)
+	val, err = db.Get(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("110"), val)
+
+	// 3.旧值不是整数或者溢出时写入失败，原来的值不受影响
+	err = db.Put(utils.GetTestKey(3), []byte("abc"))
+	assert.Nil(t, err)
+	err = db.Increment(utils.GetTestKey(3), 1)
+	assert.Equal(t, ErrValueNotInteger, err)
+	val, err = db.Get(utils.GetTestKey(3))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("abc"), val)
+
+	err = db.Increment(utils.GetTestKey(4), math.MaxInt64)
+	assert.Nil(t, err)
+	err = db.Increment(utils.GetTestKey(4), 1)
+	assert.Equal(t, ErrIncrementOverflow, err)
+	err = db.Increment(utils.GetTestKey(4), -1)
+	assert.Nil(t, err)
+	val, err = db.Get(utils.GetTestKey(4))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte(strconv.FormatInt(math.MaxInt64-1, 10)), val)
+
+	// 4.重启之后校验
+	err = db.Close()
+	assert.Nil(t, err)
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	defer func() {
+		_ = db2.Close()
+	}()
+	val, err = db2.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("1999"), val)
+	err = db2.Increment(utils.GetTestKey(1), 1)
+	assert.Nil(t, err)
+	val, err = db2.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("2000"), val)
+}
+
+func TestDB_MergeOperand(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-merge-operand")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	opts.DataFileMergeRatio = 0
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	// 没有设置合并操作符
+	err = db.MergeOperand(utils.GetTestKey(1), []byte("a"))
+	assert.Equal(t, ErrMergeOperatorNotSet, err)
+	_ = db.Close()
+
+	opts.MergeOperator = appendOperator{}
+	db, err = Open(opts)
+	assert.Nil(t, err)
+
+	err = db.Put(utils.GetTestKey(1), []byte("x"))
+	assert.Nil(t, err)
+	for i := 0; i < 3; i++ {
+		err = db.MergeOperand(utils.GetTestKey(1), []byte("a"))
+		assert.Nil(t, err)
+	}
+	err = db.Increment(utils.GetTestKey(2), 5)
+	assert.Nil(t, err)
+	err = db.MergeOperand(utils.GetTestKey(2), []byte("b"))
+	assert.Nil(t, err)
+
+	val, err := db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("xaaa"), val)
+	val, err = db.Get(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("5b"), val)
+
+	// 删除之后重新累加
+	err = db.Delete(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	err = db.MergeOperand(utils.GetTestKey(1), []byte("c"))
+	assert.Nil(t, err)
+	val, err = db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("c"), val)
+
+	// merge 之后增量记录被折叠为一条完整的数据
+	for i := 0; i < 2000; i++ {
+		err = db.Increment(utils.GetTestKey(3), 1)
+		assert.Nil(t, err)
+	}
+	err = db.Merge()
+	assert.Nil(t, err)
+	err = db.Close()
+	assert.Nil(t, err)
+
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	defer func() {
+		_ = db2.Close()
+	}()
+	val, err = db2.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("c"), val)
+	val, err = db2.Get(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("5b"), val)
+	val, err = db2.Get(utils.GetTestKey(3))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("2000"), val)
+}
+
+// key当前所在增量链的长度，不是增量记录时为0
+func mergeChainDepthOf(t *testing.T, db *DB, key []byte) uint32 {
+	record, err := db.readLogRecordByPosition(db.index.Get(key))
+	assert.Nil(t, err)
+	if record.Type != data.LogRecordMerge {
+		return 0
+	}
+	_, depth, _, _ := data.DecodeMergeValue(record.Value)
+	return depth
+}
+
+func TestDB_Increment_FoldChain(t *testing.T) {
+	opts := DefaultOptions
+	opts.FileSystem = fio.NewMemFileSystem()
+	opts.DirPath = "/bitcask"
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	key := utils.GetTestKey(1)
+	for i := 1; i <= maxMergeChainDepth; i++ {
+		err = db.Increment(key, 1)
+		assert.Nil(t, err)
+		assert.Equal(t, uint32(i), mergeChainDepthOf(t, db, key))
+	}
+	// 超过最大长度之后写入完整的数据
+	err = db.Increment(key, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(0), mergeChainDepthOf(t, db, key))
+	val, err := db.Get(key)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("17"), val)
+
+	// 重启之后从记录中读取增量链的长度继续计数
+	for i := 1; i <= 10; i++ {
+		err = db.Increment(key, 1)
+		assert.Nil(t, err)
+	}
+	assert.Nil(t, db.Close())
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	for i := 11; i <= maxMergeChainDepth; i++ {
+		err = db.Increment(key, 1)
+		assert.Nil(t, err)
+		assert.Equal(t, uint32(i), mergeChainDepthOf(t, db, key))
+	}
+	err = db.Increment(key, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(0), mergeChainDepthOf(t, db, key))
+	val, err = db.Get(key)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("34"), val)
+}
@@ -1,11 +1,13 @@
 package bitcask_go
 
 import (
+	"bitcask-go/fio"
 	"bitcask-go/utils"
 	"github.com/stretchr/testify/assert"
 	"os"
 	"sync"
 	"testing"
+	"time"
 )
 
 // 没有任何数据的情况下进行 merge
@@ -201,3 +203,75 @@ func TestDB_Merge5(t *testing.T) {
 		assert.NotNil(t, val)
 	}
 }
+
+// 在内存文件系统中 merge 并重启
+func TestDB_Merge_MemFileSystem(t *testing.T) {
+	opts := DefaultOptions
+	opts.FileSystem = fio.NewMemFileSystem()
+	opts.DataFileSize = 4 * 1024 * 1024
+	opts.DataFileMergeRatio = 0
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 20000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(256))
+		assert.Nil(t, err)
+	}
+	for i := 0; i < 5000; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+
+	err = db.Merge()
+	assert.Nil(t, err)
+
+	// 重启校验
+	err = db.Close()
+	assert.Nil(t, err)
+
+	db2, err := Open(opts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	keys := db2.ListKeys()
+	assert.Equal(t, 15000, len(keys))
+
+	for i := 0; i < 5000; i++ {
+		_, err := db2.Get(utils.GetTestKey(i))
+		assert.Equal(t, ErrKeyNotFound, err)
+	}
+
+	_, err = os.Stat(opts.DirPath)
+	assert.True(t, os.IsNotExist(err))
+}
+
+// 限制 merge 读取的速度
+func TestDB_Merge_RateLimit(t *testing.T) {
+	opts := DefaultOptions
+	opts.FileSystem = fio.NewMemFileSystem()
+	opts.DataFileSize = 64 * 1024
+	opts.DataFileMergeRatio = 0
+	opts.BackgroundIORate = 256 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 4000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(100))
+		assert.Nil(t, err)
+	}
+
+	// 大约 600KB 的数据，桶里只有 256KB 的令牌
+	now := time.Now()
+	err = db.Merge()
+	assert.Nil(t, err)
+	assert.GreaterOrEqual(t, time.Since(now), time.Second)
+
+	// 运行时取消限速
+	db.SetBackgroundIORate(0)
+	now = time.Now()
+	err = db.Merge()
+	assert.Nil(t, err)
+	assert.Less(t, time.Since(now), time.Second)
+}
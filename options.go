@@ -1,5 +1,11 @@
 package bitcask_go
 
+import (
+	"bitcask-go/data"
+	"bitcask-go/fio"
+	"time"
+)
+
 type Options struct {
 	DirPath string //数据库目录文件
 
@@ -13,7 +19,28 @@ type Options struct {
 
 	MMapAtStartUp bool //启动时是否启动MMap加载
 
+	IOType fio.FileIOType //数据文件读写的IO类型，MemoryMap会使用可读写的内存映射写入活跃文件，DirectIO只用于读取旧的数据文件
+
 	DataFileMergeRatio float32 //数据文件合并的阈值
+
+	MergeOperator MergeOperator //合并增量记录的操作符，为空时不能使用MergeOperand
+
+	FileSystem fio.FileSystem //数据文件所在的文件系统，为空时使用操作系统的文件系统
+
+	BackgroundIORate int64 //merge、备份等后台任务每秒读取的字节数，0表示不限速
+
+	ReadOnly bool //只读模式，可以有多个只读实例和一个写入实例同时打开同一个目录，b+树索引在只读模式下使用内存中的B树索引
+
+	RefreshInterval time.Duration //只读模式下定时调用Refresh读取写入实例新写入的数据，0表示不自动刷新
+
+	Checksum data.ChecksumType //新创建的文件中记录使用的校验算法，已有的文件使用文件头中记录的算法
+}
+
+// MergeOperator 用户自定义的合并操作符
+// MergeOperand 写入的增量记录在读取时和之前的值合并，并在merge时折叠为一条完整的数据
+type MergeOperator interface {
+	// Merge 将operands按照写入顺序依次合并到existing上，existing为nil表示key不存在
+	Merge(key []byte, existing []byte, operands [][]byte) ([]byte, error)
 }
 
 // IteratorOptions 索引迭代器配置项
@@ -51,7 +78,11 @@ var DefaultOptions = Options{
 	BytesPerSync:       0,
 	IndexType:          Btree,
 	MMapAtStartUp:      true,
+	IOType:             fio.StandardIO,
 	DataFileMergeRatio: 0.5,
+	ReadOnly:           false,
+	RefreshInterval:    0,
+	Checksum:           data.ChecksumCRC32C,
 }
 
 var DefaultIteratorOptions = IteratorOptions{
@@ -0,0 +1,117 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/fio"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Refresh 只读模式下读取写入实例在打开之后新写入的数据，包括新切换出来的数据文件
+// 没有提交完成的批量写入会等到读到事务完成标识之后才可见，写入模式下不需要刷新，直接返回
+func (db *DB) Refresh() error {
+	if !db.options.ReadOnly {
+		return nil
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	//找到比当前活跃文件更新的数据文件
+	dirEntries, err := db.fs.ReadDir(db.options.DirPath)
+	if err != nil {
+		return err
+	}
+	var newFileIds []int
+	for _, entry := range dirEntries {
+		if !strings.HasSuffix(entry.Name(), data.DataFileNameSuffix) {
+			continue
+		}
+		fileId, err := strconv.Atoi(strings.TrimSuffix(entry.Name(), data.DataFileNameSuffix))
+		if err != nil {
+			return ErrDataDirectoryCorrupted
+		}
+		if db.activeFile == nil || uint32(fileId) > db.activeFile.FileId {
+			newFileIds = append(newFileIds, fileId)
+		}
+	}
+	sort.Ints(newFileIds)
+
+	if db.pendingTxns == nil {
+		db.pendingTxns = make(map[uint64][]*data.TransactionRecord)
+	}
+	oldActiveFile, oldWriteOff := db.activeFile, int64(0)
+	if oldActiveFile != nil {
+		oldWriteOff = oldActiveFile.WriteOff
+	}
+
+	//写入实例切换文件之前已经写完了当前的活跃文件，从上次读到的位置继续读取
+	if db.activeFile != nil {
+		//打开时文件头可能还没有写入
+		if db.activeFile.Header == nil {
+			if err := db.activeFile.ReadHeader(); err != nil {
+				return err
+			}
+		}
+		offset, err := db.loadIndexFromRecords(db.activeFile, db.activeFile.WriteOff, len(newFileIds) == 0, db.pendingTxns)
+		if err != nil {
+			return err
+		}
+		db.activeFile.WriteOff = offset
+	}
+
+	for i, fid := range newFileIds {
+		dataFile, err := data.OpenDataFile(db.fs, db.options.DirPath, uint32(fid), fio.ReadOnlyIO, db.options.Checksum)
+		if err != nil {
+			return err
+		}
+		if db.activeFile != nil {
+			db.olderFile[db.activeFile.FileId] = db.activeFile
+		}
+		db.activeFile = dataFile
+		db.fileIds = append(db.fileIds, fid)
+
+		offset, err := db.loadIndexFromRecords(dataFile, data.FileHeaderSize, i == len(newFileIds)-1, db.pendingTxns)
+		if err != nil {
+			return err
+		}
+		dataFile.WriteOff = offset
+	}
+
+	//读到了新的数据，唤醒订阅者
+	if db.activeFile != oldActiveFile || (db.activeFile != nil && db.activeFile.WriteOff != oldWriteOff) {
+		db.notifySubscribers()
+	}
+	return nil
+}
+
+// 定时刷新只读实例，出错时等待下一次刷新重试
+func (db *DB) startRefresh() {
+	db.refreshStop = make(chan struct{})
+	db.refreshDone = make(chan struct{})
+	go func() {
+		defer close(db.refreshDone)
+		ticker := time.NewTicker(db.options.RefreshInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				_ = db.Refresh()
+			case <-db.refreshStop:
+				return
+			}
+		}
+	}()
+}
+
+// 停止定时刷新，等待正在进行的刷新完成
+func (db *DB) stopRefresh() {
+	if db.refreshStop == nil {
+		return
+	}
+	close(db.refreshStop)
+	<-db.refreshDone
+	db.refreshStop = nil
+}
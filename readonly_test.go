@@ -0,0 +1,233 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/fio"
+	"bitcask-go/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func readOnlyTestOptions(fs fio.FileSystem) Options {
+	opts := DefaultOptions
+	opts.FileSystem = fs
+	opts.DirPath = "/bitcask"
+	opts.DataFileSize = 32 * 1024
+	opts.DataFileMergeRatio = 0
+	return opts
+}
+
+func TestOpen_ReadOnly(t *testing.T) {
+	memFS := fio.NewMemFileSystem()
+	opts := readOnlyTestOptions(memFS)
+
+	//只读模式不会创建目录
+	roOpts := opts
+	roOpts.ReadOnly = true
+	_, err := Open(roOpts)
+	assert.NotNil(t, err)
+	_, err = memFS.Stat(opts.DirPath)
+	assert.NotNil(t, err)
+
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	for i := 0; i < 100; i++ {
+		err = db.Put(utils.GetTestKey(i), utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+
+	//多个只读实例可以和写入实例同时打开
+	reader1, err := Open(roOpts)
+	assert.Nil(t, err)
+	reader2, err := Open(roOpts)
+	assert.Nil(t, err)
+	assert.Equal(t, 100, len(reader1.ListKeys()))
+	value, err := reader2.Get(utils.GetTestKey(99))
+	assert.Nil(t, err)
+	assert.Equal(t, utils.GetTestKey(99), value)
+
+	//写入实例仍然是互斥的
+	_, err = Open(opts)
+	assert.Equal(t, ErrDatabaseIsUsing, err)
+
+	//所有的修改操作都会被拒绝
+	assert.Equal(t, ErrDatabaseReadOnly, reader1.Put(utils.GetTestKey(1), []byte("a")))
+	assert.Equal(t, ErrDatabaseReadOnly, reader1.Delete(utils.GetTestKey(1)))
+	_, err = reader1.CompareAndSwap(utils.GetTestKey(1), utils.GetTestKey(1), []byte("a"))
+	assert.Equal(t, ErrDatabaseReadOnly, err)
+	_, err = reader1.PutIfAbsent(utils.GetTestKey(1000), []byte("a"))
+	assert.Equal(t, ErrDatabaseReadOnly, err)
+	_, err = reader1.DeleteIfValue(utils.GetTestKey(1), utils.GetTestKey(1))
+	assert.Equal(t, ErrDatabaseReadOnly, err)
+	assert.Equal(t, ErrDatabaseReadOnly, reader1.Increment(utils.GetTestKey(1), 1))
+	wb := reader1.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Put(utils.GetTestKey(1), []byte("a")))
+	assert.Equal(t, ErrDatabaseReadOnly, wb.Commit())
+	assert.Equal(t, ErrDatabaseReadOnly, reader1.Merge())
+	assert.Equal(t, ErrDatabaseReadOnly, reader1.BackUp("/backup"))
+	assert.Equal(t, ErrDatabaseReadOnly, reader1.Checkpoint("/checkpoint"))
+
+	value, err = reader1.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, utils.GetTestKey(1), value)
+
+	//关闭只读实例不会写入任何文件
+	assert.Nil(t, reader1.Close())
+	assert.Nil(t, reader2.Close())
+	_, err = memFS.Stat(filepath.Join(opts.DirPath, data.SeqNoFileName))
+	assert.NotNil(t, err)
+}
+
+func TestDB_Refresh(t *testing.T) {
+	memFS := fio.NewMemFileSystem()
+	opts := readOnlyTestOptions(memFS)
+	roOpts := opts
+	roOpts.ReadOnly = true
+
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	//从空的目录开始
+	reader, err := Open(roOpts)
+	assert.Nil(t, err)
+	defer func() {
+		_ = reader.Close()
+	}()
+	assert.Equal(t, 0, len(reader.ListKeys()))
+
+	for i := 0; i < 100; i++ {
+		err = db.Put(utils.GetTestKey(i), utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	assert.Nil(t, reader.Refresh())
+	assert.Equal(t, 100, len(reader.ListKeys()))
+
+	//写入实例切换了多个数据文件
+	for i := 0; i < 2000; i++ {
+		err = db.Put(utils.GetTestKey(i), utils.RandomValue(64))
+		assert.Nil(t, err)
+	}
+	err = db.Delete(utils.GetTestKey(0))
+	assert.Nil(t, err)
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Put(utils.GetTestKey(2000), utils.GetTestKey(2000)))
+	assert.Nil(t, wb.Delete(utils.GetTestKey(1)))
+	assert.Nil(t, wb.Commit())
+	assert.Greater(t, len(db.olderFile), 1)
+
+	assert.Nil(t, reader.Refresh())
+	assert.Equal(t, len(db.ListKeys()), len(reader.ListKeys()))
+	assert.Equal(t, db.activeFile.FileId, reader.activeFile.FileId)
+	for _, key := range db.ListKeys() {
+		expected, err := db.Get(key)
+		assert.Nil(t, err)
+		value, err := reader.Get(key)
+		assert.Nil(t, err)
+		assert.Equal(t, expected, value)
+	}
+	_, err = reader.Get(utils.GetTestKey(1))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	//没有新的数据
+	assert.Nil(t, reader.Refresh())
+	assert.Equal(t, len(db.ListKeys()), len(reader.ListKeys()))
+}
+
+func TestDB_Refresh_Interval(t *testing.T) {
+	memFS := fio.NewMemFileSystem()
+	opts := readOnlyTestOptions(memFS)
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	err = db.Put(utils.GetTestKey(0), utils.GetTestKey(0))
+	assert.Nil(t, err)
+
+	roOpts := opts
+	roOpts.ReadOnly = true
+	roOpts.RefreshInterval = 10 * time.Millisecond
+	reader, err := Open(roOpts)
+	assert.Nil(t, err)
+	defer func() {
+		_ = reader.Close()
+	}()
+
+	err = db.Put(utils.GetTestKey(1), utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Eventually(t, func() bool {
+		value, err := reader.Get(utils.GetTestKey(1))
+		return err == nil && string(value) == string(utils.GetTestKey(1))
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestOpen_ReadOnly_DeferMergeFiles(t *testing.T) {
+	memFS := fio.NewMemFileSystem()
+	opts := readOnlyTestOptions(memFS)
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	for i := 0; i < 1000; i++ {
+		err = db.Put(utils.GetTestKey(i%100), utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	assert.Nil(t, db.Merge())
+
+	roOpts := opts
+	roOpts.ReadOnly = true
+	reader, err := Open(roOpts)
+	assert.Nil(t, err)
+
+	//有只读实例时重启不会替换数据文件
+	assert.Nil(t, db.Close())
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	_, err = memFS.Stat(db.getMergePath())
+	assert.Nil(t, err)
+	value, err := reader.Get(utils.GetTestKey(99))
+	assert.Nil(t, err)
+	assert.Equal(t, utils.GetTestKey(999), value)
+
+	//只读实例关闭之后重启加载merge的数据
+	assert.Nil(t, reader.Close())
+	assert.Nil(t, db.Close())
+	db, err = Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	_, err = memFS.Stat(db.getMergePath())
+	assert.NotNil(t, err)
+	assert.Equal(t, 100, len(db.ListKeys()))
+}
+
+func TestOpen_ReadOnly_BPlusTree(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-readonly-bptree")
+	opts.DirPath = dir
+	opts.IndexType = BPlusTree
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	for i := 0; i < 100; i++ {
+		err = db.Put(utils.GetTestKey(i), utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+
+	//写入实例持有b+树索引文件的锁，只读实例从数据文件加载索引
+	roOpts := opts
+	roOpts.ReadOnly = true
+	reader, err := Open(roOpts)
+	assert.Nil(t, err)
+	defer func() {
+		_ = reader.Close()
+	}()
+	assert.Equal(t, 100, len(reader.ListKeys()))
+
+	err = db.Put(utils.GetTestKey(100), utils.GetTestKey(100))
+	assert.Nil(t, err)
+	assert.Nil(t, reader.Refresh())
+	value, err := reader.Get(utils.GetTestKey(100))
+	assert.Nil(t, err)
+	assert.Equal(t, utils.GetTestKey(100), value)
+}
@@ -3,10 +3,33 @@ package main
 import (
 	bitcask_go "bitcask-go"
 	bitcask_redis "bitcask-go/redis"
-	"bitcask-go/utils"
+	"errors"
 	"fmt"
 	"github.com/tidwall/redcon"
+	"math"
+	"strconv"
 	"strings"
+	"time"
+)
+
+var (
+	errSyntax         = errors.New("ERR syntax error")
+	errNotInteger     = errors.New("ERR value is not an integer or out of range")
+	errNotFloat       = errors.New("ERR value is not a valid float")
+	errInvalidCursor  = errors.New("ERR invalid cursor")
+	errNotPositive    = errors.New("ERR value is out of range, must be positive")
+	errMinMaxNotFloat = errors.New("ERR min or max is not a float")
+
+	errDBIndexOutOfRange    = errors.New("ERR DB index is out of range")
+	errInvalidFirstDBIndex  = errors.New("ERR invalid first DB index")
+	errInvalidSecondDBIndex = errors.New("ERR invalid second DB index")
+
+	errNestedMulti         = errors.New("ERR MULTI calls can not be nested")
+	errExecWithoutMulti    = errors.New("ERR EXEC without MULTI")
+	errDiscardWithoutMulti = errors.New("ERR DISCARD without MULTI")
+	errWatchInMulti        = errors.New("ERR WATCH inside MULTI is not allowed")
+	errCommandInMulti      = errors.New("ERR Command not allowed inside a transaction")
+	errExecAbort           = errors.New("EXECABORT Transaction discarded because of previous errors.")
 )
 
 func newWrongNumberOfArgsError(cmd string) error {
@@ -16,135 +39,691 @@ func newWrongNumberOfArgsError(cmd string) error {
 type cmdHandler func(cli *BitcaskClient, args [][]byte) (interface{}, error)
 
 var supportedCommands = map[string]cmdHandler{
-	"set":   set,
-	"get":   get,
-	"hset":  hset,
-	"sadd":  sadd,
-	"lpush": lpush,
-	"zadd":  zadd,
+	"set":           set,
+	"setex":         setex,
+	"psetex":        psetex,
+	"expire":        expire,
+	"pexpire":       pexpire,
+	"expireat":      expireat,
+	"ttl":           ttl,
+	"pttl":          pttl,
+	"persist":       persist,
+	"del":           del,
+	"exists":        exists,
+	"type":          typeCmd,
+	"rename":        rename,
+	"renamenx":      renamenx,
+	"randomkey":     randomkey,
+	"dbsize":        dbsize,
+	"flushdb":       flushdb,
+	"keys":          keys,
+	"scan":          scan,
+	"setnx":         setnx,
+	"get":           get,
+	"mget":          mget,
+	"getset":        getset,
+	"getdel":        getdel,
+	"getex":         getex,
+	"incr":          incr,
+	"incrby":        incrby,
+	"decr":          decr,
+	"decrby":        decrby,
+	"incrbyfloat":   incrbyfloat,
+	"append":        appendCmd,
+	"strlen":        strlen,
+	"getrange":      getrange,
+	"setrange":      setrange,
+	"mset":          mset,
+	"msetnx":        msetnx,
+	"hset":          hset,
+	"hget":          hget,
+	"hmset":         hmset,
+	"hmget":         hmget,
+	"hsetnx":        hsetnx,
+	"hdel":          hdel,
+	"hexists":       hexists,
+	"hlen":          hlen,
+	"hgetall":       hgetall,
+	"hkeys":         hkeys,
+	"hvals":         hvals,
+	"hincrby":       hincrby,
+	"hincrbyfloat":  hincrbyfloat,
+	"hscan":         hscan,
+	"sadd":          sadd,
+	"sismember":     sismember,
+	"srem":          srem,
+	"scard":         scard,
+	"smembers":      smembers,
+	"spop":          spop,
+	"srandmember":   srandmember,
+	"sinter":        sinter,
+	"sunion":        sunion,
+	"sdiff":         sdiff,
+	"sinterstore":   sinterstore,
+	"sunionstore":   sunionstore,
+	"sdiffstore":    sdiffstore,
+	"lpush":         lpush,
+	"rpush":         rpush,
+	"lpop":          lpop,
+	"rpop":          rpop,
+	"llen":          llen,
+	"lrange":        lrange,
+	"lindex":        lindex,
+	"lset":          lset,
+	"ltrim":         ltrim,
+	"linsert":       linsert,
+	"lrem":          lrem,
+	"lmove":         lmove,
+	"zadd":          zadd,
+	"zscore":        zscore,
+	"zcard":         zcard,
+	"zrem":          zrem,
+	"zincrby":       zincrby,
+	"zrange":        zrange,
+	"zrevrange":     zrevrange,
+	"zrangebyscore": zrangebyscore,
+	"zcount":        zcount,
+	"zrank":         zrank,
+	"zrevrank":      zrevrank,
+	"zpopmin":       zpopmin,
+	"zpopmax":       zpopmax,
+	"info":          info,
+	"select":        selectCmd,
+	"swapdb":        swapdb,
+	"move":          move,
 }
 
 type BitcaskClient struct {
-	server *BitcaskServer
-	db     *bitcask_redis.RedisDataStructure
+	server  *BitcaskServer
+	dbIndex int // 当前选择的数据库
+	db      *bitcask_redis.RedisDataStructure
+
+	inMulti  bool              // 是否在 MULTI 和 EXEC 之间
+	multiErr bool              // 排队时出现错误，EXEC 时放弃整个事务
+	queue    [][][]byte        // 排队等待 EXEC 执行的命令
+	watching map[watchKey]bool // WATCH 的 key 和 WATCH 时 key 是否存在，由服务的 watchMu 保护
+	dirty    bool              // WATCH 的 key 已经被修改，由服务的 watchMu 保护
 }
 
 func execClientCommand(conn redcon.Conn, cmd redcon.Command) {
 	command := strings.ToLower(string(cmd.Args[0]))
-	cmdFunc, ok := supportedCommands[command]
-	if !ok {
-		conn.WriteError("Err unsupported command: '" + command + "'")
-		return
-	}
-
 	client, _ := conn.Context().(*BitcaskClient)
 	switch command {
 	case "quit":
 		_ = conn.Close()
+		return
 	case "ping":
 		conn.WriteString("PONG")
-	default:
-		res, err := cmdFunc(client, cmd.Args[1:])
+		return
+	}
+	if txnFunc, ok := txnCommands[command]; ok {
+		txnFunc(client, conn, cmd.Args[1:])
+		return
+	}
+
+	cmdFunc, ok := supportedCommands[command]
+	if !ok {
+		//事务中出现不支持的命令时，EXEC 放弃整个事务
+		if client.inMulti {
+			client.multiErr = true
+		}
+		conn.WriteError("Err unsupported command: '" + command + "'")
+		return
+	}
+	if client.inMulti {
+		client.queueCommand(conn, command, cmd.Args)
+		return
+	}
+
+	svr := client.server
+	if exclusiveCommands[command] {
+		svr.mu.Lock()
+		defer svr.mu.Unlock()
+	} else {
+		svr.mu.RLock()
+		defer svr.mu.RUnlock()
+	}
+	//交换数据库之后编号对应的实例会变化
+	client.db = svr.dbs[client.dbIndex]
+
+	res, err := cmdFunc(client, cmd.Args[1:])
+	if err == nil {
+		svr.touchCommand(client.dbIndex, command, cmd.Args[1:])
+	}
+	writeResult(conn, res, err)
+}
+
+func writeResult(conn redcon.Conn, res interface{}, err error) {
+	if err != nil {
+		if err == bitcask_go.ErrKeyNotFound {
+			conn.WriteNull()
+		} else {
+			conn.WriteError(err.Error())
+		}
+		return
+	}
+
+	conn.WriteAny(res)
+}
+
+// 需要独占服务的命令，执行期间其他命令等待
+var exclusiveCommands = map[string]bool{
+	"select": true,
+	"swapdb": true,
+	"move":   true,
+}
+
+// ======================= 事务 =======================
+
+type txnHandler func(cli *BitcaskClient, conn redcon.Conn, args [][]byte)
+
+var txnCommands = map[string]txnHandler{
+	"multi":   multi,
+	"exec":    exec,
+	"discard": discard,
+	"watch":   watch,
+	"unwatch": unwatch,
+}
+
+// 修改数据的命令中 key 的位置，执行成功之后通知 WATCH 这些 key 的客户端
+type keySpec struct {
+	first, last, step int // last 为 -1 时到最后一个参数
+}
+
+var writeCommands = map[string]keySpec{
+	"set":          {0, 0, 1},
+	"setex":        {0, 0, 1},
+	"psetex":       {0, 0, 1},
+	"setnx":        {0, 0, 1},
+	"expire":       {0, 0, 1},
+	"pexpire":      {0, 0, 1},
+	"expireat":     {0, 0, 1},
+	"persist":      {0, 0, 1},
+	"del":          {0, -1, 1},
+	"rename":       {0, 1, 1},
+	"renamenx":     {0, 1, 1},
+	"getset":       {0, 0, 1},
+	"getdel":       {0, 0, 1},
+	"getex":        {0, 0, 1},
+	"incr":         {0, 0, 1},
+	"incrby":       {0, 0, 1},
+	"decr":         {0, 0, 1},
+	"decrby":       {0, 0, 1},
+	"incrbyfloat":  {0, 0, 1},
+	"append":       {0, 0, 1},
+	"setrange":     {0, 0, 1},
+	"mset":         {0, -1, 2},
+	"msetnx":       {0, -1, 2},
+	"hset":         {0, 0, 1},
+	"hmset":        {0, 0, 1},
+	"hsetnx":       {0, 0, 1},
+	"hdel":         {0, 0, 1},
+	"hincrby":      {0, 0, 1},
+	"hincrbyfloat": {0, 0, 1},
+	"sadd":         {0, 0, 1},
+	"srem":         {0, 0, 1},
+	"spop":         {0, 0, 1},
+	"sinterstore":  {0, 0, 1},
+	"sunionstore":  {0, 0, 1},
+	"sdiffstore":   {0, 0, 1},
+	"lpush":        {0, 0, 1},
+	"rpush":        {0, 0, 1},
+	"lpop":         {0, 0, 1},
+	"rpop":         {0, 0, 1},
+	"lset":         {0, 0, 1},
+	"ltrim":        {0, 0, 1},
+	"linsert":      {0, 0, 1},
+	"lrem":         {0, 0, 1},
+	"lmove":        {0, 1, 1},
+	"zadd":         {0, 0, 1},
+	"zrem":         {0, 0, 1},
+	"zincrby":      {0, 0, 1},
+	"zpopmin":      {0, 0, 1},
+	"zpopmax":      {0, 0, 1},
+}
+
+func (spec keySpec) keys(args [][]byte) [][]byte {
+	last := spec.last
+	if last < 0 || last >= len(args) {
+		last = len(args) - 1
+	}
+	var keys [][]byte
+	for i := spec.first; i <= last; i += spec.step {
+		keys = append(keys, args[i])
+	}
+	return keys
+}
+
+type watchKey struct {
+	db  int
+	key string
+}
+
+// 在 MULTI 和 EXEC 之间的命令只检查是否支持，EXEC 时再执行
+func (cli *BitcaskClient) queueCommand(conn redcon.Conn, command string, args [][]byte) {
+	//切换或者替换数据库的命令不能在事务中执行
+	if exclusiveCommands[command] {
+		cli.multiErr = true
+		conn.WriteError(errCommandInMulti.Error())
+		return
+	}
+
+	//命令的参数在读取下一条命令时会被覆盖，需要复制
+	queued := make([][]byte, len(args))
+	for i, arg := range args {
+		queued[i] = append([]byte(nil), arg...)
+	}
+	cli.queue = append(cli.queue, queued)
+	conn.WriteString("QUEUED")
+}
+
+func (cli *BitcaskClient) resetMulti() {
+	cli.inMulti = false
+	cli.multiErr = false
+	cli.queue = nil
+}
+
+func multi(cli *BitcaskClient, conn redcon.Conn, args [][]byte) {
+	if len(args) != 0 {
+		conn.WriteError(newWrongNumberOfArgsError("multi").Error())
+		return
+	}
+	if cli.inMulti {
+		conn.WriteError(errNestedMulti.Error())
+		return
+	}
+	cli.inMulti = true
+	conn.WriteString("OK")
+}
+
+// 在一个事务中执行排队的命令，所有的修改作为一个批量写提交
+// WATCH 的 key 被修改过或者已经过期时放弃事务，返回空的数组
+func exec(cli *BitcaskClient, conn redcon.Conn, args [][]byte) {
+	if len(args) != 0 {
+		conn.WriteError(newWrongNumberOfArgsError("exec").Error())
+		return
+	}
+	if !cli.inMulti {
+		conn.WriteError(errExecWithoutMulti.Error())
+		return
+	}
+	queue, aborted := cli.queue, cli.multiErr
+	cli.resetMulti()
+
+	//执行期间独占服务，其他客户端的命令不会穿插执行
+	svr := cli.server
+	svr.mu.Lock()
+	defer svr.mu.Unlock()
+	defer svr.unwatchAll(cli)
+
+	if aborted {
+		conn.WriteError(errExecAbort.Error())
+		return
+	}
+
+	db := svr.dbs[cli.dbIndex]
+	var results []interface{}
+	var errs []error
+	for {
+		if svr.watchedKeysChanged(cli) {
+			conn.WriteArray(-1)
+			return
+		}
+
+		txn := db.Begin()
+		cli.db = txn.RedisDataStructure
+		results = make([]interface{}, len(queue))
+		errs = make([]error, len(queue))
+		for i, cmd := range queue {
+			results[i], errs[i] = supportedCommands[strings.ToLower(string(cmd[0]))](cli, cmd[1:])
+		}
+		cli.db = db
+		err := txn.Commit()
+		//后台的过期和回收任务修改了事务读取过的 key，重新执行
+		if err == bitcask_go.ErrBatchConditionFailed {
+			continue
+		}
 		if err != nil {
-			if err == bitcask_go.ErrKeyNotFound {
-				conn.WriteNull()
-			} else {
-				conn.WriteError(err.Error())
-			}
+			conn.WriteError(err.Error())
 			return
 		}
+		break
+	}
 
-		conn.WriteAny(res)
+	conn.WriteArray(len(queue))
+	for i, cmd := range queue {
+		if errs[i] == nil {
+			svr.touchCommand(cli.dbIndex, strings.ToLower(string(cmd[0])), cmd[1:])
+		}
+		writeResult(conn, results[i], errs[i])
 	}
 }
 
-func set(cli *BitcaskClient, args [][]byte) (interface{}, error) {
-	if len(args) != 2 {
-		return nil, newWrongNumberOfArgsError("set")
+func discard(cli *BitcaskClient, conn redcon.Conn, args [][]byte) {
+	if len(args) != 0 {
+		conn.WriteError(newWrongNumberOfArgsError("discard").Error())
+		return
+	}
+	if !cli.inMulti {
+		conn.WriteError(errDiscardWithoutMulti.Error())
+		return
 	}
+	cli.resetMulti()
+	cli.server.unwatchAll(cli)
+	conn.WriteString("OK")
+}
 
-	//set a 100
-	key, value := args[0], args[1]
+func watch(cli *BitcaskClient, conn redcon.Conn, args [][]byte) {
+	if len(args) == 0 {
+		conn.WriteError(newWrongNumberOfArgsError("watch").Error())
+		return
+	}
+	if cli.inMulti {
+		conn.WriteError(errWatchInMulti.Error())
+		return
+	}
 
-	if err := cli.db.Set(key, 0, value); err != nil {
-		return nil, err
+	//记录 WATCH 时 key 是否存在，之后过期的 key 视为被修改
+	svr := cli.server
+	svr.mu.RLock()
+	defer svr.mu.RUnlock()
+	alive := make([]bool, len(args))
+	for i, key := range args {
+		n, err := svr.dbs[cli.dbIndex].Exists(key)
+		if err != nil {
+			conn.WriteError(err.Error())
+			return
+		}
+		alive[i] = n > 0
 	}
+	svr.watchKeys(cli, args, alive)
+	conn.WriteString("OK")
+}
 
-	return redcon.SimpleString("OK"), nil
+func unwatch(cli *BitcaskClient, conn redcon.Conn, args [][]byte) {
+	if len(args) != 0 {
+		conn.WriteError(newWrongNumberOfArgsError("unwatch").Error())
+		return
+	}
+	cli.server.unwatchAll(cli)
+	conn.WriteString("OK")
 }
 
-func get(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+func (svr *BitcaskServer) watchKeys(cli *BitcaskClient, keys [][]byte, alive []bool) {
+	svr.watchMu.Lock()
+	defer svr.watchMu.Unlock()
+
+	if cli.watching == nil {
+		cli.watching = make(map[watchKey]bool)
+	}
+	for i, key := range keys {
+		wk := watchKey{db: cli.dbIndex, key: string(key)}
+		clients, ok := svr.watched[wk]
+		if !ok {
+			clients = make(map[*BitcaskClient]struct{})
+			svr.watched[wk] = clients
+		}
+		if _, ok = clients[cli]; ok {
+			continue
+		}
+		clients[cli] = struct{}{}
+		cli.watching[wk] = alive[i]
+	}
+}
+
+// 取消客户端 WATCH 的所有 key，返回 WATCH 之后是否被修改过
+func (svr *BitcaskServer) unwatchAll(cli *BitcaskClient) bool {
+	svr.watchMu.Lock()
+	defer svr.watchMu.Unlock()
+
+	for wk := range cli.watching {
+		clients := svr.watched[wk]
+		delete(clients, cli)
+		if len(clients) == 0 {
+			delete(svr.watched, wk)
+		}
+	}
+	dirty := cli.dirty
+	cli.watching = nil
+	cli.dirty = false
+	return dirty
+}
+
+// WATCH 的 key 是否被修改过，WATCH 时存在的 key 已经过期也视为被修改，调用方需要持有服务的锁
+func (svr *BitcaskServer) watchedKeysChanged(cli *BitcaskClient) bool {
+	svr.watchMu.Lock()
+	dirty := cli.dirty
+	var alive []watchKey
+	for wk, ok := range cli.watching {
+		if ok {
+			alive = append(alive, wk)
+		}
+	}
+	svr.watchMu.Unlock()
+	if dirty {
+		return true
+	}
+
+	//过期的 key 在被后台任务删除之前读取不到
+	for _, wk := range alive {
+		db, ok := svr.dbs[wk.db]
+		if !ok {
+			return true
+		}
+		n, err := db.Exists([]byte(wk.key))
+		if err != nil || n == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// 命令执行成功之后标记 WATCH 被修改的 key 的客户端
+func (svr *BitcaskServer) touchCommand(dbIndex int, command string, args [][]byte) {
+	switch command {
+	case "flushdb":
+		svr.touchDB(dbIndex)
+	case "swapdb":
+		a, _ := strconv.Atoi(string(args[0]))
+		b, _ := strconv.Atoi(string(args[1]))
+		svr.touchDB(a)
+		svr.touchDB(b)
+	case "move":
+		index, _ := strconv.Atoi(string(args[1]))
+		svr.touchKeys(dbIndex, args[:1])
+		svr.touchKeys(index, args[:1])
+	default:
+		if spec, ok := writeCommands[command]; ok {
+			svr.touchKeys(dbIndex, spec.keys(args))
+		}
+	}
+}
+
+func (svr *BitcaskServer) touchKeys(dbIndex int, keys [][]byte) {
+	svr.watchMu.Lock()
+	defer svr.watchMu.Unlock()
+
+	for _, key := range keys {
+		for cli := range svr.watched[watchKey{db: dbIndex, key: string(key)}] {
+			cli.dirty = true
+		}
+	}
+}
+
+func (svr *BitcaskServer) touchDB(dbIndex int) {
+	svr.watchMu.Lock()
+	defer svr.watchMu.Unlock()
+
+	for wk, clients := range svr.watched {
+		if wk.db != dbIndex {
+			continue
+		}
+		for cli := range clients {
+			cli.dirty = true
+		}
+	}
+}
+
+func selectCmd(cli *BitcaskClient, args [][]byte) (interface{}, error) {
 	if len(args) != 1 {
-		return nil, newWrongNumberOfArgsError("get")
+		return nil, newWrongNumberOfArgsError("select")
 	}
 
-	value, err := cli.db.Get(args[0])
+	index, err := strconv.Atoi(string(args[0]))
+	if err != nil {
+		return nil, errNotInteger
+	}
+	db, err := cli.server.getDB(index)
 	if err != nil {
 		return nil, err
 	}
+	cli.dbIndex = index
+	cli.db = db
 
-	return value, nil
+	return redcon.SimpleString("OK"), nil
 }
 
-func hset(cli *BitcaskClient, args [][]byte) (interface{}, error) {
-	if len(args) != 3 {
-		return nil, newWrongNumberOfArgsError("hset")
+func swapdb(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 2 {
+		return nil, newWrongNumberOfArgsError("swapdb")
 	}
 
-	var ok = 0
-	key, field, value := args[0], args[1], args[2]
-	res, err := cli.db.HSet(key, field, value)
-
+	a, err := strconv.Atoi(string(args[0]))
 	if err != nil {
-		return nil, err
+		return nil, errInvalidFirstDBIndex
 	}
-	if res {
-		ok = 1
+	b, err := strconv.Atoi(string(args[1]))
+	if err != nil {
+		return nil, errInvalidSecondDBIndex
+	}
+	if err = cli.server.swapDB(a, b); err != nil {
+		return nil, err
 	}
+	cli.db = cli.server.dbs[cli.dbIndex]
 
-	return redcon.SimpleInt(ok), nil
+	return redcon.SimpleString("OK"), nil
 }
 
-func sadd(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+func move(cli *BitcaskClient, args [][]byte) (interface{}, error) {
 	if len(args) != 2 {
-		return nil, newWrongNumberOfArgsError("sadd")
+		return nil, newWrongNumberOfArgsError("move")
 	}
 
-	var ok = 0
-	key, member := args[0], args[1]
-	res, err := cli.db.SAdd(key, member)
+	index, err := strconv.Atoi(string(args[1]))
+	if err != nil {
+		return nil, errNotInteger
+	}
+	target, err := cli.server.getDB(index)
 	if err != nil {
 		return nil, err
 	}
-	if res {
-		ok = 1
+	res, err := cli.db.Move(args[0], target)
+	if err != nil {
+		return nil, err
 	}
 
-	return redcon.SimpleInt(ok), nil
+	return boolInt(res), nil
 }
 
-func lpush(cli *BitcaskClient, args [][]byte) (interface{}, error) {
-	if len(args) != 2 {
-		return nil, newWrongNumberOfArgsError("lpush")
+func set(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) < 2 {
+		return nil, newWrongNumberOfArgsError("set")
 	}
 
+	//set a 100 [NX|XX] [GET] [EX seconds|PX milliseconds]
 	key, value := args[0], args[1]
-	res, err := cli.db.LPush(key, value)
-	if err != nil {
+
+	var nx, xx, get bool
+	var ttl time.Duration
+	for i := 2; i < len(args); i++ {
+		switch opt := strings.ToLower(string(args[i])); opt {
+		case "nx":
+			nx = true
+		case "xx":
+			xx = true
+		case "get":
+			get = true
+		case "ex", "px":
+			if ttl != 0 || i+1 >= len(args) {
+				return nil, errSyntax
+			}
+			var err error
+			if ttl, err = parseTTL(args[i+1], opt == "ex", "set"); err != nil {
+				return nil, err
+			}
+			i++
+		default:
+			return nil, errSyntax
+		}
+	}
+	if nx && xx {
+		return nil, errSyntax
+	}
+
+	if get {
+		//返回原来的值，原来的值不存在时返回nil
+		old, _, err := cli.db.SetGet(key, ttl, value, nx, xx)
+		return nullableBulk(old, err)
+	}
+
+	if nx || xx {
+		var ok bool
+		var err error
+		if nx {
+			ok, err = cli.db.SetNX(key, ttl, value)
+		} else {
+			ok, err = cli.db.SetXX(key, ttl, value)
+		}
+		if err != nil {
+			return nil, err
+		}
+		//条件不满足时返回nil
+		if !ok {
+			return nil, nil
+		}
+		return redcon.SimpleString("OK"), nil
+	}
+
+	if err := cli.db.Set(key, ttl, value); err != nil {
 		return nil, err
 	}
 
-	return redcon.SimpleInt(res), nil
+	return redcon.SimpleString("OK"), nil
 }
 
-func zadd(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+func setex(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	return setWithTTL(cli, "setex", args, true)
+}
+
+func psetex(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	return setWithTTL(cli, "psetex", args, false)
+}
+
+// setex key seconds value
+func setWithTTL(cli *BitcaskClient, cmd string, args [][]byte, inSeconds bool) (interface{}, error) {
 	if len(args) != 3 {
-		return nil, newWrongNumberOfArgsError("zadd")
+		return nil, newWrongNumberOfArgsError(cmd)
+	}
+
+	ttl, err := parseTTL(args[1], inSeconds, cmd)
+	if err != nil {
+		return nil, err
+	}
+	if err = cli.db.Set(args[0], ttl, args[2]); err != nil {
+		return nil, err
+	}
+
+	return redcon.SimpleString("OK"), nil
+}
+
+func setnx(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 2 {
+		return nil, newWrongNumberOfArgsError("setnx")
 	}
 
 	var ok = 0
-	key, score, member := args[0], args[1], args[2]
-	res, err := cli.db.ZAdd(key, utils.BytesToFloat64(score), member)
+	key, value := args[0], args[1]
+	res, err := cli.db.SetNX(key, 0, value)
 	if err != nil {
 		return nil, err
 	}
@@ -154,3 +733,1511 @@ func zadd(cli *BitcaskClient, args [][]byte) (interface{}, error) {
 
 	return redcon.SimpleInt(ok), nil
 }
+
+func get(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, newWrongNumberOfArgsError("get")
+	}
+
+	//key不存在或者已经过期时返回nil
+	return nullableBulk(cli.db.Get(args[0]))
+}
+
+func mget(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) < 1 {
+		return nil, newWrongNumberOfArgsError("mget")
+	}
+
+	values, err := cli.db.MGet(args)
+	if err != nil {
+		return nil, err
+	}
+
+	//不存在的key返回nil
+	return bulkArray(values), nil
+}
+
+func getset(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 2 {
+		return nil, newWrongNumberOfArgsError("getset")
+	}
+
+	//写入新的值并去掉过期时间，返回原来的值
+	old, _, err := cli.db.SetGet(args[0], 0, args[1], false, false)
+	return nullableBulk(old, err)
+}
+
+func getdel(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, newWrongNumberOfArgsError("getdel")
+	}
+
+	return nullableBulk(cli.db.GetDel(args[0]))
+}
+
+func getex(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) < 1 {
+		return nil, newWrongNumberOfArgsError("getex")
+	}
+
+	//getex key [EX seconds|PX milliseconds|EXAT unix-time-seconds|PXAT unix-time-milliseconds|PERSIST]
+	var at time.Time
+	var persist bool
+	for i := 1; i < len(args); i++ {
+		if persist || !at.IsZero() {
+			return nil, errSyntax
+		}
+		switch opt := strings.ToLower(string(args[i])); opt {
+		case "persist":
+			persist = true
+		case "ex", "px", "exat", "pxat":
+			if i+1 >= len(args) {
+				return nil, errSyntax
+			}
+			inSeconds := opt == "ex" || opt == "exat"
+			ttl, err := parseTTL(args[i+1], inSeconds, "getex")
+			if err != nil {
+				return nil, err
+			}
+			if opt == "ex" || opt == "px" {
+				at = time.Now().Add(ttl)
+			} else {
+				at = time.Unix(0, 0).Add(ttl)
+			}
+			i++
+		default:
+			return nil, errSyntax
+		}
+	}
+
+	return nullableBulk(cli.db.GetEx(args[0], at, persist))
+}
+
+func incr(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, newWrongNumberOfArgsError("incr")
+	}
+
+	res, err := cli.db.Incr(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	return redcon.SimpleInt(res), nil
+}
+
+func decr(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, newWrongNumberOfArgsError("decr")
+	}
+
+	res, err := cli.db.Decr(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	return redcon.SimpleInt(res), nil
+}
+
+func incrby(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 2 {
+		return nil, newWrongNumberOfArgsError("incrby")
+	}
+
+	incr, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		return nil, errNotInteger
+	}
+	res, err := cli.db.IncrBy(args[0], incr)
+	if err != nil {
+		return nil, err
+	}
+
+	return redcon.SimpleInt(res), nil
+}
+
+func decrby(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 2 {
+		return nil, newWrongNumberOfArgsError("decrby")
+	}
+
+	decr, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		return nil, errNotInteger
+	}
+	res, err := cli.db.DecrBy(args[0], decr)
+	if err != nil {
+		return nil, err
+	}
+
+	return redcon.SimpleInt(res), nil
+}
+
+func incrbyfloat(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 2 {
+		return nil, newWrongNumberOfArgsError("incrbyfloat")
+	}
+
+	incr, err := strconv.ParseFloat(string(args[1]), 64)
+	if err != nil || math.IsNaN(incr) || math.IsInf(incr, 0) {
+		return nil, errNotFloat
+	}
+	res, err := cli.db.IncrByFloat(args[0], incr)
+	if err != nil {
+		return nil, err
+	}
+
+	return []byte(strconv.FormatFloat(res, 'f', -1, 64)), nil
+}
+
+func appendCmd(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 2 {
+		return nil, newWrongNumberOfArgsError("append")
+	}
+
+	res, err := cli.db.Append(args[0], args[1])
+	if err != nil {
+		return nil, err
+	}
+
+	return redcon.SimpleInt(res), nil
+}
+
+func strlen(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, newWrongNumberOfArgsError("strlen")
+	}
+
+	res, err := cli.db.StrLen(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	return redcon.SimpleInt(res), nil
+}
+
+func getrange(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 3 {
+		return nil, newWrongNumberOfArgsError("getrange")
+	}
+
+	start, end, err := parseRange(args[1], args[2])
+	if err != nil {
+		return nil, err
+	}
+	res, err := cli.db.GetRange(args[0], start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+func setrange(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 3 {
+		return nil, newWrongNumberOfArgsError("setrange")
+	}
+
+	offset, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		return nil, errNotInteger
+	}
+	res, err := cli.db.SetRange(args[0], offset, args[2])
+	if err != nil {
+		return nil, err
+	}
+
+	return redcon.SimpleInt(res), nil
+}
+
+func mset(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) < 2 || len(args)%2 != 0 {
+		return nil, newWrongNumberOfArgsError("mset")
+	}
+
+	if err := cli.db.MSet(args); err != nil {
+		return nil, err
+	}
+
+	return redcon.SimpleString("OK"), nil
+}
+
+func msetnx(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) < 2 || len(args)%2 != 0 {
+		return nil, newWrongNumberOfArgsError("msetnx")
+	}
+
+	res, err := cli.db.MSetNX(args)
+	if err != nil {
+		return nil, err
+	}
+
+	return boolInt(res), nil
+}
+
+func hset(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) < 3 || len(args)%2 != 1 {
+		return nil, newWrongNumberOfArgsError("hset")
+	}
+
+	//hset key field value [field value ...]，返回新增的field数量
+	res, err := cli.db.HMSet(args[0], args[1:])
+	if err != nil {
+		return nil, err
+	}
+
+	return redcon.SimpleInt(res), nil
+}
+
+func sadd(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 2 {
+		return nil, newWrongNumberOfArgsError("sadd")
+	}
+
+	var ok = 0
+	key, member := args[0], args[1]
+	res, err := cli.db.SAdd(key, member)
+	if err != nil {
+		return nil, err
+	}
+	if res {
+		ok = 1
+	}
+
+	return redcon.SimpleInt(ok), nil
+}
+
+func sismember(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 2 {
+		return nil, newWrongNumberOfArgsError("sismember")
+	}
+
+	res, err := cli.db.SIsMember(args[0], args[1])
+	if err != nil {
+		return nil, err
+	}
+
+	return boolInt(res), nil
+}
+
+func srem(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) < 2 {
+		return nil, newWrongNumberOfArgsError("srem")
+	}
+
+	var count = 0
+	key := args[0]
+	for _, member := range args[1:] {
+		res, err := cli.db.SRem(key, member)
+		if err != nil {
+			return nil, err
+		}
+		if res {
+			count++
+		}
+	}
+
+	return redcon.SimpleInt(count), nil
+}
+
+func scard(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, newWrongNumberOfArgsError("scard")
+	}
+
+	res, err := cli.db.SCard(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	return redcon.SimpleInt(res), nil
+}
+
+func smembers(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, newWrongNumberOfArgsError("smembers")
+	}
+
+	res, err := cli.db.SMembers(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	return bulkArray(res), nil
+}
+
+func spop(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 1 && len(args) != 2 {
+		return nil, newWrongNumberOfArgsError("spop")
+	}
+
+	//没有指定count时返回单个成员
+	if len(args) == 1 {
+		res, err := cli.db.SPop(args[0], 1)
+		if err != nil || len(res) == 0 {
+			return nil, err
+		}
+		return res[0], nil
+	}
+
+	count, err := strconv.Atoi(string(args[1]))
+	if err != nil || count < 0 {
+		return nil, errNotPositive
+	}
+	res, err := cli.db.SPop(args[0], count)
+	if err != nil {
+		return nil, err
+	}
+
+	return bulkArray(res), nil
+}
+
+func srandmember(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 1 && len(args) != 2 {
+		return nil, newWrongNumberOfArgsError("srandmember")
+	}
+
+	//没有指定count时返回单个成员
+	if len(args) == 1 {
+		res, err := cli.db.SRandMember(args[0], 1)
+		if err != nil || len(res) == 0 {
+			return nil, err
+		}
+		return res[0], nil
+	}
+
+	count, err := strconv.Atoi(string(args[1]))
+	if err != nil {
+		return nil, errNotInteger
+	}
+	res, err := cli.db.SRandMember(args[0], count)
+	if err != nil {
+		return nil, err
+	}
+
+	return bulkArray(res), nil
+}
+
+func sinter(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	return setAlgebra("sinter", args, cli.db.SInter)
+}
+
+func sunion(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	return setAlgebra("sunion", args, cli.db.SUnion)
+}
+
+func sdiff(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	return setAlgebra("sdiff", args, cli.db.SDiff)
+}
+
+func sinterstore(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	return setAlgebraStore("sinterstore", args, cli.db.SInterStore)
+}
+
+func sunionstore(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	return setAlgebraStore("sunionstore", args, cli.db.SUnionStore)
+}
+
+func sdiffstore(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	return setAlgebraStore("sdiffstore", args, cli.db.SDiffStore)
+}
+
+// sinter key [key ...]
+func setAlgebra(cmd string, args [][]byte, compute func(keys ...[]byte) ([][]byte, error)) (interface{}, error) {
+	if len(args) < 1 {
+		return nil, newWrongNumberOfArgsError(cmd)
+	}
+
+	res, err := compute(args...)
+	if err != nil {
+		return nil, err
+	}
+
+	return bulkArray(res), nil
+}
+
+// sinterstore destination key [key ...]
+func setAlgebraStore(cmd string, args [][]byte, store func(destination []byte, keys ...[]byte) (uint32, error)) (interface{}, error) {
+	if len(args) < 2 {
+		return nil, newWrongNumberOfArgsError(cmd)
+	}
+
+	res, err := store(args[0], args[1:]...)
+	if err != nil {
+		return nil, err
+	}
+
+	return redcon.SimpleInt(res), nil
+}
+
+func lpush(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 2 {
+		return nil, newWrongNumberOfArgsError("lpush")
+	}
+
+	key, value := args[0], args[1]
+	res, err := cli.db.LPush(key, value)
+	if err != nil {
+		return nil, err
+	}
+
+	return redcon.SimpleInt(res), nil
+}
+
+func rpush(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 2 {
+		return nil, newWrongNumberOfArgsError("rpush")
+	}
+
+	key, value := args[0], args[1]
+	res, err := cli.db.RPush(key, value)
+	if err != nil {
+		return nil, err
+	}
+
+	return redcon.SimpleInt(res), nil
+}
+
+func lpop(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, newWrongNumberOfArgsError("lpop")
+	}
+
+	return nullableBulk(cli.db.LPop(args[0]))
+}
+
+func rpop(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, newWrongNumberOfArgsError("rpop")
+	}
+
+	return nullableBulk(cli.db.RPop(args[0]))
+}
+
+func llen(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, newWrongNumberOfArgsError("llen")
+	}
+
+	res, err := cli.db.LLen(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	return redcon.SimpleInt(res), nil
+}
+
+func lrange(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 3 {
+		return nil, newWrongNumberOfArgsError("lrange")
+	}
+
+	start, stop, err := parseRange(args[1], args[2])
+	if err != nil {
+		return nil, err
+	}
+	res, err := cli.db.LRange(args[0], start, stop)
+	if err != nil {
+		return nil, err
+	}
+
+	return bulkArray(res), nil
+}
+
+func lindex(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 2 {
+		return nil, newWrongNumberOfArgsError("lindex")
+	}
+
+	index, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		return nil, errNotInteger
+	}
+
+	return nullableBulk(cli.db.LIndex(args[0], index))
+}
+
+func lset(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 3 {
+		return nil, newWrongNumberOfArgsError("lset")
+	}
+
+	index, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		return nil, errNotInteger
+	}
+	if err = cli.db.LSet(args[0], index, args[2]); err != nil {
+		return nil, err
+	}
+
+	return redcon.SimpleString("OK"), nil
+}
+
+func ltrim(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 3 {
+		return nil, newWrongNumberOfArgsError("ltrim")
+	}
+
+	start, stop, err := parseRange(args[1], args[2])
+	if err != nil {
+		return nil, err
+	}
+	if err = cli.db.LTrim(args[0], start, stop); err != nil {
+		return nil, err
+	}
+
+	return redcon.SimpleString("OK"), nil
+}
+
+func linsert(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 4 {
+		return nil, newWrongNumberOfArgsError("linsert")
+	}
+
+	//linsert key BEFORE|AFTER pivot element
+	var before bool
+	switch strings.ToLower(string(args[1])) {
+	case "before":
+		before = true
+	case "after":
+	default:
+		return nil, errSyntax
+	}
+	res, err := cli.db.LInsert(args[0], before, args[2], args[3])
+	if err != nil {
+		return nil, err
+	}
+
+	return redcon.SimpleInt(res), nil
+}
+
+func lrem(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 3 {
+		return nil, newWrongNumberOfArgsError("lrem")
+	}
+
+	count, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		return nil, errNotInteger
+	}
+	res, err := cli.db.LRem(args[0], count, args[2])
+	if err != nil {
+		return nil, err
+	}
+
+	return redcon.SimpleInt(res), nil
+}
+
+func lmove(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 4 {
+		return nil, newWrongNumberOfArgsError("lmove")
+	}
+
+	//lmove source destination LEFT|RIGHT LEFT|RIGHT
+	var sides [2]bool
+	for i, arg := range args[2:] {
+		switch strings.ToLower(string(arg)) {
+		case "left":
+			sides[i] = true
+		case "right":
+		default:
+			return nil, errSyntax
+		}
+	}
+
+	return nullableBulk(cli.db.LMove(args[0], args[1], sides[0], sides[1]))
+}
+
+// 解析 start stop 两个下标
+func parseRange(startArg, stopArg []byte) (int64, int64, error) {
+	start, err := strconv.ParseInt(string(startArg), 10, 64)
+	if err != nil {
+		return 0, 0, errNotInteger
+	}
+	stop, err := strconv.ParseInt(string(stopArg), 10, 64)
+	if err != nil {
+		return 0, 0, errNotInteger
+	}
+	return start, stop, nil
+}
+
+// 值为 nil 时返回空值
+func nullableBulk(value []byte, err error) (interface{}, error) {
+	if err != nil {
+		return nil, err
+	}
+	if value == nil {
+		return nil, nil
+	}
+	return value, nil
+}
+
+func zadd(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 3 {
+		return nil, newWrongNumberOfArgsError("zadd")
+	}
+
+	var ok = 0
+	key, member := args[0], args[2]
+	score, err := parseScore(args[1])
+	if err != nil {
+		return nil, err
+	}
+	res, err := cli.db.ZAdd(key, score, member)
+	if err != nil {
+		return nil, err
+	}
+	if res {
+		ok = 1
+	}
+
+	return redcon.SimpleInt(ok), nil
+}
+
+func zscore(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 2 {
+		return nil, newWrongNumberOfArgsError("zscore")
+	}
+
+	card, err := cli.db.ZCard(args[0])
+	if err != nil || card == 0 {
+		return nil, err
+	}
+	score, err := cli.db.ZScore(args[0], args[1])
+	if err != nil {
+		return nil, err
+	}
+
+	return []byte(formatScore(score)), nil
+}
+
+func zcard(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, newWrongNumberOfArgsError("zcard")
+	}
+
+	res, err := cli.db.ZCard(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	return redcon.SimpleInt(res), nil
+}
+
+func zrem(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) < 2 {
+		return nil, newWrongNumberOfArgsError("zrem")
+	}
+
+	var count = 0
+	key := args[0]
+	for _, member := range args[1:] {
+		res, err := cli.db.ZRem(key, member)
+		if err != nil {
+			return nil, err
+		}
+		if res {
+			count++
+		}
+	}
+
+	return redcon.SimpleInt(count), nil
+}
+
+func zincrby(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 3 {
+		return nil, newWrongNumberOfArgsError("zincrby")
+	}
+
+	incr, err := parseScore(args[1])
+	if err != nil {
+		return nil, err
+	}
+	res, err := cli.db.ZIncrBy(args[0], incr, args[2])
+	if err != nil {
+		return nil, err
+	}
+
+	return []byte(formatScore(res)), nil
+}
+
+func zrange(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	return zrangeByRank("zrange", args, cli.db.ZRange)
+}
+
+func zrevrange(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	return zrangeByRank("zrevrange", args, cli.db.ZRevRange)
+}
+
+// zrange key start stop [WITHSCORES]
+func zrangeByRank(cmd string, args [][]byte, rangeFunc func(key []byte, start, stop int64) ([]bitcask_redis.ZSetMember, error)) (interface{}, error) {
+	if len(args) != 3 && len(args) != 4 {
+		return nil, newWrongNumberOfArgsError(cmd)
+	}
+
+	withScores := false
+	if len(args) == 4 {
+		if strings.ToLower(string(args[3])) != "withscores" {
+			return nil, errSyntax
+		}
+		withScores = true
+	}
+	start, stop, err := parseRange(args[1], args[2])
+	if err != nil {
+		return nil, err
+	}
+	res, err := rangeFunc(args[0], start, stop)
+	if err != nil {
+		return nil, err
+	}
+
+	return zsetMembersReply(res, withScores), nil
+}
+
+func zrangebyscore(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) < 3 {
+		return nil, newWrongNumberOfArgsError("zrangebyscore")
+	}
+
+	//zrangebyscore key min max [WITHSCORES] [LIMIT offset count]
+	r, err := parseScoreRange(args[1], args[2])
+	if err != nil {
+		return nil, err
+	}
+	withScores := false
+	offset, count := 0, -1
+	for i := 3; i < len(args); i++ {
+		switch strings.ToLower(string(args[i])) {
+		case "withscores":
+			withScores = true
+		case "limit":
+			if i+2 >= len(args) {
+				return nil, errSyntax
+			}
+			if offset, err = strconv.Atoi(string(args[i+1])); err != nil {
+				return nil, errNotInteger
+			}
+			if count, err = strconv.Atoi(string(args[i+2])); err != nil {
+				return nil, errNotInteger
+			}
+			i += 2
+		default:
+			return nil, errSyntax
+		}
+	}
+	//offset为负数时返回空
+	if offset < 0 {
+		return []interface{}{}, nil
+	}
+
+	res, err := cli.db.ZRangeByScore(args[0], r, offset, count)
+	if err != nil {
+		return nil, err
+	}
+
+	return zsetMembersReply(res, withScores), nil
+}
+
+func zcount(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 3 {
+		return nil, newWrongNumberOfArgsError("zcount")
+	}
+
+	r, err := parseScoreRange(args[1], args[2])
+	if err != nil {
+		return nil, err
+	}
+	res, err := cli.db.ZCount(args[0], r)
+	if err != nil {
+		return nil, err
+	}
+
+	return redcon.SimpleInt(res), nil
+}
+
+func zrank(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 2 {
+		return nil, newWrongNumberOfArgsError("zrank")
+	}
+
+	return rankReply(cli.db.ZRank(args[0], args[1]))
+}
+
+func zrevrank(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 2 {
+		return nil, newWrongNumberOfArgsError("zrevrank")
+	}
+
+	return rankReply(cli.db.ZRevRank(args[0], args[1]))
+}
+
+func zpopmin(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	return zpop("zpopmin", args, cli.db.ZPopMin)
+}
+
+func zpopmax(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	return zpop("zpopmax", args, cli.db.ZPopMax)
+}
+
+// zpopmin key [count]
+func zpop(cmd string, args [][]byte, popFunc func(key []byte, count int) ([]bitcask_redis.ZSetMember, error)) (interface{}, error) {
+	if len(args) != 1 && len(args) != 2 {
+		return nil, newWrongNumberOfArgsError(cmd)
+	}
+
+	count := 1
+	if len(args) == 2 {
+		var err error
+		if count, err = strconv.Atoi(string(args[1])); err != nil || count < 0 {
+			return nil, errNotPositive
+		}
+	}
+	res, err := popFunc(args[0], count)
+	if err != nil {
+		return nil, err
+	}
+
+	return zsetMembersReply(res, true), nil
+}
+
+// 解析分数，支持 inf
+func parseScore(arg []byte) (float64, error) {
+	score, err := strconv.ParseFloat(string(arg), 64)
+	if err != nil || math.IsNaN(score) {
+		return 0, errNotFloat
+	}
+	return score, nil
+}
+
+// 解析分数范围，( 开头表示不包含边界
+func parseScoreRange(minArg, maxArg []byte) (bitcask_redis.ScoreRange, error) {
+	var r bitcask_redis.ScoreRange
+	var err error
+	if len(minArg) > 0 && minArg[0] == '(' {
+		r.MinExclusive = true
+		minArg = minArg[1:]
+	}
+	if len(maxArg) > 0 && maxArg[0] == '(' {
+		r.MaxExclusive = true
+		maxArg = maxArg[1:]
+	}
+	if r.Min, err = parseScore(minArg); err != nil {
+		return r, errMinMaxNotFloat
+	}
+	if r.Max, err = parseScore(maxArg); err != nil {
+		return r, errMinMaxNotFloat
+	}
+	return r, nil
+}
+
+func formatScore(score float64) string {
+	switch {
+	case math.IsInf(score, 1):
+		return "inf"
+	case math.IsInf(score, -1):
+		return "-inf"
+	}
+	return strconv.FormatFloat(score, 'g', -1, 64)
+}
+
+func zsetMembersReply(members []bitcask_redis.ZSetMember, withScores bool) []interface{} {
+	res := make([]interface{}, 0, len(members)*2)
+	for _, m := range members {
+		res = append(res, m.Member)
+		if withScores {
+			res = append(res, []byte(formatScore(m.Score)))
+		}
+	}
+	return res
+}
+
+// 排名为 -1 表示 member 不存在，返回空值
+func rankReply(rank int64, err error) (interface{}, error) {
+	if err != nil || rank < 0 {
+		return nil, err
+	}
+	return redcon.SimpleInt(rank), nil
+}
+
+func expire(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	return expireWithTTL(cli, "expire", args, true)
+}
+
+func pexpire(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	return expireWithTTL(cli, "pexpire", args, false)
+}
+
+// expire key seconds，过期时间可以是负数，表示立即过期
+func expireWithTTL(cli *BitcaskClient, cmd string, args [][]byte, inSeconds bool) (interface{}, error) {
+	if len(args) != 2 {
+		return nil, newWrongNumberOfArgsError(cmd)
+	}
+
+	ttl, err := parseDuration(args[1], inSeconds)
+	if err != nil {
+		return nil, err
+	}
+	res, err := cli.db.Expire(args[0], ttl)
+	if err != nil {
+		return nil, err
+	}
+
+	return boolInt(res), nil
+}
+
+func expireat(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 2 {
+		return nil, newWrongNumberOfArgsError("expireat")
+	}
+
+	//expireat key unix-time-seconds
+	seconds, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		return nil, errNotInteger
+	}
+	if seconds > math.MaxInt64/int64(time.Second) || seconds < math.MinInt64/int64(time.Second) {
+		return nil, newInvalidExpireError("expireat")
+	}
+	res, err := cli.db.ExpireAt(args[0], time.Unix(seconds, 0))
+	if err != nil {
+		return nil, err
+	}
+
+	return boolInt(res), nil
+}
+
+func ttl(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	return ttlReply(cli, "ttl", args, time.Second)
+}
+
+func pttl(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	return ttlReply(cli, "pttl", args, time.Millisecond)
+}
+
+// key不存在时返回-2，没有过期时间时返回-1
+func ttlReply(cli *BitcaskClient, cmd string, args [][]byte, unit time.Duration) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, newWrongNumberOfArgsError(cmd)
+	}
+
+	res, err := cli.db.TTL(args[0])
+	if err == bitcask_go.ErrKeyNotFound {
+		return redcon.SimpleInt(-2), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if res == bitcask_redis.NoExpiration {
+		return redcon.SimpleInt(-1), nil
+	}
+
+	//四舍五入到对应的单位
+	return redcon.SimpleInt((res + unit/2) / unit), nil
+}
+
+func persist(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, newWrongNumberOfArgsError("persist")
+	}
+
+	res, err := cli.db.Persist(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	return boolInt(res), nil
+}
+
+// 数据类型和 TYPE 命令返回的名称
+var typeNames = map[bitcask_redis.RedisDataType]string{
+	bitcask_redis.String: "string",
+	bitcask_redis.Hash:   "hash",
+	bitcask_redis.List:   "list",
+	bitcask_redis.Set:    "set",
+	bitcask_redis.ZSet:   "zset",
+}
+
+func del(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) < 1 {
+		return nil, newWrongNumberOfArgsError("del")
+	}
+
+	res, err := cli.db.DelKeys(args...)
+	if err != nil {
+		return nil, err
+	}
+
+	return redcon.SimpleInt(res), nil
+}
+
+func exists(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) < 1 {
+		return nil, newWrongNumberOfArgsError("exists")
+	}
+
+	res, err := cli.db.Exists(args...)
+	if err != nil {
+		return nil, err
+	}
+
+	return redcon.SimpleInt(res), nil
+}
+
+func typeCmd(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, newWrongNumberOfArgsError("type")
+	}
+
+	res, err := cli.db.Type(args[0])
+	if err == bitcask_go.ErrKeyNotFound {
+		return redcon.SimpleString("none"), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return redcon.SimpleString(typeNames[res]), nil
+}
+
+func rename(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 2 {
+		return nil, newWrongNumberOfArgsError("rename")
+	}
+
+	if err := cli.db.Rename(args[0], args[1]); err != nil {
+		return nil, err
+	}
+
+	return redcon.SimpleString("OK"), nil
+}
+
+func renamenx(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 2 {
+		return nil, newWrongNumberOfArgsError("renamenx")
+	}
+
+	res, err := cli.db.RenameNX(args[0], args[1])
+	if err != nil {
+		return nil, err
+	}
+
+	return boolInt(res), nil
+}
+
+func randomkey(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 0 {
+		return nil, newWrongNumberOfArgsError("randomkey")
+	}
+
+	return nullableBulk(cli.db.RandomKey())
+}
+
+func dbsize(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 0 {
+		return nil, newWrongNumberOfArgsError("dbsize")
+	}
+
+	res, err := cli.db.DBSize()
+	if err != nil {
+		return nil, err
+	}
+
+	return redcon.SimpleInt(res), nil
+}
+
+func flushdb(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	//flushdb [ASYNC|SYNC]，都按照同步的方式执行
+	if len(args) > 1 {
+		return nil, errSyntax
+	}
+	if len(args) == 1 {
+		if opt := strings.ToLower(string(args[0])); opt != "async" && opt != "sync" {
+			return nil, errSyntax
+		}
+	}
+
+	if err := cli.db.FlushDB(); err != nil {
+		return nil, err
+	}
+
+	return redcon.SimpleString("OK"), nil
+}
+
+func keys(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, newWrongNumberOfArgsError("keys")
+	}
+
+	res, err := cli.db.Keys(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	return bulkArray(res), nil
+}
+
+func scan(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) < 1 {
+		return nil, newWrongNumberOfArgsError("scan")
+	}
+
+	//scan cursor [MATCH pattern] [COUNT count] [TYPE type]
+	cursor, err := strconv.ParseUint(string(args[0]), 10, 64)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+	var pattern []byte
+	var count int
+	dataType := bitcask_redis.AnyType
+	for i := 1; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			return nil, errSyntax
+		}
+		switch strings.ToLower(string(args[i])) {
+		case "match":
+			pattern = args[i+1]
+		case "count":
+			count, err = strconv.Atoi(string(args[i+1]))
+			if err != nil {
+				return nil, errNotInteger
+			}
+			if count < 1 {
+				return nil, errSyntax
+			}
+		case "type":
+			if dataType, err = parseTypeName(args[i+1]); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, errSyntax
+		}
+	}
+
+	next, res, err := cli.db.Scan(cursor, pattern, count, dataType)
+	if err != nil {
+		return nil, err
+	}
+
+	return []interface{}{[]byte(strconv.FormatUint(next, 10)), bulkArray(res)}, nil
+}
+
+func parseTypeName(arg []byte) (bitcask_redis.RedisDataType, error) {
+	name := strings.ToLower(string(arg))
+	for dataType, typeName := range typeNames {
+		if typeName == name {
+			return dataType, nil
+		}
+	}
+	return 0, fmt.Errorf("ERR unknown type name '%s'", arg)
+}
+
+func newInvalidExpireError(cmd string) error {
+	return fmt.Errorf("ERR invalid expire time in '%s' command", cmd)
+}
+
+// 解析秒或者毫秒为单位的时间，超出time.Duration范围时返回错误
+func parseDuration(arg []byte, inSeconds bool) (time.Duration, error) {
+	n, err := strconv.ParseInt(string(arg), 10, 64)
+	if err != nil {
+		return 0, errNotInteger
+	}
+	unit := time.Millisecond
+	if inSeconds {
+		unit = time.Second
+	}
+	if n > math.MaxInt64/int64(unit) || n < math.MinInt64/int64(unit) {
+		return 0, errNotInteger
+	}
+	return time.Duration(n) * unit, nil
+}
+
+// 解析写入时指定的过期时间，必须是正数
+func parseTTL(arg []byte, inSeconds bool, cmd string) (time.Duration, error) {
+	ttl, err := parseDuration(arg, inSeconds)
+	if err != nil {
+		return 0, err
+	}
+	if ttl <= 0 {
+		return 0, newInvalidExpireError(cmd)
+	}
+	return ttl, nil
+}
+
+func hget(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 2 {
+		return nil, newWrongNumberOfArgsError("hget")
+	}
+
+	//key不存在时返回nil
+	return nullableBulk(cli.db.HGet(args[0], args[1]))
+}
+
+func hmset(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) < 3 || len(args)%2 != 1 {
+		return nil, newWrongNumberOfArgsError("hmset")
+	}
+
+	if _, err := cli.db.HMSet(args[0], args[1:]); err != nil {
+		return nil, err
+	}
+
+	return redcon.SimpleString("OK"), nil
+}
+
+func hmget(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) < 2 {
+		return nil, newWrongNumberOfArgsError("hmget")
+	}
+
+	values, err := cli.db.HMGet(args[0], args[1:])
+	if err != nil {
+		return nil, err
+	}
+
+	return bulkArray(values), nil
+}
+
+func hsetnx(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 3 {
+		return nil, newWrongNumberOfArgsError("hsetnx")
+	}
+
+	res, err := cli.db.HSetNX(args[0], args[1], args[2])
+	if err != nil {
+		return nil, err
+	}
+
+	return boolInt(res), nil
+}
+
+func hdel(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) < 2 {
+		return nil, newWrongNumberOfArgsError("hdel")
+	}
+
+	var count = 0
+	key := args[0]
+	for _, field := range args[1:] {
+		res, err := cli.db.HDel(key, field)
+		if err != nil {
+			return nil, err
+		}
+		if res {
+			count++
+		}
+	}
+
+	return redcon.SimpleInt(count), nil
+}
+
+func hexists(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 2 {
+		return nil, newWrongNumberOfArgsError("hexists")
+	}
+
+	res, err := cli.db.HExists(args[0], args[1])
+	if err != nil {
+		return nil, err
+	}
+
+	return boolInt(res), nil
+}
+
+func hlen(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, newWrongNumberOfArgsError("hlen")
+	}
+
+	res, err := cli.db.HLen(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	return redcon.SimpleInt(res), nil
+}
+
+func hgetall(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, newWrongNumberOfArgsError("hgetall")
+	}
+
+	res, err := cli.db.HGetAll(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	return bulkArray(res), nil
+}
+
+func hkeys(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, newWrongNumberOfArgsError("hkeys")
+	}
+
+	res, err := cli.db.HKeys(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	return bulkArray(res), nil
+}
+
+func hvals(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, newWrongNumberOfArgsError("hvals")
+	}
+
+	res, err := cli.db.HVals(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	return bulkArray(res), nil
+}
+
+func hincrby(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 3 {
+		return nil, newWrongNumberOfArgsError("hincrby")
+	}
+
+	incr, err := strconv.ParseInt(string(args[2]), 10, 64)
+	if err != nil {
+		return nil, errNotInteger
+	}
+	res, err := cli.db.HIncrBy(args[0], args[1], incr)
+	if err != nil {
+		return nil, err
+	}
+
+	return redcon.SimpleInt(res), nil
+}
+
+func hincrbyfloat(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 3 {
+		return nil, newWrongNumberOfArgsError("hincrbyfloat")
+	}
+
+	incr, err := strconv.ParseFloat(string(args[2]), 64)
+	if err != nil || math.IsNaN(incr) || math.IsInf(incr, 0) {
+		return nil, errNotFloat
+	}
+	res, err := cli.db.HIncrByFloat(args[0], args[1], incr)
+	if err != nil {
+		return nil, err
+	}
+
+	return []byte(strconv.FormatFloat(res, 'f', -1, 64)), nil
+}
+
+func hscan(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) < 2 {
+		return nil, newWrongNumberOfArgsError("hscan")
+	}
+
+	//hscan key cursor [MATCH pattern] [COUNT count]
+	cursor, err := strconv.ParseUint(string(args[1]), 10, 64)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+	var pattern []byte
+	var count int
+	for i := 2; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			return nil, errSyntax
+		}
+		switch strings.ToLower(string(args[i])) {
+		case "match":
+			pattern = args[i+1]
+		case "count":
+			count, err = strconv.Atoi(string(args[i+1]))
+			if err != nil {
+				return nil, errNotInteger
+			}
+			if count < 1 {
+				return nil, errSyntax
+			}
+		default:
+			return nil, errSyntax
+		}
+	}
+
+	next, res, err := cli.db.HScan(args[0], cursor, pattern, count)
+	if err != nil {
+		return nil, err
+	}
+
+	return []interface{}{[]byte(strconv.FormatUint(next, 10)), bulkArray(res)}, nil
+}
+
+// 转换成数组回复，nil 的元素返回空值
+func bulkArray(values [][]byte) []interface{} {
+	res := make([]interface{}, len(values))
+	for i, value := range values {
+		if value != nil {
+			res[i] = value
+		}
+	}
+	return res
+}
+
+func boolInt(ok bool) redcon.SimpleInt {
+	if ok {
+		return 1
+	}
+	return 0
+}
+
+// 按顺序输出的 INFO 分组
+var infoSections = []struct {
+	name string
+	fn   func(cli *BitcaskClient, b *strings.Builder)
+}{
+	{"stats", infoStats},
+	{"keyspace", infoKeyspace},
+}
+
+func info(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) > 1 {
+		return nil, errSyntax
+	}
+	section := "default"
+	if len(args) == 1 {
+		section = strings.ToLower(string(args[0]))
+	}
+
+	var b strings.Builder
+	for _, s := range infoSections {
+		if section != "default" && section != "all" && section != "everything" && section != s.name {
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteString("\r\n")
+		}
+		s.fn(cli, &b)
+	}
+	return []byte(b.String()), nil
+}
+
+func infoStats(cli *BitcaskClient, b *strings.Builder) {
+	stats := cli.db.Stats()
+	b.WriteString("# Stats\r\n")
+	fmt.Fprintf(b, "expired_keys:%d\r\n", stats.ExpiredKeys)
+	fmt.Fprintf(b, "reclaimed_versions:%d\r\n", stats.ReclaimedVersions)
+	fmt.Fprintf(b, "reclaimed_keys:%d\r\n", stats.ReclaimedKeys)
+	fmt.Fprintf(b, "pending_reclaim_versions:%d\r\n", stats.PendingVersions)
+}
+
+func infoKeyspace(cli *BitcaskClient, b *strings.Builder) {
+	b.WriteString("# Keyspace\r\n")
+	for i := 0; i < cli.server.databases; i++ {
+		db, ok := cli.server.dbs[i]
+		if !ok {
+			continue
+		}
+		keys, expires, err := db.KeyspaceStats()
+		if err != nil || keys == 0 {
+			continue
+		}
+		fmt.Fprintf(b, "db%d:keys=%d,expires=%d,avg_ttl=0\r\n", i, keys, expires)
+	}
+}
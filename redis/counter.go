@@ -0,0 +1,236 @@
+package redis
+
+import (
+	bitcask_go "bitcask-go"
+	"bytes"
+	"sync"
+)
+
+// 数据库中 key 的数量，写入时更新，统计信息不需要遍历所有的 key
+// 已经过期但是还没有被删除的 key 也计算在内，和 Redis 一致
+type keyspaceCounters struct {
+	mu      sync.Mutex // 读取旧值和写入之间不能有其他写入，否则计数会出错
+	keys    int64      // 用户的 key 的数量
+	expires int64      // 其中设置了过期时间的 key 的数量
+	pending int64      // 等待回收的失效版本数量
+}
+
+// 是否是需要计数的 key，其他 key 的写入不需要读取旧值
+func isCountedKey(key []byte) bool {
+	if bytes.HasPrefix(key, staleVersionPrefix) {
+		return true
+	}
+	return !isElementKey(key) && !isInternalKey(key)
+}
+
+// 一个存在的 key 对计数的影响，n 为 1 表示写入，-1 表示删除
+func (c *keyspaceCounters) add(key, value []byte, n int64) {
+	switch {
+	case bytes.HasPrefix(key, staleVersionPrefix):
+		c.pending += n
+	case isElementKey(key) || isInternalKey(key):
+	// 没有元素的复合类型等同于不存在
+	case len(value) == 0 || (value[0] != String && decodeMetadata(value).size == 0):
+	default:
+		c.keys += n
+		if decodeExpire(value) != 0 {
+			c.expires += n
+		}
+	}
+}
+
+// key 的值从 oldValue 变成 newValue，exists 表示写入之前或者之后 key 是否存在
+func (c *keyspaceCounters) replace(key []byte, oldValue []byte, oldExists bool, newValue []byte, newExists bool) {
+	if oldExists {
+		c.add(key, oldValue, -1)
+	}
+	if newExists {
+		c.add(key, newValue, 1)
+	}
+}
+
+// 遍历所有的 key 计数，打开时和只读模式下使用
+func (rds *RedisDataStructure) countKeyspace() (*keyspaceCounters, error) {
+	c := &keyspaceCounters{}
+	err := rds.iterateKeys(func(key, encValue []byte) bool {
+		c.add(key, encValue, 1)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = rds.iteratePrefix(staleVersionPrefix, func(suffix, value []byte) bool {
+		c.pending++
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// 打开时加载计数，升级 key 的编码方式期间的写入不影响结果
+func (rds *RedisDataStructure) loadCounters() error {
+	c, err := rds.countKeyspace()
+	if err != nil {
+		return err
+	}
+	rds.counters.mu.Lock()
+	defer rds.counters.mu.Unlock()
+	rds.counters.keys, rds.counters.expires, rds.counters.pending = c.keys, c.expires, c.pending
+	return nil
+}
+
+// 返回当前的计数，只读模式下其他实例的写入不会更新计数，需要遍历所有的 key
+func (rds *RedisDataStructure) keyspaceCounts() (keys, expires, pending int64, err error) {
+	if rds.readOnly {
+		c, err := rds.countKeyspace()
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		return c.keys, c.expires, c.pending, nil
+	}
+	rds.counters.mu.Lock()
+	defer rds.counters.mu.Unlock()
+	return rds.counters.keys, rds.counters.expires, rds.counters.pending, nil
+}
+
+// 读取 key 的值，返回 key 是否存在
+func (s dbStore) lookup(key []byte) ([]byte, bool, error) {
+	value, err := s.DB.Get(key)
+	if err == bitcask_go.ErrKeyNotFound {
+		return nil, false, nil
+	}
+	return value, err == nil, err
+}
+
+func (s dbStore) Put(key []byte, value []byte) error {
+	if !isCountedKey(key) {
+		return s.DB.Put(key, value)
+	}
+	s.counters.mu.Lock()
+	defer s.counters.mu.Unlock()
+	oldValue, exists, err := s.lookup(key)
+	if err != nil {
+		return err
+	}
+	if err = s.DB.Put(key, value); err != nil {
+		return err
+	}
+	s.counters.replace(key, oldValue, exists, value, true)
+	return nil
+}
+
+func (s dbStore) Delete(key []byte) error {
+	if !isCountedKey(key) {
+		return s.DB.Delete(key)
+	}
+	s.counters.mu.Lock()
+	defer s.counters.mu.Unlock()
+	oldValue, exists, err := s.lookup(key)
+	if err != nil {
+		return err
+	}
+	if err = s.DB.Delete(key); err != nil {
+		return err
+	}
+	s.counters.replace(key, oldValue, exists, nil, false)
+	return nil
+}
+
+func (s dbStore) CompareAndSwap(key []byte, expected []byte, value []byte) (bool, error) {
+	if !isCountedKey(key) {
+		return s.DB.CompareAndSwap(key, expected, value)
+	}
+	s.counters.mu.Lock()
+	defer s.counters.mu.Unlock()
+	ok, err := s.DB.CompareAndSwap(key, expected, value)
+	if ok {
+		s.counters.replace(key, expected, true, value, true)
+	}
+	return ok, err
+}
+
+func (s dbStore) PutIfAbsent(key []byte, value []byte) (bool, error) {
+	if !isCountedKey(key) {
+		return s.DB.PutIfAbsent(key, value)
+	}
+	s.counters.mu.Lock()
+	defer s.counters.mu.Unlock()
+	ok, err := s.DB.PutIfAbsent(key, value)
+	if ok {
+		s.counters.replace(key, nil, false, value, true)
+	}
+	return ok, err
+}
+
+func (s dbStore) DeleteIfValue(key []byte, expected []byte) (bool, error) {
+	if !isCountedKey(key) {
+		return s.DB.DeleteIfValue(key, expected)
+	}
+	s.counters.mu.Lock()
+	defer s.counters.mu.Unlock()
+	ok, err := s.DB.DeleteIfValue(key, expected)
+	if ok {
+		s.counters.replace(key, expected, true, nil, false)
+	}
+	return ok, err
+}
+
+// 批量写，提交成功之后根据写入之前的值更新计数
+type countingBatch struct {
+	*bitcask_go.WriteBatch
+	store  dbStore
+	writes map[string]*txnWrite // 需要计数的 key 最后一次的写入
+}
+
+func (wb *countingBatch) Put(key []byte, value []byte) error {
+	if err := wb.WriteBatch.Put(key, value); err != nil {
+		return err
+	}
+	if isCountedKey(key) {
+		wb.writes[string(key)] = &txnWrite{value: value}
+	}
+	return nil
+}
+
+func (wb *countingBatch) Delete(key []byte) error {
+	if err := wb.WriteBatch.Delete(key); err != nil {
+		return err
+	}
+	if isCountedKey(key) {
+		wb.writes[string(key)] = &txnWrite{deleted: true}
+	}
+	return nil
+}
+
+func (wb *countingBatch) Commit() error {
+	if len(wb.writes) == 0 {
+		return wb.WriteBatch.Commit()
+	}
+	counters := wb.store.counters
+	counters.mu.Lock()
+	defer counters.mu.Unlock()
+
+	type oldValue struct {
+		value  []byte
+		exists bool
+	}
+	oldValues := make(map[string]oldValue, len(wb.writes))
+	for key := range wb.writes {
+		value, exists, err := wb.store.lookup([]byte(key))
+		if err != nil {
+			return err
+		}
+		oldValues[key] = oldValue{value: value, exists: exists}
+	}
+	if err := wb.WriteBatch.Commit(); err != nil {
+		return err
+	}
+	for key, w := range wb.writes {
+		old := oldValues[key]
+		counters.replace([]byte(key), old.value, old.exists, w.value, !w.deleted)
+	}
+	wb.writes = make(map[string]*txnWrite)
+	return nil
+}
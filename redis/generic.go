@@ -1,19 +1,261 @@
 package redis
 
-import "errors"
+import (
+	bitcask_go "bitcask-go"
+	"bytes"
+	"errors"
+	"time"
+)
+
+// SCAN 类命令没有指定 COUNT 时每次返回的数量
+const defaultScanCount = 10
 
 func (rds *RedisDataStructure) Del(key []byte) error {
-	return rds.db.Delete(key)
+	_, err := rds.delKey(key)
+	return err
 }
 
 func (rds *RedisDataStructure) Type(key []byte) (RedisDataType, error) {
-	encValue, err := rds.db.Get(key)
+	encValue, err := rds.kv.Get(key)
 	if err != nil {
 		return 0, err
 	}
 	if len(encValue) == 0 {
 		return 0, errors.New("value is null")
 	}
+	if !isAliveValue(encValue) {
+		return 0, bitcask_go.ErrKeyNotFound
+	}
 
 	return encValue[0], nil
 }
+
+// NoExpiration key 没有设置过期时间时 TTL 的返回值
+const NoExpiration time.Duration = -1
+
+// Expire 设置 key 在 ttl 之后过期，key 不存在时返回 false
+func (rds *RedisDataStructure) Expire(key []byte, ttl time.Duration) (bool, error) {
+	return rds.ExpireAt(key, time.Now().Add(ttl))
+}
+
+// ExpireAt 设置 key 过期的时间点，时间点已经过去时直接删除 key
+func (rds *RedisDataStructure) ExpireAt(key []byte, at time.Time) (bool, error) {
+	return rds.updateExpire(key, at.UnixNano(), false)
+}
+
+// Persist 去掉 key 的过期时间，key 不存在或者没有过期时间时返回 false
+func (rds *RedisDataStructure) Persist(key []byte) (bool, error) {
+	return rds.updateExpire(key, 0, true)
+}
+
+// TTL 返回 key 的剩余有效时间，没有过期时间时返回 NoExpiration，key 不存在时返回 ErrKeyNotFound
+func (rds *RedisDataStructure) TTL(key []byte) (time.Duration, error) {
+	encValue, err := rds.kv.Get(key)
+	if err != nil {
+		return 0, err
+	}
+	if !isAliveValue(encValue) {
+		return 0, bitcask_go.ErrKeyNotFound
+	}
+
+	expire := decodeExpire(encValue)
+	if expire == 0 {
+		return NoExpiration, nil
+	}
+	ttl := time.Duration(expire - time.Now().UnixNano())
+	if ttl < 0 {
+		ttl = 0
+	}
+	return ttl, nil
+}
+
+// 修改过期时间，String 类型的过期时间编码在值里面，其他类型在元数据中
+func (rds *RedisDataStructure) updateExpire(key []byte, expire int64, persist bool) (bool, error) {
+	for {
+		encValue, err := rds.kv.Get(key)
+		if err == bitcask_go.ErrKeyNotFound {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		if !isAliveValue(encValue) {
+			return false, nil
+		}
+		if persist && decodeExpire(encValue) == 0 {
+			return false, nil
+		}
+
+		// 条件写入，key 在读取之后被并发修改则重新判断
+		var ok bool
+		if expire != 0 && expire <= time.Now().UnixNano() {
+			if err = rds.markStaleValue(key, encValue); err != nil {
+				return false, err
+			}
+			ok, err = rds.kv.DeleteIfValue(key, encValue)
+		} else {
+			var version int64
+			if encValue[0] != String {
+				version = decodeMetadata(encValue).version
+			}
+			if err = rds.recordExpire(key, version, expire); err != nil {
+				return false, err
+			}
+			ok, err = rds.kv.CompareAndSwap(key, encValue, encodeWithExpire(encValue, expire))
+		}
+		if err != nil {
+			return false, err
+		}
+		if ok {
+			return true, nil
+		}
+	}
+}
+
+// 判断存储的值对应的 key 是否仍然有效
+// String 类型的过期时间编码在值里面，其他类型记录在元数据中
+func isAliveValue(encValue []byte) bool {
+	if len(encValue) == 0 {
+		return false
+	}
+	if encValue[0] != String && decodeMetadata(encValue).size == 0 {
+		return false
+	}
+
+	expire := decodeExpire(encValue)
+	return expire == 0 || expire > time.Now().UnixNano()
+}
+
+// 解码存储的值中的过期时间
+func decodeExpire(encValue []byte) int64 {
+	if encValue[0] == String {
+		_, expire := decodeStringValue(encValue)
+		return expire
+	}
+	return decodeMetadata(encValue).expire
+}
+
+// 替换存储的值中的过期时间
+func encodeWithExpire(encValue []byte, expire int64) []byte {
+	if encValue[0] == String {
+		value, _ := decodeStringValue(encValue)
+		return encodeStringValueAt(value, expire)
+	}
+	meta := decodeMetadata(encValue)
+	meta.expire = expire
+	return meta.encode()
+}
+
+// 判断数据部分的 key 是否存在
+func (rds *RedisDataStructure) exists(encKey []byte) (bool, error) {
+	_, err := rds.kv.Get(encKey)
+	if err == bitcask_go.ErrKeyNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// 按顺序遍历以 prefix 开头的 key，fn 的参数是去掉 prefix 之后的部分，返回 false 时停止遍历
+func (rds *RedisDataStructure) iteratePrefix(prefix []byte, fn func(suffix, value []byte) bool) error {
+	return rds.iterateRange(prefix, nil, false, fn)
+}
+
+// 遍历以 prefix 开头的 key，正向遍历时从 prefix+from 开始，反向遍历时从以 prefix+from 开头的最后一个 key 开始
+func (rds *RedisDataStructure) iterateRange(prefix, from []byte, reverse bool, fn func(suffix, value []byte) bool) error {
+	// 索引是有序的，不使用迭代器的 Prefix 选项，离开前缀的范围之后直接结束
+	it := rds.kv.NewIterator(bitcask_go.IteratorOptions{Reserve: reverse})
+	defer it.Close()
+
+	target := append(append(make([]byte, 0, len(prefix)+len(from)), prefix...), from...)
+	if reverse {
+		// 反向遍历时跳过所有以 target 开头的 key 之后的部分
+		target = prefixSuccessor(target)
+		if target == nil {
+			it.Rewind()
+		} else {
+			it.Seek(target)
+			// b+树索引反向 Seek 的结果是第一个大于等于 target 的 key
+			if !it.Valid() {
+				it.Rewind()
+			}
+			for ; it.Valid() && bytes.Compare(it.Key(), target) >= 0; it.Next() {
+			}
+		}
+	} else {
+		it.Seek(target)
+	}
+
+	for ; it.Valid() && bytes.HasPrefix(it.Key(), prefix); it.Next() {
+		value, err := it.Value()
+		if err != nil {
+			return err
+		}
+		// b+树索引返回的 key 只在迭代器关闭之前有效
+		suffix := append([]byte(nil), it.Key()[len(prefix):]...)
+		if !fn(suffix, value) {
+			break
+		}
+	}
+	return nil
+}
+
+// 大于所有以 prefix 开头的 key 的最小值，prefix 全部是 0xff 时返回 nil
+func prefixSuccessor(prefix []byte) []byte {
+	for i := len(prefix) - 1; i >= 0; i-- {
+		if prefix[i] != 0xff {
+			succ := append([]byte(nil), prefix[:i+1]...)
+			succ[i]++
+			return succ
+		}
+	}
+	return nil
+}
+
+// 需要写入 n 条数据的批量写，超过默认的数量限制时放宽限制，保证一次提交
+func (rds *RedisDataStructure) newWriteBatch(n int) kvBatch {
+	opts := bitcask_go.DefaultWriteBatchOptions
+	if n > opts.MaxBatchNum {
+		opts.MaxBatchNum = n
+	}
+	return rds.kv.NewWriteBatch(opts)
+}
+
+// 读取 key 的值用于条件提交，key 不存在时返回 nil，存在时即使值为空也返回非 nil
+// 提交时通过 Expect 检查读取之后没有被并发修改
+func (rds *RedisDataStructure) getForUpdate(key []byte) ([]byte, error) {
+	value, err := rds.kv.Get(key)
+	if err == bitcask_go.ErrKeyNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if value == nil {
+		value = []byte{}
+	}
+	return value, nil
+}
+
+// 读取数据部分时 key 不存在，如果元数据已经不是 metaBuf，说明数据部分被并发修改，返回 ErrBatchConditionFailed 重新执行
+func (rds *RedisDataStructure) checkElementConflict(key, metaBuf []byte, err error) error {
+	if err != bitcask_go.ErrKeyNotFound {
+		return err
+	}
+	current, getErr := rds.getForUpdate(key)
+	if getErr != nil {
+		return getErr
+	}
+	if (current == nil) != (metaBuf == nil) || !bytes.Equal(current, metaBuf) {
+		return bitcask_go.ErrBatchConditionFailed
+	}
+	return err
+}
+
+// 执行读取之后条件提交的写入，提交时读取过的值已经被并发修改则重新执行
+func retryOnConflict(fn func() error) error {
+	for {
+		if err := fn(); err != bitcask_go.ErrBatchConditionFailed {
+			return err
+		}
+	}
+}
@@ -0,0 +1,580 @@
+package redis
+
+import (
+	bitcask_go "bitcask-go"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math/rand"
+	"sort"
+)
+
+var (
+	ErrKeyspaceUpgradeRequired = errors.New("the redis keyspace uses an old encoding, open it in read write mode once to upgrade")
+	ErrSameObject              = errors.New("ERR source and destination objects are the same")
+)
+
+var (
+	// 内部使用的 key 的前缀，用户的 key 不能以这个前缀开头
+	internalKeyPrefix = []byte("\x00bitcask-redis\x00")
+	// 复合类型数据部分的 key 的前缀
+	elementKeyPrefix = append(append([]byte(nil), internalKeyPrefix...), "element\x00"...)
+	// 版本 1 中数据部分的 key 的前缀，后面直接是用户的 key，用户的 key 不能以这个前缀开头
+	separatedElementKeyPrefix = []byte{0x00, 0xff, 'e'}
+	// 记录 key 编码方式的版本
+	keyspaceVersionKey = append(append([]byte(nil), internalKeyPrefix...), "version"...)
+)
+
+// key 编码方式的版本
+const (
+	// 数据部分的 key 直接以用户的 key 开头，和用户的 key 混在一起
+	keyspaceVersionLegacy byte = iota
+	// 数据部分的 key 以 separatedElementKeyPrefix 开头，后面的 key 没有长度，可能是另一个 key 的数据部分的前缀
+	keyspaceVersionSeparated
+	// 数据部分的 key 以 elementKeyPrefix 开头，后面是 key 的长度和 key
+	keyspaceVersionLengthPrefixed
+)
+
+// AnyType 遍历 key 时不过滤数据类型
+const AnyType RedisDataType = 0xff
+
+// 是否是内部使用的 key
+func isInternalKey(key []byte) bool {
+	return bytes.HasPrefix(key, internalKeyPrefix)
+}
+
+// 是否是复合类型数据部分的 key
+func isElementKey(key []byte) bool {
+	return bytes.HasPrefix(key, elementKeyPrefix) || bytes.HasPrefix(key, separatedElementKeyPrefix)
+}
+
+// Exists 返回存在的 key 的数量，重复的 key 会重复计算
+func (rds *RedisDataStructure) Exists(keys ...[]byte) (int, error) {
+	encValues, errs := rds.kv.MultiGet(keys)
+
+	var n int
+	for i, encValue := range encValues {
+		if errs[i] != nil {
+			if errs[i] == bitcask_go.ErrKeyNotFound || errs[i] == bitcask_go.ErrKeyIsEmpty {
+				continue
+			}
+			return 0, errs[i]
+		}
+		if isAliveValue(encValue) {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// DelKeys 删除多个 key，返回实际删除的 key 数量
+func (rds *RedisDataStructure) DelKeys(keys ...[]byte) (int, error) {
+	var n int
+	for _, key := range keys {
+		ok, err := rds.delKey(key)
+		if err != nil {
+			return n, err
+		}
+		if ok {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// 删除 key，key 已经过期时也会删除，返回删除之前 key 是否有效
+func (rds *RedisDataStructure) delKey(key []byte) (bool, error) {
+	for {
+		encValue, err := rds.kv.Get(key)
+		if err == bitcask_go.ErrKeyNotFound {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		// 只删除元数据，数据部分交给后台回收
+		if err = rds.markStaleValue(key, encValue); err != nil {
+			return false, err
+		}
+
+		// 条件删除，key 在读取之后被并发修改则重新判断
+		ok, err := rds.kv.DeleteIfValue(key, encValue)
+		if err != nil {
+			return false, err
+		}
+		if ok {
+			return isAliveValue(encValue), nil
+		}
+	}
+}
+
+// Rename 将 source 改名为 destination，destination 原来的值会被覆盖
+// source 不存在时返回 ErrNoSuchKey
+func (rds *RedisDataStructure) Rename(source, destination []byte) error {
+	_, err := rds.renameInner(source, destination, false)
+	return err
+}
+
+// RenameNX 只有在 destination 不存在时才改名，返回是否改名
+func (rds *RedisDataStructure) RenameNX(source, destination []byte) (bool, error) {
+	return rds.renameInner(source, destination, true)
+}
+
+func (rds *RedisDataStructure) renameInner(source, destination []byte, nx bool) (bool, error) {
+	for {
+		encValue, err := rds.kv.Get(source)
+		if err != nil && err != bitcask_go.ErrKeyNotFound {
+			return false, err
+		}
+		if err == bitcask_go.ErrKeyNotFound || !isAliveValue(encValue) {
+			return false, ErrNoSuchKey
+		}
+
+		oldValue, err := rds.kv.Get(destination)
+		if err != nil && err != bitcask_go.ErrKeyNotFound {
+			return false, err
+		}
+		if err == bitcask_go.ErrKeyNotFound {
+			oldValue = nil
+		}
+		if nx && oldValue != nil && isAliveValue(oldValue) {
+			return false, nil
+		}
+		if bytes.Equal(source, destination) {
+			return true, nil
+		}
+
+		// 数据部分的 key 中包含用户的 key，需要和元数据一起移动到 destination 下面
+		elements, values, version, expire, err := rds.loadElements(source, encValue)
+		if err != nil {
+			return false, err
+		}
+
+		if oldValue != nil {
+			if err = rds.markStaleValue(destination, oldValue); err != nil {
+				return false, err
+			}
+		}
+		if err = rds.recordExpire(destination, version, expire); err != nil {
+			return false, err
+		}
+
+		wb := rds.newWriteBatch(len(elements)*2 + 2)
+		// 检查和写入在同一次提交中完成，key 在读取之后被并发修改则重新判断
+		_ = wb.Expect(source, encValue)
+		_ = wb.Expect(destination, oldValue)
+		srcPrefix := encodeElementPrefix(source, version, 0)
+		dstPrefix := encodeElementPrefix(destination, version, 0)
+		for i, suffix := range elements {
+			_ = wb.Delete(append(append([]byte(nil), srcPrefix...), suffix...))
+			_ = wb.Put(append(append([]byte(nil), dstPrefix...), suffix...), values[i])
+		}
+		_ = wb.Delete(source)
+		_ = wb.Put(destination, encValue)
+
+		err = wb.Commit()
+		if err == bitcask_go.ErrBatchConditionFailed {
+			continue
+		}
+		if err != nil {
+			return false, err
+		}
+		return true, nil
+	}
+}
+
+// Move 将 key 移动到另一个数据库 target 中，key 不存在或者 target 中已经存在时返回 false
+// 先写入 target 再删除原来的 key，两个数据库之间的移动需要调用方保证没有并发修改
+func (rds *RedisDataStructure) Move(key []byte, target *RedisDataStructure) (bool, error) {
+	if rds == target {
+		return false, ErrSameObject
+	}
+
+	for {
+		encValue, err := rds.kv.Get(key)
+		if err != nil && err != bitcask_go.ErrKeyNotFound {
+			return false, err
+		}
+		if err == bitcask_go.ErrKeyNotFound || !isAliveValue(encValue) {
+			return false, nil
+		}
+
+		oldValue, err := target.kv.Get(key)
+		if err != nil && err != bitcask_go.ErrKeyNotFound {
+			return false, err
+		}
+		if err == bitcask_go.ErrKeyNotFound {
+			oldValue = nil
+		}
+		if oldValue != nil && isAliveValue(oldValue) {
+			return false, nil
+		}
+
+		elements, values, version, expire, err := rds.loadElements(key, encValue)
+		if err != nil {
+			return false, err
+		}
+		if oldValue != nil {
+			if err = target.markStaleValue(key, oldValue); err != nil {
+				return false, err
+			}
+		}
+		if err = target.recordExpire(key, version, expire); err != nil {
+			return false, err
+		}
+
+		wb := target.newWriteBatch(len(elements) + 1)
+		_ = wb.Expect(key, oldValue)
+		prefix := encodeElementPrefix(key, version, 0)
+		for i, suffix := range elements {
+			_ = wb.Put(append(append([]byte(nil), prefix...), suffix...), values[i])
+		}
+		_ = wb.Put(key, encValue)
+		err = wb.Commit()
+		if err == bitcask_go.ErrBatchConditionFailed {
+			continue
+		}
+		if err != nil {
+			return false, err
+		}
+
+		// 原来的数据部分交给后台回收
+		if err = rds.markStaleValue(key, encValue); err != nil {
+			return false, err
+		}
+		if _, err = rds.kv.DeleteIfValue(key, encValue); err != nil {
+			return false, err
+		}
+		return true, nil
+	}
+}
+
+// 读取复合类型的数据部分，返回去掉前缀之后的 key、对应的值，以及元数据中的版本和过期时间
+func (rds *RedisDataStructure) loadElements(key, encValue []byte) ([][]byte, [][]byte, int64, int64, error) {
+	if encValue[0] == String {
+		_, expire := decodeStringValue(encValue)
+		return nil, nil, 0, expire, nil
+	}
+
+	var elements, values [][]byte
+	meta := decodeMetadata(encValue)
+	err := rds.iteratePrefix(encodeElementPrefix(key, meta.version, 0), func(suffix, value []byte) bool {
+		elements = append(elements, suffix)
+		values = append(values, value)
+		return true
+	})
+	return elements, values, meta.version, meta.expire, err
+}
+
+// KeyspaceStats 返回 key 的数量和其中设置了过期时间的 key 的数量，已经过期但是还没有被删除的 key 也计算在内
+func (rds *RedisDataStructure) KeyspaceStats() (int, int, error) {
+	keys, expires, _, err := rds.keyspaceCounts()
+	return int(keys), int(expires), err
+}
+
+// Scan 从 cursor 开始遍历 key，返回下一次遍历的 cursor 和匹配的 key，遍历完成时 cursor 为 0
+// cursor 是已经遍历过的 key 数量，dataType 为 AnyType 时不过滤数据类型
+func (rds *RedisDataStructure) Scan(cursor uint64, pattern []byte, count int, dataType RedisDataType) (uint64, [][]byte, error) {
+	if count <= 0 {
+		count = defaultScanCount
+	}
+
+	res := [][]byte{}
+	var index, next uint64
+	err := rds.iterateKeys(func(key, encValue []byte) bool {
+		if index < cursor {
+			index++
+			return true
+		}
+		if index >= cursor+uint64(count) {
+			next = index
+			return false
+		}
+		index++
+		if matchKey(key, encValue, pattern, dataType) {
+			res = append(res, key)
+		}
+		return true
+	})
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return next, res, nil
+}
+
+// Keys 返回所有匹配 pattern 的 key
+func (rds *RedisDataStructure) Keys(pattern []byte) ([][]byte, error) {
+	res := [][]byte{}
+	err := rds.iterateKeys(func(key, encValue []byte) bool {
+		if matchKey(key, encValue, pattern, AnyType) {
+			res = append(res, key)
+		}
+		return true
+	})
+	return res, err
+}
+
+// DBSize 返回有效的 key 的数量
+func (rds *RedisDataStructure) DBSize() (int, error) {
+	var n int
+	err := rds.iterateKeys(func(key, encValue []byte) bool {
+		if isAliveValue(encValue) {
+			n++
+		}
+		return true
+	})
+	return n, err
+}
+
+// RandomKey 随机返回一个有效的 key，没有 key 时返回 nil
+func (rds *RedisDataStructure) RandomKey() ([]byte, error) {
+	var keys [][]byte
+	err := rds.iterateKeys(func(key, encValue []byte) bool {
+		if isAliveValue(encValue) {
+			keys = append(keys, key)
+		}
+		return true
+	})
+	if err != nil || len(keys) == 0 {
+		return nil, err
+	}
+	return keys[rand.Intn(len(keys))], nil
+}
+
+// FlushDB 删除所有的 key
+func (rds *RedisDataStructure) FlushDB() error {
+	for {
+		// 先收集再删除，b+树索引在迭代期间不能写入
+		var keys [][]byte
+		err := rds.iterateRange(nil, nil, false, func(key, value []byte) bool {
+			if !bytes.Equal(key, keyspaceVersionKey) {
+				keys = append(keys, key)
+			}
+			return len(keys) < reclaimBatchSize
+		})
+		if err != nil {
+			return err
+		}
+		if len(keys) == 0 {
+			return nil
+		}
+
+		wb := rds.newWriteBatch(len(keys))
+		for _, key := range keys {
+			_ = wb.Delete(key)
+		}
+		if err = wb.Commit(); err != nil {
+			return err
+		}
+	}
+}
+
+// 判断 key 是否有效并且匹配 pattern 和数据类型
+func matchKey(key, encValue, pattern []byte, dataType RedisDataType) bool {
+	if !isAliveValue(encValue) {
+		return false
+	}
+	if dataType != AnyType && encValue[0] != dataType {
+		return false
+	}
+	return pattern == nil || stringMatch(pattern, key)
+}
+
+// 按顺序遍历用户的 key，也就是元数据和 String 类型的 key，跳过数据部分和内部使用的 key
+// 已经过期的 key 也会遍历到，由调用方判断
+func (rds *RedisDataStructure) iterateKeys(fn func(key, encValue []byte) bool) error {
+	it := rds.kv.NewIterator(bitcask_go.IteratorOptions{})
+	defer it.Close()
+
+	for it.Rewind(); it.Valid(); {
+		key := it.Key()
+		// 保留的前缀下面的 key 是连续的，直接跳过整个范围
+		if bytes.HasPrefix(key, separatedElementKeyPrefix) {
+			it.Seek(prefixSuccessor(separatedElementKeyPrefix))
+			continue
+		}
+		if isInternalKey(key) {
+			it.Seek(prefixSuccessor(internalKeyPrefix))
+			continue
+		}
+
+		value, err := it.Value()
+		if err != nil {
+			return err
+		}
+		// b+树索引返回的 key 只在迭代器关闭之前有效
+		if !fn(append([]byte(nil), key...), value) {
+			break
+		}
+		it.Next()
+	}
+	return nil
+}
+
+// 检查 key 的编码方式，旧的编码方式写入的数据在打开时升级
+func (rds *RedisDataStructure) checkKeyspaceVersion() error {
+	value, err := rds.kv.Get(keyspaceVersionKey)
+	if err == nil && len(value) > 0 && value[0] >= keyspaceVersionLengthPrefixed {
+		return nil
+	}
+	if err != nil && err != bitcask_go.ErrKeyNotFound {
+		return err
+	}
+	from := keyspaceVersionLegacy
+	if err == nil && len(value) > 0 {
+		from = value[0]
+	}
+
+	if rds.db.Stat().KeyNum > 0 {
+		if rds.readOnly {
+			return ErrKeyspaceUpgradeRequired
+		}
+		if err = rds.upgradeKeyspace(from); err != nil {
+			return err
+		}
+	}
+	if rds.readOnly {
+		return nil
+	}
+	return rds.kv.Put(keyspaceVersionKey, []byte{keyspaceVersionLengthPrefixed})
+}
+
+// 将旧的编码方式写入的数据部分移动到 elementKeyPrefix 下面
+// 旧的编码方式中 key 没有长度，长的 key 的数据部分可能以短的 key 的前缀开头，先移动长的 key
+// 新的数据部分在单独的前缀下面，中途失败时已经移动的数据不受影响，下一次打开时继续升级
+func (rds *RedisDataStructure) upgradeKeyspace(from byte) error {
+	type version struct {
+		key     []byte
+		version int64
+	}
+	var versions []version
+	err := rds.iterateKeys(func(key, encValue []byte) bool {
+		if v, ok := decodeLegacyMetadataVersion(encValue); ok {
+			versions = append(versions, version{key: key, version: v})
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	var namespace []byte
+	if from == keyspaceVersionSeparated {
+		namespace = separatedElementKeyPrefix
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		return len(versions[i].key) > len(versions[j].key)
+	})
+	for _, v := range versions {
+		if err = rds.moveLegacyElements(namespace, v.key, v.version, false); err != nil {
+			return err
+		}
+	}
+
+	// 版本 1 的数据部分在单独的前缀下面，有效的数据移动之后剩下的都是等待回收的数据
+	if from == keyspaceVersionSeparated {
+		return rds.deletePrefix(separatedElementKeyPrefix)
+	}
+
+	// 等待回收的版本不需要移动，直接删除
+	var stale []version
+	err = rds.iteratePrefix(staleVersionPrefix, func(suffix, value []byte) bool {
+		if len(suffix) >= 8 {
+			stale = append(stale, version{key: suffix[8:], version: int64(binary.LittleEndian.Uint64(suffix[:8]))})
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	for _, v := range stale {
+		if err = rds.moveLegacyElements(nil, v.key, v.version, true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 分批移动旧的编码方式下 namespace + key + version 开头的数据部分，onlyDelete 为 true 时直接删除
+func (rds *RedisDataStructure) moveLegacyElements(namespace, key []byte, version int64, onlyDelete bool) error {
+	prefix := make([]byte, len(namespace)+len(key)+8)
+	copy(prefix, namespace)
+	copy(prefix[len(namespace):], key)
+	binary.LittleEndian.PutUint64(prefix[len(namespace)+len(key):], uint64(version))
+	newPrefix := encodeElementPrefix(key, version, 0)
+
+	for {
+		var keys, values [][]byte
+		err := rds.iteratePrefix(prefix, func(suffix, value []byte) bool {
+			keys = append(keys, append(append([]byte(nil), prefix...), suffix...))
+			values = append(values, value)
+			return len(keys) < reclaimBatchSize
+		})
+		if err != nil {
+			return err
+		}
+		if len(keys) == 0 {
+			return nil
+		}
+
+		wb := rds.newWriteBatch(len(keys) * 2)
+		for i, k := range keys {
+			_ = wb.Delete(k)
+			if !onlyDelete {
+				_ = wb.Put(append(append([]byte(nil), newPrefix...), k[len(prefix):]...), values[i])
+			}
+		}
+		if err = wb.Commit(); err != nil {
+			return err
+		}
+	}
+}
+
+// 分批删除以 prefix 开头的所有 key
+func (rds *RedisDataStructure) deletePrefix(prefix []byte) error {
+	for {
+		// 先收集再删除，b+树索引在迭代期间不能写入
+		var keys [][]byte
+		err := rds.iteratePrefix(prefix, func(suffix, value []byte) bool {
+			keys = append(keys, append(append([]byte(nil), prefix...), suffix...))
+			return len(keys) < reclaimBatchSize
+		})
+		if err != nil {
+			return err
+		}
+		if len(keys) == 0 {
+			return nil
+		}
+
+		wb := rds.newWriteBatch(len(keys))
+		for _, k := range keys {
+			_ = wb.Delete(k)
+		}
+		if err = wb.Commit(); err != nil {
+			return err
+		}
+	}
+}
+
+// 旧的编码方式下无法区分元数据和数据部分，能够按照元数据解码的值视为元数据，返回其中的版本
+func decodeLegacyMetadataVersion(encValue []byte) (int64, bool) {
+	if len(encValue) == 0 || encValue[0] < Hash || encValue[0] > ZSet {
+		return 0, false
+	}
+
+	var index = 1
+	// expire + version + size
+	var version int64
+	for i := 0; i < 3; i++ {
+		v, n := binary.Varint(encValue[index:])
+		if n <= 0 {
+			return 0, false
+		}
+		if i == 1 {
+			version = v
+		}
+		index += n
+	}
+	return version, true
+}
@@ -9,10 +9,25 @@ import (
 const (
 	maxMetadataSize   = 1 + binary.MaxVarintLen64*2 + binary.MaxVarintLen32
 	extraListMetaSize = binary.MaxVarintLen64 * 2
+	extraZSetMetaSize = 1
 
 	initialListMark = math.MaxUint64 / 2
 )
 
+// ZSet 数据部分的编码方式，旧版本写入的元数据中没有这个字段
+const (
+	// 分数使用文本编码，member 和 score 两种 key 直接跟在 version 后面
+	zsetEncodingLegacy byte = iota
+	// 分数使用按字节排序的二进制编码，两种 key 使用不同的标记区分
+	zsetEncodingSortable
+)
+
+// ZSet 数据部分 key 中 version 之后的标记
+const (
+	zsetMemberMark byte = 'm'
+	zsetScoreMark  byte = 's'
+)
+
 // 元数据
 type metadata struct {
 	dataType byte   // 数据类型
@@ -21,6 +36,7 @@ type metadata struct {
 	size     uint32 // 数据量
 	head     uint64 // List 数据结构专用
 	tail     uint64 // List 数据结构专用
+	encoding byte   // ZSet 数据结构专用
 }
 
 func (md *metadata) encode() []byte {
@@ -28,6 +44,9 @@ func (md *metadata) encode() []byte {
 	if md.dataType == List {
 		size += extraListMetaSize
 	}
+	if md.dataType == ZSet {
+		size += extraZSetMetaSize
+	}
 	buf := make([]byte, size)
 
 	buf[0] = md.dataType
@@ -41,6 +60,11 @@ func (md *metadata) encode() []byte {
 		index += binary.PutUvarint(buf[index:], md.tail)
 	}
 
+	if md.dataType == ZSet {
+		buf[index] = md.encoding
+		index++
+	}
+
 	return buf[:index]
 }
 
@@ -66,6 +90,11 @@ func decodeMetadata(buf []byte) *metadata {
 		tail, _ = binary.Uvarint(buf[index:])
 	}
 
+	var encoding = zsetEncodingLegacy
+	if dataType == ZSet && index < len(buf) {
+		encoding = buf[index]
+	}
+
 	return &metadata{
 		dataType: dataType,
 		expire:   expire,
@@ -73,9 +102,26 @@ func decodeMetadata(buf []byte) *metadata {
 		size:     uint32(size),
 		head:     head,
 		tail:     tail,
+		encoding: encoding,
 	}
 }
 
+// 数据部分的 key 统一以 elementKeyPrefix 开头，和元数据以及 String 类型的 key 分开
+// elementKeyPrefix + key size + key + version + ...，带上 key 的长度，一个 key 的数据部分不会以另一个 key 的前缀开头
+func encodeElementPrefix(key []byte, version int64, extra int) []byte {
+	buf := make([]byte, 0, len(elementKeyPrefix)+binary.MaxVarintLen32+len(key)+8+extra)
+
+	// prefix
+	buf = append(buf, elementKeyPrefix...)
+
+	// key size + key
+	buf = binary.AppendUvarint(buf, uint64(len(key)))
+	buf = append(buf, key...)
+
+	// version
+	return binary.LittleEndian.AppendUint64(buf, uint64(version))
+}
+
 type hashInternalKey struct {
 	key     []byte
 	version int64
@@ -83,21 +129,10 @@ type hashInternalKey struct {
 }
 
 func (hk *hashInternalKey) encode() []byte {
-	buf := make([]byte, len(hk.key)+len(hk.field)+8)
-
-	//key
-	var index = 0
-	copy(buf[index:index+len(hk.key)], hk.key)
-	index += len(hk.key)
-
-	//version
-	binary.LittleEndian.PutUint64(buf[index:index+8], uint64(hk.version))
-	index += 8
+	buf := encodeElementPrefix(hk.key, hk.version, len(hk.field))
 
 	//field
-	copy(buf[index:index+len(hk.field)], hk.field)
-
-	return buf
+	return append(buf, hk.field...)
 }
 
 type setInternalKey struct {
@@ -107,25 +142,13 @@ type setInternalKey struct {
 }
 
 func (sk *setInternalKey) encode() []byte {
-	buf := make([]byte, len(sk.key)+len(sk.member)+8+4)
-
-	//key
-	var index = 0
-	copy(buf[index:index+len(sk.key)], sk.key)
-	index += len(sk.key)
-
-	//version
-	binary.LittleEndian.PutUint64(buf[index:index+8], uint64(sk.version))
-	index += 8
+	buf := encodeElementPrefix(sk.key, sk.version, len(sk.member)+4)
 
 	//member
-	copy(buf[index:index+len(sk.member)], sk.member)
-	index += len(sk.member)
+	buf = append(buf, sk.member...)
 
 	//member size
-	binary.LittleEndian.PutUint32(buf[index:], uint32(len(sk.member)))
-
-	return buf
+	return binary.LittleEndian.AppendUint32(buf, uint32(len(sk.member)))
 }
 
 type listInternalKey struct {
@@ -135,21 +158,10 @@ type listInternalKey struct {
 }
 
 func (lk *listInternalKey) encode() []byte {
-	buf := make([]byte, len(lk.key)+8+8)
-
-	// key
-	var index = 0
-	copy(buf[index:index+len(lk.key)], lk.key)
-	index += len(lk.key)
-
-	// version
-	binary.LittleEndian.PutUint64(buf[index:index+8], uint64(lk.version))
-	index += 8
+	buf := encodeElementPrefix(lk.key, lk.version, 8)
 
 	// index
-	binary.LittleEndian.PutUint64(buf[index:], lk.index)
-
-	return buf
+	return binary.LittleEndian.AppendUint64(buf, lk.index)
 }
 
 type ZSetInternalKey struct {
@@ -159,47 +171,52 @@ type ZSetInternalKey struct {
 	score   float64
 }
 
+// prefix + key + version + 's' + score + member + member size，同一个 ZSet 的成员按照分数排序
 func (zk *ZSetInternalKey) encodeWithScore() []byte {
-	scoreBuf := utils.Float64ToBytes(zk.score)
-	buf := make([]byte, len(zk.key)+len(zk.member)+len(scoreBuf)+8+4)
+	scoreBuf := utils.Float64ToSortableBytes(zk.score)
+	buf := encodeElementPrefix(zk.key, zk.version, 1+len(scoreBuf)+len(zk.member)+4)
 
-	// key
-	var index = 0
-	copy(buf[index:index+len(zk.key)], zk.key)
-	index += len(zk.key)
-
-	// version
-	binary.LittleEndian.PutUint64(buf[index:index+8], uint64(zk.version))
-	index += 8
+	// mark
+	buf = append(buf, zsetScoreMark)
 
 	// score
-	copy(buf[index:index+len(scoreBuf)], scoreBuf)
-	index += len(scoreBuf)
+	buf = append(buf, scoreBuf...)
 
 	//member
-	copy(buf[index:index+len(zk.member)], zk.member)
-	index += len(zk.member)
+	buf = append(buf, zk.member...)
 
 	//member size
-	binary.LittleEndian.PutUint32(buf[index:], uint32(len(zk.member)))
-
-	return buf
+	return binary.LittleEndian.AppendUint32(buf, uint32(len(zk.member)))
 }
 
+// prefix + key + version + 'm' + member
 func (zk *ZSetInternalKey) encodeWithMember() []byte {
-	buf := make([]byte, len(zk.key)+len(zk.member)+8)
+	buf := encodeElementPrefix(zk.key, zk.version, 1+len(zk.member))
 
-	// key
-	var index = 0
-	copy(buf[index:index+len(zk.key)], zk.key)
-	index += len(zk.key)
-
-	// version
-	binary.LittleEndian.PutUint64(buf[index:index+8], uint64(zk.version))
-	index += 8
+	// mark
+	buf = append(buf, zsetMemberMark)
 
 	//member
-	copy(buf[index:], zk.member)
+	return append(buf, zk.member...)
+}
 
+// 同一个 ZSet 所有数据部分 key 的前缀，mark 为 0 时不包含标记
+func (zk *ZSetInternalKey) encodePrefix(mark byte) []byte {
+	buf := encodeElementPrefix(zk.key, zk.version, 1)
+	if mark != 0 {
+		buf = append(buf, mark)
+	}
 	return buf
 }
+
+// 解码 score key 去掉前缀之后的部分，得到分数和 member
+func decodeZSetScoreSuffix(suffix []byte) (float64, []byte, bool) {
+	if len(suffix) < 8+4 {
+		return 0, nil, false
+	}
+	memberSize := binary.LittleEndian.Uint32(suffix[len(suffix)-4:])
+	if int(memberSize) != len(suffix)-8-4 {
+		return 0, nil, false
+	}
+	return utils.SortableBytesToFloat64(suffix[:8]), suffix[8 : 8+memberSize], true
+}
@@ -0,0 +1,331 @@
+package redis
+
+import (
+	bitcask_go "bitcask-go"
+	"encoding/binary"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// 等待回收的数据部分：前缀 + version + key
+	staleVersionPrefix = append(append([]byte(nil), internalKeyPrefix...), "stale\x00"...)
+	// 设置了过期时间的 key：前缀 + key，值是 version + 过期时间
+	expireIndexPrefix = append(append([]byte(nil), internalKeyPrefix...), "expire\x00"...)
+)
+
+const (
+	// 主动过期的执行间隔，和 Redis 默认的 hz 10 一致
+	activeExpireInterval = 100 * time.Millisecond
+	// 每次主动过期检查的 key 数量
+	activeExpireSamples = 20
+	// 每次主动过期最多占用的时间
+	activeExpireTimeLimit = 25 * time.Millisecond
+	// 回收数据部分的执行间隔
+	reclaimInterval = time.Second
+	// 回收数据部分时每个批次删除的数量
+	reclaimBatchSize = 1000
+)
+
+// ReclaimStats 过期和回收的统计信息
+type ReclaimStats struct {
+	ExpiredKeys       int64 // 主动过期删除的 key 数量
+	ReclaimedVersions int64 // 回收的失效版本数量
+	ReclaimedKeys     int64 // 回收的数据部分 key 数量
+	PendingVersions   int64 // 等待回收的失效版本数量
+}
+
+type reclaimer struct {
+	stop         chan struct{} // 关闭时停止后台任务
+	done         chan struct{} // 后台任务已经退出
+	expireCursor []byte        // 下一次主动过期开始检查的位置
+
+	expiredKeys       int64
+	reclaimedVersions int64
+	reclaimedKeys     int64
+
+	touchHook atomic.Value // func(key []byte)，后台任务修改 key 之前调用
+}
+
+// SetTouchHook 设置主动过期和回收过期 key 的数据部分之前调用的函数，用于通知 WATCH 这个 key 的客户端
+// 在修改之前调用，调用之后开始的事务能够在提交时发现修改
+func (rds *RedisDataStructure) SetTouchHook(fn func(key []byte)) {
+	rds.reclaimer.touchHook.Store(fn)
+}
+
+func (rds *RedisDataStructure) touch(key []byte) {
+	if fn, ok := rds.reclaimer.touchHook.Load().(func(key []byte)); ok && fn != nil {
+		fn(key)
+	}
+}
+
+// Stats 返回过期和回收的统计信息
+func (rds *RedisDataStructure) Stats() ReclaimStats {
+	_, _, pending, _ := rds.keyspaceCounts()
+	return ReclaimStats{
+		ExpiredKeys:       atomic.LoadInt64(&rds.reclaimer.expiredKeys),
+		ReclaimedVersions: atomic.LoadInt64(&rds.reclaimer.reclaimedVersions),
+		ReclaimedKeys:     atomic.LoadInt64(&rds.reclaimer.reclaimedKeys),
+		PendingVersions:   pending,
+	}
+}
+
+// 启动主动过期和回收数据部分的后台任务
+func (rds *RedisDataStructure) startReclaimer() {
+	rds.reclaimer.stop = make(chan struct{})
+	rds.reclaimer.done = make(chan struct{})
+	go func() {
+		defer close(rds.reclaimer.done)
+		expireTicker := time.NewTicker(activeExpireInterval)
+		defer expireTicker.Stop()
+		reclaimTicker := time.NewTicker(reclaimInterval)
+		defer reclaimTicker.Stop()
+		for {
+			select {
+			case <-expireTicker.C:
+				rds.activeExpire()
+			case <-reclaimTicker.C:
+				_, _ = rds.reclaimStaleVersions()
+			case <-rds.reclaimer.stop:
+				return
+			}
+		}
+	}()
+}
+
+// 停止后台任务，等待正在进行的任务完成
+func (rds *RedisDataStructure) stopReclaimer() {
+	if rds.reclaimer.stop == nil {
+		return
+	}
+	close(rds.reclaimer.stop)
+	<-rds.reclaimer.done
+	rds.reclaimer.stop = nil
+}
+
+// 记录 key 的一个版本的数据部分已经失效，等待后台回收
+// 回收之前会重新检查版本是否失效，多记录不会删除有效的数据
+func (rds *RedisDataStructure) markStaleVersion(key []byte, version int64) error {
+	// 只读模式下读取到过期的 key 时不能写入，由可写的实例记录
+	if rds.readOnly {
+		return nil
+	}
+	return rds.kv.Put(staleVersionKey(key, version), nil)
+}
+
+// 存储的值是复合类型的元数据时记录这个版本失效
+func (rds *RedisDataStructure) markStaleValue(key, encValue []byte) error {
+	if len(encValue) == 0 || encValue[0] == String {
+		return nil
+	}
+	return rds.markStaleVersion(key, decodeMetadata(encValue).version)
+}
+
+// 记录 key 的过期时间，主动过期时检查
+func (rds *RedisDataStructure) recordExpire(key []byte, version, expire int64) error {
+	if expire == 0 {
+		return nil
+	}
+	return rds.kv.Put(expireIndexKey(key), encodeExpireIndexValue(version, expire))
+}
+
+// 和 Redis 一样，一轮检查中过期的 key 超过四分之一时继续检查，直到超过时间限制
+func (rds *RedisDataStructure) activeExpire() {
+	deadline := time.Now().Add(activeExpireTimeLimit)
+	for time.Now().Before(deadline) {
+		expired, err := rds.activeExpireCycle()
+		if err != nil || expired <= activeExpireSamples/4 {
+			return
+		}
+		select {
+		case <-rds.reclaimer.stop:
+			return
+		default:
+		}
+	}
+}
+
+// 检查一部分设置了过期时间的 key，删除已经过期的 key，复合类型的数据部分交给回收任务
+// 从上一次结束的位置继续检查，到末尾之后从头开始，返回删除的 key 数量
+func (rds *RedisDataStructure) activeExpireCycle() (int, error) {
+	type entry struct {
+		key, value []byte
+	}
+	var entries []entry
+	err := rds.iterateRange(expireIndexPrefix, rds.reclaimer.expireCursor, false, func(suffix, value []byte) bool {
+		entries = append(entries, entry{key: suffix, value: value})
+		return len(entries) < activeExpireSamples
+	})
+	if err != nil {
+		return 0, err
+	}
+	if len(entries) < activeExpireSamples {
+		rds.reclaimer.expireCursor = nil
+	} else {
+		rds.reclaimer.expireCursor = append(entries[len(entries)-1].key, 0)
+	}
+
+	var expired int
+	now := time.Now().UnixNano()
+	for _, e := range entries {
+		version, expire := decodeExpireIndexValue(e.value)
+		if expire > now {
+			continue
+		}
+		ok, err := rds.expireKey(e.key, version)
+		if err != nil {
+			return expired, err
+		}
+		if ok {
+			expired++
+		}
+		// 过期时间在检查期间被重新记录时保留
+		if _, err = rds.kv.DeleteIfValue(expireIndexKey(e.key), e.value); err != nil {
+			return expired, err
+		}
+	}
+	atomic.AddInt64(&rds.reclaimer.expiredKeys, int64(expired))
+	return expired, nil
+}
+
+// 处理过期时间已经到了的 key，version 是记录过期时间时复合类型的版本，返回是否删除了 key
+func (rds *RedisDataStructure) expireKey(key []byte, version int64) (bool, error) {
+	encValue, err := rds.kv.Get(key)
+	if err != nil && err != bitcask_go.ErrKeyNotFound {
+		return false, err
+	}
+	found := err == nil && len(encValue) > 0
+
+	// 记录之后 key 被删除或者重新创建，原来的版本已经失效
+	if version != 0 && (!found || encValue[0] == String || decodeMetadata(encValue).version != version) {
+		if err = rds.markStaleVersion(key, version); err != nil {
+			return false, err
+		}
+	}
+	if !found {
+		return false, nil
+	}
+
+	expire := decodeExpire(encValue)
+	if expire == 0 {
+		return false, nil
+	}
+	// 过期时间被修改过，重新记录
+	if expire > time.Now().UnixNano() {
+		var newVersion int64
+		if encValue[0] != String {
+			newVersion = decodeMetadata(encValue).version
+		}
+		return false, rds.recordExpire(key, newVersion, expire)
+	}
+
+	rds.touch(key)
+	if err = rds.markStaleValue(key, encValue); err != nil {
+		return false, err
+	}
+	return rds.kv.DeleteIfValue(key, encValue)
+}
+
+// 回收所有失效版本的数据部分，返回回收的版本数量
+func (rds *RedisDataStructure) reclaimStaleVersions() (int, error) {
+	var markers [][]byte
+	err := rds.iteratePrefix(staleVersionPrefix, func(suffix, value []byte) bool {
+		markers = append(markers, suffix)
+		return true
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	var reclaimed int
+	for _, marker := range markers {
+		if len(marker) < 8 {
+			continue
+		}
+		select {
+		case <-rds.reclaimer.stop:
+			return reclaimed, nil
+		default:
+		}
+
+		version := int64(binary.LittleEndian.Uint64(marker[:8]))
+		key := marker[8:]
+		if err = rds.reclaimVersion(key, version); err != nil {
+			return reclaimed, err
+		}
+		if err = rds.kv.Delete(staleVersionKey(key, version)); err != nil {
+			return reclaimed, err
+		}
+		reclaimed++
+		atomic.AddInt64(&rds.reclaimer.reclaimedVersions, 1)
+	}
+	return reclaimed, nil
+}
+
+// 分批删除一个版本的数据部分，版本仍然有效时不做任何修改
+func (rds *RedisDataStructure) reclaimVersion(key []byte, version int64) error {
+	encValue, err := rds.kv.Get(key)
+	if err != nil && err != bitcask_go.ErrKeyNotFound {
+		return err
+	}
+	if err == nil && len(encValue) > 0 && encValue[0] != String && decodeMetadata(encValue).version == version {
+		if isAliveValue(encValue) {
+			return nil
+		}
+		// 过期之后还没有被删除的 key 的数据部分被回收
+		rds.touch(key)
+	}
+
+	// 数据部分的 key 都以 prefix + key size + key + version 开头，不会匹配到其他 key 的数据部分
+	prefix := encodeElementPrefix(key, version, 0)
+	for {
+		// 先收集再删除，b+树索引在迭代期间不能写入
+		var keys [][]byte
+		err = rds.iteratePrefix(prefix, func(suffix, value []byte) bool {
+			keys = append(keys, append(append([]byte(nil), prefix...), suffix...))
+			return len(keys) < reclaimBatchSize
+		})
+		if err != nil {
+			return err
+		}
+		if len(keys) == 0 {
+			return nil
+		}
+
+		wb := rds.newWriteBatch(len(keys))
+		for _, k := range keys {
+			_ = wb.Delete(k)
+		}
+		if err = wb.Commit(); err != nil {
+			return err
+		}
+		atomic.AddInt64(&rds.reclaimer.reclaimedKeys, int64(len(keys)))
+	}
+}
+
+func staleVersionKey(key []byte, version int64) []byte {
+	buf := make([]byte, len(staleVersionPrefix)+8+len(key))
+	copy(buf, staleVersionPrefix)
+	binary.LittleEndian.PutUint64(buf[len(staleVersionPrefix):], uint64(version))
+	copy(buf[len(staleVersionPrefix)+8:], key)
+	return buf
+}
+
+func expireIndexKey(key []byte) []byte {
+	return append(append([]byte(nil), expireIndexPrefix...), key...)
+}
+
+// version + expire，String 类型的 version 为 0
+func encodeExpireIndexValue(version, expire int64) []byte {
+	buf := make([]byte, binary.MaxVarintLen64*2)
+	var index = 0
+	index += binary.PutVarint(buf[index:], version)
+	index += binary.PutVarint(buf[index:], expire)
+	return buf[:index]
+}
+
+func decodeExpireIndexValue(buf []byte) (int64, int64) {
+	version, n := binary.Varint(buf)
+	expire, _ := binary.Varint(buf[n:])
+	return version, expire
+}
@@ -0,0 +1,400 @@
+package redis
+
+import (
+	bitcask_go "bitcask-go"
+	"bytes"
+	"errors"
+	"sort"
+)
+
+var ErrTxnFinished = errors.New("the transaction has already been committed")
+
+// 数据结构服务使用的存储接口，直接访问存储引擎或者在事务中暂存写入的数据
+type kvStore interface {
+	Get(key []byte) ([]byte, error)
+	MultiGet(keys [][]byte) ([][]byte, []error)
+	Put(key []byte, value []byte) error
+	Delete(key []byte) error
+	CompareAndSwap(key []byte, expected []byte, value []byte) (bool, error)
+	PutIfAbsent(key []byte, value []byte) (bool, error)
+	DeleteIfValue(key []byte, expected []byte) (bool, error)
+	NewIterator(opts bitcask_go.IteratorOptions) kvIterator
+	NewWriteBatch(opts bitcask_go.WriteBatchOptions) kvBatch
+}
+
+type kvIterator interface {
+	Rewind()
+	Seek(key []byte)
+	Next()
+	Valid() bool
+	Key() []byte
+	Value() ([]byte, error)
+	Close()
+}
+
+type kvBatch interface {
+	Put(key []byte, value []byte) error
+	Delete(key []byte) error
+	Expect(key []byte, value []byte) error
+	Commit() error
+}
+
+// 直接访问存储引擎，写入时更新 key 的计数
+type dbStore struct {
+	*bitcask_go.DB
+	counters *keyspaceCounters
+}
+
+func (s dbStore) NewIterator(opts bitcask_go.IteratorOptions) kvIterator {
+	return s.DB.NewIterator(opts)
+}
+
+func (s dbStore) NewWriteBatch(opts bitcask_go.WriteBatchOptions) kvBatch {
+	return &countingBatch{
+		WriteBatch: s.DB.NewWriteBatch(opts),
+		store:      s,
+		writes:     make(map[string]*txnWrite),
+	}
+}
+
+// Txn 事务，所有的写入暂存在内存中，提交时作为一个 WriteBatch 写入存储引擎
+// 事务中的读取能够看到之前暂存的写入，事务不是并发安全的
+type Txn struct {
+	*RedisDataStructure
+	store *txnStore
+}
+
+// Begin 开始一个事务，通过返回的 Txn 调用的命令在 Commit 之前不会写入存储引擎
+// 事务中读取过的 key 在提交之前被修改时提交失败，返回 ErrBatchConditionFailed，遍历读取到的 key 不做检查
+func (rds *RedisDataStructure) Begin() *Txn {
+	store := &txnStore{
+		db:     rds.db,
+		base:   rds.kv,
+		writes: make(map[string]*txnWrite),
+		reads:  make(map[string][]byte),
+	}
+	txn := *rds
+	txn.kv = store
+	return &Txn{RedisDataStructure: &txn, store: store}
+}
+
+// Commit 提交事务中暂存的所有写入，同时检查读取过的 key 没有被修改
+func (txn *Txn) Commit() error {
+	if txn.store.writes == nil {
+		return ErrTxnFinished
+	}
+	writes, reads := txn.store.writes, txn.store.reads
+	txn.store.writes, txn.store.reads = nil, nil
+	// 只读模式下没有并发的修改
+	if len(writes) == 0 && (len(reads) == 0 || txn.readOnly) {
+		return nil
+	}
+
+	opts := bitcask_go.DefaultWriteBatchOptions
+	if len(writes) > opts.MaxBatchNum {
+		opts.MaxBatchNum = len(writes)
+	}
+	wb := txn.store.base.NewWriteBatch(opts)
+	for key, value := range reads {
+		_ = wb.Expect([]byte(key), value)
+	}
+	for key, w := range writes {
+		if w.deleted {
+			_ = wb.Delete([]byte(key))
+		} else {
+			_ = wb.Put([]byte(key), w.value)
+		}
+	}
+	return wb.Commit()
+}
+
+type txnWrite struct {
+	value   []byte
+	deleted bool
+}
+
+// 事务中暂存写入的存储，读取时先查找暂存的数据
+type txnStore struct {
+	db     *bitcask_go.DB
+	base   kvStore // 提交时通过它写入，保证计数正确
+	writes map[string]*txnWrite
+	reads  map[string][]byte // 第一次从存储引擎读取到的值，nil 表示 key 不存在
+}
+
+func (s *txnStore) Get(key []byte) ([]byte, error) {
+	if len(key) == 0 {
+		return nil, bitcask_go.ErrKeyIsEmpty
+	}
+	if w, ok := s.writes[string(key)]; ok {
+		if w.deleted {
+			return nil, bitcask_go.ErrKeyNotFound
+		}
+		return w.value, nil
+	}
+	value, err := s.db.Get(key)
+	if _, ok := s.reads[string(key)]; !ok && s.reads != nil {
+		if err == nil {
+			s.reads[string(key)] = append([]byte{}, value...)
+		} else if err == bitcask_go.ErrKeyNotFound {
+			s.reads[string(key)] = nil
+		}
+	}
+	return value, err
+}
+
+func (s *txnStore) MultiGet(keys [][]byte) ([][]byte, []error) {
+	values := make([][]byte, len(keys))
+	errs := make([]error, len(keys))
+	for i, key := range keys {
+		values[i], errs[i] = s.Get(key)
+	}
+	return values, errs
+}
+
+func (s *txnStore) Put(key []byte, value []byte) error {
+	if s.writes == nil {
+		return ErrTxnFinished
+	}
+	if len(key) == 0 {
+		return bitcask_go.ErrKeyIsEmpty
+	}
+	s.writes[string(key)] = &txnWrite{value: append([]byte{}, value...)}
+	return nil
+}
+
+func (s *txnStore) Delete(key []byte) error {
+	if s.writes == nil {
+		return ErrTxnFinished
+	}
+	if len(key) == 0 {
+		return bitcask_go.ErrKeyIsEmpty
+	}
+	s.writes[string(key)] = &txnWrite{deleted: true}
+	return nil
+}
+
+func (s *txnStore) CompareAndSwap(key []byte, expected []byte, value []byte) (bool, error) {
+	oldValue, err := s.Get(key)
+	if err == bitcask_go.ErrKeyNotFound {
+		return false, nil
+	}
+	if err != nil || !bytes.Equal(oldValue, expected) {
+		return false, err
+	}
+	return true, s.Put(key, value)
+}
+
+func (s *txnStore) PutIfAbsent(key []byte, value []byte) (bool, error) {
+	_, err := s.Get(key)
+	if err != bitcask_go.ErrKeyNotFound {
+		return false, err
+	}
+	return true, s.Put(key, value)
+}
+
+func (s *txnStore) DeleteIfValue(key []byte, expected []byte) (bool, error) {
+	oldValue, err := s.Get(key)
+	if err == bitcask_go.ErrKeyNotFound {
+		return false, nil
+	}
+	if err != nil || !bytes.Equal(oldValue, expected) {
+		return false, err
+	}
+	return true, s.Delete(key)
+}
+
+func (s *txnStore) NewWriteBatch(opts bitcask_go.WriteBatchOptions) kvBatch {
+	return &txnBatch{
+		store:   s,
+		writes:  make(map[string]*txnWrite),
+		expects: make(map[string][]byte),
+	}
+}
+
+// 合并存储引擎和暂存的数据，暂存的数据覆盖存储引擎中相同的 key
+func (s *txnStore) NewIterator(opts bitcask_go.IteratorOptions) kvIterator {
+	keys := make([][]byte, 0, len(s.writes))
+	for key := range s.writes {
+		if bytes.HasPrefix([]byte(key), opts.Prefix) {
+			keys = append(keys, []byte(key))
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return bytes.Compare(keys[i], keys[j]) < 0
+	})
+
+	return &txnIterator{
+		store:   s,
+		base:    s.db.NewIterator(opts),
+		keys:    keys,
+		reverse: opts.Reserve,
+	}
+}
+
+// 事务中的批量写，提交时写入事务暂存的数据
+type txnBatch struct {
+	store   *txnStore
+	writes  map[string]*txnWrite
+	expects map[string][]byte
+}
+
+func (wb *txnBatch) Put(key []byte, value []byte) error {
+	if len(key) == 0 {
+		return bitcask_go.ErrKeyIsEmpty
+	}
+	wb.writes[string(key)] = &txnWrite{value: append([]byte{}, value...)}
+	return nil
+}
+
+func (wb *txnBatch) Delete(key []byte) error {
+	if len(key) == 0 {
+		return bitcask_go.ErrKeyIsEmpty
+	}
+	wb.writes[string(key)] = &txnWrite{deleted: true}
+	return nil
+}
+
+func (wb *txnBatch) Expect(key []byte, value []byte) error {
+	if len(key) == 0 {
+		return bitcask_go.ErrKeyIsEmpty
+	}
+	if value != nil {
+		value = append([]byte{}, value...)
+	}
+	wb.expects[string(key)] = value
+	return nil
+}
+
+func (wb *txnBatch) Commit() error {
+	for key, expected := range wb.expects {
+		value, err := wb.store.Get([]byte(key))
+		if err != nil && err != bitcask_go.ErrKeyNotFound {
+			return err
+		}
+		if (expected == nil) != (err == bitcask_go.ErrKeyNotFound) || !bytes.Equal(value, expected) {
+			return bitcask_go.ErrBatchConditionFailed
+		}
+	}
+	if wb.store.writes == nil {
+		return ErrTxnFinished
+	}
+	for key, w := range wb.writes {
+		wb.store.writes[key] = w
+	}
+	wb.writes = make(map[string]*txnWrite)
+	wb.expects = make(map[string][]byte)
+	return nil
+}
+
+// 按顺序合并存储引擎的迭代器和暂存的 key
+type txnIterator struct {
+	store   *txnStore
+	base    *bitcask_go.Iterator
+	keys    [][]byte // 暂存的 key，按照升序排列
+	pos     int      // 当前暂存的 key 的位置
+	reverse bool
+
+	fromWrites bool // 当前的 key 是否来自暂存的数据
+}
+
+func (it *txnIterator) Rewind() {
+	it.base.Rewind()
+	if it.reverse {
+		it.pos = len(it.keys) - 1
+	} else {
+		it.pos = 0
+	}
+	it.settle()
+}
+
+func (it *txnIterator) Seek(key []byte) {
+	it.base.Seek(key)
+	if it.reverse {
+		// b+树索引反向 Seek 的结果是第一个大于等于 key 的 key，统一为最后一个小于等于 key 的位置
+		if !it.base.Valid() {
+			it.base.Rewind()
+		}
+		for it.base.Valid() && bytes.Compare(it.base.Key(), key) > 0 {
+			it.base.Next()
+		}
+		// 最后一个小于等于 key 的位置
+		it.pos = sort.Search(len(it.keys), func(i int) bool {
+			return bytes.Compare(it.keys[i], key) > 0
+		}) - 1
+	} else {
+		it.pos = sort.Search(len(it.keys), func(i int) bool {
+			return bytes.Compare(it.keys[i], key) >= 0
+		})
+	}
+	it.settle()
+}
+
+func (it *txnIterator) Next() {
+	if it.fromWrites {
+		it.advance()
+	} else {
+		it.base.Next()
+	}
+	it.settle()
+}
+
+func (it *txnIterator) Valid() bool {
+	return it.base.Valid() || it.writesValid()
+}
+
+func (it *txnIterator) Key() []byte {
+	if it.fromWrites {
+		return it.keys[it.pos]
+	}
+	return it.base.Key()
+}
+
+func (it *txnIterator) Value() ([]byte, error) {
+	if it.fromWrites {
+		return it.store.writes[string(it.keys[it.pos])].value, nil
+	}
+	return it.base.Value()
+}
+
+func (it *txnIterator) Close() {
+	it.base.Close()
+}
+
+func (it *txnIterator) writesValid() bool {
+	return it.pos >= 0 && it.pos < len(it.keys)
+}
+
+func (it *txnIterator) advance() {
+	if it.reverse {
+		it.pos--
+	} else {
+		it.pos++
+	}
+}
+
+// 选择两边中顺序靠前的 key，跳过被暂存的数据覆盖的 key 和暂存的删除
+func (it *txnIterator) settle() {
+	for {
+		if !it.writesValid() {
+			it.fromWrites = false
+			return
+		}
+		if it.base.Valid() {
+			cmp := bytes.Compare(it.base.Key(), it.keys[it.pos])
+			if cmp == 0 {
+				it.base.Next()
+				continue
+			}
+			if (cmp < 0) != it.reverse {
+				it.fromWrites = false
+				return
+			}
+		}
+		if it.store.writes[string(it.keys[it.pos])].deleted {
+			it.advance()
+			continue
+		}
+		it.fromWrites = true
+		return
+	}
+}
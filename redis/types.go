@@ -3,13 +3,29 @@ package redis
 import (
 	bitcask_go "bitcask-go"
 	"bitcask-go/utils"
+	"bytes"
 	"encoding/binary"
 	"errors"
+	"math"
+	"math/rand"
+	"strconv"
 	"time"
 )
 
 var (
-	ErrWrongTypeOperation = errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")
+	ErrWrongTypeOperation  = errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")
+	ErrWrongNumberOfArgs   = errors.New("ERR wrong number of arguments")
+	ErrHashValueNotInteger = errors.New("ERR hash value is not an integer")
+	ErrHashValueNotFloat   = errors.New("ERR hash value is not a float")
+	ErrIncrOverflow        = errors.New("ERR increment or decrement would overflow")
+	ErrIncrNaNOrInfinity   = errors.New("ERR increment would produce NaN or Infinity")
+	ErrNoSuchKey           = errors.New("ERR no such key")
+	ErrIndexOutOfRange     = errors.New("ERR index out of range")
+	ErrScoreNaN            = errors.New("ERR resulting score is not a number (NaN)")
+	ErrValueNotInteger     = errors.New("ERR value is not an integer or out of range")
+	ErrValueNotFloat       = errors.New("ERR value is not a valid float")
+	ErrStringTooLong       = errors.New("ERR string exceeds maximum allowed size (proto-max-bulk-len)")
+	ErrOffsetOutOfRange    = errors.New("ERR offset is out of range")
 )
 
 type RedisDataType = byte
@@ -24,7 +40,11 @@ const (
 
 // RedisDataStructure Redis 数据结构服务
 type RedisDataStructure struct {
-	db *bitcask_go.DB
+	db        *bitcask_go.DB
+	kv        kvStore // 数据的读写，事务中暂存写入
+	readOnly  bool
+	reclaimer *reclaimer        // 主动过期和回收失效数据的后台任务
+	counters  *keyspaceCounters // key 的数量，通过 kv 写入时更新
 }
 
 // NewRedisDataStructure 初始化 Redis 数据结构服务
@@ -34,97 +54,637 @@ func NewRedisDataStructure(opts bitcask_go.Options) (*RedisDataStructure, error)
 		return nil, err
 	}
 
-	return &RedisDataStructure{db: db}, nil
+	counters := &keyspaceCounters{}
+	rds := &RedisDataStructure{
+		db:        db,
+		kv:        dbStore{DB: db, counters: counters},
+		readOnly:  opts.ReadOnly,
+		reclaimer: &reclaimer{},
+		counters:  counters,
+	}
+	if err = rds.checkKeyspaceVersion(); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	if !opts.ReadOnly {
+		if err = rds.loadCounters(); err != nil {
+			_ = db.Close()
+			return nil, err
+		}
+	}
+	// 只读模式下不能删除数据，不启动后台任务
+	if !opts.ReadOnly {
+		rds.startReclaimer()
+	}
+	return rds, nil
 }
 
 func (rds *RedisDataStructure) Close() error {
+	rds.stopReclaimer()
 	return rds.db.Close()
 }
 
 // ======================= String 数据结构 =======================
 
 func (rds *RedisDataStructure) Set(key []byte, ttl time.Duration, value []byte) error {
+	// 覆盖复合类型的 key 时需要记录原来的数据部分失效
+	_, _, err := rds.setWithCondition(key, ttl, value, setAlways, false)
+	return err
+}
+
+// SetNX 只有在 key 不存在时才写入，已经过期的 key 视为不存在
+func (rds *RedisDataStructure) SetNX(key []byte, ttl time.Duration, value []byte) (bool, error) {
+	_, ok, err := rds.setWithCondition(key, ttl, value, setIfNotExist, false)
+	return ok, err
+}
+
+// SetXX 只有在 key 存在时才写入
+func (rds *RedisDataStructure) SetXX(key []byte, ttl time.Duration, value []byte) (bool, error) {
+	_, ok, err := rds.setWithCondition(key, ttl, value, setIfExist, false)
+	return ok, err
+}
+
+// SetGet 按照 nx/xx 的条件写入，同时返回原来的值，key 不存在时原来的值为 nil
+// 原来的值不是 String 类型时返回 ErrWrongTypeOperation，不会写入
+func (rds *RedisDataStructure) SetGet(key []byte, ttl time.Duration, value []byte, nx, xx bool) ([]byte, bool, error) {
+	mode := setAlways
+	if nx {
+		mode = setIfNotExist
+	} else if xx {
+		mode = setIfExist
+	}
+	return rds.setWithCondition(key, ttl, value, mode, true)
+}
+
+const (
+	setAlways = iota
+	setIfNotExist
+	setIfExist
+)
+
+func (rds *RedisDataStructure) setWithCondition(key []byte, ttl time.Duration, value []byte, mode int, withOld bool) ([]byte, bool, error) {
 	if value == nil {
-		return nil
+		return nil, false, nil
 	}
-	// 编码 value : type + expire + payload
-	buf := make([]byte, binary.MaxVarintLen64+1)
-	buf[0] = String
-	index := 1
+	encValue := encodeStringValue(value, ttl)
+
+	for {
+		oldValue, err := rds.kv.Get(key)
+		if err != nil && err != bitcask_go.ErrKeyNotFound {
+			return nil, false, err
+		}
+		found := err == nil
+		alive := found && isAliveValue(oldValue)
+
+		var old []byte
+		if alive && withOld {
+			if oldValue[0] != String {
+				return nil, false, ErrWrongTypeOperation
+			}
+			old, _ = decodeStringValue(oldValue)
+		}
+		if (mode == setIfNotExist && alive) || (mode == setIfExist && !alive) {
+			return old, false, nil
+		}
+
+		// 覆盖复合类型的 key 之后原来的数据部分不会再被访问到
+		if found {
+			if err = rds.markStaleValue(key, oldValue); err != nil {
+				return nil, false, err
+			}
+		}
+		if ttl > 0 {
+			_, expire := decodeStringValue(encValue)
+			if err = rds.recordExpire(key, 0, expire); err != nil {
+				return nil, false, err
+			}
+		}
+
+		// 条件写入，key 在读取之后被并发修改则重新判断
+		var ok bool
+		if found {
+			ok, err = rds.kv.CompareAndSwap(key, oldValue, encValue)
+		} else {
+			ok, err = rds.kv.PutIfAbsent(key, encValue)
+		}
+		if err != nil {
+			return nil, false, err
+		}
+		if ok {
+			return old, true, nil
+		}
+	}
+}
+
+// 编码 value : type + expire + payload
+func encodeStringValue(value []byte, ttl time.Duration) []byte {
 	var expire int64 = 0
 	if ttl != 0 {
 		expire = time.Now().Add(ttl).UnixNano()
 	}
+	return encodeStringValueAt(value, expire)
+}
+
+// 使用过期的时间点编码 value，expire 为 0 表示不过期
+func encodeStringValueAt(value []byte, expire int64) []byte {
+	buf := make([]byte, binary.MaxVarintLen64+1)
+	buf[0] = String
+	index := 1
 	index += binary.PutVarint(buf[index:], expire)
 
 	encValue := make([]byte, index+len(value))
 	copy(encValue[:index], buf[:index])
 	copy(encValue[index:], value)
 
-	// 调用存储接口写入数据
-	return rds.db.Put(key, encValue)
+	return encValue
+}
+
+// 解码 String 类型的 value，返回实际数据和过期时间
+func decodeStringValue(encValue []byte) ([]byte, int64) {
+	index := 1
+	expire, n := binary.Varint(encValue[index:])
+	index += n
+	return encValue[index:], expire
 }
 
 func (rds *RedisDataStructure) Get(key []byte) ([]byte, error) {
-	encValue, err := rds.db.Get(key)
+	encValue, err := rds.kv.Get(key)
 	if err != nil {
 		return nil, err
 	}
+	// 判断是否过期，过期的 key 不需要判断数据类型
+	if !isAliveValue(encValue) {
+		return nil, nil
+	}
 	//解码
 	dataType := encValue[0]
 	if dataType != String {
 		return nil, ErrWrongTypeOperation
 	}
-	index := 1
-	expire, n := binary.Varint(encValue[index:])
-	index += n
-	// 判断是否过期
-	if expire > 0 && expire < time.Now().UnixNano() {
-		return nil, nil
+	value, _ := decodeStringValue(encValue)
+
+	return value, err
+}
+
+// MGet 批量获取 String 类型的值
+// key 不存在、已经过期或者不是 String 类型时，对应位置的值为 nil
+func (rds *RedisDataStructure) MGet(keys [][]byte) ([][]byte, error) {
+	encValues, errs := rds.kv.MultiGet(keys)
+
+	values := make([][]byte, len(keys))
+	now := time.Now().UnixNano()
+	for i, encValue := range encValues {
+		if errs[i] != nil {
+			if errs[i] == bitcask_go.ErrKeyNotFound || errs[i] == bitcask_go.ErrKeyIsEmpty {
+				continue
+			}
+			return nil, errs[i]
+		}
+		if len(encValue) == 0 || encValue[0] != String {
+			continue
+		}
+		value, expire := decodeStringValue(encValue)
+		if expire > 0 && expire < now {
+			continue
+		}
+		values[i] = value
 	}
 
-	return encValue[index:], err
+	return values, nil
 }
 
-// ======================= Hash 数据结构 =======================
+// 字符串的最大长度，和 Redis 的 proto-max-bulk-len 默认值一致
+const maxStringSize = 512 * 1024 * 1024
 
-func (rds *RedisDataStructure) HSet(key, field, value []byte) (bool, error) {
-	// 查找对应的元数据,不存在则新建
-	meta, err := rds.findMetadata(key, Hash)
+// Incr 将 key 的值加 1，key 不存在时视为 0
+func (rds *RedisDataStructure) Incr(key []byte) (int64, error) {
+	return rds.IncrBy(key, 1)
+}
+
+// Decr 将 key 的值减 1，key 不存在时视为 0
+func (rds *RedisDataStructure) Decr(key []byte) (int64, error) {
+	return rds.IncrBy(key, -1)
+}
+
+// DecrBy 将 key 的值减去 decr，key 不存在时视为 0
+func (rds *RedisDataStructure) DecrBy(key []byte, decr int64) (int64, error) {
+	if decr == math.MinInt64 {
+		return 0, ErrIncrOverflow
+	}
+	return rds.IncrBy(key, -decr)
+}
+
+// IncrBy 将 key 的值加上 incr，保留原来的过期时间
+func (rds *RedisDataStructure) IncrBy(key []byte, incr int64) (int64, error) {
+	var num int64
+	err := rds.updateString(key, func(value []byte, expire int64, alive bool) ([]byte, int64, error) {
+		num = 0
+		if alive {
+			var err error
+			num, err = strconv.ParseInt(string(value), 10, 64)
+			if err != nil {
+				return nil, 0, ErrValueNotInteger
+			}
+		}
+		if (incr > 0 && num > math.MaxInt64-incr) || (incr < 0 && num < math.MinInt64-incr) {
+			return nil, 0, ErrIncrOverflow
+		}
+		num += incr
+		return []byte(strconv.FormatInt(num, 10)), expire, nil
+	})
 	if err != nil {
-		return false, err
+		return 0, err
 	}
+	return num, nil
+}
 
-	// 构造 Hash 数据部分的key
-	hk := &hashInternalKey{
-		key:     key,
-		version: meta.version,
-		field:   field,
+// IncrByFloat 将 key 的值加上浮点数 incr，保留原来的过期时间
+func (rds *RedisDataStructure) IncrByFloat(key []byte, incr float64) (float64, error) {
+	var num float64
+	err := rds.updateString(key, func(value []byte, expire int64, alive bool) ([]byte, int64, error) {
+		num = 0
+		if alive {
+			var err error
+			num, err = strconv.ParseFloat(string(value), 64)
+			if err != nil || math.IsNaN(num) || math.IsInf(num, 0) {
+				return nil, 0, ErrValueNotFloat
+			}
+		}
+		num += incr
+		if math.IsNaN(num) || math.IsInf(num, 0) {
+			return nil, 0, ErrIncrNaNOrInfinity
+		}
+		return []byte(strconv.FormatFloat(num, 'f', -1, 64)), expire, nil
+	})
+	if err != nil {
+		return 0, err
 	}
+	return num, nil
+}
 
-	enckey := hk.encode()
+// Append 在 key 的值后面追加 value，key 不存在时等同于 Set，返回追加之后的长度
+func (rds *RedisDataStructure) Append(key, value []byte) (int, error) {
+	var size int
+	err := rds.updateString(key, func(old []byte, expire int64, alive bool) ([]byte, int64, error) {
+		if len(old)+len(value) > maxStringSize {
+			return nil, 0, ErrStringTooLong
+		}
+		newValue := make([]byte, 0, len(old)+len(value))
+		newValue = append(append(newValue, old...), value...)
+		size = len(newValue)
+		return newValue, expire, nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return size, nil
+}
 
-	//先查找数据是否存在
-	var exist = true
-	if _, err := rds.db.Get(enckey); err == bitcask_go.ErrKeyNotFound {
-		exist = false
+// StrLen 返回 key 的值的长度，key 不存在时返回 0
+func (rds *RedisDataStructure) StrLen(key []byte) (int, error) {
+	value, err := rds.Get(key)
+	if err != nil && err != bitcask_go.ErrKeyNotFound {
+		return 0, err
 	}
+	return len(value), nil
+}
 
-	wb := rds.db.NewWriteBatch(bitcask_go.DefaultWriteBatchOptions)
-	//如果不存在，更新元数据
-	if !exist {
-		meta.size++
-		_ = wb.Put(key, meta.encode())
+// GetRange 返回 key 的值在 [start, end] 之间的部分，负数表示从末尾开始的位置
+func (rds *RedisDataStructure) GetRange(key []byte, start, end int64) ([]byte, error) {
+	value, err := rds.Get(key)
+	if err != nil && err != bitcask_go.ErrKeyNotFound {
+		return nil, err
 	}
 
-	_ = wb.Put(enckey, value)
+	start, end, ok := normalizeRange(start, end, uint32(len(value)))
+	if !ok {
+		return []byte{}, nil
+	}
+	return value[start : end+1], nil
+}
 
-	if err = wb.Commit(); err != nil {
+// SetRange 从 offset 开始覆盖 key 的值，长度不够时用 0 填充，返回修改之后的长度
+// value 为空时不会创建 key
+func (rds *RedisDataStructure) SetRange(key []byte, offset int64, value []byte) (int, error) {
+	if offset < 0 {
+		return 0, ErrOffsetOutOfRange
+	}
+	if offset+int64(len(value)) > maxStringSize {
+		return 0, ErrStringTooLong
+	}
+	if len(value) == 0 {
+		return rds.StrLen(key)
+	}
+
+	var size int
+	err := rds.updateString(key, func(old []byte, expire int64, alive bool) ([]byte, int64, error) {
+		size = len(old)
+		if end := int(offset) + len(value); end > size {
+			size = end
+		}
+		newValue := make([]byte, size)
+		copy(newValue, old)
+		copy(newValue[offset:], value)
+		return newValue, expire, nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return size, nil
+}
+
+// GetDel 返回 key 的值并删除 key，key 不存在时返回 nil
+func (rds *RedisDataStructure) GetDel(key []byte) ([]byte, error) {
+	for {
+		encValue, alive, err := rds.getString(key)
+		if err != nil || !alive {
+			return nil, err
+		}
+
+		// 条件删除，key 在读取之后被并发修改则重新判断
+		ok, err := rds.kv.DeleteIfValue(key, encValue)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			value, _ := decodeStringValue(encValue)
+			return value, nil
+		}
+	}
+}
+
+// GetEx 返回 key 的值并修改过期时间，at 为零值时不修改，persist 为 true 时去掉过期时间
+// 过期的时间点已经过去时删除 key
+func (rds *RedisDataStructure) GetEx(key []byte, at time.Time, persist bool) ([]byte, error) {
+	for {
+		encValue, alive, err := rds.getString(key)
+		if err != nil || !alive {
+			return nil, err
+		}
+		value, expire := decodeStringValue(encValue)
+
+		var newExpire int64
+		switch {
+		case persist:
+			newExpire = 0
+		case !at.IsZero():
+			newExpire = at.UnixNano()
+		default:
+			return value, nil
+		}
+		if newExpire == expire {
+			return value, nil
+		}
+
+		var ok bool
+		if newExpire != 0 && newExpire <= time.Now().UnixNano() {
+			ok, err = rds.kv.DeleteIfValue(key, encValue)
+		} else {
+			if err = rds.recordExpire(key, 0, newExpire); err != nil {
+				return nil, err
+			}
+			ok, err = rds.kv.CompareAndSwap(key, encValue, encodeStringValueAt(value, newExpire))
+		}
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return value, nil
+		}
+	}
+}
+
+// MSet 同时设置多个 key 的值，pairs 是 key value 交替的列表，所有的 key 一次提交
+func (rds *RedisDataStructure) MSet(pairs [][]byte) error {
+	_, err := rds.msetInner(pairs, false)
+	return err
+}
+
+// MSetNX 只有在所有的 key 都不存在时才同时设置，返回是否写入
+func (rds *RedisDataStructure) MSetNX(pairs [][]byte) (bool, error) {
+	return rds.msetInner(pairs, true)
+}
+
+func (rds *RedisDataStructure) msetInner(pairs [][]byte, nx bool) (bool, error) {
+	if len(pairs) == 0 || len(pairs)%2 != 0 {
+		return false, ErrWrongNumberOfArgs
+	}
+	keys := make([][]byte, 0, len(pairs)/2)
+	for i := 0; i < len(pairs); i += 2 {
+		keys = append(keys, pairs[i])
+	}
+
+	for {
+		encValues, errs := rds.kv.MultiGet(keys)
+		wb := rds.newWriteBatch(len(keys))
+		for i, key := range keys {
+			if errs[i] != nil && errs[i] != bitcask_go.ErrKeyNotFound {
+				return false, errs[i]
+			}
+			if errs[i] == nil {
+				if nx && isAliveValue(encValues[i]) {
+					return false, nil
+				}
+				// 覆盖复合类型的 key 之后原来的数据部分不会再被访问到
+				if err := rds.markStaleValue(key, encValues[i]); err != nil {
+					return false, err
+				}
+			}
+			if nx {
+				_ = wb.Expect(key, encValues[i])
+			}
+			_ = wb.Put(key, encodeStringValue(pairs[2*i+1], 0))
+		}
+
+		// 检查和写入在同一次提交中完成，key 在读取之后被并发修改则重新判断
+		err := wb.Commit()
+		if err == bitcask_go.ErrBatchConditionFailed {
+			continue
+		}
+		if err != nil {
+			return false, err
+		}
+		return true, nil
+	}
+}
+
+// 读取 String 类型的 key，key 不存在或者已经过期时 alive 为 false
+// 已经过期的 key 仍然返回存储的值，用于条件写入
+func (rds *RedisDataStructure) getString(key []byte) ([]byte, bool, error) {
+	encValue, err := rds.kv.Get(key)
+	if err == bitcask_go.ErrKeyNotFound {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	if !isAliveValue(encValue) {
+		return encValue, false, nil
+	}
+	if encValue[0] != String {
+		return nil, false, ErrWrongTypeOperation
+	}
+	return encValue, true, nil
+}
+
+// 读取 String 类型的值，使用 fn 计算新的值和过期时间之后写入
+// key 不存在或者已经过期时 fn 的 alive 为 false，key 在读取之后被并发修改则重新计算
+func (rds *RedisDataStructure) updateString(key []byte, fn func(value []byte, expire int64, alive bool) ([]byte, int64, error)) error {
+	for {
+		encValue, alive, err := rds.getString(key)
+		if err != nil {
+			return err
+		}
+		var value []byte
+		var expire int64
+		if alive {
+			value, expire = decodeStringValue(encValue)
+		}
+
+		newValue, newExpire, err := fn(value, expire, alive)
+		if err != nil {
+			return err
+		}
+		if encValue != nil {
+			if err = rds.markStaleValue(key, encValue); err != nil {
+				return err
+			}
+		}
+		if newExpire != expire {
+			if err = rds.recordExpire(key, 0, newExpire); err != nil {
+				return err
+			}
+		}
+
+		var ok bool
+		if encValue != nil {
+			ok, err = rds.kv.CompareAndSwap(key, encValue, encodeStringValueAt(newValue, newExpire))
+		} else {
+			ok, err = rds.kv.PutIfAbsent(key, encodeStringValueAt(newValue, newExpire))
+		}
+		if err != nil {
+			return err
+		}
+		if ok {
+			return nil
+		}
+	}
+}
+
+// ======================= Hash 数据结构 =======================
+
+func (rds *RedisDataStructure) HSet(key, field, value []byte) (bool, error) {
+	n, err := rds.HMSet(key, [][]byte{field, value})
+	if err != nil {
 		return false, err
 	}
+	return n == 1, nil
+}
 
-	return !exist, nil
+// HMSet 写入多个 field，pairs 为 field value 交替排列，返回新增的 field 数量
+func (rds *RedisDataStructure) HMSet(key []byte, pairs [][]byte) (uint32, error) {
+	if len(pairs) == 0 || len(pairs)%2 != 0 {
+		return 0, ErrWrongNumberOfArgs
+	}
+
+	var added uint32
+	err := retryOnConflict(func() error {
+		// 查找对应的元数据,不存在则新建
+		meta, metaBuf, err := rds.findMetadataForUpdate(key, Hash)
+		if err != nil {
+			return err
+		}
+
+		wb := rds.newWriteBatch(len(pairs)/2 + 1)
+		_ = wb.Expect(key, metaBuf)
+		added = 0
+		seen := make(map[string]struct{}, len(pairs)/2)
+		for i := 0; i < len(pairs); i += 2 {
+			field, value := pairs[i], pairs[i+1]
+			// 构造 Hash 数据部分的key
+			hk := &hashInternalKey{
+				key:     key,
+				version: meta.version,
+				field:   field,
+			}
+			encKey := hk.encode()
+
+			//先查找数据是否存在，同一个field出现多次只计算一次
+			if _, ok := seen[string(field)]; !ok {
+				seen[string(field)] = struct{}{}
+				oldValue, err := rds.getForUpdate(encKey)
+				if err != nil {
+					return err
+				}
+				if oldValue == nil {
+					added++
+				}
+				_ = wb.Expect(encKey, oldValue)
+			}
+			_ = wb.Put(encKey, value)
+		}
+
+		//有新的field时更新元数据
+		if added > 0 {
+			meta.size += added
+			_ = wb.Put(key, meta.encode())
+		}
+		return wb.Commit()
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return added, nil
+}
+
+// HSetNX 只有在 field 不存在时才写入
+func (rds *RedisDataStructure) HSetNX(key, field, value []byte) (bool, error) {
+	var ok bool
+	err := rds.updateHashField(key, field, func(oldValue []byte) ([]byte, error) {
+		ok = oldValue == nil
+		if !ok {
+			return nil, nil
+		}
+		return value, nil
+	})
+	return ok, err
+}
+
+// 读取 field 的值，使用 fn 计算新的值之后写入，field 不存在时 fn 的参数为 nil，fn 返回 nil 时不写入
+// 元数据或者 field 在读取之后被并发修改则重新计算
+func (rds *RedisDataStructure) updateHashField(key, field []byte, fn func(oldValue []byte) ([]byte, error)) error {
+	return retryOnConflict(func() error {
+		meta, metaBuf, err := rds.findMetadataForUpdate(key, Hash)
+		if err != nil {
+			return err
+		}
+		hk := &hashInternalKey{
+			key:     key,
+			version: meta.version,
+			field:   field,
+		}
+		encKey := hk.encode()
+		oldValue, err := rds.getForUpdate(encKey)
+		if err != nil {
+			return err
+		}
 
+		value, err := fn(oldValue)
+		if err != nil || value == nil {
+			return err
+		}
+
+		wb := rds.kv.NewWriteBatch(bitcask_go.DefaultWriteBatchOptions)
+		_ = wb.Expect(key, metaBuf)
+		_ = wb.Expect(encKey, oldValue)
+		if oldValue == nil {
+			meta.size++
+			_ = wb.Put(key, meta.encode())
+		}
+		_ = wb.Put(encKey, value)
+		return wb.Commit()
+	})
 }
 
 func (rds *RedisDataStructure) HGet(key, field []byte) ([]byte, error) {
@@ -144,339 +704,1584 @@ func (rds *RedisDataStructure) HGet(key, field []byte) ([]byte, error) {
 		field:   field,
 	}
 
-	return rds.db.Get(hk.encode())
+	return rds.kv.Get(hk.encode())
 }
 
-func (rds *RedisDataStructure) HDel(key, field []byte) (bool, error) {
+// HMGet 批量获取 field 的值，不存在的 field 对应位置为 nil
+func (rds *RedisDataStructure) HMGet(key []byte, fields [][]byte) ([][]byte, error) {
+	meta, err := rds.findMetadata(key, Hash)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make([][]byte, len(fields))
+	if meta.size == 0 {
+		return values, nil
+	}
+
+	encKeys := make([][]byte, len(fields))
+	for i, field := range fields {
+		hk := &hashInternalKey{
+			key:     key,
+			version: meta.version,
+			field:   field,
+		}
+		encKeys[i] = hk.encode()
+	}
+
+	encValues, errs := rds.kv.MultiGet(encKeys)
+	for i, value := range encValues {
+		if errs[i] != nil {
+			if errs[i] == bitcask_go.ErrKeyNotFound {
+				continue
+			}
+			return nil, errs[i]
+		}
+		values[i] = value
+	}
+
+	return values, nil
+}
+
+func (rds *RedisDataStructure) HExists(key, field []byte) (bool, error) {
 	meta, err := rds.findMetadata(key, Hash)
 	if err != nil {
 		return false, err
 	}
-	//元数据的size为0，说明这个key压根没存数据
 	if meta.size == 0 {
 		return false, nil
 	}
 
-	// 构造 Hash 数据部分的key
 	hk := &hashInternalKey{
 		key:     key,
 		version: meta.version,
 		field:   field,
 	}
 
-	encKey := hk.encode()
-
-	//查看是否存在,存在删除才为true，否则是false
-	var exist = true
-	if _, err := rds.db.Get(encKey); err == bitcask_go.ErrKeyNotFound {
-		exist = false
+	return rds.exists(hk.encode())
+}
+
+func (rds *RedisDataStructure) HLen(key []byte) (uint32, error) {
+	meta, err := rds.findMetadata(key, Hash)
+	if err != nil {
+		return 0, err
+	}
+	return meta.size, nil
+}
+
+// HGetAll 返回所有的 field 和 value，field value 交替排列
+func (rds *RedisDataStructure) HGetAll(key []byte) ([][]byte, error) {
+	var res [][]byte
+	err := rds.iterateHash(key, func(field, value []byte) bool {
+		res = append(res, field, value)
+		return true
+	})
+	return res, err
+}
+
+func (rds *RedisDataStructure) HKeys(key []byte) ([][]byte, error) {
+	var res [][]byte
+	err := rds.iterateHash(key, func(field, value []byte) bool {
+		res = append(res, field)
+		return true
+	})
+	return res, err
+}
+
+func (rds *RedisDataStructure) HVals(key []byte) ([][]byte, error) {
+	var res [][]byte
+	err := rds.iterateHash(key, func(field, value []byte) bool {
+		res = append(res, value)
+		return true
+	})
+	return res, err
+}
+
+// HScan 从 cursor 开始遍历 field，返回下一次遍历的 cursor 和 field value 交替排列的结果，遍历完成时 cursor 为 0
+// cursor 是已经遍历过的 field 数量，遍历期间删除 field 可能导致部分 field 被跳过
+func (rds *RedisDataStructure) HScan(key []byte, cursor uint64, pattern []byte, count int) (uint64, [][]byte, error) {
+	if count <= 0 {
+		count = defaultScanCount
+	}
+
+	var res [][]byte
+	var index, next uint64
+	err := rds.iterateHash(key, func(field, value []byte) bool {
+		if index < cursor {
+			index++
+			return true
+		}
+		if index >= cursor+uint64(count) {
+			next = index
+			return false
+		}
+		index++
+		if pattern == nil || stringMatch(pattern, field) {
+			res = append(res, field, value)
+		}
+		return true
+	})
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return next, res, nil
+}
+
+// HIncrBy 将 field 的值加上 incr，field 不存在时当作 0
+func (rds *RedisDataStructure) HIncrBy(key, field []byte, incr int64) (int64, error) {
+	var num int64
+	err := rds.updateHashField(key, field, func(value []byte) ([]byte, error) {
+		num = 0
+		if value != nil {
+			var err error
+			num, err = strconv.ParseInt(string(value), 10, 64)
+			if err != nil {
+				return nil, ErrHashValueNotInteger
+			}
+		}
+		if (incr > 0 && num > math.MaxInt64-incr) || (incr < 0 && num < math.MinInt64-incr) {
+			return nil, ErrIncrOverflow
+		}
+		num += incr
+		return []byte(strconv.FormatInt(num, 10)), nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return num, nil
+}
+
+// HIncrByFloat 将 field 的值加上浮点数 incr，field 不存在时当作 0
+func (rds *RedisDataStructure) HIncrByFloat(key, field []byte, incr float64) (float64, error) {
+	var num float64
+	err := rds.updateHashField(key, field, func(value []byte) ([]byte, error) {
+		num = 0
+		if value != nil {
+			var err error
+			num, err = strconv.ParseFloat(string(value), 64)
+			if err != nil || math.IsNaN(num) || math.IsInf(num, 0) {
+				return nil, ErrHashValueNotFloat
+			}
+		}
+		num += incr
+		if math.IsNaN(num) || math.IsInf(num, 0) {
+			return nil, ErrIncrNaNOrInfinity
+		}
+		return []byte(strconv.FormatFloat(num, 'f', -1, 64)), nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return num, nil
+}
+
+func (rds *RedisDataStructure) HDel(key, field []byte) (bool, error) {
+	var exist bool
+	err := retryOnConflict(func() error {
+		meta, metaBuf, err := rds.findMetadataForUpdate(key, Hash)
+		if err != nil {
+			return err
+		}
+		//元数据的size为0，说明这个key压根没存数据
+		exist = false
+		if meta.size == 0 {
+			return nil
+		}
+
+		// 构造 Hash 数据部分的key
+		hk := &hashInternalKey{
+			key:     key,
+			version: meta.version,
+			field:   field,
+		}
+
+		encKey := hk.encode()
+
+		//查看是否存在,存在删除才为true，否则是false
+		oldValue, err := rds.getForUpdate(encKey)
+		if err != nil || oldValue == nil {
+			return err
+		}
+		exist = true
+
+		wb := rds.kv.NewWriteBatch(bitcask_go.DefaultWriteBatchOptions)
+		_ = wb.Expect(key, metaBuf)
+		_ = wb.Expect(encKey, oldValue)
+		meta.size--
+		_ = wb.Put(key, meta.encode())
+		_ = wb.Delete(encKey)
+		return wb.Commit()
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return exist, nil
+}
+
+// 按 field 的顺序遍历 Hash 的数据部分，fn 返回 false 时停止遍历
+func (rds *RedisDataStructure) iterateHash(key []byte, fn func(field, value []byte) bool) error {
+	meta, err := rds.findMetadata(key, Hash)
+	if err != nil {
+		return err
+	}
+	if meta.size == 0 {
+		return nil
+	}
+
+	// field 为空时编码的结果就是同一个 Hash 所有数据部分 key 的前缀
+	hk := &hashInternalKey{
+		key:     key,
+		version: meta.version,
+	}
+	return rds.iteratePrefix(hk.encode(), fn)
+}
+
+// ======================= Set 数据结构 =======================
+
+// 集合的成员变化时都会更新元数据中的数量，写入时只需要检查元数据没有被并发修改
+func (rds *RedisDataStructure) SAdd(key, member []byte) (bool, error) {
+	var ok bool
+	err := retryOnConflict(func() error {
+		meta, metaBuf, err := rds.findMetadataForUpdate(key, Set)
+		if err != nil {
+			return err
+		}
+
+		sk := &setInternalKey{
+			key:     key,
+			version: meta.version,
+			member:  member,
+		}
+
+		ok = false
+		if _, err = rds.kv.Get(sk.encode()); err != bitcask_go.ErrKeyNotFound {
+			return err
+		}
+		//不存在的话则更新
+		wb := rds.kv.NewWriteBatch(bitcask_go.DefaultWriteBatchOptions)
+		_ = wb.Expect(key, metaBuf)
+		meta.size++
+		_ = wb.Put(key, meta.encode())
+		_ = wb.Put(sk.encode(), nil)
+		ok = true
+		return wb.Commit()
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return ok, nil
+}
+
+func (rds *RedisDataStructure) SIsMember(key, member []byte) (bool, error) {
+	meta, err := rds.findMetadata(key, Set)
+	if err != nil {
+		return false, nil
+	}
+	if meta.size == 0 {
+		return false, nil
+	}
+
+	sk := &setInternalKey{
+		key:     key,
+		version: meta.version,
+		member:  member,
+	}
+
+	_, err = rds.kv.Get(sk.encode())
+
+	if err != nil && err != bitcask_go.ErrKeyNotFound {
+		return false, err
+	}
+
+	if err == bitcask_go.ErrKeyNotFound {
+		return false, nil
+	}
+
+	return true, nil
+}
+
+func (rds *RedisDataStructure) SRem(key, member []byte) (bool, error) {
+	var ok bool
+	err := retryOnConflict(func() error {
+		meta, metaBuf, err := rds.findMetadataForUpdate(key, Set)
+		if err != nil {
+			return err
+		}
+		ok = false
+		if meta.size == 0 {
+			return nil
+		}
+
+		sk := &setInternalKey{
+			key:     key,
+			version: meta.version,
+			member:  member,
+		}
+
+		if _, err = rds.kv.Get(sk.encode()); err == bitcask_go.ErrKeyNotFound {
+			return nil
+		}
+
+		//更新元数据和数据部分
+		wb := rds.kv.NewWriteBatch(bitcask_go.DefaultWriteBatchOptions)
+		_ = wb.Expect(key, metaBuf)
+		meta.size--
+		_ = wb.Put(key, meta.encode())
+		_ = wb.Delete(sk.encode())
+		ok = true
+		return wb.Commit()
+	})
+	if err != nil {
+		return false, nil
+	}
+	return ok, nil
+
+}
+
+func (rds *RedisDataStructure) SCard(key []byte) (uint32, error) {
+	meta, err := rds.findMetadata(key, Set)
+	if err != nil {
+		return 0, err
+	}
+	return meta.size, nil
+}
+
+// SMembers 按顺序返回集合的所有成员
+func (rds *RedisDataStructure) SMembers(key []byte) ([][]byte, error) {
+	meta, err := rds.findMetadata(key, Set)
+	if err != nil {
+		return nil, err
+	}
+	return rds.setMembers(key, meta)
+}
+
+// SPop 随机删除并返回 count 个成员
+func (rds *RedisDataStructure) SPop(key []byte, count int) ([][]byte, error) {
+	var members [][]byte
+	err := retryOnConflict(func() error {
+		meta, metaBuf, err := rds.findMetadataForUpdate(key, Set)
+		if err != nil {
+			return err
+		}
+		members = [][]byte{}
+		if meta.size == 0 || count <= 0 {
+			return nil
+		}
+
+		if members, err = rds.setMembers(key, meta); err != nil {
+			return err
+		}
+		rand.Shuffle(len(members), func(i, j int) {
+			members[i], members[j] = members[j], members[i]
+		})
+		if count < len(members) {
+			members = members[:count]
+		}
+
+		// 并发取出的成员不会重复
+		wb := rds.newWriteBatch(len(members) + 1)
+		_ = wb.Expect(key, metaBuf)
+		for _, member := range members {
+			sk := &setInternalKey{
+				key:     key,
+				version: meta.version,
+				member:  member,
+			}
+			_ = wb.Delete(sk.encode())
+		}
+		meta.size -= uint32(len(members))
+		_ = wb.Put(key, meta.encode())
+		return wb.Commit()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return members, nil
+}
+
+// SRandMember 随机返回成员，count 大于 0 时返回不重复的成员，小于 0 时返回 -count 个可能重复的成员
+func (rds *RedisDataStructure) SRandMember(key []byte, count int) ([][]byte, error) {
+	members, err := rds.SMembers(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(members) == 0 || count == 0 {
+		return [][]byte{}, nil
+	}
+
+	if count < 0 {
+		res := make([][]byte, -count)
+		for i := range res {
+			res[i] = members[rand.Intn(len(members))]
+		}
+		return res, nil
+	}
+
+	rand.Shuffle(len(members), func(i, j int) {
+		members[i], members[j] = members[j], members[i]
+	})
+	if count < len(members) {
+		members = members[:count]
+	}
+	return members, nil
+}
+
+// SInter 返回所有集合的交集，顺序和第一个集合一致
+func (rds *RedisDataStructure) SInter(keys ...[]byte) ([][]byte, error) {
+	sets, err := rds.loadSets(keys)
+	if err != nil {
+		return nil, err
+	}
+
+	// 记录每个成员出现在其他集合中的次数，每个集合中的成员不会重复
+	counts := make(map[string]int)
+	for _, members := range sets[1:] {
+		for _, member := range members {
+			counts[string(member)]++
+		}
+	}
+	res := [][]byte{}
+	for _, member := range sets[0] {
+		if counts[string(member)] == len(sets)-1 {
+			res = append(res, member)
+		}
+	}
+	return res, nil
+}
+
+// SUnion 返回所有集合的并集
+func (rds *RedisDataStructure) SUnion(keys ...[]byte) ([][]byte, error) {
+	sets, err := rds.loadSets(keys)
+	if err != nil {
+		return nil, err
+	}
+
+	res := [][]byte{}
+	seen := make(map[string]struct{})
+	for _, members := range sets {
+		for _, member := range members {
+			if _, ok := seen[string(member)]; !ok {
+				seen[string(member)] = struct{}{}
+				res = append(res, member)
+			}
+		}
+	}
+	return res, nil
+}
+
+// SDiff 返回第一个集合中不属于其他集合的成员
+func (rds *RedisDataStructure) SDiff(keys ...[]byte) ([][]byte, error) {
+	sets, err := rds.loadSets(keys)
+	if err != nil {
+		return nil, err
+	}
+
+	others := make(map[string]struct{})
+	for _, members := range sets[1:] {
+		for _, member := range members {
+			others[string(member)] = struct{}{}
+		}
+	}
+	res := [][]byte{}
+	for _, member := range sets[0] {
+		if _, ok := others[string(member)]; !ok {
+			res = append(res, member)
+		}
+	}
+	return res, nil
+}
+
+// SInterStore 将交集保存到 destination，返回结果的成员数量
+func (rds *RedisDataStructure) SInterStore(destination []byte, keys ...[]byte) (uint32, error) {
+	return rds.storeSet(destination, keys, rds.SInter)
+}
+
+// SUnionStore 将并集保存到 destination，返回结果的成员数量
+func (rds *RedisDataStructure) SUnionStore(destination []byte, keys ...[]byte) (uint32, error) {
+	return rds.storeSet(destination, keys, rds.SUnion)
+}
+
+// SDiffStore 将差集保存到 destination，返回结果的成员数量
+func (rds *RedisDataStructure) SDiffStore(destination []byte, keys ...[]byte) (uint32, error) {
+	return rds.storeSet(destination, keys, rds.SDiff)
+}
+
+// 计算结果之后整体替换 destination，不管 destination 原来是什么类型
+// 使用新的版本号写入，原来的数据部分不会再被访问到
+func (rds *RedisDataStructure) storeSet(destination []byte, keys [][]byte, compute func(keys ...[]byte) ([][]byte, error)) (uint32, error) {
+	members, err := compute(keys...)
+	if err != nil {
+		return 0, err
+	}
+
+	// 结果为空时删除 destination
+	if len(members) == 0 {
+		if err = rds.Del(destination); err != nil {
+			return 0, err
+		}
+		return 0, nil
+	}
+
+	meta := &metadata{
+		dataType: Set,
+		version:  time.Now().UnixNano(),
+		size:     uint32(len(members)),
+	}
+	err = retryOnConflict(func() error {
+		// 覆盖的值在提交之前被并发修改时，新的值也需要交给后台回收
+		oldValue, err := rds.getForUpdate(destination)
+		if err != nil {
+			return err
+		}
+		if oldValue != nil {
+			if err = rds.markStaleValue(destination, oldValue); err != nil {
+				return err
+			}
+		}
+
+		wb := rds.newWriteBatch(len(members) + 1)
+		_ = wb.Expect(destination, oldValue)
+		for _, member := range members {
+			sk := &setInternalKey{
+				key:     destination,
+				version: meta.version,
+				member:  member,
+			}
+			_ = wb.Put(sk.encode(), nil)
+		}
+		_ = wb.Put(destination, meta.encode())
+		return wb.Commit()
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return meta.size, nil
+}
+
+// 遍历集合的数据部分，数据部分的 key 以 member + member size 结尾
+func (rds *RedisDataStructure) setMembers(key []byte, meta *metadata) ([][]byte, error) {
+	members := [][]byte{}
+	if meta.size == 0 {
+		return members, nil
+	}
+
+	// member 为空时编码的结果去掉 member size 就是同一个集合所有数据部分 key 的前缀
+	sk := &setInternalKey{
+		key:     key,
+		version: meta.version,
+	}
+	prefix := sk.encode()
+	prefix = prefix[:len(prefix)-4]
+	err := rds.iteratePrefix(prefix, func(suffix, value []byte) bool {
+		if len(suffix) < 4 {
+			return true
+		}
+		memberSize := binary.LittleEndian.Uint32(suffix[len(suffix)-4:])
+		if int(memberSize) != len(suffix)-4 {
+			return true
+		}
+		members = append(members, suffix[:memberSize])
+		return true
+	})
+	return members, err
+}
+
+// 加载所有集合的成员，任何一个 key 的类型不是集合时返回错误
+func (rds *RedisDataStructure) loadSets(keys [][]byte) ([][][]byte, error) {
+	if len(keys) == 0 {
+		return nil, ErrWrongNumberOfArgs
+	}
+
+	sets := make([][][]byte, len(keys))
+	for i, key := range keys {
+		members, err := rds.SMembers(key)
+		if err != nil {
+			return nil, err
+		}
+		sets[i] = members
+	}
+	return sets, nil
+}
+
+// ======================= List 数据结构 =======================
+
+func (rds *RedisDataStructure) LPush(key, element []byte) (uint32, error) {
+	return rds.pushInner(key, element, true)
+}
+
+func (rds *RedisDataStructure) RPush(key, element []byte) (uint32, error) {
+	return rds.pushInner(key, element, false)
+}
+
+func (rds *RedisDataStructure) pushInner(key, element []byte, isLeft bool) (uint32, error) {
+	var size uint32
+	err := retryOnConflict(func() error {
+		// 查找元数据
+		meta, metaBuf, err := rds.findMetadataForUpdate(key, List)
+		if err != nil {
+			return err
+		}
+
+		// 构造数据部分的 key
+		lk := &listInternalKey{
+			key:     key,
+			version: meta.version,
+		}
+		if isLeft {
+			lk.index = meta.head - 1
+		} else {
+			lk.index = meta.tail
+		}
+
+		// 更新元数据和数据部分，元数据被并发修改时写入的位置可能已经被占用
+		wb := rds.kv.NewWriteBatch(bitcask_go.DefaultWriteBatchOptions)
+		_ = wb.Expect(key, metaBuf)
+		meta.size++
+		if isLeft {
+			meta.head--
+		} else {
+			meta.tail++
+		}
+		_ = wb.Put(key, meta.encode())
+		_ = wb.Put(lk.encode(), element)
+		size = meta.size
+		return wb.Commit()
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return size, nil
+}
+
+func (rds *RedisDataStructure) LPop(key []byte) ([]byte, error) {
+	return rds.popInner(key, true)
+}
+
+func (rds *RedisDataStructure) RPop(key []byte) ([]byte, error) {
+	return rds.popInner(key, false)
+}
+
+func (rds *RedisDataStructure) popInner(key []byte, isLeft bool) ([]byte, error) {
+	var element []byte
+	err := retryOnConflict(func() error {
+		// 查找元数据
+		meta, metaBuf, err := rds.findMetadataForUpdate(key, List)
+		if err != nil {
+			return err
+		}
+		element = nil
+		if meta.size == 0 {
+			return nil
+		}
+
+		wb := rds.kv.NewWriteBatch(bitcask_go.DefaultWriteBatchOptions)
+		_ = wb.Expect(key, metaBuf)
+		element, err = rds.popElement(wb, key, meta, isLeft)
+		if err != nil {
+			return rds.checkElementConflict(key, metaBuf, err)
+		}
+		_ = wb.Put(key, meta.encode())
+		return wb.Commit()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return element, nil
+}
+
+// 从列表的一端取出元素，删除数据部分并更新 meta，由调用方写入元数据并提交
+// 提交时要求元素没有被 LSet 等操作并发修改
+func (rds *RedisDataStructure) popElement(wb kvBatch, key []byte, meta *metadata, isLeft bool) ([]byte, error) {
+	// 构造数据部分的 key
+	lk := &listInternalKey{
+		key:     key,
+		version: meta.version,
+	}
+	if isLeft {
+		lk.index = meta.head
+	} else {
+		lk.index = meta.tail - 1
+	}
+
+	element, err := rds.getForUpdate(lk.encode())
+	if err != nil {
+		return nil, err
+	}
+	if element == nil {
+		return nil, bitcask_go.ErrKeyNotFound
+	}
+	_ = wb.Expect(lk.encode(), element)
+	_ = wb.Delete(lk.encode())
+
+	meta.size--
+	if isLeft {
+		meta.head++
+	} else {
+		meta.tail--
+	}
+	return element, nil
+}
+
+func (rds *RedisDataStructure) LLen(key []byte) (uint32, error) {
+	meta, err := rds.findMetadata(key, List)
+	if err != nil {
+		return 0, err
+	}
+	return meta.size, nil
+}
+
+// LIndex 获取下标对应的元素，负数表示从尾部开始计算，超出范围时返回 nil
+func (rds *RedisDataStructure) LIndex(key []byte, index int64) ([]byte, error) {
+	meta, err := rds.findMetadata(key, List)
+	if err != nil {
+		return nil, err
+	}
+
+	pos, ok := listPosition(meta, index)
+	if !ok {
+		return nil, nil
+	}
+	lk := &listInternalKey{
+		key:     key,
+		version: meta.version,
+		index:   pos,
+	}
+	return rds.kv.Get(lk.encode())
+}
+
+// LRange 获取 [start, stop] 范围内的元素，下标的规则和 Redis 一致
+func (rds *RedisDataStructure) LRange(key []byte, start, stop int64) ([][]byte, error) {
+	meta, err := rds.findMetadata(key, List)
+	if err != nil {
+		return nil, err
+	}
+
+	start, stop, ok := normalizeRange(start, stop, meta.size)
+	if !ok {
+		return [][]byte{}, nil
+	}
+	return rds.listElements(key, meta, uint64(start), uint64(stop)+1)
+}
+
+// LSet 修改下标对应的元素
+func (rds *RedisDataStructure) LSet(key []byte, index int64, element []byte) error {
+	return retryOnConflict(func() error {
+		meta, metaBuf, err := rds.findMetadataForUpdate(key, List)
+		if err != nil {
+			return err
+		}
+		if meta.size == 0 {
+			return ErrNoSuchKey
+		}
+
+		pos, ok := listPosition(meta, index)
+		if !ok {
+			return ErrIndexOutOfRange
+		}
+		lk := &listInternalKey{
+			key:     key,
+			version: meta.version,
+			index:   pos,
+		}
+		// 元数据被并发修改之后下标对应的位置可能已经变化
+		wb := rds.kv.NewWriteBatch(bitcask_go.DefaultWriteBatchOptions)
+		_ = wb.Expect(key, metaBuf)
+		_ = wb.Put(lk.encode(), element)
+		return wb.Commit()
+	})
+}
+
+// LTrim 只保留 [start, stop] 范围内的元素
+func (rds *RedisDataStructure) LTrim(key []byte, start, stop int64) error {
+	return retryOnConflict(func() error {
+		meta, metaBuf, err := rds.findMetadataForUpdate(key, List)
+		if err != nil {
+			return err
+		}
+		if meta.size == 0 {
+			return nil
+		}
+
+		// 范围为空时删除所有元素
+		start, stop, ok := normalizeRange(start, stop, meta.size)
+		if !ok {
+			start, stop = 0, -1
+		}
+		newHead := meta.head + uint64(start)
+		newTail := meta.head + uint64(stop+1)
+		if newHead == meta.head && newTail == meta.tail {
+			return nil
+		}
+
+		wb := rds.newWriteBatch(int(meta.size) - int(stop-start+1) + 1)
+		_ = wb.Expect(key, metaBuf)
+		lk := &listInternalKey{
+			key:     key,
+			version: meta.version,
+		}
+		for lk.index = meta.head; lk.index < newHead; lk.index++ {
+			_ = wb.Delete(lk.encode())
+		}
+		for lk.index = newTail; lk.index < meta.tail; lk.index++ {
+			_ = wb.Delete(lk.encode())
+		}
+
+		meta.head, meta.tail = newHead, newTail
+		meta.size = uint32(newTail - newHead)
+		_ = wb.Put(key, meta.encode())
+		return wb.Commit()
+	})
+}
+
+// LInsert 在第一个等于 pivot 的元素之前或者之后插入元素，返回插入之后列表的长度
+// key 不存在时返回 0，pivot 不存在时返回 -1
+func (rds *RedisDataStructure) LInsert(key []byte, before bool, pivot, element []byte) (int64, error) {
+	var size int64
+	err := retryOnConflict(func() error {
+		meta, metaBuf, err := rds.findMetadataForUpdate(key, List)
+		if err != nil {
+			return err
+		}
+		size = 0
+		if meta.size == 0 {
+			return nil
+		}
+
+		elements, err := rds.listElements(key, meta, 0, uint64(meta.size))
+		if err != nil {
+			return rds.checkElementConflict(key, metaBuf, err)
+		}
+		var index = -1
+		for i, e := range elements {
+			if bytes.Equal(e, pivot) {
+				index = i
+				break
+			}
+		}
+		if index == -1 {
+			size = -1
+			return nil
+		}
+		if !before {
+			index++
+		}
+
+		newElements := make([][]byte, 0, len(elements)+1)
+		newElements = append(newElements, elements[:index]...)
+		newElements = append(newElements, element)
+		newElements = append(newElements, elements[index:]...)
+		if err = rds.rewriteList(key, meta, metaBuf, elements, newElements); err != nil {
+			return err
+		}
+		size = int64(meta.size)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return size, nil
+}
+
+// LRem 删除等于 element 的元素，count 大于 0 时从头部开始删除 count 个，小于 0 时从尾部开始，等于 0 时全部删除
+func (rds *RedisDataStructure) LRem(key []byte, count int64, element []byte) (uint32, error) {
+	var n int64
+	err := retryOnConflict(func() error {
+		meta, metaBuf, err := rds.findMetadataForUpdate(key, List)
+		if err != nil {
+			return err
+		}
+		n = 0
+		if meta.size == 0 {
+			return nil
+		}
+
+		elements, err := rds.listElements(key, meta, 0, uint64(meta.size))
+		if err != nil {
+			return rds.checkElementConflict(key, metaBuf, err)
+		}
+
+		// 标记需要删除的元素
+		removed := make([]bool, len(elements))
+		for i := range elements {
+			j := i
+			if count < 0 {
+				j = len(elements) - 1 - i
+			}
+			if bytes.Equal(elements[j], element) {
+				removed[j] = true
+				n++
+				if n == count || n == -count {
+					break
+				}
+			}
+		}
+		if n == 0 {
+			return nil
+		}
+
+		newElements := make([][]byte, 0, len(elements)-int(n))
+		for i, e := range elements {
+			if !removed[i] {
+				newElements = append(newElements, e)
+			}
+		}
+		return rds.rewriteList(key, meta, metaBuf, elements, newElements)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return uint32(n), nil
+}
+
+// LMove 从 source 的一端取出元素放到 destination 的一端，source 和 destination 可以是同一个列表
+func (rds *RedisDataStructure) LMove(source, destination []byte, fromLeft, toLeft bool) ([]byte, error) {
+	var element []byte
+	err := retryOnConflict(func() error {
+		srcMeta, srcBuf, err := rds.findMetadataForUpdate(source, List)
+		if err != nil {
+			return err
+		}
+		dstMeta, dstBuf := srcMeta, srcBuf
+		if !bytes.Equal(source, destination) {
+			if dstMeta, dstBuf, err = rds.findMetadataForUpdate(destination, List); err != nil {
+				return err
+			}
+		}
+		element = nil
+		if srcMeta.size == 0 {
+			return nil
+		}
+
+		wb := rds.kv.NewWriteBatch(bitcask_go.DefaultWriteBatchOptions)
+		_ = wb.Expect(source, srcBuf)
+		_ = wb.Expect(destination, dstBuf)
+		element, err = rds.popElement(wb, source, srcMeta, fromLeft)
+		if err != nil {
+			return rds.checkElementConflict(source, srcBuf, err)
+		}
+
+		lk := &listInternalKey{
+			key:     destination,
+			version: dstMeta.version,
+		}
+		dstMeta.size++
+		if toLeft {
+			dstMeta.head--
+			lk.index = dstMeta.head
+		} else {
+			lk.index = dstMeta.tail
+			dstMeta.tail++
+		}
+		_ = wb.Put(lk.encode(), element)
+		_ = wb.Put(source, srcMeta.encode())
+		_ = wb.Put(destination, dstMeta.encode())
+		return wb.Commit()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return element, nil
+}
+
+// 获取 [start, end) 位置的元素，位置从 0 开始，是相对于 head 的偏移
+func (rds *RedisDataStructure) listElements(key []byte, meta *metadata, start, end uint64) ([][]byte, error) {
+	encKeys := make([][]byte, 0, end-start)
+	for i := start; i < end; i++ {
+		lk := &listInternalKey{
+			key:     key,
+			version: meta.version,
+			index:   meta.head + i,
+		}
+		encKeys = append(encKeys, lk.encode())
+	}
+
+	elements, errs := rds.kv.MultiGet(encKeys)
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return elements, nil
+}
+
+// 将列表从 head 开始重写为 newElements，只写入发生变化的位置，多出来的位置删除
+// 提交时要求元数据和读取到的 elements 都没有被并发修改
+func (rds *RedisDataStructure) rewriteList(key []byte, meta *metadata, metaBuf []byte, elements, newElements [][]byte) error {
+	wb := rds.newWriteBatch(len(elements) + len(newElements) + 1)
+	_ = wb.Expect(key, metaBuf)
+	lk := &listInternalKey{
+		key:     key,
+		version: meta.version,
+	}
+	for i, e := range elements {
+		// 元素的值为空时也要求 key 存在
+		lk.index = meta.head + uint64(i)
+		_ = wb.Expect(lk.encode(), append([]byte{}, e...))
+	}
+	for i, e := range newElements {
+		if i < len(elements) && bytes.Equal(elements[i], e) {
+			continue
+		}
+		lk.index = meta.head + uint64(i)
+		_ = wb.Put(lk.encode(), e)
+	}
+	for i := len(newElements); i < len(elements); i++ {
+		lk.index = meta.head + uint64(i)
+		_ = wb.Delete(lk.encode())
+	}
+
+	meta.size = uint32(len(newElements))
+	meta.tail = meta.head + uint64(len(newElements))
+	_ = wb.Put(key, meta.encode())
+	return wb.Commit()
+}
+
+// 将 Redis 的下标转换为数据部分的 index，超出范围时返回 false
+func listPosition(meta *metadata, index int64) (uint64, bool) {
+	if index < 0 {
+		index += int64(meta.size)
+	}
+	if index < 0 || index >= int64(meta.size) {
+		return 0, false
+	}
+	return meta.head + uint64(index), true
+}
+
+// 按照 Redis 的规则处理 [start, stop] 范围，负数表示从尾部开始计算，范围为空时返回 false
+func normalizeRange(start, stop int64, size uint32) (int64, int64, bool) {
+	if start < 0 {
+		start += int64(size)
+	}
+	if stop < 0 {
+		stop += int64(size)
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= int64(size) {
+		stop = int64(size) - 1
+	}
+	if start > stop || start >= int64(size) {
+		return 0, 0, false
 	}
+	return start, stop, true
+}
 
-	if exist {
-		wb := rds.db.NewWriteBatch(bitcask_go.DefaultWriteBatchOptions)
-		meta.size--
-		_ = wb.Put(key, meta.encode())
-		_ = wb.Delete(key)
+// ======================= ZSet 数据结构 =======================
 
-		if err = wb.Commit(); err != nil {
-			return false, err
-		}
+// ZSetMember 有序集合的成员和对应的分数
+type ZSetMember struct {
+	Member []byte
+	Score  float64
+}
 
-	}
+// ScoreRange 分数的范围，Exclusive 为 true 时不包含对应的边界
+type ScoreRange struct {
+	Min          float64
+	Max          float64
+	MinExclusive bool
+	MaxExclusive bool
+}
 
-	return exist, nil
+func (r ScoreRange) aboveMin(score float64) bool {
+	return score > r.Min || (!r.MinExclusive && score == r.Min)
 }
 
-// ======================= Set 数据结构 =======================
+func (r ScoreRange) belowMax(score float64) bool {
+	return score < r.Max || (!r.MaxExclusive && score == r.Max)
+}
 
-func (rds *RedisDataStructure) SAdd(key, member []byte) (bool, error) {
-	meta, err := rds.findMetadata(key, Hash)
+func (rds *RedisDataStructure) ZAdd(key []byte, score float64, member []byte) (bool, error) {
+	if math.IsNaN(score) {
+		return false, ErrScoreNaN
+	}
+	_, exist, err := rds.zsetPut(key, member, func(float64, bool) float64 {
+		return score
+	})
 	if err != nil {
 		return false, err
 	}
+	return !exist, nil
+}
 
-	sk := &setInternalKey{
-		key:     key,
-		version: meta.version,
-		member:  member,
-	}
-
-	var ok bool
-	if _, err = rds.db.Get(sk.encode()); err == bitcask_go.ErrKeyNotFound {
-		//不存在的话则更新
-		wb := rds.db.NewWriteBatch(bitcask_go.DefaultWriteBatchOptions)
-		meta.size++
-		_ = wb.Put(key, meta.encode())
-		_ = wb.Put(sk.encode(), nil)
-
-		if err = wb.Commit(); err != nil {
-			return false, err
-		}
-
-		ok = true
+// ZIncrBy 将 member 的分数加上 incr，member 不存在时当作 0，返回新的分数
+func (rds *RedisDataStructure) ZIncrBy(key []byte, incr float64, member []byte) (float64, error) {
+	score, _, err := rds.zsetPut(key, member, func(oldScore float64, exist bool) float64 {
+		return oldScore + incr
+	})
+	if err != nil {
+		return 0, err
 	}
-
-	return ok, nil
+	return score, nil
 }
 
-func (rds *RedisDataStructure) SIsMember(key, member []byte) (bool, error) {
-	meta, err := rds.findMetadata(key, Set)
+func (rds *RedisDataStructure) ZScore(key, member []byte) (float64, error) {
+	meta, err := rds.findZSetMetadata(key)
 	if err != nil {
-		return false, nil
+		return -1, err
 	}
+
 	if meta.size == 0 {
-		return false, nil
+		return -1, nil
 	}
 
-	sk := &setInternalKey{
+	//构造数据部分的key
+	zk := &ZSetInternalKey{
 		key:     key,
 		version: meta.version,
 		member:  member,
 	}
 
-	_, err = rds.db.Get(sk.encode())
-
-	if err != nil && err != bitcask_go.ErrKeyNotFound {
-		return false, err
-	}
-
-	if err == bitcask_go.ErrKeyNotFound {
-		return false, nil
+	value, err := rds.kv.Get(zk.encodeWithMember())
+	if err != nil {
+		return -1, err
 	}
 
-	return true, nil
+	return utils.SortableBytesToFloat64(value), nil
 }
 
-func (rds *RedisDataStructure) SRem(key, member []byte) (bool, error) {
-	meta, err := rds.findMetadata(key, Set)
+func (rds *RedisDataStructure) ZCard(key []byte) (uint32, error) {
+	meta, err := rds.findZSetMetadata(key)
 	if err != nil {
-		return false, nil
-	}
-	if meta.size == 0 {
-		return false, nil
+		return 0, err
 	}
+	return meta.size, nil
+}
 
-	sk := &setInternalKey{
-		key:     key,
-		version: meta.version,
-		member:  member,
-	}
+func (rds *RedisDataStructure) ZRem(key, member []byte) (bool, error) {
+	var ok bool
+	err := retryOnConflict(func() error {
+		meta, metaBuf, err := rds.findZSetMetadataForUpdate(key)
+		if err != nil {
+			return err
+		}
+		ok = false
+		if meta.size == 0 {
+			return nil
+		}
 
-	if _, err = rds.db.Get(sk.encode()); err == bitcask_go.ErrKeyNotFound {
-		return false, nil
-	}
+		zk := &ZSetInternalKey{
+			key:     key,
+			version: meta.version,
+			member:  member,
+		}
+		value, err := rds.getForUpdate(zk.encodeWithMember())
+		if err != nil || value == nil {
+			return err
+		}
+		zk.score = utils.SortableBytesToFloat64(value)
 
-	//更新元数据和数据部分
-	wb := rds.db.NewWriteBatch(bitcask_go.DefaultWriteBatchOptions)
-	meta.size--
-	_ = wb.Put(key, meta.encode())
-	_ = wb.Delete(sk.encode())
-	if err = wb.Commit(); err != nil {
+		// 分数被并发修改时需要删除的 score key 也会变化
+		wb := rds.kv.NewWriteBatch(bitcask_go.DefaultWriteBatchOptions)
+		_ = wb.Expect(key, metaBuf)
+		_ = wb.Expect(zk.encodeWithMember(), value)
+		meta.size--
+		_ = wb.Put(key, meta.encode())
+		_ = wb.Delete(zk.encodeWithMember())
+		_ = wb.Delete(zk.encodeWithScore())
+		ok = true
+		return wb.Commit()
+	})
+	if err != nil {
 		return false, err
 	}
-	return true, nil
+	return ok, nil
+}
 
+// ZRange 按照分数从小到大返回 [start, stop] 排名范围内的成员，下标的规则和 LRANGE 一致
+func (rds *RedisDataStructure) ZRange(key []byte, start, stop int64) ([]ZSetMember, error) {
+	return rds.zrangeByRank(key, start, stop, false)
 }
 
-// ======================= List 数据结构 =======================
+// ZRevRange 按照分数从大到小返回 [start, stop] 排名范围内的成员
+func (rds *RedisDataStructure) ZRevRange(key []byte, start, stop int64) ([]ZSetMember, error) {
+	return rds.zrangeByRank(key, start, stop, true)
+}
 
-func (rds *RedisDataStructure) LPush(key, element []byte) (uint32, error) {
-	return rds.pushInner(key, element, true)
+// ZRangeByScore 按照分数从小到大返回分数范围内的成员，跳过前 offset 个，count 小于 0 时返回全部
+func (rds *RedisDataStructure) ZRangeByScore(key []byte, r ScoreRange, offset, count int) ([]ZSetMember, error) {
+	return rds.zrangeByScore(key, r, offset, count, false)
 }
 
-func (rds *RedisDataStructure) RPush(key, element []byte) (uint32, error) {
-	return rds.pushInner(key, element, false)
+// ZRevRangeByScore 按照分数从大到小返回分数范围内的成员
+func (rds *RedisDataStructure) ZRevRangeByScore(key []byte, r ScoreRange, offset, count int) ([]ZSetMember, error) {
+	return rds.zrangeByScore(key, r, offset, count, true)
 }
 
-func (rds *RedisDataStructure) pushInner(key, element []byte, isLeft bool) (uint32, error) {
-	// 查找元数据
-	meta, err := rds.findMetadata(key, List)
+// ZCount 分数范围内的成员数量
+func (rds *RedisDataStructure) ZCount(key []byte, r ScoreRange) (uint32, error) {
+	members, err := rds.zrangeByScore(key, r, 0, -1, false)
 	if err != nil {
 		return 0, err
 	}
+	return uint32(len(members)), nil
+}
 
-	// 构造数据部分的 key
-	lk := &listInternalKey{
-		key:     key,
-		version: meta.version,
-	}
-	if isLeft {
-		lk.index = meta.head - 1
-	} else {
-		lk.index = meta.tail
-	}
-
-	// 更新元数据和数据部分
-	wb := rds.db.NewWriteBatch(bitcask_go.DefaultWriteBatchOptions)
-	meta.size++
-	if isLeft {
-		meta.head--
-	} else {
-		meta.tail++
-	}
-	_ = wb.Put(key, meta.encode())
-	_ = wb.Put(lk.encode(), element)
-	if err = wb.Commit(); err != nil {
-		return 0, err
-	}
+// ZRank 按照分数从小到大的排名，从 0 开始，member 不存在时返回 -1
+func (rds *RedisDataStructure) ZRank(key, member []byte) (int64, error) {
+	return rds.zrank(key, member, false)
+}
 
-	return meta.size, nil
+// ZRevRank 按照分数从大到小的排名，从 0 开始，member 不存在时返回 -1
+func (rds *RedisDataStructure) ZRevRank(key, member []byte) (int64, error) {
+	return rds.zrank(key, member, true)
 }
 
-func (rds *RedisDataStructure) LPop(key []byte) ([]byte, error) {
-	return rds.popInner(key, true)
+// ZPopMin 删除并返回分数最小的 count 个成员
+func (rds *RedisDataStructure) ZPopMin(key []byte, count int) ([]ZSetMember, error) {
+	return rds.zpop(key, count, false)
 }
 
-func (rds *RedisDataStructure) RPop(key []byte) ([]byte, error) {
-	return rds.popInner(key, false)
+// ZPopMax 删除并返回分数最大的 count 个成员
+func (rds *RedisDataStructure) ZPopMax(key []byte, count int) ([]ZSetMember, error) {
+	return rds.zpop(key, count, true)
 }
 
-func (rds *RedisDataStructure) popInner(key []byte, isLeft bool) ([]byte, error) {
-	// 查找元数据
-	meta, err := rds.findMetadata(key, List)
+// 写入 member 的分数，score 根据原来的分数计算新的分数，返回新的分数和 member 原来是否存在
+// 使用 score 根据原来的分数计算新的分数之后写入，元数据或者 member 在读取之后被并发修改则重新计算
+func (rds *RedisDataStructure) zsetPut(key []byte, member []byte,
+	score func(oldScore float64, exist bool) float64) (float64, bool, error) {
+	var newScore float64
+	var exist bool
+	err := retryOnConflict(func() error {
+		meta, metaBuf, err := rds.findZSetMetadataForUpdate(key)
+		if err != nil {
+			return err
+		}
+
+		//构造数据部分的key
+		zk := &ZSetInternalKey{
+			key:     key,
+			version: meta.version,
+			member:  member,
+		}
+
+		//查看是否已经存在
+		value, err := rds.getForUpdate(zk.encodeWithMember())
+		if err != nil {
+			return err
+		}
+		exist = value != nil
+
+		var oldScore float64
+		if exist {
+			oldScore = utils.SortableBytesToFloat64(value)
+		}
+		zk.score = score(oldScore, exist)
+		newScore = zk.score
+		if math.IsNaN(zk.score) {
+			return ErrScoreNaN
+		}
+		if exist && zk.score == oldScore {
+			return nil
+		}
+
+		//更新元数据和数据部分，原来的 score key 根据读取到的分数删除
+		wb := rds.kv.NewWriteBatch(bitcask_go.DefaultWriteBatchOptions)
+		_ = wb.Expect(key, metaBuf)
+		_ = wb.Expect(zk.encodeWithMember(), value)
+		if !exist {
+			meta.size++
+			_ = wb.Put(key, meta.encode())
+		} else {
+			oldKey := &ZSetInternalKey{
+				key:     key,
+				version: meta.version,
+				member:  member,
+				score:   oldScore,
+			}
+			_ = wb.Delete(oldKey.encodeWithScore())
+		}
+		_ = wb.Put(zk.encodeWithMember(), utils.Float64ToSortableBytes(zk.score))
+		_ = wb.Put(zk.encodeWithScore(), nil)
+		return wb.Commit()
+	})
 	if err != nil {
-		return nil, err
+		return 0, false, err
 	}
+	return newScore, exist, nil
+}
+
+// 按照分数的顺序遍历成员，from 为空时从头开始，反向遍历时从分数等于 from 的最后一个成员开始
+func (rds *RedisDataStructure) iterateZSet(key []byte, meta *metadata, from []byte, reverse bool, fn func(m ZSetMember) bool) error {
 	if meta.size == 0 {
-		return nil, nil
+		return nil
 	}
 
-	// 构造数据部分的 key
-	lk := &listInternalKey{
+	zk := &ZSetInternalKey{
 		key:     key,
 		version: meta.version,
 	}
-	if isLeft {
-		lk.index = meta.head
-	} else {
-		lk.index = meta.tail - 1
-	}
+	return rds.iterateRange(zk.encodePrefix(zsetScoreMark), from, reverse, func(suffix, value []byte) bool {
+		score, member, ok := decodeZSetScoreSuffix(suffix)
+		if !ok {
+			return true
+		}
+		return fn(ZSetMember{Member: member, Score: score})
+	})
+}
 
-	element, err := rds.db.Get(lk.encode())
+func (rds *RedisDataStructure) zrangeByRank(key []byte, start, stop int64, reverse bool) ([]ZSetMember, error) {
+	meta, err := rds.findZSetMetadata(key)
 	if err != nil {
 		return nil, err
 	}
 
-	// 更新元数据
-	meta.size--
-	if isLeft {
-		meta.head++
-	} else {
-		meta.tail--
+	res := []ZSetMember{}
+	start, stop, ok := normalizeRange(start, stop, meta.size)
+	if !ok {
+		return res, nil
 	}
-	if err = rds.db.Put(key, meta.encode()); err != nil {
+
+	var rank int64
+	err = rds.iterateZSet(key, meta, nil, reverse, func(m ZSetMember) bool {
+		if rank >= start {
+			res = append(res, m)
+		}
+		rank++
+		return rank <= stop
+	})
+	return res, err
+}
+
+func (rds *RedisDataStructure) zrangeByScore(key []byte, r ScoreRange, offset, count int, reverse bool) ([]ZSetMember, error) {
+	meta, err := rds.findZSetMetadata(key)
+	if err != nil {
 		return nil, err
 	}
 
-	return element, nil
-}
+	res := []ZSetMember{}
+	if count == 0 {
+		return res, nil
+	}
 
-// ======================= ZSet 数据结构 =======================
+	// 从起点一侧的边界开始遍历
+	from := utils.Float64ToSortableBytes(r.Min)
+	if reverse {
+		from = utils.Float64ToSortableBytes(r.Max)
+	}
+	err = rds.iterateZSet(key, meta, from, reverse, func(m ZSetMember) bool {
+		// 起点一侧的边界不满足时跳过，另一侧的边界不满足时结束
+		if !r.aboveMin(m.Score) {
+			return !reverse
+		}
+		if !r.belowMax(m.Score) {
+			return reverse
+		}
+		if offset > 0 {
+			offset--
+			return true
+		}
+		res = append(res, m)
+		return count < 0 || len(res) < count
+	})
+	return res, err
+}
 
-func (rds *RedisDataStructure) ZAdd(key []byte, score float64, member []byte) (bool, error) {
-	meta, err := rds.findMetadata(key, ZSet)
+func (rds *RedisDataStructure) zrank(key, member []byte, reverse bool) (int64, error) {
+	meta, err := rds.findZSetMetadata(key)
 	if err != nil {
-		return false, err
+		return -1, err
+	}
+	if meta.size == 0 {
+		return -1, nil
 	}
 
-	//构造数据部分的key
 	zk := &ZSetInternalKey{
 		key:     key,
 		version: meta.version,
 		member:  member,
-		score:   score,
-	}
-
-	var exist = true
-	//查看是否已经存在
-	value, err := rds.db.Get(zk.encodeWithMember())
-	if err != nil && err != bitcask_go.ErrKeyNotFound {
-		return false, err
-	}
-
-	if err == bitcask_go.ErrKeyNotFound {
-		exist = false
 	}
-
-	if exist {
-		if score == utils.BytesToFloat64(value) {
-			return false, nil
+	if _, err = rds.kv.Get(zk.encodeWithMember()); err != nil {
+		if err == bitcask_go.ErrKeyNotFound {
+			return -1, nil
 		}
+		return -1, err
 	}
 
-	//更新元数据和数据部分
-	wb := rds.db.NewWriteBatch(bitcask_go.DefaultWriteBatchOptions)
-	if !exist {
-		meta.size++
-		_ = wb.Put(key, meta.encode())
-	}
+	var rank int64 = -1
+	var count int64
+	err = rds.iterateZSet(key, meta, nil, reverse, func(m ZSetMember) bool {
+		if bytes.Equal(m.Member, member) {
+			rank = count
+			return false
+		}
+		count++
+		return true
+	})
+	return rank, err
+}
 
-	if exist {
-		oldKey := &ZSetInternalKey{
-			key:     key,
-			version: meta.version,
-			member:  member,
-			score:   utils.BytesToFloat64(value),
+func (rds *RedisDataStructure) zpop(key []byte, count int, max bool) ([]ZSetMember, error) {
+	var res []ZSetMember
+	err := retryOnConflict(func() error {
+		meta, metaBuf, err := rds.findZSetMetadataForUpdate(key)
+		if err != nil {
+			return err
 		}
 
-		_ = wb.Delete(oldKey.encodeWithScore())
-	}
+		res = []ZSetMember{}
+		if meta.size == 0 || count <= 0 {
+			return nil
+		}
 
-	//更新数据部分
-	_ = wb.Put(zk.encodeWithMember(), utils.Float64ToBytes(score))
-	_ = wb.Put(zk.encodeWithScore(), nil)
+		err = rds.iterateZSet(key, meta, nil, max, func(m ZSetMember) bool {
+			res = append(res, m)
+			return len(res) < count
+		})
+		if err != nil {
+			return err
+		}
 
-	if err = wb.Commit(); err != nil {
-		return false, err
+		// 取出的成员的分数被并发修改时，排序和需要删除的 score key 都会变化
+		wb := rds.newWriteBatch(len(res)*2 + 1)
+		_ = wb.Expect(key, metaBuf)
+		for _, m := range res {
+			zk := &ZSetInternalKey{
+				key:     key,
+				version: meta.version,
+				member:  m.Member,
+				score:   m.Score,
+			}
+			_ = wb.Expect(zk.encodeWithMember(), utils.Float64ToSortableBytes(m.Score))
+			_ = wb.Delete(zk.encodeWithMember())
+			_ = wb.Delete(zk.encodeWithScore())
+		}
+		meta.size -= uint32(len(res))
+		_ = wb.Put(key, meta.encode())
+		return wb.Commit()
+	})
+	if err != nil {
+		return nil, err
 	}
+	return res, nil
+}
 
-	return !exist, nil
+// 查找 ZSet 的元数据，旧编码的 ZSet 在第一次访问时迁移到新的编码
+func (rds *RedisDataStructure) findZSetMetadata(key []byte) (*metadata, error) {
+	meta, _, err := rds.findZSetMetadataForUpdate(key)
+	return meta, err
 }
 
-func (rds *RedisDataStructure) ZScore(key, member []byte) (float64, error) {
-	meta, err := rds.findMetadata(key, ZSet)
+// 查找 ZSet 的元数据，同时返回读取到的原始值，迁移编码之后是新写入的元数据
+func (rds *RedisDataStructure) findZSetMetadataForUpdate(key []byte) (*metadata, []byte, error) {
+	var meta *metadata
+	var metaBuf []byte
+	err := retryOnConflict(func() error {
+		var err error
+		meta, metaBuf, err = rds.findMetadataForUpdate(key, ZSet)
+		if err != nil {
+			return err
+		}
+		if meta.encoding == zsetEncodingLegacy {
+			// 没有数据时只需要在下次写入元数据时使用新的编码
+			if meta.size == 0 {
+				meta.encoding = zsetEncodingSortable
+				return nil
+			}
+			metaBuf, err = rds.migrateZSet(key, meta, metaBuf)
+			return err
+		}
+		return nil
+	})
 	if err != nil {
-		return -1, err
-	}
-
-	if meta.size == 0 {
-		return -1, nil
+		return nil, nil, err
 	}
+	return meta, metaBuf, nil
+}
 
-	//构造数据部分的key
+// 旧编码的分数是文本，member key 的值是分数，score key 的值为空
+// 使用新的版本号写入新编码的数据部分，在同一个批次中删除原来的数据部分
+// 元数据已经被并发修改（比如已经被其他请求迁移）时返回 ErrBatchConditionFailed，成功时返回新的元数据的编码
+func (rds *RedisDataStructure) migrateZSet(key []byte, meta *metadata, metaBuf []byte) ([]byte, error) {
 	zk := &ZSetInternalKey{
 		key:     key,
 		version: meta.version,
-		member:  member,
 	}
-
-	value, err := rds.db.Get(zk.encodeWithMember())
+	prefix := zk.encodePrefix(0)
+
+	var oldKeys [][]byte
+	var members []ZSetMember
+	err := rds.iteratePrefix(prefix, func(suffix, value []byte) bool {
+		oldKeys = append(oldKeys, append(append([]byte(nil), prefix...), suffix...))
+		if len(value) > 0 {
+			members = append(members, ZSetMember{Member: suffix, Score: utils.BytesToFloat64(value)})
+		}
+		return true
+	})
 	if err != nil {
-		return -1, err
+		return nil, err
+	}
+
+	newMeta := &metadata{
+		dataType: ZSet,
+		expire:   meta.expire,
+		version:  time.Now().UnixNano(),
+		size:     uint32(len(members)),
+		encoding: zsetEncodingSortable,
+	}
+	wb := rds.newWriteBatch(len(oldKeys) + len(members)*2 + 1)
+	_ = wb.Expect(key, metaBuf)
+	for _, oldKey := range oldKeys {
+		_ = wb.Delete(oldKey)
+	}
+	for _, m := range members {
+		newKey := &ZSetInternalKey{
+			key:     key,
+			version: newMeta.version,
+			member:  m.Member,
+			score:   m.Score,
+		}
+		_ = wb.Put(newKey.encodeWithMember(), utils.Float64ToSortableBytes(m.Score))
+		_ = wb.Put(newKey.encodeWithScore(), nil)
+	}
+	newMetaBuf := newMeta.encode()
+	_ = wb.Put(key, newMetaBuf)
+	if err = wb.Commit(); err != nil {
+		return nil, err
 	}
 
-	return utils.BytesToFloat64(value), nil
+	*meta = *newMeta
+	return newMetaBuf, nil
 }
 
 func (rds *RedisDataStructure) findMetadata(key []byte, dataType RedisDataType) (*metadata, error) {
-	metaBuf, err := rds.db.Get(key)
-	if err != nil && err != bitcask_go.ErrKeyNotFound {
-		return nil, err
+	meta, _, err := rds.findMetadataForUpdate(key, dataType)
+	return meta, err
+}
+
+// 查找元数据，同时返回读取到的原始值，key 不存在时为 nil
+// 写入时通过 Expect 检查元数据在读取之后没有被并发修改
+func (rds *RedisDataStructure) findMetadataForUpdate(key []byte, dataType RedisDataType) (*metadata, []byte, error) {
+	metaBuf, err := rds.getForUpdate(key)
+	if err != nil {
+		return nil, nil, err
 	}
 
 	var meta *metadata
 	var exist = true
-	if err == bitcask_go.ErrKeyNotFound {
+	if metaBuf == nil {
 		exist = false
+	} else if !isAliveValue(metaBuf) {
+		//已经过期或者没有数据的 key 视为不存在，不需要判断数据类型
+		//写入时会使用新的版本，原来的数据部分交给后台回收
+		exist = false
+		if err = rds.markStaleValue(key, metaBuf); err != nil {
+			return nil, nil, err
+		}
 	} else {
 		meta = decodeMetadata(metaBuf)
 		//判断数据类型
 		if meta.dataType != dataType {
-			return nil, ErrWrongTypeOperation
-		}
-		//判断过期时间
-		if meta.expire != 0 && meta.expire <= time.Now().UnixNano() {
-			exist = false
+			return nil, nil, ErrWrongTypeOperation
 		}
 	}
 
@@ -492,7 +2297,10 @@ func (rds *RedisDataStructure) findMetadata(key []byte, dataType RedisDataType)
 			meta.head = initialListMark
 			meta.tail = initialListMark
 		}
+		if dataType == ZSet {
+			meta.encoding = zsetEncodingSortable
+		}
 	}
 
-	return meta, nil
+	return meta, metaBuf, nil
 }
@@ -3,7 +3,13 @@ package redis
 import (
 	bitcask "bitcask-go"
 	"bitcask-go/utils"
+	"bytes"
+	"encoding/binary"
 	"github.com/stretchr/testify/assert"
+	"math"
+	"os"
+	"strconv"
+	"sync"
 	"testing"
 	"time"
 )
@@ -121,6 +127,99 @@ func TestRedisDataStructure_SAdd(t *testing.T) {
 	t.Log(ok, err)
 }
 
+func TestRedisDataStructure_SetCommands(t *testing.T) {
+	opts := bitcask.DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-redis-set")
+	opts.DirPath = dir
+	rds, err := NewRedisDataStructure(opts)
+	assert.Nil(t, err)
+	defer func() {
+		_ = rds.Close()
+		_ = os.RemoveAll(dir)
+	}()
+
+	setOf := func(members ...string) [][]byte {
+		res := make([][]byte, len(members))
+		for i, m := range members {
+			res[i] = []byte(m)
+		}
+		return res
+	}
+	key1, key2, key3 := []byte("set1"), []byte("set2"), []byte("set3")
+	for _, m := range setOf("a", "b", "c", "d") {
+		_, err = rds.SAdd(key1, m)
+		assert.Nil(t, err)
+	}
+	for _, m := range setOf("c", "d", "e") {
+		_, err = rds.SAdd(key2, m)
+		assert.Nil(t, err)
+	}
+
+	size, err := rds.SCard(key1)
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(4), size)
+	members, err := rds.SMembers(key1)
+	assert.Nil(t, err)
+	assert.Equal(t, setOf("a", "b", "c", "d"), members)
+
+	members, err = rds.SInter(key1, key2)
+	assert.Nil(t, err)
+	assert.Equal(t, setOf("c", "d"), members)
+	members, err = rds.SUnion(key1, key2)
+	assert.Nil(t, err)
+	assert.Equal(t, setOf("a", "b", "c", "d", "e"), members)
+	members, err = rds.SDiff(key1, key2, key3)
+	assert.Nil(t, err)
+	assert.Equal(t, setOf("a", "b"), members)
+	members, err = rds.SInter(key1, key3)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(members))
+
+	// destination 原来是其他类型时直接覆盖
+	err = rds.Set(key3, 0, []byte("value"))
+	assert.Nil(t, err)
+	n, err := rds.SUnionStore(key3, key1, key2)
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(5), n)
+	members, err = rds.SMembers(key3)
+	assert.Nil(t, err)
+	assert.Equal(t, setOf("a", "b", "c", "d", "e"), members)
+	n, err = rds.SDiffStore(key3, key3, key1)
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(1), n)
+	members, err = rds.SMembers(key3)
+	assert.Nil(t, err)
+	assert.Equal(t, setOf("e"), members)
+	n, err = rds.SInterStore(key3, key3, key1)
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(0), n)
+	_, err = rds.Type(key3)
+	assert.Equal(t, bitcask.ErrKeyNotFound, err)
+
+	members, err = rds.SRandMember(key1, 10)
+	assert.Nil(t, err)
+	assert.ElementsMatch(t, setOf("a", "b", "c", "d"), members)
+	members, err = rds.SRandMember(key1, -10)
+	assert.Nil(t, err)
+	assert.Equal(t, 10, len(members))
+
+	members, err = rds.SPop(key1, 3)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(members))
+	rest, err := rds.SMembers(key1)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(rest))
+	assert.ElementsMatch(t, setOf("a", "b", "c", "d"), append(members, rest...))
+	size, err = rds.SCard(key1)
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(1), size)
+
+	err = rds.Set(key3, 0, []byte("value"))
+	assert.Nil(t, err)
+	_, err = rds.SInter(key1, key3)
+	assert.Equal(t, ErrWrongTypeOperation, err)
+}
+
 func TestRedisDataStructure_List(t *testing.T) {
 	opts := bitcask.DefaultOptions
 	rds, err := NewRedisDataStructure(opts)
@@ -145,6 +244,114 @@ func TestRedisDataStructure_List(t *testing.T) {
 	t.Log(string(pop), err)
 }
 
+func TestRedisDataStructure_ListCommands(t *testing.T) {
+	opts := bitcask.DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-redis-list")
+	opts.DirPath = dir
+	rds, err := NewRedisDataStructure(opts)
+	assert.Nil(t, err)
+	defer func() {
+		_ = rds.Close()
+		_ = os.RemoveAll(dir)
+	}()
+
+	key := utils.GetTestKey(1)
+	listOf := func(elements ...string) [][]byte {
+		res := make([][]byte, len(elements))
+		for i, e := range elements {
+			res[i] = []byte(e)
+		}
+		return res
+	}
+	for _, e := range []string{"c", "b", "a"} {
+		_, err = rds.LPush(key, []byte(e))
+		assert.Nil(t, err)
+	}
+	for _, e := range []string{"d", "a", "e"} {
+		_, err = rds.RPush(key, []byte(e))
+		assert.Nil(t, err)
+	}
+
+	size, err := rds.LLen(key)
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(6), size)
+	elements, err := rds.LRange(key, 0, -1)
+	assert.Nil(t, err)
+	assert.Equal(t, listOf("a", "b", "c", "d", "a", "e"), elements)
+	elements, err = rds.LRange(key, -2, 100)
+	assert.Nil(t, err)
+	assert.Equal(t, listOf("a", "e"), elements)
+	elements, err = rds.LRange(key, 4, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(elements))
+
+	element, err := rds.LIndex(key, -1)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("e"), element)
+	element, err = rds.LIndex(key, 6)
+	assert.Nil(t, err)
+	assert.Nil(t, element)
+
+	assert.Nil(t, rds.LSet(key, 1, []byte("B")))
+	assert.Equal(t, ErrIndexOutOfRange, rds.LSet(key, -7, []byte("x")))
+	assert.Equal(t, ErrNoSuchKey, rds.LSet(utils.GetTestKey(2), 0, []byte("x")))
+
+	n, err := rds.LInsert(key, true, []byte("c"), []byte("x"))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(7), n)
+	n, err = rds.LInsert(key, false, []byte("e"), []byte("y"))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(8), n)
+	n, err = rds.LInsert(key, false, []byte("not-exist"), []byte("y"))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(-1), n)
+	elements, err = rds.LRange(key, 0, -1)
+	assert.Nil(t, err)
+	assert.Equal(t, listOf("a", "B", "x", "c", "d", "a", "e", "y"), elements)
+
+	// 从尾部删除一个
+	removed, err := rds.LRem(key, -1, []byte("a"))
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(1), removed)
+	elements, err = rds.LRange(key, 0, -1)
+	assert.Nil(t, err)
+	assert.Equal(t, listOf("a", "B", "x", "c", "d", "e", "y"), elements)
+
+	assert.Nil(t, rds.LTrim(key, 1, -2))
+	elements, err = rds.LRange(key, 0, -1)
+	assert.Nil(t, err)
+	assert.Equal(t, listOf("B", "x", "c", "d", "e"), elements)
+
+	// 同一个列表中移动相当于旋转
+	element, err = rds.LMove(key, key, false, true)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("e"), element)
+	dst := utils.GetTestKey(3)
+	element, err = rds.LMove(key, dst, true, false)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("e"), element)
+	_, err = rds.LMove(key, dst, true, false)
+	assert.Nil(t, err)
+	elements, err = rds.LRange(key, 0, -1)
+	assert.Nil(t, err)
+	assert.Equal(t, listOf("x", "c", "d"), elements)
+	elements, err = rds.LRange(dst, 0, -1)
+	assert.Nil(t, err)
+	assert.Equal(t, listOf("e", "B"), elements)
+
+	// 删除全部元素之后数据部分也被删除
+	assert.Nil(t, rds.LTrim(key, 5, 10))
+	size, err = rds.LLen(key)
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(0), size)
+	element, err = rds.LPop(key)
+	assert.Nil(t, err)
+	assert.Nil(t, element)
+	for _, listKey := range rds.db.ListKeys() {
+		assert.False(t, len(listKey) > len(key) && string(listKey[:len(key)]) == string(key))
+	}
+}
+
 func TestRedisDataStructure_ZSet(t *testing.T) {
 	opts := bitcask.DefaultOptions
 	rds, err := NewRedisDataStructure(opts)
@@ -173,3 +380,1355 @@ func TestRedisDataStructure_ZSet(t *testing.T) {
 	assert.Nil(t, err)
 
 }
+
+func TestRedisDataStructure_ZSetCommands(t *testing.T) {
+	opts := bitcask.DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-redis-zset")
+	opts.DirPath = dir
+	rds, err := NewRedisDataStructure(opts)
+	assert.Nil(t, err)
+	defer func() {
+		_ = rds.Close()
+		_ = os.RemoveAll(dir)
+	}()
+
+	key := utils.GetTestKey(1)
+	members := map[string]float64{"a": -2.5, "b": 10, "c": 1, "d": 1, "e": 100, "f": math.Inf(-1)}
+	for member, score := range members {
+		ok, err := rds.ZAdd(key, score, []byte(member))
+		assert.Nil(t, err)
+		assert.True(t, ok)
+	}
+	ok, err := rds.ZAdd(key, 9, []byte("b"))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	_, err = rds.ZAdd(key, math.NaN(), []byte("x"))
+	assert.Equal(t, ErrScoreNaN, err)
+
+	memberNames := func(res []ZSetMember) []string {
+		names := make([]string, len(res))
+		for i, m := range res {
+			names[i] = string(m.Member)
+		}
+		return names
+	}
+
+	// 分数相同时按照 member 排序
+	res, err := rds.ZRange(key, 0, -1)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"f", "a", "c", "d", "b", "e"}, memberNames(res))
+	assert.Equal(t, float64(9), res[4].Score)
+	res, err = rds.ZRevRange(key, 1, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"b", "d"}, memberNames(res))
+
+	res, err = rds.ZRangeByScore(key, ScoreRange{Min: 1, Max: 100, MaxExclusive: true}, 0, -1)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"c", "d", "b"}, memberNames(res))
+	res, err = rds.ZRangeByScore(key, ScoreRange{Min: 1, Max: math.Inf(1), MinExclusive: true}, 1, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"e"}, memberNames(res))
+	res, err = rds.ZRevRangeByScore(key, ScoreRange{Min: math.Inf(-1), Max: 1}, 0, -1)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"d", "c", "a", "f"}, memberNames(res))
+	count, err := rds.ZCount(key, ScoreRange{Min: -3, Max: 1})
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(3), count)
+
+	rank, err := rds.ZRank(key, []byte("d"))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(3), rank)
+	rank, err = rds.ZRevRank(key, []byte("d"))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), rank)
+	rank, err = rds.ZRank(key, []byte("x"))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(-1), rank)
+
+	score, err := rds.ZIncrBy(key, 95, []byte("c"))
+	assert.Nil(t, err)
+	assert.Equal(t, float64(96), score)
+	score, err = rds.ZIncrBy(key, 3, []byte("x"))
+	assert.Nil(t, err)
+	assert.Equal(t, float64(3), score)
+	_, err = rds.ZIncrBy(key, math.Inf(1), []byte("f"))
+	assert.Equal(t, ErrScoreNaN, err)
+
+	ok, err = rds.ZRem(key, []byte("x"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	card, err := rds.ZCard(key)
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(6), card)
+
+	res, err = rds.ZPopMin(key, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"f", "a"}, memberNames(res))
+	res, err = rds.ZPopMax(key, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"e"}, memberNames(res))
+	res, err = rds.ZRange(key, 0, -1)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"d", "b", "c"}, memberNames(res))
+	card, err = rds.ZCard(key)
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(3), card)
+}
+
+func TestRedisDataStructure_ZSetMigration(t *testing.T) {
+	opts := bitcask.DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-redis-zset-migration")
+	opts.DirPath = dir
+	rds, err := NewRedisDataStructure(opts)
+	assert.Nil(t, err)
+	defer func() {
+		_ = rds.Close()
+		_ = os.RemoveAll(dir)
+	}()
+
+	// 按照旧的编码写入：元数据没有 encoding 字段，分数使用文本编码
+	key := utils.GetTestKey(1)
+	meta := &metadata{dataType: ZSet, version: time.Now().UnixNano(), size: 3}
+	encMeta := meta.encode()
+	assert.Nil(t, rds.db.Put(key, encMeta[:len(encMeta)-1]))
+	prefix := (&ZSetInternalKey{key: key, version: meta.version}).encodePrefix(0)
+	for member, score := range map[string]string{"a": "10", "b": "2", "c": "-1.5"} {
+		memberKey := append(append([]byte(nil), prefix...), member...)
+		assert.Nil(t, rds.db.Put(memberKey, []byte(score)))
+		scoreKey := append(append(append([]byte(nil), prefix...), score...), member...)
+		scoreKey = binary.LittleEndian.AppendUint32(scoreKey, uint32(len(member)))
+		assert.Nil(t, rds.db.Put(scoreKey, nil))
+	}
+
+	res, err := rds.ZRange(key, 0, -1)
+	assert.Nil(t, err)
+	assert.Equal(t, []ZSetMember{{[]byte("c"), -1.5}, {[]byte("b"), 2}, {[]byte("a"), 10}}, res)
+
+	// 旧的数据部分已经删除
+	for _, k := range rds.db.ListKeys() {
+		assert.False(t, bytes.HasPrefix(k, prefix))
+	}
+	encMeta, err = rds.db.Get(key)
+	assert.Nil(t, err)
+	assert.Equal(t, zsetEncodingSortable, decodeMetadata(encMeta).encoding)
+
+	score, err := rds.ZScore(key, []byte("b"))
+	assert.Nil(t, err)
+	assert.Equal(t, float64(2), score)
+}
+
+func TestRedisDataStructure_SetNX(t *testing.T) {
+	opts := bitcask.DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-redis-setnx")
+	opts.DirPath = dir
+	rds, err := NewRedisDataStructure(opts)
+	assert.Nil(t, err)
+	defer func() {
+		_ = rds.Close()
+		_ = os.RemoveAll(dir)
+	}()
+
+	ok, err := rds.SetXX(utils.GetTestKey(1), 0, []byte("a"))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	ok, err = rds.SetNX(utils.GetTestKey(1), 0, []byte("a"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	ok, err = rds.SetNX(utils.GetTestKey(1), 0, []byte("b"))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	ok, err = rds.SetXX(utils.GetTestKey(1), 0, []byte("c"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	val, err := rds.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("c"), val)
+
+	// 已经过期的 key 视为不存在
+	err = rds.Set(utils.GetTestKey(2), time.Millisecond, []byte("a"))
+	assert.Nil(t, err)
+	time.Sleep(time.Millisecond * 5)
+	ok, err = rds.SetNX(utils.GetTestKey(2), 0, []byte("b"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+}
+
+func TestRedisDataStructure_HashCommands(t *testing.T) {
+	opts := bitcask.DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-redis-hash")
+	opts.DirPath = dir
+	rds, err := NewRedisDataStructure(opts)
+	assert.Nil(t, err)
+	defer func() {
+		_ = rds.Close()
+		_ = os.RemoveAll(dir)
+	}()
+
+	key := utils.GetTestKey(1)
+	n, err := rds.HMSet(key, [][]byte{[]byte("b"), []byte("2"), []byte("a"), []byte("1"), []byte("b"), []byte("3")})
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(2), n)
+	_, err = rds.HMSet(key, [][]byte{[]byte("c")})
+	assert.Equal(t, ErrWrongNumberOfArgs, err)
+
+	size, err := rds.HLen(key)
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(2), size)
+
+	// 按 field 的顺序返回
+	all, err := rds.HGetAll(key)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("a"), []byte("1"), []byte("b"), []byte("3")}, all)
+	keys, err := rds.HKeys(key)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("a"), []byte("b")}, keys)
+	vals, err := rds.HVals(key)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("1"), []byte("3")}, vals)
+
+	values, err := rds.HMGet(key, [][]byte{[]byte("a"), []byte("x"), []byte("b")})
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("1"), nil, []byte("3")}, values)
+
+	ok, err := rds.HExists(key, []byte("a"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	ok, err = rds.HSetNX(key, []byte("a"), []byte("100"))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	ok, err = rds.HSetNX(key, []byte("c"), []byte("100"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+
+	num, err := rds.HIncrBy(key, []byte("c"), -101)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(-1), num)
+	num, err = rds.HIncrBy(key, []byte("d"), 5)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(5), num)
+	_, err = rds.HIncrBy(key, []byte("d"), math.MaxInt64)
+	assert.Equal(t, ErrIncrOverflow, err)
+	_, err = rds.HSet(key, []byte("e"), []byte("abc"))
+	assert.Nil(t, err)
+	_, err = rds.HIncrBy(key, []byte("e"), 1)
+	assert.Equal(t, ErrHashValueNotInteger, err)
+	_, err = rds.HIncrByFloat(key, []byte("e"), 1)
+	assert.Equal(t, ErrHashValueNotFloat, err)
+	f, err := rds.HIncrByFloat(key, []byte("d"), 0.5)
+	assert.Nil(t, err)
+	assert.Equal(t, 5.5, f)
+	value, err := rds.HGet(key, []byte("d"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("5.5"), value)
+
+	// 删除之后数量和遍历结果都更新
+	ok, err = rds.HDel(key, []byte("e"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	size, err = rds.HLen(key)
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(4), size)
+
+	// 分多次遍历
+	var fields [][]byte
+	var cursor uint64
+	for {
+		next, res, err := rds.HScan(key, cursor, nil, 3)
+		assert.Nil(t, err)
+		for i := 0; i < len(res); i += 2 {
+			fields = append(fields, res[i])
+		}
+		if next == 0 {
+			break
+		}
+		cursor = next
+	}
+	assert.Equal(t, [][]byte{[]byte("a"), []byte("b"), []byte("c"), []byte("d")}, fields)
+	_, res, err := rds.HScan(key, 0, []byte("[ac]"), 0)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("a"), []byte("1"), []byte("c"), []byte("-1")}, res)
+
+	// 不存在的 key 和其他类型的 key
+	all, err = rds.HGetAll(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(all))
+	err = rds.Set(utils.GetTestKey(3), 0, []byte("a"))
+	assert.Nil(t, err)
+	_, err = rds.HLen(utils.GetTestKey(3))
+	assert.Equal(t, ErrWrongTypeOperation, err)
+}
+
+func TestStringMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		str     string
+		match   bool
+	}{
+		{"*", "", true},
+		{"*", "user:1/name", true},
+		{"user:*", "user:1", true},
+		{"user:*", "users", false},
+		{"h?llo", "hello", true},
+		{"h?llo", "hllo", false},
+		{"h*llo", "heeeello", true},
+		{"h[ae]llo", "hallo", true},
+		{"h[ae]llo", "hillo", false},
+		{"h[^e]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-b]llo", "hbllo", true},
+		{"h[a-b]llo", "hcllo", false},
+		{"h\\*llo", "h*llo", true},
+		{"h\\*llo", "hello", false},
+		{"**a", "bba", true},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.match, stringMatch([]byte(test.pattern), []byte(test.str)), test.pattern+" "+test.str)
+	}
+}
+
+func TestRedisDataStructure_Expire(t *testing.T) {
+	opts := bitcask.DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-redis-expire")
+	opts.DirPath = dir
+	rds, err := NewRedisDataStructure(opts)
+	assert.Nil(t, err)
+	defer func() {
+		_ = rds.Close()
+		_ = os.RemoveAll(dir)
+	}()
+
+	strKey, hashKey, listKey, setKey, zsetKey := []byte("str"), []byte("hash"), []byte("list"), []byte("set"), []byte("zset")
+	assert.Nil(t, rds.Set(strKey, 0, []byte("value")))
+	_, err = rds.HSet(hashKey, []byte("field"), []byte("value"))
+	assert.Nil(t, err)
+	_, err = rds.RPush(listKey, []byte("value"))
+	assert.Nil(t, err)
+	_, err = rds.SAdd(setKey, []byte("value"))
+	assert.Nil(t, err)
+	_, err = rds.ZAdd(zsetKey, 1, []byte("value"))
+	assert.Nil(t, err)
+
+	for _, key := range [][]byte{strKey, hashKey, listKey, setKey, zsetKey} {
+		ttl, err := rds.TTL(key)
+		assert.Nil(t, err)
+		assert.Equal(t, NoExpiration, ttl)
+
+		ok, err := rds.Expire(key, time.Hour)
+		assert.Nil(t, err)
+		assert.True(t, ok)
+		ttl, err = rds.TTL(key)
+		assert.Nil(t, err)
+		assert.True(t, ttl > time.Hour-time.Minute && ttl <= time.Hour)
+
+		ok, err = rds.Persist(key)
+		assert.Nil(t, err)
+		assert.True(t, ok)
+		ok, err = rds.Persist(key)
+		assert.Nil(t, err)
+		assert.False(t, ok)
+
+		ok, err = rds.Expire(key, time.Millisecond)
+		assert.Nil(t, err)
+		assert.True(t, ok)
+	}
+	time.Sleep(time.Millisecond * 5)
+
+	// 过期之后视为不存在，数据部分不再可见
+	for _, key := range [][]byte{strKey, hashKey, listKey, setKey, zsetKey} {
+		_, err = rds.TTL(key)
+		assert.Equal(t, bitcask.ErrKeyNotFound, err)
+		ok, err := rds.Expire(key, time.Hour)
+		assert.Nil(t, err)
+		assert.False(t, ok)
+	}
+	value, err := rds.Get(strKey)
+	assert.Nil(t, err)
+	assert.Nil(t, value)
+	size, err := rds.HLen(hashKey)
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(0), size)
+	members, err := rds.SMembers(setKey)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(members))
+
+	// 过期时间点已经过去时直接删除
+	_, err = rds.HSet(hashKey, []byte("field"), []byte("value"))
+	assert.Nil(t, err)
+	ok, err := rds.ExpireAt(hashKey, time.Now().Add(-time.Second))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	_, err = rds.Type(hashKey)
+	assert.Equal(t, bitcask.ErrKeyNotFound, err)
+
+	// SET ... GET
+	old, ok, err := rds.SetGet(strKey, time.Hour, []byte("v1"), false, false)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Nil(t, old)
+	old, ok, err = rds.SetGet(strKey, 0, []byte("v2"), true, false)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	assert.Equal(t, []byte("v1"), old)
+	old, ok, err = rds.SetGet(strKey, 0, []byte("v3"), false, true)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, []byte("v1"), old)
+	ttl, err := rds.TTL(strKey)
+	assert.Nil(t, err)
+	assert.Equal(t, NoExpiration, ttl)
+	_, _, err = rds.SetGet(listKey, 0, []byte("v"), false, false)
+	assert.Nil(t, err)
+	_, err = rds.RPush(listKey, []byte("value"))
+	assert.Equal(t, ErrWrongTypeOperation, err)
+	_, _, err = rds.SetGet(zsetKey, 0, []byte("v"), false, false)
+	assert.Nil(t, err)
+	_, err = rds.ZAdd(setKey, 1, []byte("value"))
+	assert.Nil(t, err)
+	_, _, err = rds.SetGet(setKey, 0, []byte("v"), false, false)
+	assert.Equal(t, ErrWrongTypeOperation, err)
+}
+
+func TestRedisDataStructure_Reclaim(t *testing.T) {
+	opts := bitcask.DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-redis-reclaim")
+	opts.DirPath = dir
+	rds, err := NewRedisDataStructure(opts)
+	assert.Nil(t, err)
+	defer func() {
+		_ = rds.Close()
+		_ = os.RemoveAll(dir)
+	}()
+	// 停止后台任务，直接调用每一轮的处理
+	rds.stopReclaimer()
+
+	// 统计用户的 key 和数据部分的数量
+	countKeys := func() int {
+		var n int
+		for _, key := range rds.db.ListKeys() {
+			if !isInternalKey(key) || isElementKey(key) {
+				n++
+			}
+		}
+		return n
+	}
+
+	hashKey, listKey := []byte("hash"), []byte("list")
+	for i := 0; i < 3; i++ {
+		_, err = rds.HSet(hashKey, []byte{byte('a' + i)}, []byte("value"))
+		assert.Nil(t, err)
+		_, err = rds.RPush(listKey, []byte("value"))
+		assert.Nil(t, err)
+	}
+	assert.Equal(t, 8, countKeys())
+
+	// 删除和覆盖之后数据部分等待回收
+	assert.Nil(t, rds.Del(hashKey))
+	assert.Nil(t, rds.Set(listKey, 0, []byte("value")))
+	assert.Equal(t, int64(2), rds.Stats().PendingVersions)
+	assert.Equal(t, 7, countKeys())
+
+	n, err := rds.reclaimStaleVersions()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, 1, countKeys())
+	stats := rds.Stats()
+	assert.Equal(t, int64(2), stats.ReclaimedVersions)
+	assert.Equal(t, int64(6), stats.ReclaimedKeys)
+	assert.Equal(t, int64(0), stats.PendingVersions)
+
+	// 仍然有效的版本不会被回收
+	setKey := []byte("set")
+	_, err = rds.SAdd(setKey, []byte("member"))
+	assert.Nil(t, err)
+	meta, err := rds.findMetadata(setKey, Set)
+	assert.Nil(t, err)
+	assert.Nil(t, rds.markStaleVersion(setKey, meta.version))
+	_, err = rds.reclaimStaleVersions()
+	assert.Nil(t, err)
+	ok, err := rds.SIsMember(setKey, []byte("member"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+
+	// 主动过期删除过期的 key，复合类型的数据部分交给回收任务
+	_, err = rds.Expire(setKey, time.Millisecond)
+	assert.Nil(t, err)
+	for i := 0; i < activeExpireSamples+5; i++ {
+		assert.Nil(t, rds.Set([]byte{'k', byte(i)}, time.Millisecond, []byte("value")))
+	}
+	assert.Nil(t, rds.Set([]byte("persist"), time.Hour, []byte("value")))
+	_, err = rds.Persist([]byte("persist"))
+	assert.Nil(t, err)
+	time.Sleep(5 * time.Millisecond)
+
+	var expired int
+	for i := 0; i < 3; i++ {
+		n, err = rds.activeExpireCycle()
+		assert.Nil(t, err)
+		expired += n
+	}
+	assert.Equal(t, activeExpireSamples+6, expired)
+	assert.Equal(t, int64(expired), rds.Stats().ExpiredKeys)
+	_, err = rds.db.Get(setKey)
+	assert.Equal(t, bitcask.ErrKeyNotFound, err)
+
+	_, err = rds.reclaimStaleVersions()
+	assert.Nil(t, err)
+	// 剩下 list 和 persist
+	assert.Equal(t, 2, countKeys())
+	// persist 的过期记录要到原来的过期时间才会被清理
+	var internal int
+	for _, key := range rds.db.ListKeys() {
+		if bytes.HasPrefix(key, expireIndexPrefix) {
+			internal++
+		}
+	}
+	assert.Equal(t, 1, internal)
+}
+
+func TestRedisDataStructure_StringCommands(t *testing.T) {
+	opts := bitcask.DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-redis-string")
+	opts.DirPath = dir
+	rds, err := NewRedisDataStructure(opts)
+	assert.Nil(t, err)
+	defer func() {
+		_ = rds.Close()
+		_ = os.RemoveAll(dir)
+	}()
+
+	// 1.数值类型的命令，保留原来的过期时间
+	key := []byte("counter")
+	n, err := rds.Incr(key)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), n)
+	n, err = rds.IncrBy(key, 10)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(11), n)
+	n, err = rds.DecrBy(key, 20)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(-9), n)
+	n, err = rds.Decr(key)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(-10), n)
+	_, err = rds.DecrBy(key, math.MinInt64)
+	assert.Equal(t, ErrIncrOverflow, err)
+
+	_, err = rds.Expire(key, time.Hour)
+	assert.Nil(t, err)
+	_, err = rds.Incr(key)
+	assert.Nil(t, err)
+	ttl, err := rds.TTL(key)
+	assert.Nil(t, err)
+	assert.True(t, ttl > time.Minute)
+
+	assert.Nil(t, rds.Set(key, 0, []byte(strconv.FormatInt(math.MaxInt64, 10))))
+	_, err = rds.Incr(key)
+	assert.Equal(t, ErrIncrOverflow, err)
+	assert.Nil(t, rds.Set(key, 0, []byte("abc")))
+	_, err = rds.Incr(key)
+	assert.Equal(t, ErrValueNotInteger, err)
+
+	f, err := rds.IncrByFloat([]byte("float"), 10.5)
+	assert.Nil(t, err)
+	assert.Equal(t, 10.5, f)
+	f, err = rds.IncrByFloat([]byte("float"), -0.25)
+	assert.Nil(t, err)
+	assert.Equal(t, 10.25, f)
+	value, err := rds.Get([]byte("float"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("10.25"), value)
+	_, err = rds.IncrByFloat(key, 1)
+	assert.Equal(t, ErrValueNotFloat, err)
+
+	// 2.长度和范围
+	key = []byte("str")
+	size, err := rds.Append(key, []byte("Hello"))
+	assert.Nil(t, err)
+	assert.Equal(t, 5, size)
+	size, err = rds.Append(key, []byte(" World"))
+	assert.Nil(t, err)
+	assert.Equal(t, 11, size)
+	size, err = rds.StrLen(key)
+	assert.Nil(t, err)
+	assert.Equal(t, 11, size)
+	size, err = rds.StrLen([]byte("not-exist"))
+	assert.Nil(t, err)
+	assert.Equal(t, 0, size)
+
+	value, err = rds.GetRange(key, 0, 4)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("Hello"), value)
+	value, err = rds.GetRange(key, -5, -1)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("World"), value)
+	value, err = rds.GetRange(key, 5, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{}, value)
+
+	size, err = rds.SetRange(key, 6, []byte("Redis"))
+	assert.Nil(t, err)
+	assert.Equal(t, 11, size)
+	size, err = rds.SetRange([]byte("pad"), 3, []byte("a"))
+	assert.Nil(t, err)
+	assert.Equal(t, 4, size)
+	value, err = rds.Get([]byte("pad"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{0, 0, 0, 'a'}, value)
+	size, err = rds.SetRange([]byte("empty"), 3, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, size)
+	_, err = rds.db.Get([]byte("empty"))
+	assert.Equal(t, bitcask.ErrKeyNotFound, err)
+	_, err = rds.SetRange(key, -1, []byte("a"))
+	assert.Equal(t, ErrOffsetOutOfRange, err)
+
+	// 3.GetDel 和 GetEx
+	value, err = rds.GetEx(key, time.Now().Add(time.Hour), false)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("Hello Redis"), value)
+	ttl, err = rds.TTL(key)
+	assert.Nil(t, err)
+	assert.True(t, ttl > time.Minute)
+	_, err = rds.GetEx(key, time.Time{}, true)
+	assert.Nil(t, err)
+	ttl, err = rds.TTL(key)
+	assert.Nil(t, err)
+	assert.Equal(t, NoExpiration, ttl)
+	value, err = rds.GetEx(key, time.Now().Add(-time.Second), false)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("Hello Redis"), value)
+	_, err = rds.TTL(key)
+	assert.Equal(t, bitcask.ErrKeyNotFound, err)
+
+	assert.Nil(t, rds.Set(key, 0, []byte("value")))
+	value, err = rds.GetDel(key)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("value"), value)
+	value, err = rds.GetDel(key)
+	assert.Nil(t, err)
+	assert.Nil(t, value)
+
+	// 4.过期的复合类型视为不存在
+	hashKey := []byte("hash")
+	_, err = rds.HSet(hashKey, []byte("field"), []byte("value"))
+	assert.Nil(t, err)
+	_, err = rds.Incr(hashKey)
+	assert.Equal(t, ErrWrongTypeOperation, err)
+	_, err = rds.Expire(hashKey, time.Millisecond)
+	assert.Nil(t, err)
+	time.Sleep(2 * time.Millisecond)
+	value, err = rds.Get(hashKey)
+	assert.Nil(t, err)
+	assert.Nil(t, value)
+	size, err = rds.Append(hashKey, []byte("value"))
+	assert.Nil(t, err)
+	assert.Equal(t, 5, size)
+
+	// 5.批量写入
+	assert.Nil(t, rds.MSet([][]byte{[]byte("a"), []byte("1"), []byte("b"), []byte("2")}))
+	values, err := rds.MGet([][]byte{[]byte("a"), []byte("b"), []byte("c")})
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("1"), []byte("2"), nil}, values)
+	assert.Equal(t, ErrWrongNumberOfArgs, rds.MSet([][]byte{[]byte("a")}))
+
+	ok, err := rds.MSetNX([][]byte{[]byte("b"), []byte("3"), []byte("c"), []byte("3")})
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	_, err = rds.db.Get([]byte("c"))
+	assert.Equal(t, bitcask.ErrKeyNotFound, err)
+	ok, err = rds.MSetNX([][]byte{[]byte("c"), []byte("3"), []byte("d"), []byte("4")})
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	values, err = rds.MGet([][]byte{[]byte("c"), []byte("d")})
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("3"), []byte("4")}, values)
+}
+
+func TestRedisDataStructure_KeyspaceCommands(t *testing.T) {
+	opts := bitcask.DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-redis-keyspace")
+	opts.DirPath = dir
+	rds, err := NewRedisDataStructure(opts)
+	assert.Nil(t, err)
+	defer func() {
+		_ = rds.Close()
+		_ = os.RemoveAll(dir)
+	}()
+
+	assert.Nil(t, rds.Set([]byte("str"), 0, []byte("value")))
+	assert.Nil(t, rds.Set([]byte("expired"), time.Millisecond, []byte("value")))
+	for i := 0; i < 3; i++ {
+		_, err = rds.HSet([]byte("hash"), []byte{byte('a' + i)}, []byte("value"))
+		assert.Nil(t, err)
+		_, err = rds.SAdd([]byte("set"), []byte{byte('a' + i)})
+		assert.Nil(t, err)
+	}
+	_, err = rds.ZAdd([]byte("zset"), 1, []byte("member"))
+	assert.Nil(t, err)
+	time.Sleep(2 * time.Millisecond)
+
+	// 1.只返回用户的 key，不包括数据部分和过期的 key
+	keys, err := rds.Keys(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("hash"), []byte("set"), []byte("str"), []byte("zset")}, keys)
+	keys, err = rds.Keys([]byte("*s*t"))
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("set"), []byte("zset")}, keys)
+	size, err := rds.DBSize()
+	assert.Nil(t, err)
+	assert.Equal(t, 4, size)
+
+	n, err := rds.Exists([]byte("str"), []byte("str"), []byte("expired"), []byte("not-exist"))
+	assert.Nil(t, err)
+	assert.Equal(t, 2, n)
+	_, err = rds.Type([]byte("expired"))
+	assert.Equal(t, bitcask.ErrKeyNotFound, err)
+
+	// 2.分批遍历
+	var all [][]byte
+	var cursor uint64
+	for {
+		var res [][]byte
+		cursor, res, err = rds.Scan(cursor, nil, 2, AnyType)
+		assert.Nil(t, err)
+		all = append(all, res...)
+		if cursor == 0 {
+			break
+		}
+	}
+	assert.Equal(t, 4, len(all))
+	_, keys, err = rds.Scan(0, nil, 100, Hash)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("hash")}, keys)
+	_, keys, err = rds.Scan(0, []byte("z*"), 100, AnyType)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("zset")}, keys)
+
+	key, err := rds.RandomKey()
+	assert.Nil(t, err)
+	assert.Contains(t, all, key)
+
+	// 3.改名时移动复合类型的数据部分
+	assert.Nil(t, rds.Rename([]byte("hash"), []byte("hash2")))
+	value, err := rds.HGet([]byte("hash2"), []byte("b"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("value"), value)
+	n, err = rds.Exists([]byte("hash"))
+	assert.Nil(t, err)
+	assert.Equal(t, 0, n)
+	assert.Equal(t, ErrNoSuchKey, rds.Rename([]byte("hash"), []byte("hash3")))
+
+	// 覆盖原来的值
+	assert.Nil(t, rds.Rename([]byte("hash2"), []byte("set")))
+	typ, err := rds.Type([]byte("set"))
+	assert.Nil(t, err)
+	assert.Equal(t, Hash, typ)
+	assert.Equal(t, int64(1), rds.Stats().PendingVersions)
+
+	ok, err := rds.RenameNX([]byte("set"), []byte("str"))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	ok, err = rds.RenameNX([]byte("str"), []byte("str2"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	value, err = rds.Get([]byte("str2"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("value"), value)
+
+	n, err = rds.DelKeys([]byte("str2"), []byte("str2"), []byte("not-exist"))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n)
+
+	// 4.清空所有的 key
+	assert.Nil(t, rds.FlushDB())
+	size, err = rds.DBSize()
+	assert.Nil(t, err)
+	assert.Equal(t, 0, size)
+	assert.Equal(t, [][]byte{keyspaceVersionKey}, rds.db.ListKeys())
+	key, err = rds.RandomKey()
+	assert.Nil(t, err)
+	assert.Nil(t, key)
+}
+
+func TestRedisDataStructure_KeyspaceMigration(t *testing.T) {
+	opts := bitcask.DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-redis-keyspace-migration")
+	opts.DirPath = dir
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+
+	// 按照旧的编码写入：数据部分的 key 直接以用户的 key 开头
+	db, err := bitcask.Open(opts)
+	assert.Nil(t, err)
+	meta := &metadata{dataType: Hash, version: time.Now().UnixNano(), size: 2}
+	assert.Nil(t, db.Put([]byte("hash"), meta.encode()))
+	for _, field := range []string{"a", "b"} {
+		hk := legacyElementKey(nil, []byte("hash"), meta.version, []byte(field))
+		assert.Nil(t, db.Put(hk, []byte("value-"+field)))
+	}
+	// 已经删除的版本
+	staleKey := legacyElementKey(nil, []byte("hash"), meta.version-1, []byte("c"))
+	assert.Nil(t, db.Put(staleKey, []byte("value")))
+	assert.Nil(t, db.Put(staleVersionKey([]byte("hash"), meta.version-1), nil))
+	assert.Nil(t, db.Put([]byte("str"), encodeStringValue([]byte("value"), 0)))
+	assert.Nil(t, db.Close())
+
+	// 只读模式下不能升级
+	opts.ReadOnly = true
+	_, err = NewRedisDataStructure(opts)
+	assert.Equal(t, ErrKeyspaceUpgradeRequired, err)
+
+	opts.ReadOnly = false
+	rds, err := NewRedisDataStructure(opts)
+	assert.Nil(t, err)
+	defer func() {
+		_ = rds.Close()
+	}()
+
+	keys, err := rds.Keys(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("hash"), []byte("str")}, keys)
+	values, err := rds.HMGet([]byte("hash"), [][]byte{[]byte("a"), []byte("b"), []byte("c")})
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("value-a"), []byte("value-b"), nil}, values)
+	for _, key := range rds.db.ListKeys() {
+		assert.False(t, bytes.HasPrefix(key, []byte("hash")) && len(key) > len("hash"))
+	}
+	value, err := rds.db.Get(keyspaceVersionKey)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{keyspaceVersionLengthPrefixed}, value)
+}
+
+// 旧的编码方式下数据部分的 key：namespace + key + version + suffix
+func legacyElementKey(namespace, key []byte, version int64, suffix []byte) []byte {
+	buf := append(append([]byte(nil), namespace...), key...)
+	buf = binary.LittleEndian.AppendUint64(buf, uint64(version))
+	return append(buf, suffix...)
+}
+
+func TestRedisDataStructure_KeyspaceMigrationSeparated(t *testing.T) {
+	opts := bitcask.DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-redis-keyspace-separated")
+	opts.DirPath = dir
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+
+	// 按照版本 1 的编码写入：数据部分的 key 没有长度
+	// long 以 short + version 开头，long 的数据部分也以 short 的数据部分的前缀开头
+	db, err := bitcask.Open(opts)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Put(keyspaceVersionKey, []byte{keyspaceVersionSeparated}))
+	version := time.Now().UnixNano()
+	short := []byte("a")
+	long := binary.LittleEndian.AppendUint64(append([]byte(nil), short...), uint64(version))
+	for _, key := range [][]byte{short, long} {
+		meta := &metadata{dataType: Hash, version: version, size: 1}
+		assert.Nil(t, db.Put(key, meta.encode()))
+		hk := legacyElementKey(separatedElementKeyPrefix, key, version, []byte("field"))
+		assert.Nil(t, db.Put(hk, []byte("value-"+strconv.Itoa(len(key)))))
+	}
+	// 已经删除的版本
+	assert.Nil(t, db.Put(legacyElementKey(separatedElementKeyPrefix, short, version-1, []byte("c")), []byte("value")))
+	assert.Nil(t, db.Put(staleVersionKey(short, version-1), nil))
+	assert.Nil(t, db.Close())
+
+	rds, err := NewRedisDataStructure(opts)
+	assert.Nil(t, err)
+	defer func() {
+		_ = rds.Close()
+	}()
+
+	for _, key := range [][]byte{short, long} {
+		all, err := rds.HGetAll(key)
+		assert.Nil(t, err)
+		assert.Equal(t, [][]byte{[]byte("field"), []byte("value-" + strconv.Itoa(len(key)))}, all)
+	}
+	for _, key := range rds.db.ListKeys() {
+		assert.False(t, bytes.HasPrefix(key, separatedElementKeyPrefix))
+	}
+	value, err := rds.db.Get(keyspaceVersionKey)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{keyspaceVersionLengthPrefixed}, value)
+
+	// 回收 short 的数据部分不会删除 long 的数据部分
+	assert.Nil(t, rds.Del(short))
+	_, err = rds.reclaimStaleVersions()
+	assert.Nil(t, err)
+	all, err := rds.HGetAll(long)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("field"), []byte("value-" + strconv.Itoa(len(long)))}, all)
+}
+
+func TestRedisDataStructure_Move(t *testing.T) {
+	var dbs []*RedisDataStructure
+	for i := 0; i < 2; i++ {
+		opts := bitcask.DefaultOptions
+		dir, _ := os.MkdirTemp("", "bitcask-go-redis-move")
+		opts.DirPath = dir
+		rds, err := NewRedisDataStructure(opts)
+		assert.Nil(t, err)
+		defer func() {
+			_ = rds.Close()
+			_ = os.RemoveAll(dir)
+		}()
+		dbs = append(dbs, rds)
+	}
+	src, dst := dbs[0], dbs[1]
+
+	_, err := src.RPush([]byte("list"), []byte("a"))
+	assert.Nil(t, err)
+	_, err = src.RPush([]byte("list"), []byte("b"))
+	assert.Nil(t, err)
+	_, err = src.Expire([]byte("list"), time.Hour)
+	assert.Nil(t, err)
+	assert.Nil(t, src.Set([]byte("str"), 0, []byte("value")))
+	assert.Nil(t, dst.Set([]byte("str"), 0, []byte("other")))
+
+	ok, err := src.Move([]byte("list"), dst)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	values, err := dst.LRange([]byte("list"), 0, -1)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("a"), []byte("b")}, values)
+	n, err := src.Exists([]byte("list"))
+	assert.Nil(t, err)
+	assert.Equal(t, 0, n)
+
+	keys, expires, err := dst.KeyspaceStats()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, keys)
+	assert.Equal(t, 1, expires)
+
+	// 目标数据库中已经存在时不移动
+	ok, err = src.Move([]byte("str"), dst)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	ok, err = src.Move([]byte("not-exist"), dst)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	_, err = src.Move([]byte("str"), src)
+	assert.Equal(t, ErrSameObject, err)
+}
+
+func TestRedisDataStructure_KeyspaceStats(t *testing.T) {
+	opts := bitcask.DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-redis-keyspace-stats")
+	opts.DirPath = dir
+	rds, err := NewRedisDataStructure(opts)
+	assert.Nil(t, err)
+	defer func() {
+		_ = rds.Close()
+		_ = os.RemoveAll(dir)
+	}()
+	rds.stopReclaimer()
+
+	// 计数和遍历所有 key 的结果一致
+	checkStats := func(keys, expires int, pending int64) {
+		n, e, err := rds.KeyspaceStats()
+		assert.Nil(t, err)
+		assert.Equal(t, keys, n)
+		assert.Equal(t, expires, e)
+		assert.Equal(t, pending, rds.Stats().PendingVersions)
+		c, err := rds.countKeyspace()
+		assert.Nil(t, err)
+		assert.Equal(t, int64(keys), c.keys)
+		assert.Equal(t, int64(expires), c.expires)
+		assert.Equal(t, pending, c.pending)
+	}
+
+	assert.Nil(t, rds.Set([]byte("str"), 0, []byte("value")))
+	assert.Nil(t, rds.Set([]byte("ttl"), 50*time.Millisecond, []byte("value")))
+	_, err = rds.HSet([]byte("hash"), []byte("f"), []byte("v"))
+	assert.Nil(t, err)
+	_, err = rds.RPush([]byte("list"), []byte("a"))
+	assert.Nil(t, err)
+	_, err = rds.Expire([]byte("list"), time.Hour)
+	assert.Nil(t, err)
+	checkStats(4, 2, 0)
+
+	// 复合类型的最后一个元素被删除之后 key 不存在
+	_, err = rds.LPop([]byte("list"))
+	assert.Nil(t, err)
+	checkStats(3, 1, 0)
+	assert.Nil(t, rds.Del([]byte("hash")))
+	checkStats(2, 1, 1)
+
+	txn := rds.Begin()
+	assert.Nil(t, txn.Set([]byte("txn"), 0, []byte("value")))
+	assert.Nil(t, txn.Commit())
+	checkStats(3, 1, 1)
+
+	// 主动过期和回收同样更新计数
+	time.Sleep(60 * time.Millisecond)
+	_, err = rds.activeExpireCycle()
+	assert.Nil(t, err)
+	checkStats(2, 0, 1)
+	_, err = rds.reclaimStaleVersions()
+	assert.Nil(t, err)
+	checkStats(2, 0, 0)
+
+	// 重新打开时加载计数
+	assert.Nil(t, rds.Close())
+	rds, err = NewRedisDataStructure(opts)
+	assert.Nil(t, err)
+	rds.stopReclaimer()
+	checkStats(2, 0, 0)
+}
+
+func TestRedisDataStructure_Txn(t *testing.T) {
+	opts := bitcask.DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-redis-txn")
+	opts.DirPath = dir
+	rds, err := NewRedisDataStructure(opts)
+	assert.Nil(t, err)
+	defer func() {
+		_ = rds.Close()
+		_ = os.RemoveAll(dir)
+	}()
+
+	assert.Nil(t, rds.Set([]byte("str"), 0, []byte("1")))
+	_, err = rds.RPush([]byte("list"), []byte("a"))
+	assert.Nil(t, err)
+	_, err = rds.ZAdd([]byte("zset"), 2, []byte("b"))
+	assert.Nil(t, err)
+
+	txn := rds.Begin()
+	n, err := txn.Incr([]byte("str"))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), n)
+	_, err = txn.RPush([]byte("list"), []byte("b"))
+	assert.Nil(t, err)
+	_, err = txn.ZAdd([]byte("zset"), 1, []byte("a"))
+	assert.Nil(t, err)
+	_, err = txn.ZAdd([]byte("zset"), 3, []byte("c"))
+	assert.Nil(t, err)
+	_, err = txn.HSet([]byte("hash"), []byte("f"), []byte("v"))
+	assert.Nil(t, err)
+
+	// 事务中能够读取到暂存的写入
+	values, err := txn.LRange([]byte("list"), 0, -1)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("a"), []byte("b")}, values)
+	members, err := txn.ZRevRange([]byte("zset"), 0, -1)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(members))
+	assert.Equal(t, []byte("c"), members[0].Member)
+	assert.Equal(t, []byte("a"), members[2].Member)
+	keys, err := txn.Keys([]byte("*"))
+	assert.Nil(t, err)
+	assert.Equal(t, 4, len(keys))
+
+	// 提交之前不会写入存储引擎
+	value, err := rds.Get([]byte("str"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("1"), value)
+	value, err = rds.HGet([]byte("hash"), []byte("f"))
+	assert.Nil(t, err)
+	assert.Nil(t, value)
+
+	assert.Nil(t, txn.Commit())
+	assert.Equal(t, ErrTxnFinished, txn.Commit())
+
+	value, err = rds.Get([]byte("str"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("2"), value)
+	values, err = rds.LRange([]byte("list"), 0, -1)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("a"), []byte("b")}, values)
+	members, err = rds.ZRange([]byte("zset"), 0, -1)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(members))
+	value, err = rds.HGet([]byte("hash"), []byte("f"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v"), value)
+
+	// 删除在提交之前对存储引擎不可见，事务中已经读取不到
+	txn = rds.Begin()
+	n2, err := txn.DelKeys([]byte("list"), []byte("str"))
+	assert.Nil(t, err)
+	assert.Equal(t, 2, n2)
+	n2, err = txn.Exists([]byte("list"), []byte("str"), []byte("zset"))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n2)
+	n2, err = rds.Exists([]byte("list"), []byte("str"))
+	assert.Nil(t, err)
+	assert.Equal(t, 2, n2)
+	assert.Nil(t, txn.Commit())
+	n2, err = rds.Exists([]byte("list"), []byte("str"))
+	assert.Nil(t, err)
+	assert.Equal(t, 0, n2)
+}
+
+func TestRedisDataStructure_TxnConflict(t *testing.T) {
+	opts := bitcask.DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-redis-txn-conflict")
+	opts.DirPath = dir
+	rds, err := NewRedisDataStructure(opts)
+	assert.Nil(t, err)
+	defer func() {
+		_ = rds.Close()
+		_ = os.RemoveAll(dir)
+	}()
+
+	assert.Nil(t, rds.Set([]byte("str"), 0, []byte("1")))
+
+	// 读取过的 key 在提交之前被修改，提交失败并且不写入任何数据
+	txn := rds.Begin()
+	value, err := txn.Get([]byte("str"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("1"), value)
+	assert.Nil(t, txn.Set([]byte("other"), 0, []byte("value")))
+	assert.Nil(t, rds.Set([]byte("str"), 0, []byte("2")))
+	assert.Equal(t, bitcask.ErrBatchConditionFailed, txn.Commit())
+	n, err := rds.Exists([]byte("other"))
+	assert.Nil(t, err)
+	assert.Equal(t, 0, n)
+
+	// 读取时不存在的 key 在提交之前被创建
+	txn = rds.Begin()
+	n, err = txn.Exists([]byte("new"))
+	assert.Nil(t, err)
+	assert.Equal(t, 0, n)
+	assert.Nil(t, rds.Set([]byte("new"), 0, []byte("value")))
+	assert.Equal(t, bitcask.ErrBatchConditionFailed, txn.Commit())
+
+	// 没有并发修改时正常提交
+	txn = rds.Begin()
+	_, err = txn.Incr([]byte("str"))
+	assert.Nil(t, err)
+	assert.Nil(t, txn.Commit())
+	value, err = rds.Get([]byte("str"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("3"), value)
+}
+
+func TestRedisDataStructure_HashConcurrent(t *testing.T) {
+	opts := bitcask.DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-redis-hash-concurrent")
+	opts.DirPath = dir
+	rds, err := NewRedisDataStructure(opts)
+	assert.Nil(t, err)
+	defer func() {
+		_ = rds.Close()
+		_ = os.RemoveAll(dir)
+	}()
+
+	// 并发修改同一个 Hash，不会丢失更新，元数据中的数量正确
+	key := []byte("hash")
+	wg := new(sync.WaitGroup)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				_, err := rds.HIncrBy(key, []byte("counter"), 1)
+				assert.Nil(t, err)
+				_, err = rds.HSetNX(key, []byte(strconv.Itoa(j)), []byte("value"))
+				assert.Nil(t, err)
+				_, err = rds.HSet(key, []byte(strconv.Itoa(i*50+j)), []byte("value"))
+				assert.Nil(t, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	value, err := rds.HGet(key, []byte("counter"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("400"), value)
+	size, err := rds.HLen(key)
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(401), size)
+	fields, err := rds.HKeys(key)
+	assert.Nil(t, err)
+	assert.Equal(t, 401, len(fields))
+}
+
+func TestRedisDataStructure_ListConcurrent(t *testing.T) {
+	opts := bitcask.DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-redis-list-concurrent")
+	opts.DirPath = dir
+	rds, err := NewRedisDataStructure(opts)
+	assert.Nil(t, err)
+	defer func() {
+		_ = rds.Close()
+		_ = os.RemoveAll(dir)
+	}()
+
+	// 并发写入两端，每个元素占用不同的位置
+	key := []byte("list")
+	wg := new(sync.WaitGroup)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				element := []byte(strconv.Itoa(i*50 + j))
+				var err error
+				if j%2 == 0 {
+					_, err = rds.LPush(key, element)
+				} else {
+					_, err = rds.RPush(key, element)
+				}
+				assert.Nil(t, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	size, err := rds.LLen(key)
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(400), size)
+	elements, err := rds.LRange(key, 0, -1)
+	assert.Nil(t, err)
+	seen := make(map[string]bool)
+	for _, e := range elements {
+		seen[string(e)] = true
+	}
+	assert.Equal(t, 400, len(seen))
+
+	// 并发取出，每个元素只会被取出一次
+	var mu sync.Mutex
+	popped := make(map[string]bool)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 25; j++ {
+				element, err := rds.LPop(key)
+				assert.Nil(t, err)
+				mu.Lock()
+				assert.False(t, popped[string(element)])
+				popped[string(element)] = true
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 200, len(popped))
+	size, err = rds.LLen(key)
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(200), size)
+}
+
+func TestRedisDataStructure_SetConcurrent(t *testing.T) {
+	opts := bitcask.DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-redis-set-concurrent")
+	opts.DirPath = dir
+	rds, err := NewRedisDataStructure(opts)
+	assert.Nil(t, err)
+	defer func() {
+		_ = rds.Close()
+		_ = os.RemoveAll(dir)
+	}()
+
+	// 并发写入相同的成员，每个成员只计算一次
+	key := []byte("set")
+	wg := new(sync.WaitGroup)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				_, err := rds.SAdd(key, []byte(strconv.Itoa(j)))
+				assert.Nil(t, err)
+			}
+		}()
+	}
+	wg.Wait()
+	size, err := rds.SCard(key)
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(100), size)
+
+	// 并发取出，每个成员只会被取出一次
+	var mu sync.Mutex
+	popped := make(map[string]bool)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 5; j++ {
+				members, err := rds.SPop(key, 1)
+				assert.Nil(t, err)
+				mu.Lock()
+				for _, member := range members {
+					assert.False(t, popped[string(member)])
+					popped[string(member)] = true
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 40, len(popped))
+	size, err = rds.SCard(key)
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(60), size)
+}
+
+func TestRedisDataStructure_ZSetConcurrent(t *testing.T) {
+	opts := bitcask.DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-redis-zset-concurrent")
+	opts.DirPath = dir
+	rds, err := NewRedisDataStructure(opts)
+	assert.Nil(t, err)
+	defer func() {
+		_ = rds.Close()
+		_ = os.RemoveAll(dir)
+	}()
+
+	// 并发修改同一个成员的分数，不会丢失更新，也不会留下旧分数的数据
+	key := []byte("zset")
+	wg := new(sync.WaitGroup)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				_, err := rds.ZIncrBy(key, 1, []byte("counter"))
+				assert.Nil(t, err)
+				_, err = rds.ZAdd(key, float64(j), []byte(strconv.Itoa(i*50+j)))
+				assert.Nil(t, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	score, err := rds.ZScore(key, []byte("counter"))
+	assert.Nil(t, err)
+	assert.Equal(t, float64(400), score)
+	size, err := rds.ZCard(key)
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(401), size)
+	members, err := rds.ZRangeByScore(key, ScoreRange{Min: math.Inf(-1), Max: math.Inf(1)}, 0, -1)
+	assert.Nil(t, err)
+	assert.Equal(t, 401, len(members))
+
+	// 并发取出，每个成员只会被取出一次
+	var mu sync.Mutex
+	popped := make(map[string]bool)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				res, err := rds.ZPopMin(key, 1)
+				assert.Nil(t, err)
+				mu.Lock()
+				for _, m := range res {
+					assert.False(t, popped[string(m.Member)])
+					popped[string(m.Member)] = true
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 80, len(popped))
+	size, err = rds.ZCard(key)
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(321), size)
+}
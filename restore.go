@@ -0,0 +1,353 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/fio"
+	"bitcask-go/index"
+	"errors"
+	"io"
+	iofs "io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const restoringDirSuffix = "-restoring"
+
+// RestoreOptions 恢复配置项
+type RestoreOptions struct {
+	// Options 恢复之后的数据库使用的配置，DirPath会被替换为目标目录
+	Options Options
+
+	// UntilSeq 只恢复结束位置不超过它的记录，含义和Change.Seq一致，0表示不限制
+	UntilSeq uint64
+
+	// UntilTime 只恢复在这个时间之前写入的记录，精度是毫秒，零值表示不限制
+	// 旧版本写入的数据文件中没有时间标记，只能恢复在这个时间之前已经写完的数据文件
+	UntilTime time.Time
+}
+
+// DefaultRestoreOptions 默认的恢复配置，恢复备份中的全部数据
+var DefaultRestoreOptions = RestoreOptions{
+	Options: DefaultOptions,
+}
+
+// Restore 将备份恢复到目标目录，目标目录必须不存在或者为空
+// 恢复时校验备份清单中所有文件的大小和校验和，并且重新构建索引，不使用备份中的索引文件
+// 先恢复到临时目录，完成之后再重命名为目标目录，中途失败不会留下不完整的数据
+func Restore(backupDir, targetDir string, opts RestoreOptions) error {
+	if opts.Options.FileSystem == nil {
+		opts.Options.FileSystem = fio.OSFileSystem{}
+	}
+	fs := opts.Options.FileSystem
+
+	manifest, err := ReadBackupManifest(fs, backupDir)
+	if err != nil {
+		return err
+	}
+
+	//目标目录存在并且不为空
+	if entries, err := fs.ReadDir(targetDir); err == nil && len(entries) > 0 {
+		return ErrRestoreTargetNotEmpty
+	}
+
+	//找到恢复到哪个位置，按时间恢复需要读取数据文件，在拷贝之后计算
+	untilFid, untilOffset, limited := restorePoint(opts)
+
+	tmpDir := filepath.Clean(targetDir) + restoringDirSuffix
+	if err := fs.RemoveAll(tmpDir); err != nil {
+		return err
+	}
+	if err := fs.MkdirAll(tmpDir, os.ModePerm); err != nil {
+		return err
+	}
+	restored := false
+	defer func() {
+		if !restored {
+			_ = fs.RemoveAll(tmpDir)
+		}
+	}()
+
+	for _, file := range manifest.Files {
+		//索引和事务序列号在恢复之后重新生成
+		if file.Name == index.BptreeIndexFileName || file.Name == data.SeqNoFileName {
+			continue
+		}
+		fileId, isDataFile := parseDataFileId(file.Name)
+		if isDataFile && limited && fileId > untilFid {
+			continue
+		}
+
+		srcDir := file.Dir
+		if srcDir == "" {
+			srcDir = backupDir
+		}
+		destPath := filepath.Join(tmpDir, file.Name)
+		if err := restoreFile(fs, filepath.Join(srcDir, file.Name), destPath, file); err != nil {
+			return err
+		}
+	}
+
+	if !opts.UntilTime.IsZero() {
+		fid, offset, found, err := timeRestorePoint(fs, tmpDir, manifest, opts.UntilTime)
+		if err != nil {
+			return err
+		}
+		if found && (!limited || fid < untilFid || (fid == untilFid && offset < untilOffset)) {
+			untilFid, untilOffset, limited = fid, offset, true
+		}
+	}
+
+	//丢弃恢复位置之后的记录
+	if limited {
+		if err := cutDataFiles(fs, tmpDir, untilFid, untilOffset); err != nil {
+			return err
+		}
+	}
+
+	//merge之后的数据文件已经丢失了原来的写入顺序，不能恢复到这些文件中间的位置
+	if limited {
+		if _, err := fs.Stat(filepath.Join(tmpDir, data.MergeFinishedFileName)); err == nil {
+			nonMergeFileId, err := readNonMergeFileId(fs, tmpDir)
+			if err != nil {
+				return err
+			}
+			if untilFid < nonMergeFileId {
+				return ErrRestorePointUnavailable
+			}
+		}
+	}
+
+	//打开一次数据库，重新构建索引，同时检查所有的记录都可以正常读取
+	dbOpts := opts.Options
+	dbOpts.DirPath = tmpDir
+	if err := rebuildIndex(dbOpts); err != nil {
+		return err
+	}
+
+	if _, err := fs.Stat(targetDir); err == nil {
+		if err := fs.Remove(targetDir); err != nil {
+			return err
+		}
+	}
+	if err := fs.Rename(tmpDir, targetDir); err != nil {
+		return err
+	}
+	restored = true
+	return nil
+}
+
+// 根据UntilSeq计算恢复到的文件id和文件中的偏移，limited为false表示不限制
+func restorePoint(opts RestoreOptions) (fileId uint32, offset int64, limited bool) {
+	if opts.UntilSeq > 0 {
+		fileId = uint32(opts.UntilSeq >> changeSeqOffsetBits)
+		offset = int64(opts.UntilSeq & changeSeqOffsetMask)
+		limited = true
+	}
+	return
+}
+
+// 根据数据文件中的时间标记计算恢复到的位置，也就是第一个在until之后的时间标记，found为false表示不限制
+// 数据文件的修改时间不早于其中最后一次写入的时间，修改时间不晚于until的文件不需要读取
+func timeRestorePoint(fs fio.FileSystem, dirPath string, manifest *BackupManifest, until time.Time) (fileId uint32, offset int64, found bool, err error) {
+	var fileIds []uint32
+	for _, file := range manifest.Files {
+		fid, isDataFile := parseDataFileId(file.Name)
+		if isDataFile && file.ModTime.After(until) {
+			fileIds = append(fileIds, fid)
+		}
+	}
+	sort.Slice(fileIds, func(i, j int) bool {
+		return fileIds[i] < fileIds[j]
+	})
+
+	untilMs := until.UnixMilli()
+	for _, fid := range fileIds {
+		//按UntilSeq恢复时后面的文件没有拷贝
+		if _, err := fs.Stat(data.GetDataFileName(dirPath, fid)); errors.Is(err, iofs.ErrNotExist) {
+			break
+		}
+		offset, found, err = findTimeMark(fs, dirPath, fid, untilMs)
+		if err != nil || found {
+			return fid, offset, found, err
+		}
+	}
+	return 0, 0, false, nil
+}
+
+// 在数据文件中找到第一个晚于untilMs的时间标记的位置
+// 第一个时间标记之前的记录是旧版本写入的，不知道写入时间，只能从文件开头截断
+func findTimeMark(fs fio.FileSystem, dirPath string, fileId uint32, untilMs int64) (int64, bool, error) {
+	dataFile, err := data.OpenDataFile(fs, dirPath, fileId, fio.StandardIO, data.ChecksumCRC32IEEE)
+	if err != nil {
+		return 0, false, err
+	}
+	defer func() {
+		_ = dataFile.Close()
+	}()
+
+	var offset = data.FileHeaderSize
+	var hasMark, hasLegacy bool
+	for {
+		logRecord, size, err := dataFile.ReadLogRecord(offset)
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return 0, false, err
+		}
+		if logRecord.Type != data.LogRecordTimestamp {
+			hasLegacy = hasLegacy || !hasMark
+		} else if data.DecodeTimestamp(logRecord.Value) > untilMs {
+			if hasLegacy {
+				return data.FileHeaderSize, true, nil
+			}
+			return offset, true, nil
+		} else {
+			hasMark = true
+		}
+		offset += size
+	}
+	if !hasMark {
+		return data.FileHeaderSize, true, nil
+	}
+	return 0, false, nil
+}
+
+// 删除恢复位置之后的数据文件，截断恢复位置所在的数据文件
+func cutDataFiles(fs fio.FileSystem, dirPath string, fileId uint32, offset int64) error {
+	entries, err := fs.ReadDir(dirPath)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		fid, isDataFile := parseDataFileId(entry.Name())
+		if !isDataFile || fid < fileId {
+			continue
+		}
+		if fid > fileId || offset <= data.FileHeaderSize {
+			if err := fs.Remove(filepath.Join(dirPath, entry.Name())); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := truncateDataFile(fs, dirPath, fid, offset); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 拷贝单个文件并校验
+func restoreFile(fs fio.FileSystem, srcPath, destPath string, file BackupFile) error {
+	info, err := fs.Stat(srcPath)
+	if err != nil {
+		if errors.Is(err, iofs.ErrNotExist) {
+			return ErrBackupCorrupted
+		}
+		return err
+	}
+	if info.Size() != file.Size {
+		return ErrBackupCorrupted
+	}
+
+	if err := fio.CopyFile(fs, srcPath, destPath, nil); err != nil {
+		return err
+	}
+	//校验拷贝之后的文件，同时覆盖备份文件损坏和拷贝出错两种情况
+	checksum, err := fileChecksum(fs, destPath, nil)
+	if err != nil {
+		return err
+	}
+	if checksum != file.Checksum {
+		return ErrBackupCorrupted
+	}
+	return nil
+}
+
+// 截断数据文件，只保留结束位置不超过offset的完整记录
+func truncateDataFile(fs fio.FileSystem, dirPath string, fileId uint32, offset int64) error {
+	//文件已经存在，使用文件头中记录的校验算法
+	dataFile, err := data.OpenDataFile(fs, dirPath, fileId, fio.StandardIO, data.ChecksumCRC32IEEE)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = dataFile.Close()
+	}()
+
+	var end = data.FileHeaderSize
+	for {
+		_, size, err := dataFile.ReadLogRecord(end)
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return err
+		}
+		if end+size > offset {
+			break
+		}
+		end += size
+	}
+
+	file, err := fs.OpenFile(data.GetDataFileName(dirPath, fileId), os.O_RDWR, fio.DataFilePerm)
+	if err != nil {
+		return err
+	}
+	err = file.Truncate(end)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// 从数据文件加载索引，b+树索引需要把内存中构建好的索引写入到索引文件中
+func rebuildIndex(opts Options) error {
+	if opts.IndexType != BPlusTree {
+		db, err := Open(opts)
+		if err != nil {
+			return err
+		}
+		return db.Close()
+	}
+
+	memOpts := opts
+	memOpts.IndexType = Btree
+	db, err := Open(memOpts)
+	if err != nil {
+		return err
+	}
+
+	bpt := index.NewBPlusTree(opts.DirPath, true)
+	iterator := db.index.Iterator(false)
+	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		bpt.Put(iterator.Key(), iterator.Value())
+	}
+	iterator.Close()
+	if err := bpt.Close(); err != nil {
+		_ = db.Close()
+		return err
+	}
+
+	//关闭时会保存事务序列号，b+树索引模式启动时需要加载
+	return db.Close()
+}
+
+// 解析数据文件名中的文件id
+func parseDataFileId(fileName string) (uint32, bool) {
+	if !strings.HasSuffix(fileName, data.DataFileNameSuffix) {
+		return 0, false
+	}
+	fileId, err := strconv.ParseUint(strings.TrimSuffix(fileName, data.DataFileNameSuffix), 10, 32)
+	if err != nil {
+		return 0, false
+	}
+	return uint32(fileId), true
+}
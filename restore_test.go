@@ -0,0 +1,337 @@
+package bitcask_go
+
+import (
+	"bitcask-go/fio"
+	"bitcask-go/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func restoreTestOptions(fs fio.FileSystem) Options {
+	opts := DefaultOptions
+	opts.FileSystem = fs
+	opts.DirPath = "/bitcask"
+	opts.DataFileSize = 32 * 1024
+	opts.DataFileMergeRatio = 0
+	return opts
+}
+
+// 当前写入的位置，和Change.Seq含义一致
+func currentSeq(db *DB) uint64 {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return uint64(db.activeFile.FileId)<<changeSeqOffsetBits | uint64(db.activeFile.WriteOff)
+}
+
+func TestRestore(t *testing.T) {
+	memFS := fio.NewMemFileSystem()
+	opts := restoreTestOptions(memFS)
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 1000; i++ {
+		err = db.Put(utils.GetTestKey(i), utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	err = db.Delete(utils.GetTestKey(0))
+	assert.Nil(t, err)
+	err = db.BackUp("/backup")
+	assert.Nil(t, err)
+
+	restoreOpts := DefaultRestoreOptions
+	restoreOpts.Options = opts
+	err = Restore("/backup", "/restore", restoreOpts)
+	assert.Nil(t, err)
+	_, err = memFS.Stat("/restore" + restoringDirSuffix)
+	assert.NotNil(t, err)
+
+	//目标目录不为空
+	err = Restore("/backup", "/restore", restoreOpts)
+	assert.Equal(t, ErrRestoreTargetNotEmpty, err)
+
+	opts.DirPath = "/restore"
+	db2, err := Open(opts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	assert.Equal(t, 999, len(db2.ListKeys()))
+	value, err := db2.Get(utils.GetTestKey(999))
+	assert.Nil(t, err)
+	assert.Equal(t, utils.GetTestKey(999), value)
+	//恢复之后可以继续写入
+	err = db2.Put(utils.GetTestKey(0), utils.GetTestKey(0))
+	assert.Nil(t, err)
+}
+
+func TestRestore_Corrupted(t *testing.T) {
+	memFS := fio.NewMemFileSystem()
+	opts := restoreTestOptions(memFS)
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 100; i++ {
+		err = db.Put(utils.GetTestKey(i), utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	manifest, err := db.BackUpWithOptions("/backup", DefaultBackupOptions)
+	assert.Nil(t, err)
+
+	restoreOpts := DefaultRestoreOptions
+	restoreOpts.Options = opts
+
+	//没有清单
+	err = Restore("/not-exist", "/restore", restoreOpts)
+	assert.Equal(t, ErrBackupManifestNotFound, err)
+
+	//修改备份中的数据，大小不变
+	file, err := memFS.OpenFile(filepath.Join("/backup", manifest.Files[0].Name), os.O_RDWR, fio.DataFilePerm)
+	assert.Nil(t, err)
+	_, err = file.Write([]byte("garbage"))
+	assert.Nil(t, err)
+	assert.Nil(t, file.Close())
+
+	err = Restore("/backup", "/restore", restoreOpts)
+	assert.Equal(t, ErrBackupCorrupted, err)
+	_, err = memFS.Stat("/restore")
+	assert.NotNil(t, err)
+	_, err = memFS.Stat("/restore" + restoringDirSuffix)
+	assert.NotNil(t, err)
+}
+
+func TestRestore_UntilSeq(t *testing.T) {
+	memFS := fio.NewMemFileSystem()
+	opts := restoreTestOptions(memFS)
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 500; i++ {
+		err = db.Put(utils.GetTestKey(i), utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	goodSeq := currentSeq(db)
+
+	//写入错误的数据
+	for i := 0; i < 500; i++ {
+		err = db.Put(utils.GetTestKey(i), []byte("garbage"))
+		assert.Nil(t, err)
+	}
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Put(utils.GetTestKey(500), []byte("garbage")))
+	assert.Nil(t, wb.Delete(utils.GetTestKey(1)))
+	assert.Nil(t, wb.Commit())
+	err = db.BackUp("/backup")
+	assert.Nil(t, err)
+
+	restoreOpts := DefaultRestoreOptions
+	restoreOpts.Options = opts
+	restoreOpts.UntilSeq = goodSeq
+	err = Restore("/backup", "/restore", restoreOpts)
+	assert.Nil(t, err)
+
+	restored := opts
+	restored.DirPath = "/restore"
+	db2, err := Open(restored)
+	assert.Nil(t, err)
+	assert.Equal(t, 500, len(db2.ListKeys()))
+	for i := 0; i < 500; i++ {
+		value, err := db2.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, utils.GetTestKey(i), value)
+	}
+	destroyDB(db2)
+
+	//位置落在记录中间时不恢复这条记录
+	restoreOpts.UntilSeq = goodSeq - 1
+	err = Restore("/backup", "/restore", restoreOpts)
+	assert.Nil(t, err)
+	db3, err := Open(restored)
+	defer destroyDB(db3)
+	assert.Nil(t, err)
+	assert.Equal(t, 499, len(db3.ListKeys()))
+	_, err = db3.Get(utils.GetTestKey(499))
+	assert.Equal(t, ErrKeyNotFound, err)
+}
+
+func TestRestore_UntilTime(t *testing.T) {
+	memFS := fio.NewMemFileSystem()
+	opts := restoreTestOptions(memFS)
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 500; i++ {
+		err = db.Put(utils.GetTestKey(i), utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	//备份会切换活跃文件，之后的写入在新的数据文件中
+	err = db.BackUp("/backup-full")
+	assert.Nil(t, err)
+	time.Sleep(10 * time.Millisecond)
+	until := time.Now()
+	time.Sleep(10 * time.Millisecond)
+
+	for i := 0; i < 500; i++ {
+		err = db.Put(utils.GetTestKey(i), []byte("garbage"))
+		assert.Nil(t, err)
+	}
+	_, err = db.BackUpWithOptions("/backup-inc", BackupOptions{Incremental: "/backup-full"})
+	assert.Nil(t, err)
+
+	//从增量备份恢复，没有变化的文件从上一次备份中读取
+	restoreOpts := DefaultRestoreOptions
+	restoreOpts.Options = opts
+	restoreOpts.UntilTime = until
+	err = Restore("/backup-inc", "/restore", restoreOpts)
+	assert.Nil(t, err)
+
+	restored := opts
+	restored.DirPath = "/restore"
+	db2, err := Open(restored)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	assert.Equal(t, 500, len(db2.ListKeys()))
+	for i := 0; i < 500; i++ {
+		value, err := db2.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, utils.GetTestKey(i), value)
+	}
+}
+
+func TestRestore_UntilTimeMidFile(t *testing.T) {
+	memFS := fio.NewMemFileSystem()
+	opts := restoreTestOptions(memFS)
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 200; i++ {
+		err = db.Put(utils.GetTestKey(i), utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	fid := db.activeFile.FileId
+	time.Sleep(10 * time.Millisecond)
+	until := time.Now()
+	time.Sleep(10 * time.Millisecond)
+
+	for i := 0; i < 200; i++ {
+		err = db.Put(utils.GetTestKey(i), []byte("garbage"))
+		assert.Nil(t, err)
+	}
+	err = db.Put([]byte("after"), []byte("garbage"))
+	assert.Nil(t, err)
+	//恢复位置在同一个数据文件中间
+	assert.Equal(t, fid, db.activeFile.FileId)
+	err = db.BackUp("/backup")
+	assert.Nil(t, err)
+
+	restoreOpts := DefaultRestoreOptions
+	restoreOpts.Options = opts
+	restoreOpts.UntilTime = until
+	err = Restore("/backup", "/restore", restoreOpts)
+	assert.Nil(t, err)
+
+	restored := opts
+	restored.DirPath = "/restore"
+	db2, err := Open(restored)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	assert.Equal(t, 200, len(db2.ListKeys()))
+	for i := 0; i < 200; i++ {
+		value, err := db2.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, utils.GetTestKey(i), value)
+	}
+	_, err = db2.Get([]byte("after"))
+	assert.Equal(t, ErrKeyNotFound, err)
+}
+
+func TestRestore_MergedData(t *testing.T) {
+	memFS := fio.NewMemFileSystem()
+	opts := restoreTestOptions(memFS)
+	db, err := Open(opts)
+	assert.Nil(t, err)
+
+	for i := 0; i < 1000; i++ {
+		err = db.Put(utils.GetTestKey(i%100), utils.RandomValue(64))
+		assert.Nil(t, err)
+	}
+	seq := currentSeq(db)
+	err = db.Merge()
+	assert.Nil(t, err)
+	//重启之后加载merge之后的数据
+	assert.Nil(t, db.Close())
+	db, err = Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	err = db.Put(utils.GetTestKey(100), utils.RandomValue(64))
+	assert.Nil(t, err)
+	err = db.BackUp("/backup")
+	assert.Nil(t, err)
+
+	restoreOpts := DefaultRestoreOptions
+	restoreOpts.Options = opts
+	restoreOpts.UntilSeq = seq
+	err = Restore("/backup", "/restore", restoreOpts)
+	assert.Equal(t, ErrRestorePointUnavailable, err)
+
+	restoreOpts.UntilSeq = currentSeq(db)
+	err = Restore("/backup", "/restore", restoreOpts)
+	assert.Nil(t, err)
+	restored := opts
+	restored.DirPath = "/restore"
+	db2, err := Open(restored)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	assert.Equal(t, 101, len(db2.ListKeys()))
+}
+
+func TestRestore_BPlusTree(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-restore-bptree")
+	opts.DirPath = dir
+	opts.IndexType = BPlusTree
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 100; i++ {
+		err = db.Put(utils.GetTestKey(i), utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	seq := currentSeq(db)
+	for i := 0; i < 100; i++ {
+		err = db.Put(utils.GetTestKey(i), []byte("garbage"))
+		assert.Nil(t, err)
+	}
+	backupDir, _ := os.MkdirTemp("", "bitcask-go-restore-bptree-backup")
+	defer os.RemoveAll(backupDir)
+	err = db.BackUp(backupDir)
+	assert.Nil(t, err)
+
+	targetDir := filepath.Join(os.TempDir(), "bitcask-go-restore-bptree-target")
+	restoreOpts := DefaultRestoreOptions
+	restoreOpts.Options = opts
+	restoreOpts.UntilSeq = seq
+	err = Restore(backupDir, targetDir, restoreOpts)
+	assert.Nil(t, err)
+
+	restored := opts
+	restored.DirPath = targetDir
+	db2, err := Open(restored)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	assert.Equal(t, 100, len(db2.ListKeys()))
+	value, err := db2.Get(utils.GetTestKey(99))
+	assert.Nil(t, err)
+	assert.Equal(t, utils.GetTestKey(99), value)
+
+	//恢复之后事务序列号文件存在，可以使用批量写
+	wb := db2.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Put(utils.GetTestKey(100), utils.GetTestKey(100)))
+	assert.Nil(t, wb.Commit())
+}
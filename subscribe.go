@@ -0,0 +1,290 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"io"
+	"path/filepath"
+	"sync"
+)
+
+const (
+	// 订阅者缓冲的变更数量，缓冲区满之后订阅者停止读取数据文件，不会阻塞写入
+	subscriptionBufferSize = 128
+	// 每次持有锁最多读取的记录数量
+	subscriptionReadBatch = 64
+
+	changeSeqOffsetBits = 40
+	changeSeqOffsetMask = 1<<changeSeqOffsetBits - 1
+)
+
+type ChangeType = byte
+
+const (
+	// ChangePut 写入数据
+	ChangePut ChangeType = iota
+	// ChangeDelete 删除数据
+	ChangeDelete
+)
+
+// Change 一条已经提交的变更
+type Change struct {
+	Key   []byte
+	Value []byte
+	Type  ChangeType
+	//变更记录在数据文件中的结束位置，文件id在高24位，偏移在低40位，单调递增
+	//WriteBatch中的变更使用事务完成标识的结束位置
+	Seq uint64
+}
+
+// Subscription 变更订阅，从数据文件中顺序读取已经提交的变更
+type Subscription struct {
+	db      *DB
+	prefix  []byte
+	fid     uint32 //当前读取的文件id
+	offset  int64  //当前读取的位置
+	changes chan *Change
+	closeCh chan struct{}
+	doneCh  chan struct{}
+	once    sync.Once
+	err     error
+}
+
+type subscriptionRecord struct {
+	record *data.LogRecord
+	seq    uint64
+}
+
+// Subscribe 订阅key前缀为prefix的变更，fromSeq为0表示从头开始
+// 传入最后收到的变更的Seq可以从它之后恢复订阅，如果fromSeq所在的文件已经被merge重写，则从头重放，
+// 所以变更至少会被投递一次
+func (db *DB) Subscribe(prefix []byte, fromSeq uint64) (*Subscription, error) {
+	fid, offset := uint32(fromSeq>>changeSeqOffsetBits), int64(fromSeq&changeSeqOffsetMask)
+
+	//fromSeq所在的文件参与了merge，位置已经失效
+	mergeFinFileName := filepath.Join(db.options.DirPath, data.MergeFinishedFileName)
+	if _, err := db.fs.Stat(mergeFinFileName); err == nil {
+		nonMergeFileId, err := db.getNonMergeFileId(db.options.DirPath)
+		if err != nil {
+			return nil, err
+		}
+		if fid < nonMergeFileId {
+			fid, offset = 0, data.FileHeaderSize
+		}
+	}
+
+	sub := &Subscription{
+		db:      db,
+		prefix:  prefix,
+		fid:     fid,
+		offset:  offset,
+		changes: make(chan *Change, subscriptionBufferSize),
+		closeCh: make(chan struct{}),
+		doneCh:  make(chan struct{}),
+	}
+
+	db.mu.Lock()
+	if db.subscriptions == nil {
+		db.subscriptions = make(map[*Subscription]struct{})
+	}
+	if db.changeNotify == nil {
+		db.changeNotify = make(chan struct{})
+	}
+	db.subscriptions[sub] = struct{}{}
+	db.mu.Unlock()
+
+	go sub.run()
+	return sub, nil
+}
+
+// Changes 变更通道，订阅关闭或者出错时通道被关闭
+func (sub *Subscription) Changes() <-chan *Change {
+	return sub.changes
+}
+
+// Err 返回导致订阅结束的错误
+func (sub *Subscription) Err() error {
+	<-sub.doneCh
+	return sub.err
+}
+
+// Close 关闭订阅
+func (sub *Subscription) Close() {
+	sub.once.Do(func() {
+		close(sub.closeCh)
+	})
+	<-sub.doneCh
+
+	sub.db.mu.Lock()
+	delete(sub.db.subscriptions, sub)
+	sub.db.mu.Unlock()
+}
+
+func (sub *Subscription) run() {
+	defer close(sub.doneCh)
+	defer close(sub.changes)
+
+	//暂存还未提交的事务数据
+	transactionChanges := make(map[uint64][]*Change)
+	for {
+		records, notify, err := sub.readRecords()
+		if err != nil {
+			sub.err = err
+			return
+		}
+
+		for _, rec := range records {
+			realKey, seqNo := parseLogRecordKey(rec.record.Key)
+			change := &Change{Key: realKey, Value: rec.record.Value, Type: ChangePut, Seq: rec.seq}
+			if rec.record.Type == data.LogRecordDeleted {
+				change.Type = ChangeDelete
+				change.Value = nil
+			}
+
+			//非事务操作直接投递
+			if seqNo == nonTransactionSeqNo {
+				if !sub.send(change) {
+					return
+				}
+				continue
+			}
+
+			//事务完成之后才投递事务中的数据
+			if rec.record.Type == data.LogRecordTxnFinished {
+				for _, c := range transactionChanges[seqNo] {
+					c.Seq = rec.seq
+					if !sub.send(c) {
+						return
+					}
+				}
+				delete(transactionChanges, seqNo)
+				continue
+			}
+			transactionChanges[seqNo] = append(transactionChanges[seqNo], change)
+		}
+
+		//已经读到最新的位置，等待新的数据写入
+		if notify != nil {
+			select {
+			case <-notify:
+			case <-sub.closeCh:
+				return
+			}
+		}
+	}
+}
+
+// 投递变更，返回false表示订阅已经关闭
+func (sub *Subscription) send(change *Change) bool {
+	if len(sub.prefix) > 0 && (len(change.Key) < len(sub.prefix) || string(change.Key[:len(sub.prefix)]) != string(sub.prefix)) {
+		return true
+	}
+
+	select {
+	case sub.changes <- change:
+		return true
+	case <-sub.closeCh:
+		return false
+	}
+}
+
+// 持有读锁从当前位置读取一批记录
+// 已经读到最新位置时返回需要等待的通知通道
+func (sub *Subscription) readRecords() ([]*subscriptionRecord, chan struct{}, error) {
+	db := sub.db
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	var records []*subscriptionRecord
+	for len(records) < subscriptionReadBatch {
+		dataFile := sub.nextDataFile()
+		if dataFile == nil {
+			return records, db.changeNotify, nil
+		}
+
+		//活跃文件只读取到已经写入的位置
+		isActive := dataFile == db.activeFile
+		if isActive && sub.offset >= dataFile.WriteOff {
+			return records, db.changeNotify, nil
+		}
+
+		logRecord, size, err := dataFile.ReadLogRecord(sub.offset)
+		if err != nil {
+			if err == io.EOF && !isActive {
+				//当前文件读取完毕，继续读取下一个文件
+				sub.fid, sub.offset = sub.fid+1, data.FileHeaderSize
+				continue
+			}
+			return nil, nil, err
+		}
+
+		//时间标记不是数据的变更
+		if logRecord.Type == data.LogRecordTimestamp {
+			sub.offset += size
+			continue
+		}
+
+		//增量记录读取合并之后的值
+		if logRecord.Type == data.LogRecordMerge {
+			realKey, _ := parseLogRecordKey(logRecord.Key)
+			value, err := db.resolveMergeRecord(realKey, logRecord)
+			if err != nil {
+				return nil, nil, err
+			}
+			logRecord.Value = value
+		}
+
+		sub.offset += size
+		records = append(records, &subscriptionRecord{
+			record: logRecord,
+			seq:    uint64(sub.fid)<<changeSeqOffsetBits | uint64(sub.offset),
+		})
+	}
+
+	return records, nil, nil
+}
+
+// 找到文件id大于等于当前位置的第一个数据文件，调用方需要持有锁
+func (sub *Subscription) nextDataFile() *data.DataFile {
+	db := sub.db
+	var dataFile *data.DataFile
+	if db.activeFile != nil && db.activeFile.FileId >= sub.fid {
+		dataFile = db.activeFile
+	}
+	for fid, file := range db.olderFile {
+		if fid >= sub.fid && (dataFile == nil || fid < dataFile.FileId) {
+			dataFile = file
+		}
+	}
+
+	if dataFile != nil && dataFile.FileId != sub.fid {
+		sub.fid, sub.offset = dataFile.FileId, data.FileHeaderSize
+	}
+	//记录从文件头之后开始
+	if sub.offset < data.FileHeaderSize {
+		sub.offset = data.FileHeaderSize
+	}
+	return dataFile
+}
+
+// 通知订阅者有新的数据写入，调用方需要持有锁
+func (db *DB) notifySubscribers() {
+	if len(db.subscriptions) == 0 {
+		return
+	}
+	close(db.changeNotify)
+	db.changeNotify = make(chan struct{})
+}
+
+// 关闭所有的订阅
+func (db *DB) closeSubscriptions() {
+	db.mu.RLock()
+	subs := make([]*Subscription, 0, len(db.subscriptions))
+	for sub := range db.subscriptions {
+		subs = append(subs, sub)
+	}
+	db.mu.RUnlock()
+
+	for _, sub := range subs {
+		sub.Close()
+	}
+}
@@ -0,0 +1,113 @@
+package bitcask_go
+
+import (
+	"bitcask-go/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+	"time"
+)
+
+func receiveChange(t *testing.T, sub *Subscription) *Change {
+	select {
+	case change, ok := <-sub.Changes():
+		assert.True(t, ok)
+		return change
+	case <-time.After(time.Second * 5):
+		t.Fatal("timeout waiting for change")
+	}
+	return nil
+}
+
+func TestDB_Subscribe(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-subscribe")
+	opts.DirPath = dir
+	opts.DataFileSize = 8 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	// 订阅之前写入的数据也能读取到
+	err = db.Put([]byte("user-1"), []byte("a"))
+	assert.Nil(t, err)
+
+	sub, err := db.Subscribe([]byte("user-"), 0)
+	assert.Nil(t, err)
+	defer sub.Close()
+
+	change := receiveChange(t, sub)
+	assert.Equal(t, []byte("user-1"), change.Key)
+	assert.Equal(t, []byte("a"), change.Value)
+	assert.Equal(t, ChangePut, change.Type)
+
+	// 前缀不匹配的数据被过滤
+	err = db.Put([]byte("order-1"), []byte("b"))
+	assert.Nil(t, err)
+	err = db.Delete([]byte("user-1"))
+	assert.Nil(t, err)
+	change = receiveChange(t, sub)
+	assert.Equal(t, []byte("user-1"), change.Key)
+	assert.Equal(t, ChangeDelete, change.Type)
+
+	// 事务提交之后才能读取到
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	_ = wb.Put([]byte("user-2"), []byte("c"))
+	_ = wb.Put([]byte("user-3"), []byte("d"))
+	select {
+	case <-sub.Changes():
+		t.Fatal("uncommitted change delivered")
+	case <-time.After(time.Millisecond * 50):
+	}
+	err = wb.Commit()
+	assert.Nil(t, err)
+	c1, c2 := receiveChange(t, sub), receiveChange(t, sub)
+	assert.Equal(t, c1.Seq, c2.Seq)
+	assert.ElementsMatch(t, [][]byte{[]byte("user-2"), []byte("user-3")}, [][]byte{c1.Key, c2.Key})
+
+	// 订阅者不读取数据时不会阻塞写入，并且跨越多个数据文件
+	for i := 0; i < 1000; i++ {
+		err := db.Put([]byte("user-"+string(utils.GetTestKey(i))), utils.RandomValue(10))
+		assert.Nil(t, err)
+	}
+	assert.Greater(t, len(db.olderFile), 0)
+	var last *Change
+	for i := 0; i < 1000; i++ {
+		change := receiveChange(t, sub)
+		assert.Equal(t, []byte("user-"+string(utils.GetTestKey(i))), change.Key)
+		if last != nil {
+			assert.Greater(t, change.Seq, last.Seq)
+		}
+		last = change
+	}
+
+	// 从指定的位置恢复订阅
+	sub2, err := db.Subscribe(nil, c1.Seq)
+	assert.Nil(t, err)
+	defer sub2.Close()
+	change = receiveChange(t, sub2)
+	assert.Equal(t, []byte("user-"+string(utils.GetTestKey(0))), change.Key)
+}
+
+func TestDB_Subscribe_Close(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-subscribe-close")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	sub, err := db.Subscribe(nil, 0)
+	assert.Nil(t, err)
+
+	err = db.Put(utils.GetTestKey(1), utils.RandomValue(10))
+	assert.Nil(t, err)
+	receiveChange(t, sub)
+
+	sub.Close()
+	_, ok := <-sub.Changes()
+	assert.False(t, ok)
+	assert.Nil(t, sub.Err())
+}
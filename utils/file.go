@@ -1,31 +1,9 @@
 package utils
 
 import (
-	"io/fs"
-	"os"
-	"path/filepath"
-	"strings"
 	"syscall"
 )
 
-// DirSize 获取一个目录的大小
-func DirSize(dirPath string) (int64, error) {
-	var size int64
-	err := filepath.Walk(dirPath, func(path string, info fs.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-
-		if !info.IsDir() {
-			size += info.Size()
-		}
-
-		return nil
-	})
-
-	return size, err
-}
-
 // AvailableDiskSize 获取磁盘剩余的空间大小
 func AvailableDiskSize() (uint64, error) {
 	wd, err := syscall.Getwd()
@@ -40,44 +18,3 @@ func AvailableDiskSize() (uint64, error) {
 
 	return stat.Bavail * uint64(stat.Bsize), nil
 }
-
-// CopyDir 拷贝数据目录
-func CopyDir(src, dest string, exclude []string) error {
-	//目标不存在则创建
-	if _, err := os.Stat(dest); os.IsNotExist(err) {
-		if err := os.MkdirAll(dest, os.ModePerm); err != nil {
-			return err
-		}
-	}
-
-	return filepath.Walk(src, func(path string, info fs.FileInfo, err error) error {
-		fileName := strings.Replace(path, src, "", 1)
-
-		if fileName == "" {
-			return nil
-		}
-
-		//看这个文件是否在排除列表当中
-		for _, e := range exclude {
-			matched, err := filepath.Match(e, info.Name())
-			if err != nil {
-				return err
-			}
-			if matched {
-				return nil
-			}
-		}
-
-		//如果是一个文件夹
-		if info.IsDir() {
-			return os.MkdirAll(filepath.Join(dest, fileName), info.Mode())
-		}
-
-		//常规文件 读取出来
-		data, err := os.ReadFile(filepath.Join(src, fileName))
-		if err != nil {
-			return err
-		}
-		return os.WriteFile(filepath.Join(dest, fileName), data, info.Mode())
-	})
-}
@@ -1,6 +1,10 @@
 package utils
 
-import "strconv"
+import (
+	"encoding/binary"
+	"math"
+	"strconv"
+)
 
 func BytesToFloat64(val []byte) float64 {
 	f, _ := strconv.ParseFloat(string(val), 64)
@@ -10,3 +14,31 @@ func BytesToFloat64(val []byte) float64 {
 func Float64ToBytes(val float64) []byte {
 	return []byte(strconv.FormatFloat(val, 'f', -1, 64))
 }
+
+// Float64ToSortableBytes 将浮点数编码为 8 字节，编码结果按字节比较的顺序和数值的顺序一致
+// 正数翻转符号位，负数翻转所有位，-0 和 0 编码为相同的结果
+func Float64ToSortableBytes(val float64) []byte {
+	if val == 0 {
+		val = 0
+	}
+	bits := math.Float64bits(val)
+	if bits&(1<<63) != 0 {
+		bits = ^bits
+	} else {
+		bits |= 1 << 63
+	}
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, bits)
+	return buf
+}
+
+// SortableBytesToFloat64 解码 Float64ToSortableBytes 的编码结果
+func SortableBytesToFloat64(buf []byte) float64 {
+	bits := binary.BigEndian.Uint64(buf)
+	if bits&(1<<63) != 0 {
+		bits &^= 1 << 63
+	} else {
+		bits = ^bits
+	}
+	return math.Float64frombits(bits)
+}
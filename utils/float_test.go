@@ -0,0 +1,26 @@
+package utils
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"math"
+	"sort"
+	"testing"
+)
+
+func TestFloat64ToSortableBytes(t *testing.T) {
+	values := []float64{math.Inf(-1), -math.MaxFloat64, -1e10, -1.5, -1, -math.SmallestNonzeroFloat64,
+		0, math.SmallestNonzeroFloat64, 0.5, 1, 2, 1e10, math.MaxFloat64, math.Inf(1)}
+
+	encoded := make([][]byte, len(values))
+	for i, val := range values {
+		encoded[i] = Float64ToSortableBytes(val)
+		assert.Equal(t, val, SortableBytesToFloat64(encoded[i]))
+	}
+	assert.True(t, sort.SliceIsSorted(encoded, func(i, j int) bool {
+		return bytes.Compare(encoded[i], encoded[j]) < 0
+	}))
+
+	// -0 和 0 的编码相同
+	assert.Equal(t, Float64ToSortableBytes(0), Float64ToSortableBytes(math.Copysign(0, -1)))
+}
@@ -0,0 +1,112 @@
+package utils
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// RateLimiter 令牌桶限速器，限制每秒处理的字节数，速率可以在运行时调整
+// 用于merge、备份等后台任务，避免它们占满磁盘带宽影响前台的读写
+type RateLimiter struct {
+	mu     sync.Mutex
+	rate   int64     //每秒产生的令牌数，小于等于0表示不限速
+	tokens float64   //当前可用的令牌，为负数表示已经预支的令牌
+	last   time.Time //上一次补充令牌的时间
+}
+
+// NewRateLimiter 创建每秒rate字节的限速器，rate小于等于0表示不限速
+func NewRateLimiter(rate int64) *RateLimiter {
+	return &RateLimiter{
+		rate:   rate,
+		tokens: float64(rate),
+		last:   time.Now(),
+	}
+}
+
+// Rate 获取当前的速率
+func (rl *RateLimiter) Rate() int64 {
+	if rl == nil {
+		return 0
+	}
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	return rl.rate
+}
+
+// SetRate 调整速率，已经在等待的调用不受影响
+func (rl *RateLimiter) SetRate(rate int64) {
+	if rl == nil {
+		return
+	}
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	rl.refill(time.Now())
+	rl.rate = rate
+	if rl.tokens > float64(rate) {
+		rl.tokens = float64(rate)
+	}
+}
+
+// Wait 消耗n个令牌，令牌不够时阻塞到补充足够的令牌
+func (rl *RateLimiter) Wait(n int) {
+	if rl == nil || n <= 0 {
+		return
+	}
+
+	rl.mu.Lock()
+	if rl.rate <= 0 {
+		rl.mu.Unlock()
+		return
+	}
+	rl.refill(time.Now())
+	//先预支令牌，再等待补充，多个调用方并发时总的速率仍然受限
+	rl.tokens -= float64(n)
+	var wait time.Duration
+	if rl.tokens < 0 {
+		wait = time.Duration(-rl.tokens / float64(rl.rate) * float64(time.Second))
+	}
+	rl.mu.Unlock()
+
+	if wait > 0 {
+		time.Sleep(wait)
+	}
+}
+
+// 根据经过的时间补充令牌，桶的容量为一秒产生的令牌数
+func (rl *RateLimiter) refill(now time.Time) {
+	if rl.rate > 0 {
+		rl.tokens += now.Sub(rl.last).Seconds() * float64(rl.rate)
+		if rl.tokens > float64(rl.rate) {
+			rl.tokens = float64(rl.rate)
+		}
+	}
+	rl.last = now
+}
+
+// rateLimitedReader 读取数据时消耗令牌
+type rateLimitedReader struct {
+	reader  io.Reader
+	limiter *RateLimiter
+}
+
+// maxRateLimitedRead 单次读取的最大字节数，避免一次预支太多令牌
+const maxRateLimitedRead = 64 * 1024
+
+// NewRateLimitedReader 包装reader，读取的速度受limiter限制，limiter为空时不限速
+func NewRateLimitedReader(reader io.Reader, limiter *RateLimiter) io.Reader {
+	if limiter == nil {
+		return reader
+	}
+	return &rateLimitedReader{reader: reader, limiter: limiter}
+}
+
+func (r *rateLimitedReader) Read(b []byte) (int, error) {
+	if len(b) > maxRateLimitedRead {
+		b = b[:maxRateLimitedRead]
+	}
+	n, err := r.reader.Read(b)
+	r.limiter.Wait(n)
+	return n, err
+}
@@ -0,0 +1,59 @@
+package utils
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter_Wait(t *testing.T) {
+	rl := NewRateLimiter(100 * 1024)
+
+	//桶里初始有一秒的令牌
+	now := time.Now()
+	rl.Wait(100 * 1024)
+	assert.Less(t, time.Since(now), 100*time.Millisecond)
+
+	//令牌用完之后需要等待
+	now = time.Now()
+	rl.Wait(50 * 1024)
+	assert.GreaterOrEqual(t, time.Since(now), 400*time.Millisecond)
+}
+
+func TestRateLimiter_SetRate(t *testing.T) {
+	//不限速
+	rl := NewRateLimiter(0)
+	now := time.Now()
+	rl.Wait(1024 * 1024 * 1024)
+	assert.Less(t, time.Since(now), 100*time.Millisecond)
+
+	rl.SetRate(10 * 1024)
+	assert.Equal(t, int64(10*1024), rl.Rate())
+	now = time.Now()
+	rl.Wait(5 * 1024)
+	assert.GreaterOrEqual(t, time.Since(now), 400*time.Millisecond)
+
+	rl.SetRate(0)
+	now = time.Now()
+	rl.Wait(1024 * 1024)
+	assert.Less(t, time.Since(now), 100*time.Millisecond)
+
+	//空的限速器不限速
+	var nilLimiter *RateLimiter
+	nilLimiter.Wait(1024)
+	assert.Equal(t, int64(0), nilLimiter.Rate())
+}
+
+func TestNewRateLimitedReader(t *testing.T) {
+	rl := NewRateLimiter(64 * 1024)
+	rl.Wait(64 * 1024)
+
+	data := bytes.Repeat([]byte("a"), 32*1024)
+	now := time.Now()
+	b, err := io.ReadAll(NewRateLimitedReader(bytes.NewReader(data), rl))
+	assert.Nil(t, err)
+	assert.Equal(t, data, b)
+	assert.GreaterOrEqual(t, time.Since(now), 400*time.Millisecond)
+}